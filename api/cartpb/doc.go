@@ -0,0 +1,5 @@
+// Package cartpb is the gRPC api of the carts, generated from cart.proto with
+// protoc-gen-go and protoc-gen-go-grpc
+package cartpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cart.proto
@@ -5,18 +5,90 @@ import (
 	"time"
 )
 
-var ErrInvalidUserID = errors.New("userID is not valid")
+var (
+	ErrInvalidUserID           = errors.New("userID is not valid")
+	ErrInvalidStatus           = errors.New("status is not valid")
+	ErrInvalidStatusTransition = errors.New("status transition is not allowed")
+	ErrInvalidQuantity         = errors.New("quantity is not valid")
+)
+
+// MaxQuantity is the largest quantity of an item the apis accept
+const MaxQuantity = 10000
+
+// Status is the lifecycle state of a cart
+type Status string
+
+const (
+	// StatusOpen is the state of a cart that can still be changed
+	StatusOpen Status = "open"
+	// StatusCheckedOut is the final state of a cart that is converted to an order
+	StatusCheckedOut Status = "checked_out"
+	// StatusAbandoned is the state of a cart that the user left, it can be opened again
+	StatusAbandoned Status = "abandoned"
+)
+
+// statusTransitions lists the states each state can move to
+var statusTransitions = map[Status][]Status{
+	StatusOpen:      {StatusCheckedOut, StatusAbandoned},
+	StatusAbandoned: {StatusOpen},
+}
+
+// Valid reports whether the status is one of the known states
+func (s Status) Valid() bool {
+	switch s {
+	case StatusOpen, StatusCheckedOut, StatusAbandoned:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo reports whether a cart in this state may move to the next state
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
 
 // Cart holds the basic data of a shopping cart
 type Cart struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
+	ID int64 `json:"id"`
+	// UserID is the owner of the cart, it is 0 for the carts of the guests
+	UserID int64  `json:"user_id,omitempty"`
+	Status Status `json:"status"`
+	// Currency is the currency of all prices in the cart
+	Currency Currency `json:"currency"`
+	// Version is incremented by every change of the cart or its items, the
+	// clients tell by it whether the cart they read has changed since
+	Version   int64     `json:"-"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
 
-// Price is a value type for price
-type Price float64
+// IsGuest reports whether the cart belongs to a guest, i.e. no user owns it
+func (c *Cart) IsGuest() bool {
+	return c.UserID == 0
+}
+
+// IsOpen reports whether the cart can still be changed
+func (c *Cart) IsOpen() bool {
+	return c.Status == StatusOpen
+}
+
+// TransitionTo moves the cart to the next state if the transition is allowed
+func (c *Cart) TransitionTo(next Status) error {
+	if !next.Valid() {
+		return ErrInvalidStatus
+	}
+	if !c.Status.CanTransitionTo(next) {
+		return ErrInvalidStatusTransition
+	}
+
+	c.Status = next
+	return nil
+}
 
 // Item represents a fixed number of a single product in the shopping cart
 type Item struct {
@@ -26,11 +98,69 @@ type Item struct {
 	Quantity  int64 `json:"quantity"`
 
 	// Price is the total price of the item, i.e. product's price * quantity
-	Price     Price     `json:"price"`
+	Price     Money     `json:"price"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
 
+// SetQuantity changes the quantity of the item and its total price accordingly,
+// the unit price is the price of a single product. the unit price is not
+// recovered from the total, an item merged at two prices has none
+func (i *Item) SetQuantity(quantity int64, unitPrice Money) error {
+	if quantity < 0 {
+		return ErrInvalidQuantity
+	}
+
+	price, err := unitPrice.Multiply(quantity)
+	if err != nil {
+		return err
+	}
+
+	i.Price = price
+	i.Quantity = quantity
+	return nil
+}
+
+// Details is the read model of a cart, it holds the cart, all of its items
+// and the totals computed from them
+type Details struct {
+	*Cart
+	Items []*Item `json:"items"`
+
+	// ItemCount is the number of line items in the cart
+	ItemCount int64 `json:"item_count"`
+	// TotalQuantity is the sum of the quantities of all items
+	TotalQuantity int64 `json:"total_quantity"`
+	// Subtotal is the sum of the prices of all items
+	Subtotal Money `json:"subtotal"`
+}
+
+// NewDetails creates the details of a cart and computes the totals of its items
+// all items must be priced in the currency of the cart
+func NewDetails(c *Cart, items []*Item) (*Details, error) {
+	d := &Details{
+		Cart:     c,
+		Items:    items,
+		Subtotal: NewMoney(0, c.Currency),
+	}
+	if d.Items == nil {
+		d.Items = []*Item{}
+	}
+
+	for _, item := range d.Items {
+		subtotal, err := d.Subtotal.Add(item.Price)
+		if err != nil {
+			return nil, err
+		}
+
+		d.ItemCount++
+		d.TotalQuantity += item.Quantity
+		d.Subtotal = subtotal
+	}
+
+	return d, nil
+}
+
 // NewCart creates a new cart
 func NewCart(userID int64) (*Cart, error) {
 	if userID == 0 {
@@ -38,6 +168,16 @@ func NewCart(userID int64) (*Cart, error) {
 	}
 
 	return &Cart{
-		UserID: userID,
+		UserID:   userID,
+		Status:   StatusOpen,
+		Currency: DefaultCurrency,
 	}, nil
 }
+
+// NewGuestCart creates a new cart for a visitor who is not logged in
+func NewGuestCart() *Cart {
+	return &Cart{
+		Status:   StatusOpen,
+		Currency: DefaultCurrency,
+	}
+}
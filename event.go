@@ -0,0 +1,82 @@
+package cart
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EventType is the kind of change of a cart that the other services are told about
+type EventType string
+
+const (
+	// EventCartCreated is the creation of a cart, its data is the cart
+	EventCartCreated EventType = "CartCreated"
+	// EventItemAdded is a product added to a cart, its data is the item, which
+	// holds the merged quantity and price when the product was already in the cart
+	EventItemAdded EventType = "ItemAdded"
+	// EventItemUpdated is a change of the quantity of an item, its data is the
+	// item with its new quantity and price
+	EventItemUpdated EventType = "ItemUpdated"
+	// EventItemRemoved is an item removed from a cart, its data is the item
+	EventItemRemoved EventType = "ItemRemoved"
+	// EventCartEmptied is the removal of all the items of a cart, its data is the cart
+	EventCartEmptied EventType = "CartEmptied"
+	// EventCartStatusChanged is a cart abandoned or opened again, its data is the cart
+	EventCartStatusChanged EventType = "CartStatusChanged"
+	// EventCartClaimed is a guest cart given to the user who logged in, its data
+	// is the guest cart. when the user had an open cart the guest cart is abandoned
+	// and its items are added to, or update the items of, the open cart
+	EventCartClaimed EventType = "CartClaimed"
+	// EventCartCheckedOut is the checkout of a cart, its data is the order
+	EventCartCheckedOut EventType = "CartCheckedOut"
+)
+
+// EventTypes are all the event types in the order they are listed in the docs
+var EventTypes = []EventType{
+	EventCartCreated,
+	EventItemAdded,
+	EventItemUpdated,
+	EventItemRemoved,
+	EventCartEmptied,
+	EventCartStatusChanged,
+	EventCartClaimed,
+	EventCartCheckedOut,
+}
+
+// Valid reports whether the event type is one of the known types
+func (t EventType) Valid() bool {
+	for _, known := range EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is a change of a cart, the events of a cart are ordered by their ids
+type Event struct {
+	ID     int64     `json:"id"`
+	Type   EventType `json:"type"`
+	CartID int64     `json:"cart_id"`
+	// UserID is the owner of the cart, it is 0 for the carts of the guests
+	UserID int64 `json:"user_id,omitempty"`
+	// Data is the cart, item or order of the change, in their json encoding
+	Data       json.RawMessage `json:"data"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// NewEvent creates the event of the change of the cart, data is what changed
+func NewEvent(eventType EventType, c *Cart, data interface{}) (*Event, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		Type:       eventType,
+		CartID:     c.ID,
+		UserID:     c.UserID,
+		Data:       encoded,
+		OccurredAt: time.Now(),
+	}, nil
+}
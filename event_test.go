@@ -0,0 +1,29 @@
+package cart_test
+
+import (
+	"testing"
+
+	"github.com/cubny/cart"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEvent(t *testing.T) {
+	c := &cart.Cart{ID: 1, UserID: 2, Status: cart.StatusOpen, Currency: "EUR"}
+	item := &cart.Item{ID: 3, CartID: 1, ProductID: 4, Quantity: 2, Price: cart.NewMoney(2000, "EUR")}
+
+	e, err := cart.NewEvent(cart.EventItemAdded, c, item)
+	assert.Nil(t, err)
+	assert.Equal(t, cart.EventItemAdded, e.Type)
+	assert.Equal(t, int64(1), e.CartID)
+	assert.Equal(t, int64(2), e.UserID)
+	assert.False(t, e.OccurredAt.IsZero())
+	assert.JSONEq(t, `{"id":3, "cart_id":1, "product_id":4, "quantity":2, "price":20}`, string(e.Data))
+}
+
+func TestEventType_Valid(t *testing.T) {
+	for _, eventType := range cart.EventTypes {
+		assert.True(t, eventType.Valid())
+	}
+	assert.False(t, cart.EventType("CartDeleted").Valid())
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminKeys(t *testing.T) {
+	keys, err := ParseAdminKeys("acme=acme-key, globex=globex-key")
+	assert.Nil(t, err)
+
+	tenant, err := keys.VerifyAdminKey(context.TODO(), "acme-key")
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", tenant)
+	tenant, err = keys.VerifyAdminKey(context.TODO(), "globex-key")
+	assert.Nil(t, err)
+	assert.Equal(t, "globex", tenant)
+
+	for _, key := range []string{"", "acme", "acme-key2"} {
+		_, err = keys.VerifyAdminKey(context.TODO(), key)
+		assert.Equal(t, ErrInvalidAdminKey, err, key)
+	}
+
+	for _, s := range []string{"", "acme", "acme=", "=key", "acme=1,acme=2", "acme=key,globex=key"} {
+		_, err = ParseAdminKeys(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestAdminKeyFromClientRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/admin/webhooks", nil)
+	assert.Equal(t, "", AdminKeyFromClientRequest(req))
+
+	AddAdminKeyToRequest(req, "acme-key")
+	assert.Equal(t, "acme-key", AdminKeyFromClientRequest(req))
+
+	AddKeyToRequest(req, "acme-key")
+	assert.Equal(t, "", AdminKeyFromClientRequest(req))
+}
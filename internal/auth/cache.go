@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// entry is a cached lookup of a key, either the access key or the error
+type entry struct {
+	key       string
+	accessKey *AccessKey
+	err       error
+	expiresAt time.Time
+	// staleAt is when the entry stops being usable after it expired
+	staleAt time.Time
+}
+
+func (e *entry) fresh(now time.Time) bool {
+	return now.Before(e.expiresAt)
+}
+
+func (e *entry) usable(now time.Time) bool {
+	return now.Before(e.staleAt)
+}
+
+func (e *entry) result() (*AccessKey, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	ak := *e.accessKey
+	return &ak, nil
+}
+
+// maxEntries is the size of the cache, when it is full the oldest entry is
+// evicted to admit a new key. it keeps the unknown keys of a flood of bad
+// requests from growing the cache for ever
+const maxEntries = 10000
+
+// cache is an in-memory TTL cache of the lookups of the keys, the entries are
+// kept in the order they were set in
+type cache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	now        func() time.Time
+	maxEntries int
+}
+
+func newCache(now func() time.Time) *cache {
+	return &cache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		now:        now,
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *cache) get(key string) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if !e.fresh(c.now()) && !e.usable(c.now()) {
+		c.remove(el)
+		return nil, false
+	}
+	return e, true
+}
+
+// set caches the lookup of a key for ttl, the entry is kept for stale more
+// after it expires
+func (c *cache) set(key string, ak *AccessKey, err error, ttl, stale time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	for c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Front())
+	}
+
+	now := c.now()
+	c.entries[key] = c.order.PushBack(&entry{
+		key:       key,
+		accessKey: ak,
+		err:       err,
+		expiresAt: now.Add(ttl),
+		staleAt:   now.Add(ttl + stale),
+	})
+}
+
+func (c *cache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+// call is a lookup in flight
+type call struct {
+	done      chan struct{}
+	accessKey *AccessKey
+	err       error
+}
+
+// callGroup makes the concurrent lookups of a key share a single call so that
+// an expired key does not send a stampede of requests to the auth service
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// do runs fn once for all the concurrent callers of the key, a caller stops
+// waiting when its context is done
+func (g *callGroup) do(ctx context.Context, key string, fn func() (*AccessKey, error)) (*AccessKey, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		g.calls[key] = c
+		go func() {
+			c.accessKey, c.err = fn()
+
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	ak := *c.accessKey
+	return &ak, nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_Full(t *testing.T) {
+	now := time.Now()
+	c := newCache(func() time.Time { return now })
+	c.maxEntries = 3
+
+	c.set("first", &AccessKey{Key: "first"}, nil, time.Minute, 0)
+	c.set("second", &AccessKey{Key: "second"}, nil, time.Minute, 0)
+	c.set("third", &AccessKey{Key: "third"}, nil, time.Minute, 0)
+
+	// a full cache of valid entries still admits a key, the oldest entry makes
+	// room for it
+	c.set("fourth", &AccessKey{Key: "fourth"}, nil, time.Minute, 0)
+	assert.Len(t, c.entries, 3)
+	_, ok := c.get("first")
+	assert.False(t, ok)
+	_, ok = c.get("fourth")
+	assert.True(t, ok)
+
+	// setting a key again makes it the newest
+	c.set("second", &AccessKey{Key: "second"}, nil, time.Minute, 0)
+	c.set("fifth", nil, ErrNotFound, time.Minute, 0)
+	assert.Len(t, c.entries, 3)
+	_, ok = c.get("third")
+	assert.False(t, ok)
+	for _, key := range []string{"second", "fourth", "fifth"} {
+		_, ok := c.get(key)
+		assert.True(t, ok, key)
+	}
+
+	// an entry that is no longer usable is dropped when it is read
+	now = now.Add(2 * time.Minute)
+	_, ok = c.get("second")
+	assert.False(t, ok)
+	assert.Len(t, c.entries, 2)
+	assert.Equal(t, 2, c.order.Len())
+}
+
+func TestCache_Flood(t *testing.T) {
+	now := time.Now()
+	c := newCache(func() time.Time { return now })
+
+	for i := 0; i < 2*maxEntries; i++ {
+		c.set(strconv.Itoa(i), nil, ErrNotFound, time.Minute, 0)
+	}
+	assert.Len(t, c.entries, maxEntries)
+	assert.Equal(t, maxEntries, c.order.Len())
+}
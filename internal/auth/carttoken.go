@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var ErrInvalidCartToken = errors.New("cart token is not valid")
+
+// CartTokenHeader is the header the guests send the token of their cart with
+const CartTokenHeader = "X-Cart-Token"
+
+// cartTokenSize is the size of a decoded token, the cart id and its HMAC-SHA256
+const cartTokenSize = 8 + sha256.Size
+
+// CartTokens issues and verifies the tokens of the guest carts. a token is
+// opaque to the guest, it holds the id of the cart signed with a secret, so
+// whoever holds it has access to that cart and only that one
+type CartTokens struct {
+	secret []byte
+}
+
+// NewCartTokens creates the cart tokens signed with the secret
+func NewCartTokens(secret []byte) *CartTokens {
+	return &CartTokens{secret: secret}
+}
+
+// IssueCartToken creates the token of the cart
+func (t *CartTokens) IssueCartToken(cartID int64) string {
+	payload := make([]byte, 8, cartTokenSize)
+	binary.BigEndian.PutUint64(payload, uint64(cartID))
+
+	return base64.RawURLEncoding.EncodeToString(append(payload, t.sign(payload)...))
+}
+
+// VerifyCartToken verifies the token and returns the id of its cart
+func (t *CartTokens) VerifyCartToken(token string) (int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != cartTokenSize {
+		return 0, ErrInvalidCartToken
+	}
+
+	payload, sig := data[:8], data[8:]
+	if !hmac.Equal(sig, t.sign(payload)) {
+		return 0, ErrInvalidCartToken
+	}
+
+	cartID := int64(binary.BigEndian.Uint64(payload))
+	if cartID <= 0 {
+		return 0, ErrInvalidCartToken
+	}
+
+	return cartID, nil
+}
+
+func (t *CartTokens) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte("cart:"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// CartTokenFromClientRequest gets the cart token from the headers of a request. Empty if not found.
+func CartTokenFromClientRequest(req *http.Request) string {
+	return strings.TrimSpace(req.Header.Get(CartTokenHeader))
+}
+
+// AddCartTokenToRequest adds the cart token to the headers of a request.
+func AddCartTokenToRequest(req *http.Request, token string) {
+	req.Header.Set(CartTokenHeader, token)
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCartTokens(t *testing.T) {
+	tokens := NewCartTokens([]byte("secret"))
+
+	token := tokens.IssueCartToken(42)
+	cartID, err := tokens.VerifyCartToken(token)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), cartID)
+
+	// a token of another secret
+	_, err = NewCartTokens([]byte("other")).VerifyCartToken(token)
+	assert.Equal(t, ErrInvalidCartToken, err)
+
+	// a token whose cart id is changed
+	data, _ := base64.RawURLEncoding.DecodeString(token)
+	data[7] = 43
+	_, err = tokens.VerifyCartToken(base64.RawURLEncoding.EncodeToString(data))
+	assert.Equal(t, ErrInvalidCartToken, err)
+
+	_, err = tokens.VerifyCartToken("not-a-token")
+	assert.Equal(t, ErrInvalidCartToken, err)
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cubny/cart/internal/tracing"
+)
+
+// ErrUnavailable is returned when the auth service cannot be reached and the
+// key cannot be verified otherwise
+var ErrUnavailable = errors.New("auth service is unavailable")
+
+// Options configures the HTTPClient, a zero duration takes the default of
+// DefaultOptions
+type Options struct {
+	// Timeout of a single request to the auth service
+	Timeout time.Duration
+	// Retries is the number of times a failed request is retried, only network
+	// errors and 5xx responses are retried
+	Retries int
+	// RetryBackoff is the wait before the first retry, it grows linearly with
+	// every retry
+	RetryBackoff time.Duration
+	// CacheTTL is how long a valid key is cached
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long an unknown key is cached
+	NegativeCacheTTL time.Duration
+	// FailOpen decides what happens when the auth service is down. when it is
+	// set the keys that were valid within StaleTTL keep being accepted, otherwise
+	// every key that is not freshly cached is refused with ErrUnavailable
+	FailOpen bool
+	// StaleTTL is how long an expired valid key is still accepted under FailOpen
+	StaleTTL time.Duration
+}
+
+// DefaultOptions are the options of a HTTPClient unless set otherwise
+var DefaultOptions = Options{
+	Timeout:          time.Second,
+	Retries:          2,
+	RetryBackoff:     50 * time.Millisecond,
+	CacheTTL:         time.Minute,
+	NegativeCacheTTL: 10 * time.Second,
+	StaleTTL:         15 * time.Minute,
+}
+
+// HTTPClient is the client for Auth service over http. it verifies a key with
+// GET {baseURL}/verify carrying the key in the Authorisation header the same
+// way the clients of the cart send it, the service responds with
+//
+//	{"id": 1, "key": "abcdef123456", "user_id": 1}
+//
+// or with 401 or 404 when the key is not valid. lookups are cached and the
+// concurrent lookups of a key share a single request to the service
+type HTTPClient struct {
+	baseURL    string
+	httpClient *http.Client
+	opts       Options
+	cache      *cache
+	calls      *callGroup
+}
+
+// NewHTTPClient creates a client of the auth service running at baseURL
+func NewHTTPClient(baseURL string, opts Options) *HTTPClient {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions.Timeout
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultOptions.RetryBackoff
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = DefaultOptions.CacheTTL
+	}
+	if opts.NegativeCacheTTL <= 0 {
+		opts.NegativeCacheTTL = DefaultOptions.NegativeCacheTTL
+	}
+	if opts.StaleTTL <= 0 {
+		opts.StaleTTL = DefaultOptions.StaleTTL
+	}
+
+	return &HTTPClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: opts.Timeout, Transport: tracing.Transport(nil)},
+		opts:       opts,
+		cache:      newCache(time.Now),
+		calls:      &callGroup{},
+	}
+}
+
+// VerifyKey verifies a Key against the auth service, or the cache when the key
+// was looked up recently
+func (c *HTTPClient) VerifyKey(ctx context.Context, key string) (*AccessKey, error) {
+	if e, ok := c.cache.get(key); ok && e.fresh(c.cache.now()) {
+		return e.result()
+	}
+
+	// the lookup is shared by the callers of the same key so it is not bound to
+	// the cancellation of any of them, every request to the service has a timeout.
+	// it keeps the values of the context of the first caller, e.g. its trace
+	ak, err := c.calls.do(ctx, key, func() (*AccessKey, error) {
+		return c.lookup(context.WithoutCancel(ctx), key)
+	})
+	switch {
+	case err == nil:
+		c.cache.set(key, ak, nil, c.opts.CacheTTL, c.opts.StaleTTL)
+		return ak, nil
+	case err == ErrNotFound:
+		c.cache.set(key, nil, ErrNotFound, c.opts.NegativeCacheTTL, 0)
+		return nil, ErrNotFound
+	case ctx.Err() != nil:
+		return nil, err
+	}
+
+	// the service is down
+	if c.opts.FailOpen {
+		if e, ok := c.cache.get(key); ok && e.accessKey != nil && e.usable(c.cache.now()) {
+			return e.result()
+		}
+	}
+
+	return nil, ErrUnavailable
+}
+
+// lookup asks the auth service for the key, retrying on failures
+func (c *HTTPClient) lookup(ctx context.Context, key string) (*AccessKey, error) {
+	var err error
+	for attempt := 0; attempt <= c.opts.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * c.opts.RetryBackoff):
+			}
+		}
+
+		var ak *AccessKey
+		var retry bool
+		ak, retry, err = c.verify(ctx, key)
+		if !retry {
+			return ak, err
+		}
+	}
+
+	return nil, err
+}
+
+// verify makes a single request to the auth service, it reports whether the
+// request is worth retrying
+func (c *HTTPClient) verify(ctx context.Context, key string) (*AccessKey, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/verify", nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	AddKeyToRequest(req, key)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("auth: request failed, %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusNotFound:
+		return nil, false, ErrNotFound
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, true, fmt.Errorf("auth: unexpected status %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("auth: unexpected status %d", resp.StatusCode)
+	}
+
+	ak := &struct {
+		ID     int64  `json:"id"`
+		Key    string `json:"key"`
+		UserID int64  `json:"user_id"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(ak); err != nil {
+		return nil, false, fmt.Errorf("auth: cannot decode response, %s", err)
+	}
+	if ak.Key == "" {
+		ak.Key = key
+	}
+
+	return &AccessKey{ID: ak.ID, Key: ak.Key, UserID: ak.UserID}, false, nil
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart/internal/tracing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// authServer is a stand-in of the auth service which knows the keys of the stub
+// client, it fails with 503 while down is set
+type authServer struct {
+	*httptest.Server
+	hits  int32
+	down  int32
+	delay time.Duration
+}
+
+func newAuthServer() *authServer {
+	s := &authServer{}
+	stub := New()
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.hits, 1)
+		time.Sleep(s.delay)
+		if atomic.LoadInt32(&s.down) > 0 {
+			atomic.AddInt32(&s.down, -1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		ak, err := stub.VerifyKey(r.Context(), KeyFromClientRequest(r))
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": ak.ID, "key": ak.Key, "user_id": ak.UserID})
+	}))
+	return s
+}
+
+func (s *authServer) setDown(times int32) {
+	atomic.StoreInt32(&s.down, times)
+}
+
+func (s *authServer) hitCount() int32 {
+	return atomic.LoadInt32(&s.hits)
+}
+
+// newTestClient creates a client whose clock is moved by the returned func
+func newTestClient(url string, opts Options) (*HTTPClient, func(time.Duration)) {
+	c := NewHTTPClient(url, opts)
+	now := time.Now()
+	var mu sync.Mutex
+	c.cache.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	return c, func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func TestHTTPClient_VerifyKey(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+
+	c, advance := newTestClient(srv.URL, Options{CacheTTL: time.Minute, NegativeCacheTTL: time.Second})
+
+	ak, err := c.VerifyKey(context.TODO(), "bcdefg123456")
+	assert.Nil(t, err)
+	assert.Equal(t, &AccessKey{ID: 2, Key: "bcdefg123456", UserID: 12}, ak)
+
+	// a valid key is served from the cache until it expires
+	ak, err = c.VerifyKey(context.TODO(), "bcdefg123456")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), ak.UserID)
+	assert.Equal(t, int32(1), srv.hitCount())
+
+	advance(2 * time.Minute)
+	_, err = c.VerifyKey(context.TODO(), "bcdefg123456")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), srv.hitCount())
+
+	// an unknown key is cached for the NegativeCacheTTL
+	_, err = c.VerifyKey(context.TODO(), "unknown")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = c.VerifyKey(context.TODO(), "unknown")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, int32(3), srv.hitCount())
+
+	advance(2 * time.Second)
+	_, err = c.VerifyKey(context.TODO(), "unknown")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, int32(4), srv.hitCount())
+}
+
+func TestHTTPClient_VerifyKey_Trace(t *testing.T) {
+	tracing.NewInMemoryExporter()
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	// the lookup carries the trace of the caller to the auth service
+	ctx, span := tracing.Start(context.TODO(), "handler.Authorise")
+	defer span.End()
+	_, err := NewHTTPClient(srv.URL, Options{}).VerifyKey(ctx, "unknown")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+}
+
+func TestHTTPClient_VerifyKey_Retries(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+
+	c, _ := newTestClient(srv.URL, Options{Retries: 2, RetryBackoff: time.Millisecond})
+
+	srv.setDown(2)
+	ak, err := c.VerifyKey(context.TODO(), "abcdef123456")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), ak.UserID)
+	assert.Equal(t, int32(3), srv.hitCount())
+
+	c, _ = newTestClient(srv.URL, Options{Retries: 2, RetryBackoff: time.Millisecond})
+	srv.setDown(3)
+	_, err = c.VerifyKey(context.TODO(), "abcdef123456")
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Equal(t, int32(6), srv.hitCount())
+}
+
+func TestHTTPClient_VerifyKey_FailurePolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		failOpen      bool
+		after         time.Duration
+		expectedError error
+	}{
+		{
+			name:          "fail closed - ErrUnavailable",
+			failOpen:      false,
+			after:         2 * time.Minute,
+			expectedError: ErrUnavailable,
+		},
+		{
+			name:          "fail open with a recently valid key - ok",
+			failOpen:      true,
+			after:         2 * time.Minute,
+			expectedError: nil,
+		},
+		{
+			name:          "fail open with a stale key - ErrUnavailable",
+			failOpen:      true,
+			after:         time.Hour,
+			expectedError: ErrUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := newAuthServer()
+			defer srv.Close()
+
+			c, advance := newTestClient(srv.URL, Options{
+				Retries:      1,
+				RetryBackoff: time.Millisecond,
+				CacheTTL:     time.Minute,
+				StaleTTL:     10 * time.Minute,
+				FailOpen:     test.failOpen,
+			})
+			_, err := c.VerifyKey(context.TODO(), "abcdef123456")
+			assert.Nil(t, err)
+
+			advance(test.after)
+			srv.setDown(100)
+			_, err = c.VerifyKey(context.TODO(), "abcdef123456")
+			assert.Equal(t, test.expectedError, err)
+
+			// keys never seen before are refused either way
+			_, err = c.VerifyKey(context.TODO(), "bcdefg123456")
+			assert.Equal(t, ErrUnavailable, err)
+		})
+	}
+}
+
+func TestHTTPClient_VerifyKey_Stampede(t *testing.T) {
+	srv := newAuthServer()
+	srv.delay = 50 * time.Millisecond
+	defer srv.Close()
+
+	c, _ := newTestClient(srv.URL, Options{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ak, err := c.VerifyKey(context.TODO(), "cdefgh123456")
+			assert.Nil(t, err)
+			assert.Equal(t, int64(20), ak.UserID)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), srv.hitCount())
+}
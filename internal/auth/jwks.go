@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cubny/cart/internal/tracing"
+)
+
+var (
+	ErrKeyNotFound = errors.New("signing key not found")
+	// ErrJWKSSources is returned by OpenJWKS when both a file and a url are given
+	ErrJWKSSources = errors.New("jwks: a file or a url can be given, not both")
+)
+
+// jwksRefreshInterval is how often the keys of a remote JWKS are fetched again,
+// and jwksMinRefreshInterval how soon they can be fetched again for a key id
+// that is not known, so that tokens with made up key ids cannot flood the issuer
+const (
+	jwksRefreshInterval    = time.Hour
+	jwksMinRefreshInterval = time.Minute
+)
+
+// JWKS is a JSON Web Key Set (RFC 7517) holding the public keys tokens are
+// signed with. the keys are read once from a file, or fetched from a url and
+// refreshed periodically and when a token is signed with an unknown key
+type JWKS struct {
+	url        string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// fetching is closed when the fetch in flight is done, nil when the keys
+	// are not being fetched
+	fetching chan struct{}
+}
+
+// OpenJWKS opens the JWKS of the file at path or of the url, only one of them
+// can be given so that the keys do not come from a source that was not meant.
+// httpClient is the client of the url, see NewRemoteJWKS
+func OpenJWKS(path, url string, httpClient *http.Client) (*JWKS, error) {
+	switch {
+	case path != "" && url != "":
+		return nil, ErrJWKSSources
+	case path != "":
+		return LoadJWKSFile(path)
+	}
+
+	return NewRemoteJWKS(url, httpClient), nil
+}
+
+// LoadJWKSFile reads the keys of a JWKS file
+func LoadJWKSFile(path string) (*JWKS, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWKS{keys: keys}, nil
+}
+
+// NewRemoteJWKS creates a JWKS whose keys are fetched from the url, usually the
+// jwks_uri of an OIDC issuer. if httpClient is nil a client with the
+// DefaultOptions timeout is used, whose requests carry the trace context
+func NewRemoteJWKS(url string, httpClient *http.Client) *JWKS {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultOptions.Timeout, Transport: tracing.Transport(nil)}
+	}
+
+	return &JWKS{url: url, httpClient: httpClient, keys: map[string]crypto.PublicKey{}}
+}
+
+// Key returns the public key with the key id. the keys are fetched without
+// holding the lock, the concurrent callers that need them share a single fetch
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	if j.url == "" || !j.due(kid) {
+		defer j.mu.Unlock()
+		return j.lookup(kid)
+	}
+
+	done := j.fetching
+	if done == nil {
+		done = make(chan struct{})
+		j.fetching = done
+		// the fetch is shared so it is not bound to the caller that started it,
+		// the http client has a timeout
+		go j.refresh(context.WithoutCancel(ctx), done)
+	}
+	j.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-done:
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.keys) == 0 {
+		return nil, ErrUnavailable
+	}
+	return j.lookup(kid)
+}
+
+// due reports whether the keys are fetched again for the key id
+func (j *JWKS) due(kid string) bool {
+	since := time.Since(j.fetchedAt)
+	_, known := j.keys[kid]
+	return since > jwksRefreshInterval || (!known && since > jwksMinRefreshInterval)
+}
+
+// refresh fetches the keys and swaps them in, done is closed once they are
+func (j *JWKS) refresh(ctx context.Context, done chan struct{}) {
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	switch {
+	case err == nil:
+		j.keys = keys
+		j.fetchedAt = time.Now()
+	case len(j.keys) > 0:
+		// keep using the keys fetched before
+		j.fetchedAt = time.Now()
+	}
+	j.fetching = nil
+	j.mu.Unlock()
+
+	close(done)
+}
+
+// lookup finds the key, a token without a key id can only be verified by a
+// set of a single key
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, error) {
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+// fetch gets the keys from the url
+func (j *JWKS) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := j.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: request failed, %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJWKS(data)
+}
+
+// jwk is a JSON Web Key, only the fields of the RSA and EC public keys are read
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the signing keys of a JWKS document, keys of other types or
+// uses are skipped
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	set := &struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("jwks: invalid json, %s", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwks: invalid key %q, %s", k.Kid, err)
+		}
+
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("jwks: no signing keys")
+	}
+
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on the curve")
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url value")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers the SHA-256 hash of RS256 and ES256
+	_ "crypto/sha512" // registers the SHA-384 and SHA-512 hashes
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("token is not valid")
+
+// KeySet provides the public keys the tokens are signed with, JWKS is the
+// usual one
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// JWTOptions configures the JWTVerifier
+type JWTOptions struct {
+	// Audience the tokens must be issued for, any audience is accepted if empty
+	Audience string
+	// UserIDClaim is the claim holding the numeric user id, "sub" by default
+	UserIDClaim string
+	// Leeway is the clock skew tolerated when checking exp and nbf
+	Leeway time.Duration
+}
+
+// DefaultJWTOptions are the options of a JWTVerifier unless set otherwise
+var DefaultJWTOptions = JWTOptions{
+	UserIDClaim: "sub",
+	Leeway:      30 * time.Second,
+}
+
+// JWTVerifier verifies the JSON Web Tokens (RFC 7519) sent as bearer tokens,
+// e.g. the ones issued by an OIDC provider. the tokens must be signed with
+// RS256/384/512 or ES256/384/512 by a key of the KeySet and must have an exp
+type JWTVerifier struct {
+	keys KeySet
+	opts JWTOptions
+	now  func() time.Time
+}
+
+// NewJWTVerifier creates a verifier of the tokens signed by the keys
+func NewJWTVerifier(keys KeySet, opts JWTOptions) *JWTVerifier {
+	if opts.UserIDClaim == "" {
+		opts.UserIDClaim = DefaultJWTOptions.UserIDClaim
+	}
+	if opts.Leeway < 0 {
+		opts.Leeway = 0
+	}
+
+	return &JWTVerifier{keys: keys, opts: opts, now: time.Now}
+}
+
+// TokenFromClientRequest gets the bearer token from the standard Authorization
+// header of a request. Empty if not found.
+func TokenFromClientRequest(req *http.Request) string {
+	parts := strings.Fields(req.Header.Get("Authorization"))
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// AddTokenToRequest adds the bearer token to the headers of a request.
+func AddTokenToRequest(req *http.Request, token string) {
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+}
+
+// VerifyToken verifies the signature and the claims of a token and returns the
+// access key of the user the token is issued for, the key of the access key is
+// the token itself
+func (v *JWTVerifier) VerifyToken(ctx context.Context, token string) (*AccessKey, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	header := &struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	switch {
+	case err == ErrKeyNotFound:
+		return nil, ErrInvalidToken
+	case err != nil:
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := v.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+
+	userID, err := numericClaim(claims[v.opts.UserIDClaim])
+	if err != nil || userID <= 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return &AccessKey{Key: token, UserID: userID}, nil
+}
+
+// verifyClaims checks the exp, nbf and aud claims
+func (v *JWTVerifier) verifyClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, err := numericClaim(claims["exp"])
+	if err != nil || !now.Before(time.Unix(exp, 0).Add(v.opts.Leeway)) {
+		return ErrInvalidToken
+	}
+
+	if _, ok := claims["nbf"]; ok {
+		nbf, err := numericClaim(claims["nbf"])
+		if err != nil || now.Add(v.opts.Leeway).Before(time.Unix(nbf, 0)) {
+			return ErrInvalidToken
+		}
+	}
+
+	if v.opts.Audience == "" {
+		return nil
+	}
+
+	// aud is either a string or an array of strings
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == v.opts.Audience {
+			return nil
+		}
+	case []interface{}:
+		for _, a := range aud {
+			if a == v.opts.Audience {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidToken
+}
+
+// verifySignature checks the signature of the signing input with the algorithm
+// of the token, the type of the key must match the algorithm
+func verifySignature(alg string, key crypto.PublicKey, input string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			return errors.New("algorithm does not match the key")
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		// the signature is r and s of the size of the curve side by side
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return errors.New("algorithm does not match the key")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	return errors.New("unsupported key")
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// numericClaim reads an integer claim, a string of digits is accepted too as
+// the subject of most issuers is a string
+func numericClaim(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return 0, err
+		}
+		return int64(f), nil
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+
+	return 0, errors.New("claim is not a number")
+}
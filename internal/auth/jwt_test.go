@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart/internal/tracing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signToken signs the claims with the key, it is how an issuer creates a token
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	h := crypto.SHA256.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest)
+		assert.Nil(t, err)
+		sig = s
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		assert.Nil(t, err)
+		sig = append(padBytes(r.Bytes(), 32), padBytes(s.Bytes(), 32)...)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func padBytes(b []byte, size int) []byte {
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// jwksDocument is the JWKS of the public keys
+func jwksDocument(rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) []byte {
+	doc, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kid": "rsa-1", "kty": "RSA", "use": "sig", "alg": "RS256",
+				"n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E))),
+			},
+			{
+				"kid": "ec-1", "kty": "EC", "use": "sig", "crv": "P-256",
+				"x": encodeBigInt(ecKey.X), "y": encodeBigInt(ecKey.Y),
+			},
+			{"kid": "enc-1", "kty": "RSA", "use": "enc"},
+		},
+	})
+	return doc
+}
+
+func TestJWTVerifier_VerifyToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "jwks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(path, jwksDocument(rsaKey, ecKey), 0600))
+
+	jwks, err := LoadJWKSFile(path)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "12",
+			"aud": []string{"cart", "other"},
+			"exp": now.Add(time.Hour).Unix(),
+			"nbf": now.Add(-time.Minute).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name           string
+		token          string
+		opts           JWTOptions
+		expectedUserID int64
+		expectedError  error
+	}{
+		{
+			name:           "RS256 - ok",
+			token:          signToken(t, "RS256", "rsa-1", rsaKey, claims(nil)),
+			opts:           JWTOptions{Audience: "cart"},
+			expectedUserID: 12,
+		},
+		{
+			name:           "ES256 - ok",
+			token:          signToken(t, "ES256", "ec-1", ecKey, claims(nil)),
+			opts:           JWTOptions{Audience: "cart"},
+			expectedUserID: 12,
+		},
+		{
+			name:           "user id of a custom claim - ok",
+			token:          signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"uid": 20})),
+			opts:           JWTOptions{UserIDClaim: "uid"},
+			expectedUserID: 20,
+		},
+		{
+			name:           "audience as a string - ok",
+			token:          signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"aud": "cart"})),
+			opts:           JWTOptions{Audience: "cart"},
+			expectedUserID: 12,
+		},
+		{
+			name:           "expired within the leeway - ok",
+			token:          signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()})),
+			opts:           JWTOptions{Leeway: 30 * time.Second},
+			expectedUserID: 12,
+		},
+		{
+			name:          "expired - ErrInvalidToken",
+			token:         signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "without exp - ErrInvalidToken",
+			token:         signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"exp": nil})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "not valid yet - ErrInvalidToken",
+			token:         signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "another audience - ErrInvalidToken",
+			token:         signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"aud": "billing"})),
+			opts:          JWTOptions{Audience: "cart"},
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "signed by another key - ErrInvalidToken",
+			token:         signToken(t, "RS256", "rsa-1", otherKey, claims(nil)),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "unknown key id - ErrInvalidToken",
+			token:         signToken(t, "RS256", "rsa-2", rsaKey, claims(nil)),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "algorithm of another key type - ErrInvalidToken",
+			token:         signToken(t, "ES256", "rsa-1", rsaKey, claims(nil)),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "unsigned token - ErrInvalidToken",
+			token:         signToken(t, "none", "rsa-1", rsaKey, claims(nil)),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "user id claim is not a number - ErrInvalidToken",
+			token:         signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"sub": "user@example.com"})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "malformed token - ErrInvalidToken",
+			token:         "not-a-jwt",
+			expectedError: ErrInvalidToken,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := NewJWTVerifier(jwks, test.opts)
+			ak, err := v.VerifyToken(context.TODO(), test.token)
+			assert.Equal(t, test.expectedError, err)
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedUserID, ak.UserID)
+				assert.Equal(t, test.token, ak.Key)
+			}
+		})
+	}
+}
+
+func TestOpenJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "jwks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(path, jwksDocument(rsaKey, ecKey), 0600))
+
+	jwks, err := OpenJWKS(path, "", nil)
+	assert.Nil(t, err)
+	assert.Len(t, jwks.keys, 2)
+	assert.Equal(t, "", jwks.url)
+
+	jwks, err = OpenJWKS("", "https://issuer.example.com/jwks", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://issuer.example.com/jwks", jwks.url)
+
+	// the file does not win over the url silently
+	_, err = OpenJWKS(path, "https://issuer.example.com/jwks", nil)
+	assert.Equal(t, ErrJWKSSources, err)
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	var hits, down int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&down) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(jwksDocument(rsaKey, ecKey))
+	}))
+	defer srv.Close()
+
+	v := NewJWTVerifier(NewRemoteJWKS(srv.URL, nil), JWTOptions{})
+	token := signToken(t, "RS256", "rsa-1", rsaKey, map[string]interface{}{
+		"sub": 1,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	ak, err := v.VerifyToken(context.TODO(), token)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), ak.UserID)
+
+	// the keys are fetched once, an unknown key id does not refetch them right away
+	_, err = v.VerifyToken(context.TODO(), token)
+	assert.Nil(t, err)
+	_, err = v.VerifyToken(context.TODO(), signToken(t, "RS256", "rsa-2", rsaKey, map[string]interface{}{
+		"sub": 1,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+	assert.Equal(t, ErrInvalidToken, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	// the issuer cannot be reached before any key is known
+	atomic.StoreInt32(&down, 1)
+	v = NewJWTVerifier(NewRemoteJWKS(srv.URL, nil), JWTOptions{})
+	_, err = v.VerifyToken(context.TODO(), token)
+	assert.Equal(t, ErrUnavailable, err)
+}
+
+func TestRemoteJWKS_Trace(t *testing.T) {
+	tracing.NewInMemoryExporter()
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	// the fetch of the keys carries the trace of the caller to the issuer
+	ctx, span := tracing.Start(context.TODO(), "handler.Authorise")
+	defer span.End()
+	_, err := NewRemoteJWKS(srv.URL, nil).Key(ctx, "rsa-1")
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+}
+
+func TestRemoteJWKS_Refresh(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	var hits int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) > 1 {
+			<-release
+		}
+		_, _ = w.Write(jwksDocument(rsaKey, ecKey))
+	}))
+	defer srv.Close()
+
+	jwks := NewRemoteJWKS(srv.URL, nil)
+	_, err = jwks.Key(context.TODO(), "rsa-1")
+	assert.Nil(t, err)
+
+	// the keys are due, the refresh hangs until it is released
+	jwks.mu.Lock()
+	jwks.fetchedAt = time.Now().Add(-2 * jwksRefreshInterval)
+	jwks.mu.Unlock()
+
+	results := make(chan error, 5)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, err := jwks.Key(context.TODO(), "rsa-1")
+			results <- err
+		}()
+	}
+
+	// a caller is not held by the fetch in flight past its own context
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	_, err = jwks.Key(ctx, "rsa-1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+	for i := 0; i < cap(results); i++ {
+		assert.Nil(t, <-results)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
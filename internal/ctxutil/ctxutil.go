@@ -5,14 +5,28 @@ import (
 	"errors"
 
 	"github.com/cubny/cart/internal/auth"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type ctxKeyType int
 
 const (
 	ctxAuthoriseAccess ctxKeyType = iota
+	ctxGuestCart
+	ctxCartVersion
+	ctxTenant
+	ctxRequest
 )
 
+// request is what is known of the request the context is of, the user is
+// recorded on it once the request is authorised so that the access log of the
+// request, which is written by the outermost handler, can tell the user
+type request struct {
+	id     string
+	userID int64
+}
+
 // SetUserAuthAccessKey to the provided context.
 func SetUserAuthAccessKey(ctx context.Context, accessKey *auth.AccessKey) (context.Context, error) {
 	switch {
@@ -24,6 +38,10 @@ func SetUserAuthAccessKey(ctx context.Context, accessKey *auth.AccessKey) (conte
 		return nil, errors.New("access key is required")
 	}
 
+	if req, ok := ctx.Value(ctxRequest).(*request); ok {
+		req.userID = accessKey.UserID
+	}
+
 	return context.WithValue(ctx, ctxAuthoriseAccess, *accessKey), nil
 }
 
@@ -40,3 +58,83 @@ func GetUserAuthAccessKey(ctx context.Context) (auth.AccessKey, error) {
 
 	return userAuthoriseAccess, nil
 }
+
+// SetGuestCartID marks the provided context as the one of a guest, i.e. a visitor
+// who is not logged in, holding the token of the cart. cartID is 0 for a guest
+// who has no cart yet.
+func SetGuestCartID(ctx context.Context, cartID int64) context.Context {
+	return context.WithValue(ctx, ctxGuestCart, cartID)
+}
+
+// GetGuestCartID retrieved from the provided context, ok is false if the context
+// is not the one of a guest.
+func GetGuestCartID(ctx context.Context) (cartID int64, ok bool) {
+	cartID, ok = ctx.Value(ctxGuestCart).(int64)
+	return cartID, ok
+}
+
+// SetCartVersions sets the versions of the cart that the change requested in the
+// provided context expects, the change is refused if the cart is of none of them.
+func SetCartVersions(ctx context.Context, versions []int64) context.Context {
+	return context.WithValue(ctx, ctxCartVersion, versions)
+}
+
+// GetCartVersions retrieved from the provided context, ok is false if the change
+// expects no version.
+func GetCartVersions(ctx context.Context) (versions []int64, ok bool) {
+	versions, ok = ctx.Value(ctxCartVersion).([]int64)
+	return versions, ok
+}
+
+// SetTenant sets the tenant of the admin making the request in the provided context.
+func SetTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, ctxTenant, tenant)
+}
+
+// GetTenant retrieved from the provided context.
+func GetTenant(ctx context.Context) (string, error) {
+	tenant, ok := ctx.Value(ctxTenant).(string)
+	if !ok || tenant == "" {
+		return "", errors.New("tenant is not set on the context")
+	}
+
+	return tenant, nil
+}
+
+// SetRequestID sets the id of the request the provided context is of.
+func SetRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxRequest, &request{id: id})
+}
+
+// GetRequestID retrieved from the provided context, empty if the context is not
+// of a request.
+func GetRequestID(ctx context.Context) string {
+	req, ok := ctx.Value(ctxRequest).(*request)
+	if !ok {
+		return ""
+	}
+
+	return req.id
+}
+
+// GetRequestUserID is the user the request of the provided context has been
+// authorised for so far, 0 if none, e.g. for the guests.
+func GetRequestUserID(ctx context.Context) int64 {
+	req, ok := ctx.Value(ctxRequest).(*request)
+	if !ok {
+		return 0
+	}
+
+	return req.userID
+}
+
+// Logger logs with the id of the request of the provided context, if any, so
+// that the logs can be matched to the requests that caused them.
+func Logger(ctx context.Context) *log.Entry {
+	id := GetRequestID(ctx)
+	if id == "" {
+		return log.NewEntry(log.StandardLogger())
+	}
+
+	return log.WithField("request_id", id)
+}
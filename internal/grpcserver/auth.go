@@ -0,0 +1,72 @@
+package grpcserver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/handler"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthorisationMetadata is the metadata of the calls holding the access key of
+// the user, in the format of the Authorisation header, "Key {key}"
+const AuthorisationMetadata = "authorisation"
+
+// Authorise is the interceptor that verifies the access keys of the calls, the
+// access key is set on the context of the call like the handler does
+func Authorise(authClient handler.AuthProvider) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (interface{}, error) {
+		key := keyFromMetadata(ctx)
+		if key == "" {
+			return nil, status.Error(codes.Unauthenticated, "incorrect access_key")
+		}
+
+		accessKey, err := authClient.VerifyKey(ctx, key)
+		switch {
+		case err == auth.ErrUnavailable:
+			ctxutil.Logger(ctx).WithError(err).Errorf("grpcserver.auth: cannot verify access key %s", err)
+			return nil, status.Error(codes.Unavailable, "cannot verify access_key")
+		case err != nil:
+			return nil, status.Error(codes.Unauthenticated, "incorrect access_key")
+		}
+
+		ctx, err = ctxutil.SetUserAuthAccessKey(ctx, accessKey)
+		if err != nil {
+			ctxutil.Logger(ctx).Errorf("grpcserver.auth: could not set user(%d) access key in context %s", accessKey.UserID, err)
+			return nil, status.Error(codes.Internal, "")
+		}
+
+		return next(ctx, req)
+	}
+}
+
+// AddKeyToContext adds the access key to the outgoing metadata of the calls of a client
+func AddKeyToContext(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, AuthorisationMetadata, "Key "+key)
+}
+
+// keyFromMetadata gets the access key of the incoming metadata of the call, empty if not found
+func keyFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(AuthorisationMetadata)
+	if len(values) != 1 {
+		return ""
+	}
+
+	parts := strings.Fields(values[0])
+	if len(parts) != 2 || parts[0] != "Key" {
+		return ""
+	}
+
+	return parts[1]
+}
@@ -0,0 +1,97 @@
+package grpcserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/handler"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDMetadata is the metadata of the id of the call, it is taken from the
+// call if the client gives one and returned in the header of the response
+const RequestIDMetadata = "x-request-id"
+
+var (
+	callsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cart",
+			Name:      "grpc_calls_counter",
+			Help:      "Counter of the calls of cart grpc api by method and code",
+		}, []string{"method", "code"})
+
+	callDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "cart",
+			Name:      "grpc_call_duration_seconds",
+			Help:      "Duration of the calls of cart grpc api by method and code",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(callsCount, callDuration)
+}
+
+// Instrument is the interceptor that serves the calls with the id of the call in
+// their context, like the handler does for the requests. the rate, the errors
+// and the duration of the calls are recorded by their methods, which are only
+// the methods of the service, and the access log line of each call is written
+// once it is served
+func Instrument() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		id := handler.RequestID(requestIDFromMetadata(ctx))
+		ctx = ctxutil.SetRequestID(ctx, id)
+		if err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadata, id)); err != nil {
+			ctxutil.Logger(ctx).WithError(err).Errorf("grpcserver.instrument: cannot set request id header %s", err)
+		}
+
+		resp, err := next(ctx, req)
+
+		code := status.Code(err).String()
+		labels := prometheus.Labels{"method": info.FullMethod, "code": code}
+		callsCount.With(labels).Inc()
+		callDuration.With(labels).Observe(time.Since(start).Seconds())
+		logAccess(ctx, info.FullMethod, code, time.Since(start))
+
+		return resp, err
+	}
+}
+
+// requestIDFromMetadata gets the id of the call of the incoming metadata, empty
+// if not found
+func requestIDFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(RequestIDMetadata)
+	if len(values) != 1 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// logAccess writes the access log line of the call to the access log of the
+// handler, so that the requests and the calls are logged alike
+func logAccess(ctx context.Context, method, code string, latency time.Duration) {
+	fields := log.Fields{
+		"request_id": ctxutil.GetRequestID(ctx),
+		"method":     method,
+		"code":       code,
+		"latency_ms": float64(latency.Microseconds()) / 1000,
+	}
+	if userID := ctxutil.GetRequestUserID(ctx); userID != 0 {
+		fields["user_id"] = userID
+	}
+	handler.AccessLog.WithFields(fields).Info("access")
+}
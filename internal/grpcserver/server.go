@@ -0,0 +1,182 @@
+// Package grpcserver serves the carts over gRPC, alongside the REST api of the
+// handler. it calls the same service and verifies the same access keys
+package grpcserver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/api/cartpb"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/handler"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements cartpb.CartServiceServer by the service
+type Server struct {
+	cartpb.UnimplementedCartServiceServer
+	service handler.ServiceProvider
+}
+
+// New creates a new gRPC server of the carts, the calls are authorised by the
+// access keys the authClient verifies. each call is traced in a span whose
+// context is taken from the traceparent of its metadata, and is recorded and
+// logged by Instrument
+func New(service handler.ServiceProvider, authClient handler.AuthProvider) (*grpc.Server, error) {
+	switch {
+	case authClient == nil:
+		return nil, errors.New("auth client is required")
+	case service == nil:
+		return nil, errors.New("service is required")
+	}
+
+	s := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(Instrument(), Authorise(authClient)),
+	)
+	cartpb.RegisterCartServiceServer(s, &Server{service: service})
+	return s, nil
+}
+
+// CreateCart creates a cart, the open cart of the user is returned if the user has one
+func (s *Server) CreateCart(ctx context.Context, _ *cartpb.CreateCartRequest) (*cartpb.CreateCartResponse, error) {
+	userID, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.service.CreateCart(ctx, userID)
+	if err != nil {
+		return nil, toStatus(ctx, "CreateCart", err)
+	}
+
+	return &cartpb.CreateCartResponse{Cart: toCart(c)}, nil
+}
+
+// GetCart gets a cart with its items and totals
+func (s *Server) GetCart(ctx context.Context, req *cartpb.GetCartRequest) (*cartpb.GetCartResponse, error) {
+	userID, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := s.service.CartDetails(ctx, userID, req.GetCartId())
+	if err != nil {
+		return nil, toStatus(ctx, "GetCart", err)
+	}
+
+	items := make([]*cartpb.Item, len(details.Items))
+	for i, item := range details.Items {
+		items[i] = toItem(item)
+	}
+
+	return &cartpb.GetCartResponse{
+		Cart:          toCart(details.Cart),
+		Items:         items,
+		ItemCount:     details.ItemCount,
+		TotalQuantity: details.TotalQuantity,
+		Subtotal:      toMoney(details.Subtotal),
+	}, nil
+}
+
+// AddItem adds a product to a cart, a product that is already in the cart is
+// refused unless merge is set
+func (s *Server) AddItem(ctx context.Context, req *cartpb.AddItemRequest) (*cartpb.AddItemResponse, error) {
+	userID, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.GetProductId() < 1:
+		return nil, status.Error(codes.InvalidArgument, "product_id must be at least 1")
+	case req.GetQuantity() < 1:
+		return nil, status.Error(codes.InvalidArgument, "quantity must be at least 1")
+	case req.GetQuantity() > cart.MaxQuantity:
+		return nil, status.Errorf(codes.InvalidArgument, "quantity must be at most %d", cart.MaxQuantity)
+	}
+
+	item := &cart.Item{
+		ProductID: req.GetProductId(),
+		CartID:    req.GetCartId(),
+		Quantity:  req.GetQuantity(),
+	}
+	if _, err := s.service.AddItem(ctx, userID, item, req.GetMerge()); err != nil {
+		return nil, toStatus(ctx, "AddItem", err)
+	}
+
+	return &cartpb.AddItemResponse{Item: toItem(item)}, nil
+}
+
+// RemoveItem removes an item from its cart
+func (s *Server) RemoveItem(ctx context.Context, req *cartpb.RemoveItemRequest) (*cartpb.RemoveItemResponse, error) {
+	userID, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.service.RemoveItem(ctx, userID, req.GetItemId()); err != nil {
+		return nil, toStatus(ctx, "RemoveItem", err)
+	}
+
+	return &cartpb.RemoveItemResponse{}, nil
+}
+
+// EmptyCart removes every item of a cart
+func (s *Server) EmptyCart(ctx context.Context, req *cartpb.EmptyCartRequest) (*cartpb.EmptyCartResponse, error) {
+	userID, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.service.EmptyCart(ctx, userID, req.GetCartId()); err != nil {
+		return nil, toStatus(ctx, "EmptyCart", err)
+	}
+
+	return &cartpb.EmptyCartResponse{}, nil
+}
+
+// userID is the user of the access key the call is authorised by
+func userID(ctx context.Context) (int64, error) {
+	accessKey, err := ctxutil.GetUserAuthAccessKey(ctx)
+	if err != nil {
+		ctxutil.Logger(ctx).WithError(err).Errorf("grpcserver: cannot retrieve user from access key %s", err)
+		return 0, status.Error(codes.Internal, "cannot retrieve user from access key")
+	}
+
+	return accessKey.UserID, nil
+}
+
+var cartStatuses = map[cart.Status]cartpb.CartStatus{
+	cart.StatusOpen:       cartpb.CartStatus_CART_STATUS_OPEN,
+	cart.StatusCheckedOut: cartpb.CartStatus_CART_STATUS_CHECKED_OUT,
+	cart.StatusAbandoned:  cartpb.CartStatus_CART_STATUS_ABANDONED,
+}
+
+func toCart(c *cart.Cart) *cartpb.Cart {
+	return &cartpb.Cart{
+		Id:       c.ID,
+		UserId:   c.UserID,
+		Status:   cartStatuses[c.Status],
+		Currency: string(c.Currency),
+	}
+}
+
+func toItem(item *cart.Item) *cartpb.Item {
+	return &cartpb.Item{
+		Id:        item.ID,
+		ProductId: item.ProductID,
+		CartId:    item.CartID,
+		Quantity:  item.Quantity,
+		Price:     toMoney(item.Price),
+	}
+}
+
+func toMoney(m cart.Money) *cartpb.Money {
+	return &cartpb.Money{Amount: m.Amount, Currency: string(m.Currency)}
+}
@@ -0,0 +1,307 @@
+package grpcserver_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/api/cartpb"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/grpcserver"
+	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/product"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/storage/memory"
+	"github.com/cubny/cart/internal/tracing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// unavailableAuth is an auth service that is down
+type unavailableAuth struct{}
+
+func (unavailableAuth) VerifyKey(context.Context, string) (*auth.AccessKey, error) {
+	return nil, auth.ErrUnavailable
+}
+
+// newClient serves the grpc api of the service in memory and returns its client
+func newClient(t *testing.T, svc *service.Service, authClient handler.AuthProvider) cartpb.CartServiceClient {
+	t.Helper()
+
+	s, err := grpcserver.New(svc, authClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return cartpb.NewCartServiceClient(conn)
+}
+
+func newService(t *testing.T) *service.Service {
+	t.Helper()
+
+	svc, err := service.New(memory.New(), product.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestServer(t *testing.T) {
+	svc := newService(t)
+	client := newClient(t, svc, auth.New())
+	ctx := grpcserver.AddKeyToContext(context.Background(), "abcdef123456")
+
+	created, err := client.CreateCart(ctx, &cartpb.CreateCartRequest{})
+	assert.Nil(t, err)
+	c := created.GetCart()
+	assert.Equal(t, int64(1), c.GetUserId())
+	assert.Equal(t, cartpb.CartStatus_CART_STATUS_OPEN, c.GetStatus())
+	assert.Equal(t, string(cart.DefaultCurrency), c.GetCurrency())
+
+	// the open cart of the user is returned again
+	again, err := client.CreateCart(ctx, &cartpb.CreateCartRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, c.GetId(), again.GetCart().GetId())
+
+	added, err := client.AddItem(ctx, &cartpb.AddItemRequest{CartId: c.GetId(), ProductId: 1, Quantity: 2})
+	assert.Nil(t, err)
+	item := added.GetItem()
+	assert.NotZero(t, item.GetId())
+	assert.Equal(t, int64(2), item.GetQuantity())
+	assert.Equal(t, &cartpb.Money{Amount: 20000, Currency: "EUR"}, item.GetPrice())
+
+	merged, err := client.AddItem(ctx, &cartpb.AddItemRequest{CartId: c.GetId(), ProductId: 1, Quantity: 1, Merge: true})
+	assert.Nil(t, err)
+	assert.Equal(t, item.GetId(), merged.GetItem().GetId())
+	assert.Equal(t, int64(3), merged.GetItem().GetQuantity())
+
+	other, err := client.AddItem(ctx, &cartpb.AddItemRequest{CartId: c.GetId(), ProductId: 2, Quantity: 1})
+	assert.Nil(t, err)
+
+	details, err := client.GetCart(ctx, &cartpb.GetCartRequest{CartId: c.GetId()})
+	assert.Nil(t, err)
+	assert.Equal(t, c.GetId(), details.GetCart().GetId())
+	assert.Len(t, details.GetItems(), 2)
+	assert.Equal(t, int64(2), details.GetItemCount())
+	assert.Equal(t, int64(4), details.GetTotalQuantity())
+	assert.Equal(t, &cartpb.Money{Amount: 31000, Currency: "EUR"}, details.GetSubtotal())
+
+	_, err = client.RemoveItem(ctx, &cartpb.RemoveItemRequest{ItemId: other.GetItem().GetId()})
+	assert.Nil(t, err)
+	details, err = client.GetCart(ctx, &cartpb.GetCartRequest{CartId: c.GetId()})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), details.GetItemCount())
+
+	_, err = client.EmptyCart(ctx, &cartpb.EmptyCartRequest{CartId: c.GetId()})
+	assert.Nil(t, err)
+	details, err = client.GetCart(ctx, &cartpb.GetCartRequest{CartId: c.GetId()})
+	assert.Nil(t, err)
+	assert.Empty(t, details.GetItems())
+
+	// the carts of the others are not found
+	_, err = client.GetCart(grpcserver.AddKeyToContext(context.Background(), "bcdefg123456"), &cartpb.GetCartRequest{CartId: c.GetId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_Errors(t *testing.T) {
+	svc := newService(t)
+	client := newClient(t, svc, auth.New())
+	ctx := grpcserver.AddKeyToContext(context.Background(), "abcdef123456")
+
+	created, err := client.CreateCart(ctx, &cartpb.CreateCartRequest{})
+	assert.Nil(t, err)
+	cartID := created.GetCart().GetId()
+	_, err = client.AddItem(ctx, &cartpb.AddItemRequest{CartId: cartID, ProductId: 1, Quantity: 1})
+	assert.Nil(t, err)
+
+	abandoned, err := svc.CreateCart(context.Background(), 12)
+	assert.Nil(t, err)
+	_, err = svc.ChangeCartStatus(context.Background(), 12, abandoned.ID, cart.StatusAbandoned)
+	assert.Nil(t, err)
+
+	testCases := []struct {
+		name     string
+		ctx      context.Context
+		call     func(ctx context.Context) error
+		expected codes.Code
+	}{
+		{
+			name: "no access key",
+			ctx:  context.Background(),
+			call: func(ctx context.Context) error {
+				_, err := client.CreateCart(ctx, &cartpb.CreateCartRequest{})
+				return err
+			},
+			expected: codes.Unauthenticated,
+		},
+		{
+			name: "incorrect access key",
+			ctx:  grpcserver.AddKeyToContext(context.Background(), "incorrect"),
+			call: func(ctx context.Context) error {
+				_, err := client.CreateCart(ctx, &cartpb.CreateCartRequest{})
+				return err
+			},
+			expected: codes.Unauthenticated,
+		},
+		{
+			name: "cart not found",
+			ctx:  ctx,
+			call: func(ctx context.Context) error {
+				_, err := client.GetCart(ctx, &cartpb.GetCartRequest{CartId: 404})
+				return err
+			},
+			expected: codes.NotFound,
+		},
+		{
+			name: "item not found",
+			ctx:  ctx,
+			call: func(ctx context.Context) error {
+				_, err := client.RemoveItem(ctx, &cartpb.RemoveItemRequest{ItemId: 404})
+				return err
+			},
+			expected: codes.NotFound,
+		},
+		{
+			name: "product not found",
+			ctx:  ctx,
+			call: func(ctx context.Context) error {
+				_, err := client.AddItem(ctx, &cartpb.AddItemRequest{CartId: cartID, ProductId: 404, Quantity: 1})
+				return err
+			},
+			expected: codes.NotFound,
+		},
+		{
+			name: "product already in cart",
+			ctx:  ctx,
+			call: func(ctx context.Context) error {
+				_, err := client.AddItem(ctx, &cartpb.AddItemRequest{CartId: cartID, ProductId: 1, Quantity: 1})
+				return err
+			},
+			expected: codes.AlreadyExists,
+		},
+		{
+			name: "invalid quantity",
+			ctx:  ctx,
+			call: func(ctx context.Context) error {
+				_, err := client.AddItem(ctx, &cartpb.AddItemRequest{CartId: cartID, ProductId: 2})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "quantity too large",
+			ctx:  ctx,
+			call: func(ctx context.Context) error {
+				_, err := client.AddItem(ctx, &cartpb.AddItemRequest{CartId: cartID, ProductId: 2, Quantity: 10001})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "invalid product",
+			ctx:  ctx,
+			call: func(ctx context.Context) error {
+				_, err := client.AddItem(ctx, &cartpb.AddItemRequest{CartId: cartID, Quantity: 1})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "cart not open",
+			ctx:  grpcserver.AddKeyToContext(context.Background(), "bcdefg123456"),
+			call: func(ctx context.Context) error {
+				_, err := client.EmptyCart(ctx, &cartpb.EmptyCartRequest{CartId: abandoned.ID})
+				return err
+			},
+			expected: codes.FailedPrecondition,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, status.Code(tc.call(tc.ctx)))
+		})
+	}
+
+	t.Run("auth unavailable", func(t *testing.T) {
+		client := newClient(t, svc, unavailableAuth{})
+		_, err := client.GetCart(ctx, &cartpb.GetCartRequest{CartId: cartID})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestServer_Instrument(t *testing.T) {
+	spans := tracing.NewInMemoryExporter()
+	client := newClient(t, newService(t), auth.New())
+	accessLog := test.NewLocal(handler.AccessLog)
+
+	t.Run("request id of the client", func(t *testing.T) {
+		accessLog.Reset()
+		spans.Reset()
+
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+		ctx := grpcserver.AddKeyToContext(context.Background(), "abcdef123456")
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			grpcserver.RequestIDMetadata, "client-request-1",
+			"traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		var header metadata.MD
+		_, err := client.CreateCart(ctx, &cartpb.CreateCartRequest{}, grpc.Header(&header))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"client-request-1"}, header.Get(grpcserver.RequestIDMetadata))
+
+		if assert.Len(t, accessLog.AllEntries(), 1) {
+			entry := accessLog.LastEntry()
+			assert.Equal(t, "client-request-1", entry.Data["request_id"])
+			assert.Equal(t, cartpb.CartService_CreateCart_FullMethodName, entry.Data["method"])
+			assert.Equal(t, codes.OK.String(), entry.Data["code"])
+			assert.Equal(t, int64(1), entry.Data["user_id"])
+			assert.Contains(t, entry.Data, "latency_ms")
+		}
+
+		// the call is traced in the trace of the client, down to the service
+		names := map[string]bool{}
+		for _, span := range spans.GetSpans() {
+			assert.Equal(t, traceID, span.SpanContext.TraceID().String(), span.Name)
+			names[span.Name] = true
+		}
+		assert.True(t, names["cart.v1.CartService/CreateCart"])
+		assert.True(t, names["service.CreateCart"])
+	})
+
+	t.Run("new request id", func(t *testing.T) {
+		accessLog.Reset()
+
+		var header metadata.MD
+		_, err := client.CreateCart(context.Background(), &cartpb.CreateCartRequest{}, grpc.Header(&header))
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		id := header.Get(grpcserver.RequestIDMetadata)
+		if assert.Len(t, id, 1) {
+			assert.Len(t, id[0], 32)
+		}
+
+		if assert.Len(t, accessLog.AllEntries(), 1) {
+			entry := accessLog.LastEntry()
+			assert.Equal(t, id[0], entry.Data["request_id"])
+			assert.Equal(t, codes.Unauthenticated.String(), entry.Data["code"])
+			assert.NotContains(t, entry.Data, "user_id")
+		}
+	})
+}
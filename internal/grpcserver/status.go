@@ -0,0 +1,59 @@
+package grpcserver
+
+import (
+	"context"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcInternalCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cart",
+			Name:      "grpc_internal_error_counter",
+			Help:      "Counter of the calls of cart grpc api failed with an internal error",
+		}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(grpcInternalCount)
+}
+
+// toStatus maps the errors of the service to the status of the call, the errors
+// it does not know are internal
+func toStatus(ctx context.Context, method string, err error) error {
+	switch {
+	case err == service.ErrCartNotFound:
+		return status.Error(codes.NotFound, "cart does not exist")
+	case err == service.ErrItemNotFound:
+		return status.Error(codes.NotFound, "item does not exist")
+	case err == service.ErrProductNotFound:
+		return status.Error(codes.NotFound, "product does not exist")
+	case err == service.ErrProductAlreadyInCart:
+		return status.Error(codes.AlreadyExists, "an item with the same product exists in the cart")
+	case err == service.ErrCartNotOpen:
+		return status.Error(codes.FailedPrecondition, "cart is not open")
+	case err == service.ErrProductUnavailable:
+		return status.Error(codes.FailedPrecondition, "product is not purchasable")
+	case err == cart.ErrCurrencyMismatch:
+		return status.Error(codes.FailedPrecondition, "product is not priced in the currency of the cart")
+	case err == service.ErrCartVersionMismatch:
+		return status.Error(codes.Aborted, "cart has changed since it was read")
+	case err == cart.ErrAmountOverflow:
+		return status.Error(codes.OutOfRange, "price of the item is out of range")
+	case err == cart.ErrInvalidUserID:
+		return status.Error(codes.InvalidArgument, "user is invalid")
+	case err == cart.ErrInvalidQuantity:
+		return status.Error(codes.InvalidArgument, "quantity is invalid")
+	}
+
+	ctxutil.Logger(ctx).WithError(err).Errorf("grpcserver.%s: service %s", method, err)
+	grpcInternalCount.With(prometheus.Labels{"method": method}).Inc()
+	return status.Error(codes.Internal, "cannot serve the call")
+}
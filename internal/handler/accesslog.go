@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/cubny/cart/internal/ctxutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// RequestIDHeader is the header of the id of the request, it is taken from
+	// the request if the client gives one and returned in the response
+	RequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength is the length of the longest request id taken from a client
+	maxRequestIDLength = 128
+)
+
+// AccessLog writes a json line of each request served, its output can be
+// changed, e.g. to a file, before the handler is created
+var AccessLog = &log.Logger{
+	Out:       log.StandardLogger().Out,
+	Formatter: &log.JSONFormatter{},
+	Hooks:     make(log.LevelHooks),
+	Level:     log.InfoLevel,
+}
+
+// requestID is the id of the request given by the client, or a new one
+func requestID(r *http.Request) string {
+	return RequestID(r.Header.Get(RequestIDHeader))
+}
+
+// RequestID is the id given by a client, or a new one if the client gives none
+// or one that is not printable ascii or too long to be logged
+func RequestID(id string) string {
+	if id != "" && len(id) <= maxRequestIDLength && isPrintableASCII(id) {
+		return id
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// rand.Read never fails on the supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// logAccess writes the access log line of the request served under the route
+func logAccess(r *http.Request, route string, status int, bytes int64, latency time.Duration) {
+	fields := log.Fields{
+		"request_id": ctxutil.GetRequestID(r.Context()),
+		"route":      route,
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"status":     status,
+		"latency_ms": float64(latency.Microseconds()) / 1000,
+		"bytes":      bytes,
+	}
+	if userID := ctxutil.GetRequestUserID(r.Context()); userID != 0 {
+		fields["user_id"] = userID
+	}
+	AccessLog.WithFields(fields).Info("access")
+}
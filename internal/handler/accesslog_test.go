@@ -0,0 +1,145 @@
+package handler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/handler"
+
+	"github.com/golang/mock/gomock"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_AccessLog(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().CartDetails(gomock.Any(), int64(1), int64(500)).Return(nil, errors.New("storage is down")).AnyTimes()
+
+	h, err := handler.New(serviceMock, authMock, handler.Options{})
+	assert.Nil(t, err)
+
+	accessLog := test.NewLocal(handler.AccessLog)
+	errorLog := test.NewGlobal()
+
+	tcs := []struct {
+		name      string
+		target    string
+		requestID string
+		key       string
+		status    int
+		route     string
+		userID    interface{}
+	}{
+		{
+			name:      "request id of the client is kept",
+			target:    "/health",
+			requestID: "client-request-1",
+			status:    http.StatusOK,
+			route:     "/health",
+		},
+		{
+			name:      "request id with spaces is replaced",
+			target:    "/health",
+			requestID: "not an id",
+			status:    http.StatusOK,
+			route:     "/health",
+		},
+		{
+			name:      "request id that is too long is replaced",
+			target:    "/health",
+			requestID: strings.Repeat("a", 129),
+			status:    http.StatusOK,
+			route:     "/health",
+		},
+		{
+			name:   "user of the request is logged",
+			target: "/carts/500",
+			key:    "abc123456",
+			status: http.StatusInternalServerError,
+			route:  "/carts/:cartID",
+			userID: int64(1),
+		},
+		{
+			name:   "paths of no route are logged as unmatched",
+			target: "/no/such/route",
+			status: http.StatusNotFound,
+			route:  "unmatched",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			accessLog.Reset()
+			errorLog.Reset()
+
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.requestID != "" {
+				req.Header.Set(handler.RequestIDHeader, tc.requestID)
+			}
+			if tc.key != "" {
+				auth.AddKeyToRequest(req, tc.key)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tc.status, rec.Code)
+
+			id := rec.Header().Get(handler.RequestIDHeader)
+			switch {
+			case tc.requestID == "client-request-1":
+				assert.Equal(t, tc.requestID, id)
+			default:
+				assert.Len(t, id, 32)
+			}
+
+			if assert.Len(t, accessLog.AllEntries(), 1) {
+				entry := accessLog.LastEntry()
+				assert.Equal(t, id, entry.Data["request_id"])
+				assert.Equal(t, tc.route, entry.Data["route"])
+				assert.Equal(t, http.MethodGet, entry.Data["method"])
+				assert.Equal(t, tc.status, entry.Data["status"])
+				assert.Equal(t, tc.userID, entry.Data["user_id"])
+				assert.Equal(t, int64(rec.Body.Len()), entry.Data["bytes"])
+				assert.Contains(t, entry.Data, "latency_ms")
+			}
+
+			// the errors logged while serving the request carry its id
+			for _, entry := range errorLog.AllEntries() {
+				assert.Equal(t, log.ErrorLevel, entry.Level)
+				assert.Equal(t, id, entry.Data["request_id"])
+			}
+			if tc.status == http.StatusInternalServerError {
+				assert.NotEmpty(t, errorLog.AllEntries())
+			}
+		})
+	}
+}
+
+func TestHandler_AccessLog_JSON(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	h, err := handler.New(handler.NewMockServiceProvider(ctrl), handler.NewMockAuthProvider(ctrl), handler.Options{})
+	assert.Nil(t, err)
+
+	var out strings.Builder
+	defer func() { handler.AccessLog.Out = log.StandardLogger().Out }()
+	handler.AccessLog.Out = &out
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(handler.RequestIDHeader, "client-request-2")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Regexp(t, `^\{"bytes":2,"latency_ms":[0-9.e-]+,"level":"info","method":"GET","msg":"access","path":"/health","request_id":"client-request-2","route":"/health","status":200,"time":"[^"]+"\}\n$`, out.String())
+}
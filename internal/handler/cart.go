@@ -7,100 +7,323 @@ import (
 	"strconv"
 
 	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
 	"github.com/cubny/cart/internal/ctxutil"
 	"github.com/cubny/cart/internal/jsonerror"
 	"github.com/cubny/cart/internal/service"
 
 	"github.com/julienschmidt/httprouter"
-	log "github.com/sirupsen/logrus"
 )
 
 // createCart is the handler for
 // POST /carts/
 func (h *Handler) createCart(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	accessKey, err := ctxutil.GetUserAuthAccessKey(r.Context())
+	userID, err := requestUserID(r.Context())
 	if err != nil {
-		log.WithError(err).Errorf("createCart: getUserAuthAccessKey %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("createCart: requestUserID %s", err)
 		api500Count.With(prometheus.Labels{"method": "createCart", "reason": "context"}).Inc()
-		_ = jsonerror.InternalError(w, "cannot retrieve user from accessKey")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
 		return
 	}
 
-	c, err := h.service.CreateCart(r.Context(), accessKey.UserID)
+	c, err := h.service.CreateCart(r.Context(), userID)
 	switch {
 	case err == cart.ErrInvalidUserID:
-		_ = jsonerror.InvalidParams(w, "user is invalid")
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "user is invalid", jsonerror.Extensions{"param": "user_id"})
 		return
 	case err != nil:
-		log.WithError(err).Errorf("createCart: service %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("createCart: service %s", err)
 		api500Count.With(prometheus.Labels{"method": "createCart", "reason": "service"}).Inc()
-		_ = jsonerror.InternalError(w, "cannot create cart")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot create cart", nil)
 		return
 	}
 
+	// the guest proves it owns the cart by its token from now on
+	if c.IsGuest() && h.cartTokens != nil {
+		w.Header().Set(auth.CartTokenHeader, h.cartTokens.IssueCartToken(c.ID))
+	}
+
+	w.Header().Set("ETag", cartETag(c.Version))
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(c); err != nil {
-		log.WithError(err).Errorf("createCart: encoder %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("createCart: encoder %s", err)
 		api500Count.With(prometheus.Labels{"method": "createCart", "reason": "encoder"}).Inc()
-		_ = jsonerror.InternalError(w, "cannot encode response")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// getCart is the handler for
+// GET /carts/:cartID
+func (h *Handler) getCart(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	userID, err := requestUserID(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getCart: requestUserID %s", err)
+		api500Count.With(prometheus.Labels{"method": "getCart", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
+		return
+	}
+
+	cartID, err := strconv.Atoi(p.ByName("cartID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "cart_id param is not a valid number", jsonerror.Extensions{"param": "cart_id"})
+		return
+	}
+
+	details, err := h.service.CartDetails(r.Context(), userID, int64(cartID))
+	switch {
+	case err == service.ErrCartNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getCart: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "getCart", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not get cart details", nil)
+		return
+	}
+
+	w.Header().Set("ETag", cartETag(details.Version))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getCart: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "getCart", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// changeCartStatusRequest is the body of PATCH /carts/:cartID
+type changeCartStatusRequest struct {
+	Status cart.Status `json:"status" validate:"required,oneof=open checked_out abandoned"`
+}
+
+// changeCartStatus is the handler for
+// PATCH /carts/:cartID
+func (h *Handler) changeCartStatus(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	accessKey, err := ctxutil.GetUserAuthAccessKey(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("changeCartStatus: GetUserAuthAccessKey %s", err)
+		api500Count.With(prometheus.Labels{"method": "changeCartStatus", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
+		return
+	}
+
+	cartID, err := strconv.Atoi(p.ByName("cartID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "cart_id param is not a valid number", jsonerror.Extensions{"param": "cart_id"})
+		return
+	}
+
+	statusReq := &changeCartStatusRequest{}
+	if !decodeBody(w, r, statusReq, false) {
+		return
+	}
+
+	c, err := h.service.ChangeCartStatus(r.Context(), accessKey.UserID, int64(cartID), statusReq.Status)
+	switch {
+	case err == service.ErrCartNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == service.ErrCartVersionMismatch:
+		_ = jsonerror.Write(w, r, jsonerror.CartVersionMismatch, "cart has changed since it was read", nil)
+		return
+	case err == cart.ErrInvalidStatus:
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "status is not valid", jsonerror.Extensions{"param": "status"})
+		return
+	case err == cart.ErrInvalidStatusTransition:
+		_ = jsonerror.Write(w, r, jsonerror.InvalidStatusTransition, "cart cannot move to the requested status", jsonerror.Extensions{"cart_id": cartID, "status": statusReq.Status})
+		return
+	case err == service.ErrOpenCartExists:
+		_ = jsonerror.Write(w, r, jsonerror.OpenCartExists, "user already has an open cart", nil)
+		return
+	case err == service.ErrCheckoutRequired:
+		_ = jsonerror.Write(w, r, jsonerror.CheckoutRequired, "use checkout to check out a cart", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("changeCartStatus: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "changeCartStatus", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not change cart status", nil)
 		return
 	}
+
+	w.Header().Set("ETag", cartETag(c.Version))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("changeCartStatus: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "changeCartStatus", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// addItemRequest is the body of POST /carts/:cartID/items. the price is set by
+// the product catalog, a price sent by the client is ignored, it is accepted for
+// the clients that send it still
+type addItemRequest struct {
+	ProductID *int64      `json:"product_id" validate:"required,min=1"`
+	Quantity  *int64      `json:"quantity" validate:"required,min=1,max=maxQuantity"`
+	Merge     bool        `json:"merge"`
+	Price     *cart.Money `json:"price" validate:"min=0"`
 }
 
 // addItem is the handler for
 // POST /cart/:cartID/items
+// a product that is already in the cart is refused unless merge is requested,
+// either by "merge": true in the body or by the merge=true query parameter
 func (h *Handler) addItem(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	accessKey, err := ctxutil.GetUserAuthAccessKey(r.Context())
+	userID, err := requestUserID(r.Context())
 	if err != nil {
-		log.WithError(err).Errorf("addItem: GetUserAuthAccessKey %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("addItem: requestUserID %s", err)
 		api500Count.With(prometheus.Labels{"method": "addItem", "reason": "context"}).Inc()
-		_ = jsonerror.InternalError(w, "cannot retrieve user from accessKey")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
 		return
 	}
 
 	cartID, err := strconv.Atoi(p.ByName("cartID"))
 	if err != nil {
-		_ = jsonerror.InvalidParams(w, "cart_id param is not a valid number")
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "cart_id param is not a valid number", jsonerror.Extensions{"param": "cart_id"})
 		return
 	}
 
-	itemReq := &struct {
-		ProductID int64   `json:"product_id"`
-		Price     float64 `json:"price"`
-		Quantity  int64   `json:"quantity"`
-	}{}
-	if err := json.NewDecoder(r.Body).Decode(itemReq); err != nil {
-		_ = jsonerror.BadRequest(w, "body has invalid json format")
+	itemReq := &addItemRequest{}
+	if !decodeBody(w, r, itemReq, false) {
 		return
 	}
 
+	merge := itemReq.Merge
+	if q := r.URL.Query().Get("merge"); q != "" {
+		merge, err = strconv.ParseBool(q)
+		if err != nil {
+			_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "merge param is not a valid boolean", jsonerror.Extensions{"param": "merge"})
+			return
+		}
+	}
+
 	item := &cart.Item{
-		ProductID: itemReq.ProductID,
+		ProductID: *itemReq.ProductID,
 		CartID:    int64(cartID),
-		Quantity:  itemReq.Quantity,
-		Price:     cart.Price(itemReq.Price),
+		Quantity:  *itemReq.Quantity,
 	}
 
-	err = h.service.AddItem(r.Context(), accessKey.UserID, item)
+	c, err := h.service.AddItem(r.Context(), userID, item, merge)
 	switch {
 	case err == service.ErrCartNotFound:
-		_ = jsonerror.NotFound(w, "cart does not exist")
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == service.ErrCartVersionMismatch:
+		_ = jsonerror.Write(w, r, jsonerror.CartVersionMismatch, "cart has changed since it was read", nil)
 		return
 	case err == service.ErrProductAlreadyInCart:
-		_ = jsonerror.BadRequest(w, "an item with the same product exists in the cart")
+		_ = jsonerror.Write(w, r, jsonerror.ProductAlreadyInCart, "an item with the same product exists in the cart", jsonerror.Extensions{"cart_id": cartID, "product_id": item.ProductID})
+		return
+	case err == service.ErrCartNotOpen:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotOpen, "cart is not open", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == service.ErrProductNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.ProductNotFound, "product does not exist", jsonerror.Extensions{"product_id": item.ProductID})
+		return
+	case err == service.ErrProductUnavailable:
+		_ = jsonerror.Write(w, r, jsonerror.ProductUnavailable, "product is not purchasable", jsonerror.Extensions{"product_id": item.ProductID})
+		return
+	case err == cart.ErrCurrencyMismatch:
+		_ = jsonerror.Write(w, r, jsonerror.CurrencyMismatch, "product is not priced in the currency of the cart", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == cart.ErrAmountOverflow:
+		_ = jsonerror.Write(w, r, jsonerror.AmountOutOfRange, "price of the item is out of range", jsonerror.Extensions{"cart_id": cartID})
 		return
 	case err != nil:
-		log.WithError(err).Errorf("addItem: service %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("addItem: service %s", err)
 		api500Count.With(prometheus.Labels{"method": "addItem", "reason": "service"}).Inc()
-		_ = jsonerror.InternalError(w, "could not add item to cart")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not add item to cart", nil)
 		return
 	}
 
+	w.Header().Set("ETag", cartETag(c.Version))
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(item); err != nil {
-		log.WithError(err).Errorf("addItem: encoder %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("addItem: encoder %s", err)
 		api500Count.With(prometheus.Labels{"method": "addItem", "reason": "encoder"}).Inc()
-		_ = jsonerror.InternalError(w, "cannot decode item")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot decode item", nil)
+		return
+	}
+}
+
+// updateItemRequest is the body of PATCH /items/:itemID, quantity 0 removes the item
+type updateItemRequest struct {
+	Quantity *int64 `json:"quantity" validate:"required,min=0,max=maxQuantity"`
+}
+
+// updateItem is the handler for
+// PATCH /items/:itemID
+func (h *Handler) updateItem(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	userID, err := requestUserID(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("updateItem: requestUserID %s", err)
+		api500Count.With(prometheus.Labels{"method": "updateItem", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
+		return
+	}
+
+	itemID, err := strconv.Atoi(p.ByName("itemID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "item_id param is not a valid number", jsonerror.Extensions{"param": "item_id"})
+		return
+	}
+
+	itemReq := &updateItemRequest{}
+	if !decodeBody(w, r, itemReq, false) {
+		return
+	}
+
+	item, c, err := h.service.UpdateItem(r.Context(), userID, int64(itemID), *itemReq.Quantity)
+	switch {
+	case err == service.ErrItemNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.ItemNotFound, "item does not exist", jsonerror.Extensions{"item_id": itemID})
+		return
+	case err == service.ErrCartNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", nil)
+		return
+	case err == service.ErrCartVersionMismatch:
+		_ = jsonerror.Write(w, r, jsonerror.CartVersionMismatch, "cart has changed since it was read", nil)
+		return
+	case err == service.ErrCartNotOpen:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotOpen, "cart is not open", nil)
+		return
+	case err == service.ErrProductNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.ProductNotFound, "product does not exist", jsonerror.Extensions{"item_id": itemID})
+		return
+	case err == service.ErrProductUnavailable:
+		_ = jsonerror.Write(w, r, jsonerror.ProductUnavailable, "product is not purchasable", jsonerror.Extensions{"item_id": itemID})
+		return
+	case err == cart.ErrCurrencyMismatch:
+		_ = jsonerror.Write(w, r, jsonerror.CurrencyMismatch, "product is not priced in the currency of the cart", jsonerror.Extensions{"item_id": itemID})
+		return
+	case err == cart.ErrInvalidQuantity:
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "quantity must not be negative", jsonerror.Extensions{"param": "quantity"})
+		return
+	case err == cart.ErrAmountOverflow:
+		_ = jsonerror.Write(w, r, jsonerror.AmountOutOfRange, "price of the item is out of range", nil)
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("updateItem: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "updateItem", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not update item", nil)
+		return
+	}
+
+	w.Header().Set("ETag", cartETag(c.Version))
+
+	// quantity 0 removed the item
+	if item == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("updateItem: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "updateItem", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
 		return
 	}
 }
@@ -108,34 +331,42 @@ func (h *Handler) addItem(w http.ResponseWriter, r *http.Request, p httprouter.P
 // removeItem is the handler for
 // DELETE /items/:itemID
 func (h *Handler) removeItem(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	accessKey, err := ctxutil.GetUserAuthAccessKey(r.Context())
+	userID, err := requestUserID(r.Context())
 	if err != nil {
-		log.WithError(err).Errorf("removeItem: GetUserAuthAccessKey %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("removeItem: requestUserID %s", err)
 		api500Count.With(prometheus.Labels{"method": "removeItem", "reason": "context"}).Inc()
-		_ = jsonerror.InternalError(w, "cannot retrieve user from accessKey")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
 		return
 	}
 
 	itemID, err := strconv.Atoi(p.ByName("itemID"))
 	if err != nil {
-		_ = jsonerror.InvalidParams(w, "item_id param is not a valid number")
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "item_id param is not a valid number", jsonerror.Extensions{"param": "item_id"})
 		return
 	}
 
-	err = h.service.RemoveItem(r.Context(), accessKey.UserID, int64(itemID))
+	c, err := h.service.RemoveItem(r.Context(), userID, int64(itemID))
 	switch {
 	case err == service.ErrItemNotFound:
-		_ = jsonerror.NotFound(w, "item does not exist")
+		_ = jsonerror.Write(w, r, jsonerror.ItemNotFound, "item does not exist", jsonerror.Extensions{"item_id": itemID})
 		return
 	case err == service.ErrCartNotFound:
-		_ = jsonerror.NotFound(w, "cart does not exist")
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", nil)
+		return
+	case err == service.ErrCartVersionMismatch:
+		_ = jsonerror.Write(w, r, jsonerror.CartVersionMismatch, "cart has changed since it was read", nil)
+		return
+	case err == service.ErrCartNotOpen:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotOpen, "cart is not open", nil)
 		return
 	case err != nil:
-		log.WithError(err).Errorf("removeItem: service %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("removeItem: service %s", err)
 		api500Count.With(prometheus.Labels{"method": "removeItem", "reason": "service"}).Inc()
-		_ = jsonerror.InternalError(w, "could not remove item")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not remove item", nil)
+		return
 	}
 
+	w.Header().Set("ETag", cartETag(c.Version))
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -147,31 +378,38 @@ func (h *Handler) emptyCart(w http.ResponseWriter, r *http.Request, p httprouter
 	// like /carts/:cartID/empty (which does not comply with RESTful verbs fully,
 	// although it is fine) but I find the following more readable and continent
 	// DELETE all items of this resource
-	accessKey, err := ctxutil.GetUserAuthAccessKey(r.Context())
+	userID, err := requestUserID(r.Context())
 	if err != nil {
-		log.WithError(err).Errorf("emptyCart: GetUserAuthAccessKey %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("emptyCart: requestUserID %s", err)
 		api500Count.With(prometheus.Labels{"method": "emptyCart", "reason": "context"}).Inc()
-		_ = jsonerror.InternalError(w, "cannot retrieve user from accessKey")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
 		return
 	}
 
 	cartID, err := strconv.Atoi(p.ByName("cartID"))
 	if err != nil {
-		_ = jsonerror.InvalidParams(w, "cart_id param is not a valid number")
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "cart_id param is not a valid number", jsonerror.Extensions{"param": "cart_id"})
 		return
 	}
 
-	err = h.service.EmptyCart(r.Context(), accessKey.UserID, int64(cartID))
+	c, err := h.service.EmptyCart(r.Context(), userID, int64(cartID))
 	switch {
 	case err == service.ErrCartNotFound:
-		_ = jsonerror.NotFound(w, "cart does not exist")
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == service.ErrCartVersionMismatch:
+		_ = jsonerror.Write(w, r, jsonerror.CartVersionMismatch, "cart has changed since it was read", nil)
+		return
+	case err == service.ErrCartNotOpen:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotOpen, "cart is not open", jsonerror.Extensions{"cart_id": cartID})
 		return
 	case err != nil:
-		log.WithError(err).Errorf("emptyCart: service %s", err)
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("emptyCart: service %s", err)
 		api500Count.With(prometheus.Labels{"method": "emptyCart", "reason": "service"}).Inc()
-		_ = jsonerror.InternalError(w, "could not empty cart")
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not empty cart", nil)
 		return
 	}
 
+	w.Header().Set("ETag", cartETag(c.Version))
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -1,8 +1,11 @@
 package handler_test
 
 import (
+	"context"
+
 	"github.com/cubny/cart"
 	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/ctxutil"
 	"github.com/cubny/cart/internal/handler"
 	"github.com/cubny/cart/internal/service"
 	"github.com/cubny/cart/internal/tests"
@@ -24,11 +27,16 @@ func TestHandler_CreateCart(t *testing.T) {
 	authMock.EXPECT().
 		VerifyAccessKey(gomock.Any(), "unauthorised").
 		Return(nil, auth.ErrNotFound).AnyTimes()
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "unavailable").
+		Return(nil, auth.ErrUnavailable).AnyTimes()
 
 	serviceMock := handler.NewMockServiceProvider(ctrl)
 	serviceMock.EXPECT().CreateCart(gomock.Any(), int64(1)).Return(&cart.Cart{
-		ID:     1,
-		UserID: 1,
+		ID:       1,
+		UserID:   1,
+		Status:   cart.StatusOpen,
+		Currency: "EUR",
 	}, nil)
 
 	tests := []tests.TestCase{
@@ -37,7 +45,7 @@ func TestHandler_CreateCart(t *testing.T) {
 			Method:         http.MethodPost,
 			Target:         "/carts",
 			AccessKey:      "abc123456",
-			ExpectedBody:   `{"id":1, "user_id":1}`,
+			ExpectedBody:   `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`,
 			ExpectedStatus: http.StatusCreated,
 		},
 		{
@@ -48,6 +56,14 @@ func TestHandler_CreateCart(t *testing.T) {
 			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
 			ExpectedStatus: http.StatusUnauthorized,
 		},
+		{
+			Name:           "auth service is unavailable - error",
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			AccessKey:      "unavailable",
+			ExpectedBody:   `{"error":{"code":100503, "details": "Service unavailable - cannot verify access_key"}}`,
+			ExpectedStatus: http.StatusServiceUnavailable,
+		},
 	}
 
 	execHTTPTestCases(t, serviceMock, authMock, tests)
@@ -69,46 +85,300 @@ func TestHandler_AddItem(t *testing.T) {
 		CartID:    int64(1),
 		ProductID: int64(1),
 		Quantity:  int64(1),
-		Price:     cart.Price(100.00),
 	}
 	secondItem := &cart.Item{
 		CartID:    int64(2),
 		ProductID: int64(1),
 		Quantity:  int64(1),
-		Price:     cart.Price(100.00),
+	}
+	thirdItem := &cart.Item{
+		CartID:    int64(3),
+		ProductID: int64(1),
+		Quantity:  int64(1),
 	}
 	serviceMock := handler.NewMockServiceProvider(ctrl)
 	serviceMock.EXPECT().
-		AddItem(gomock.Any(), int64(1), firstItem).
-		Return(nil)
+		AddItem(gomock.Any(), int64(1), firstItem, false).
+		DoAndReturn(func(_ context.Context, _ int64, item *cart.Item, _ bool) (*cart.Cart, error) {
+			item.Price = cart.NewMoney(10000, "EUR")
+			return &cart.Cart{ID: 1, Version: 2}, nil
+		}).Times(2)
 	serviceMock.EXPECT().
-		AddItem(gomock.Any(), int64(1), secondItem).
-		Return(service.ErrCartNotFound)
+		AddItem(gomock.Any(), int64(1), secondItem, false).
+		Return(nil, service.ErrCartNotFound)
+	serviceMock.EXPECT().
+		AddItem(gomock.Any(), int64(1), &cart.Item{CartID: int64(1), ProductID: int64(7), Quantity: int64(1)}, false).
+		Return(nil, cart.ErrCurrencyMismatch)
+	serviceMock.EXPECT().
+		AddItem(gomock.Any(), int64(1), &cart.Item{CartID: int64(1), ProductID: int64(8), Quantity: int64(1)}, false).
+		Return(nil, service.ErrProductUnavailable)
+	serviceMock.EXPECT().
+		AddItem(gomock.Any(), int64(1), &cart.Item{CartID: int64(1), ProductID: int64(9), Quantity: int64(1)}, false).
+		Return(nil, service.ErrProductNotFound)
+	serviceMock.EXPECT().
+		AddItem(gomock.Any(), int64(1), &cart.Item{CartID: int64(1), ProductID: int64(10), Quantity: int64(9000)}, false).
+		Return(nil, cart.ErrAmountOverflow)
+	serviceMock.EXPECT().
+		AddItem(gomock.Any(), int64(1), thirdItem, false).
+		Return(nil, service.ErrProductAlreadyInCart)
+	serviceMock.EXPECT().
+		AddItem(gomock.Any(), int64(1), thirdItem, true).
+		DoAndReturn(func(_ context.Context, _ int64, item *cart.Item, _ bool) (*cart.Cart, error) {
+			item.ID = 1
+			item.Quantity = 2
+			item.Price = cart.NewMoney(20000, "EUR")
+			return &cart.Cart{ID: 3, Version: 3}, nil
+		}).Times(2)
 
 	testsCases := []tests.TestCase{
 		{
-			Name:           "ok",
+			Name:            "ok",
+			Method:          http.MethodPost,
+			Target:          "/carts/1/items",
+			AccessKey:       "abc123456",
+			ReqBody:         `{"product_id":1, "quantity":1, "price": 100.00}`,
+			ExpectedBody:    `{"cart_id":1, "id":0, "price":100, "product_id":1, "quantity":1}`,
+			ExpectedHeaders: map[string]string{"ETag": `"2"`},
+			ExpectedStatus:  http.StatusCreated,
+		},
+		{
+			Name:           "cart does not exist - error",
 			Method:         http.MethodPost,
-			Target:         "/carts/1/items",
+			Target:         "/carts/2/items",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":1, "quantity":1, "price": 100.00}`,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "product already in cart - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/3/items",
 			AccessKey:      "abc123456",
 			ReqBody:        `{"product_id":1, "quantity":1, "price": 100.00}`,
+			ExpectedBody:   `{"error":{"code":100400, "details":"Bad Request - an item with the same product exists in the cart"}}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "product already in cart with merge in body - merged",
+			Method:         http.MethodPost,
+			Target:         "/carts/3/items",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":1, "quantity":1, "price": 100.00, "merge": true}`,
+			ExpectedBody:   `{"cart_id":3, "id":1, "price":200, "product_id":1, "quantity":2}`,
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "product already in cart with merge query param - merged",
+			Method:         http.MethodPost,
+			Target:         "/carts/3/items?merge=true",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":1, "quantity":1, "price": 100.00}`,
+			ExpectedBody:   `{"cart_id":3, "id":1, "price":200, "product_id":1, "quantity":2}`,
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "price of the client is ignored - ok",
+			Method:         http.MethodPost,
+			Target:         "/carts/1/items",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":1, "quantity":1, "price": "0.01"}`,
 			ExpectedBody:   `{"cart_id":1, "id":0, "price":100, "product_id":1, "quantity":1}`,
 			ExpectedStatus: http.StatusCreated,
 		},
 		{
-			Name:           "cart does not exist - error",
+			Name:           "product priced in another currency - error",
 			Method:         http.MethodPost,
-			Target:         "/carts/2/items",
+			Target:         "/carts/1/items",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":7, "quantity":1}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - product is not priced in the currency of the cart"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "price out of range - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/1/items",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":10, "quantity":9000}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - price of the item is out of range"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "product is not purchasable - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/1/items",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":8, "quantity":1}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - product is not purchasable"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "product does not exist - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/1/items",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":9, "quantity":1}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - product does not exist"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "invalid merge query param - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/3/items?merge=maybe",
 			AccessKey:      "abc123456",
 			ReqBody:        `{"product_id":1, "quantity":1, "price": 100.00}`,
-			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
-			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - merge param is not a valid boolean"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:      "zero quantity - 422",
+			Method:    http.MethodPost,
+			Target:    "/carts/1/items",
+			AccessKey: "abc123456",
+			ReqBody:   `{"product_id":1, "quantity":0}`,
+			ExpectedBody: `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[
+				{"field":"quantity", "rule":"min", "message":"quantity must be at least 1"}
+			]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:      "quantity too large - 422",
+			Method:    http.MethodPost,
+			Target:    "/carts/1/items",
+			AccessKey: "abc123456",
+			ReqBody:   `{"product_id":1, "quantity":10001}`,
+			ExpectedBody: `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[
+				{"field":"quantity", "rule":"max", "message":"quantity must be at most 10000"}
+			]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:      "every violation at once - 422",
+			Method:    http.MethodPost,
+			Target:    "/carts/1/items",
+			AccessKey: "abc123456",
+			ReqBody:   `{"product_id":0, "quantity":-1, "price":-1, "colour":"red"}`,
+			ExpectedBody: `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[
+				{"field":"colour", "rule":"unknown", "message":"colour is not a known field"},
+				{"field":"product_id", "rule":"min", "message":"product_id must be at least 1"},
+				{"field":"quantity", "rule":"min", "message":"quantity must be at least 1"},
+				{"field":"price", "rule":"min", "message":"price must be at least 0"}
+			]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:      "missing fields and wrong types - 422",
+			Method:    http.MethodPost,
+			Target:    "/carts/1/items",
+			AccessKey: "abc123456",
+			ReqBody:   `{"merge":"yes"}`,
+			ExpectedBody: `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[
+				{"field":"merge", "rule":"type", "message":"merge is not a valid boolean"},
+				{"field":"product_id", "rule":"required", "message":"product_id is required"},
+				{"field":"quantity", "rule":"required", "message":"quantity is required"}
+			]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "malformed body - 400",
+			Method:         http.MethodPost,
+			Target:         "/carts/1/items",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"product_id":1,`,
+			ExpectedBody:   `{"error":{"code":100400, "details":"Bad Request - body has invalid json format"}}`,
+			ExpectedStatus: http.StatusBadRequest,
 		},
 	}
 
 	execHTTPTestCases(t, serviceMock, authMock, testsCases)
 }
 
+func TestHandler_UpdateItem(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().UpdateItem(gomock.Any(), int64(1), int64(1), int64(3)).
+		Return(&cart.Item{ID: 1, CartID: 1, ProductID: 1, Quantity: 3, Price: cart.NewMoney(3000, "EUR")}, &cart.Cart{ID: 1, Version: 5}, nil)
+	serviceMock.EXPECT().UpdateItem(gomock.Any(), int64(1), int64(1), int64(0)).Return(nil, &cart.Cart{ID: 1, Version: 6}, nil)
+	serviceMock.EXPECT().UpdateItem(gomock.Any(), int64(1), int64(2), int64(3)).Return(nil, nil, service.ErrItemNotFound)
+	serviceMock.EXPECT().UpdateItem(gomock.Any(), int64(1), int64(3), int64(3)).Return(nil, nil, service.ErrCartNotOpen)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:            "ok - 200",
+			Method:          http.MethodPatch,
+			Target:          "/items/1",
+			AccessKey:       "abc123456",
+			ReqBody:         `{"quantity":3}`,
+			ExpectedBody:    `{"id":1, "cart_id":1, "product_id":1, "quantity":3, "price":30}`,
+			ExpectedHeaders: map[string]string{"ETag": `"5"`},
+			ExpectedStatus:  http.StatusOK,
+		},
+		{
+			Name:            "quantity 0 removes the item - 204",
+			Method:          http.MethodPatch,
+			Target:          "/items/1",
+			AccessKey:       "abc123456",
+			ReqBody:         `{"quantity":0}`,
+			ExpectedHeaders: map[string]string{"ETag": `"6"`},
+			ExpectedStatus:  http.StatusNoContent,
+		},
+		{
+			Name:           "item not found - 404",
+			Method:         http.MethodPatch,
+			Target:         "/items/2",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"quantity":3}`,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - item does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "cart not open - 409",
+			Method:         http.MethodPatch,
+			Target:         "/items/3",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"quantity":3}`,
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart is not open"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+		{
+			Name:           "negative quantity - 422",
+			Method:         http.MethodPatch,
+			Target:         "/items/1",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"quantity":-1}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[{"field":"quantity", "rule":"min", "message":"quantity must be at least 0"}]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "quantity too large - 422",
+			Method:         http.MethodPatch,
+			Target:         "/items/1",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"quantity":10001}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[{"field":"quantity", "rule":"max", "message":"quantity must be at most 10000"}]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "missing quantity - 422",
+			Method:         http.MethodPatch,
+			Target:         "/items/1",
+			AccessKey:      "abc123456",
+			ReqBody:        `{}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[{"field":"quantity", "rule":"required", "message":"quantity is required"}]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+	execHTTPTestCases(t, serviceMock, authMock, testsCases)
+}
+
 func TestHandler_RemoveItem(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -122,16 +392,17 @@ func TestHandler_RemoveItem(t *testing.T) {
 		Return(nil, auth.ErrNotFound).AnyTimes()
 
 	serviceMock := handler.NewMockServiceProvider(ctrl)
-	serviceMock.EXPECT().RemoveItem(gomock.Any(), int64(1), int64(1)).Return(nil)
-	serviceMock.EXPECT().RemoveItem(gomock.Any(), int64(1), int64(2)).Return(service.ErrItemNotFound)
+	serviceMock.EXPECT().RemoveItem(gomock.Any(), int64(1), int64(1)).Return(&cart.Cart{ID: 1, Version: 7}, nil)
+	serviceMock.EXPECT().RemoveItem(gomock.Any(), int64(1), int64(2)).Return(nil, service.ErrItemNotFound)
 
 	testsCases := []tests.TestCase{
 		{
-			Name:           "ok",
-			Method:         http.MethodDelete,
-			Target:         "/items/1",
-			AccessKey:      "abc123456",
-			ExpectedStatus: http.StatusNoContent,
+			Name:            "ok",
+			Method:          http.MethodDelete,
+			Target:          "/items/1",
+			AccessKey:       "abc123456",
+			ExpectedHeaders: map[string]string{"ETag": `"7"`},
+			ExpectedStatus:  http.StatusNoContent,
 		},
 		{
 			Name:           "item not found",
@@ -159,17 +430,19 @@ func TestHandler_EmptyCart(t *testing.T) {
 		Return(nil, auth.ErrNotFound).AnyTimes()
 
 	serviceMock := handler.NewMockServiceProvider(ctrl)
-	serviceMock.EXPECT().EmptyCart(gomock.Any(), int64(1), int64(1)).Return(nil)
-	serviceMock.EXPECT().EmptyCart(gomock.Any(), int64(1), int64(2)).Return(service.ErrCartNotFound)
-	serviceMock.EXPECT().EmptyCart(gomock.Any(), int64(1), int64(3)).Return(assert.AnError)
+	serviceMock.EXPECT().EmptyCart(gomock.Any(), int64(1), int64(1)).Return(&cart.Cart{ID: 1, Version: 8}, nil)
+	serviceMock.EXPECT().EmptyCart(gomock.Any(), int64(1), int64(2)).Return(nil, service.ErrCartNotFound)
+	serviceMock.EXPECT().EmptyCart(gomock.Any(), int64(1), int64(3)).Return(nil, assert.AnError)
+	serviceMock.EXPECT().EmptyCart(gomock.Any(), int64(1), int64(4)).Return(nil, service.ErrCartNotOpen)
 
 	testsCases := []tests.TestCase{
 		{
-			Name:           "ok - 204",
-			Method:         http.MethodDelete,
-			Target:         "/carts/1/items",
-			AccessKey:      "abc123456",
-			ExpectedStatus: http.StatusNoContent,
+			Name:            "ok - 204",
+			Method:          http.MethodDelete,
+			Target:          "/carts/1/items",
+			AccessKey:       "abc123456",
+			ExpectedHeaders: map[string]string{"ETag": `"8"`},
+			ExpectedStatus:  http.StatusNoContent,
 		},
 		{
 			Name:           "cart not found - 404",
@@ -194,6 +467,283 @@ func TestHandler_EmptyCart(t *testing.T) {
 			ExpectedStatus: http.StatusInternalServerError,
 			ExpectedBody:   `{"error":{"code":100500, "details":"Internal error - could not empty cart"}}`,
 		},
+		{
+			Name:           "cart not open - 409",
+			Method:         http.MethodDelete,
+			Target:         "/carts/4/items",
+			AccessKey:      "abc123456",
+			ExpectedStatus: http.StatusConflict,
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart is not open"}}`,
+		},
+	}
+	execHTTPTestCases(t, serviceMock, authMock, testsCases)
+}
+
+func TestHandler_GetCart(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	details, err := cart.NewDetails(
+		&cart.Cart{ID: 1, UserID: 1, Status: cart.StatusOpen, Currency: "EUR", Version: 3},
+		[]*cart.Item{
+			{ID: 1, CartID: 1, ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")},
+			{ID: 2, CartID: 1, ProductID: 2, Quantity: 1, Price: cart.NewMoney(550, "EUR")},
+		},
+	)
+	assert.Nil(t, err)
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().CartDetails(gomock.Any(), int64(1), int64(1)).Return(details, nil)
+	serviceMock.EXPECT().CartDetails(gomock.Any(), int64(1), int64(2)).Return(nil, service.ErrCartNotFound)
+	serviceMock.EXPECT().CartDetails(gomock.Any(), int64(1), int64(3)).Return(nil, assert.AnError)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:      "ok - 200",
+			Method:    http.MethodGet,
+			Target:    "/carts/1",
+			AccessKey: "abc123456",
+			ExpectedBody: `{"id":1, "user_id":1, "status":"open", "currency":"EUR", "item_count":2, "total_quantity":3, "subtotal":25.5, "items":[
+				{"id":1, "cart_id":1, "product_id":1, "quantity":2, "price":20},
+				{"id":2, "cart_id":1, "product_id":2, "quantity":1, "price":5.5}
+			]}`,
+			ExpectedHeaders: map[string]string{"ETag": `"3"`},
+			ExpectedStatus:  http.StatusOK,
+		},
+		{
+			Name:           "cart not found - 404",
+			Method:         http.MethodGet,
+			Target:         "/carts/2",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "cart id string - invalid param",
+			Method:         http.MethodGet,
+			Target:         "/carts/cart",
+			AccessKey:      "abc123456",
+			ExpectedStatus: http.StatusUnprocessableEntity,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - cart_id param is not a valid number"}}`,
+		},
+		{
+			Name:           "service error - 500",
+			Method:         http.MethodGet,
+			Target:         "/carts/3",
+			AccessKey:      "abc123456",
+			ExpectedStatus: http.StatusInternalServerError,
+			ExpectedBody:   `{"error":{"code":100500, "details":"Internal error - could not get cart details"}}`,
+		},
+	}
+	execHTTPTestCases(t, serviceMock, authMock, testsCases)
+}
+
+func TestHandler_ChangeCartStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().ChangeCartStatus(gomock.Any(), int64(1), int64(1), cart.StatusAbandoned).
+		Return(&cart.Cart{ID: 1, UserID: 1, Status: cart.StatusAbandoned, Currency: "EUR"}, nil)
+	serviceMock.EXPECT().ChangeCartStatus(gomock.Any(), int64(1), int64(2), cart.StatusAbandoned).
+		Return(nil, service.ErrCartNotFound)
+	serviceMock.EXPECT().ChangeCartStatus(gomock.Any(), int64(1), int64(3), cart.StatusOpen).
+		Return(nil, cart.ErrInvalidStatusTransition)
+	serviceMock.EXPECT().ChangeCartStatus(gomock.Any(), int64(1), int64(4), cart.StatusOpen).
+		Return(nil, service.ErrOpenCartExists)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:           "ok - 200",
+			Method:         http.MethodPatch,
+			Target:         "/carts/1",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"status":"abandoned"}`,
+			ExpectedBody:   `{"id":1, "user_id":1, "status":"abandoned", "currency":"EUR"}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "cart not found - 404",
+			Method:         http.MethodPatch,
+			Target:         "/carts/2",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"status":"abandoned"}`,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "transition not allowed - 409",
+			Method:         http.MethodPatch,
+			Target:         "/carts/3",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"status":"open"}`,
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart cannot move to the requested status"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+		{
+			Name:           "open cart exists - 409",
+			Method:         http.MethodPatch,
+			Target:         "/carts/4",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"status":"open"}`,
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - user already has an open cart"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+		{
+			Name:           "unknown status - 422",
+			Method:         http.MethodPatch,
+			Target:         "/carts/5",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"status":"paid"}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[{"field":"status", "rule":"oneof", "message":"status must be one of open, checked_out, abandoned"}]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "invalid json - 400",
+			Method:         http.MethodPatch,
+			Target:         "/carts/1",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"status":`,
+			ExpectedBody:   `{"error":{"code":100400, "details":"Bad Request - body has invalid json format"}}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+	}
+	execHTTPTestCases(t, serviceMock, authMock, testsCases)
+}
+
+func TestHandler_CartVersion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	// expectVersions checks the versions of the cart the service is told to expect
+	expectVersions := func(expected []int64, ok bool) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			versions, found := ctxutil.GetCartVersions(ctx)
+			assert.Equal(t, ok, found)
+			assert.Equal(t, expected, versions)
+		}
+	}
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().AddItem(gomock.Any(), int64(1), gomock.Any(), false).
+		DoAndReturn(func(ctx context.Context, userID int64, item *cart.Item, merge bool) (*cart.Cart, error) {
+			expectVersions([]int64{3}, true)(ctx)
+			return &cart.Cart{ID: 1, Version: 4}, nil
+		})
+	serviceMock.EXPECT().AddItem(gomock.Any(), int64(1), gomock.Any(), false).
+		DoAndReturn(func(ctx context.Context, userID int64, item *cart.Item, merge bool) (*cart.Cart, error) {
+			expectVersions([]int64{2}, true)(ctx)
+			return nil, service.ErrCartVersionMismatch
+		})
+	serviceMock.EXPECT().RemoveItem(gomock.Any(), int64(1), int64(1)).
+		DoAndReturn(func(ctx context.Context, userID, itemID int64) (*cart.Cart, error) {
+			expectVersions([]int64{3}, true)(ctx)
+			return &cart.Cart{ID: 1, Version: 4}, nil
+		})
+	serviceMock.EXPECT().UpdateItem(gomock.Any(), int64(1), int64(1), int64(2)).
+		DoAndReturn(func(ctx context.Context, userID, itemID, quantity int64) (*cart.Item, *cart.Cart, error) {
+			expectVersions([]int64{2, 3}, true)(ctx)
+			return &cart.Item{ID: 1, CartID: 1, ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")}, &cart.Cart{ID: 1, Version: 4}, nil
+		})
+	serviceMock.EXPECT().EmptyCart(gomock.Any(), int64(1), int64(1)).
+		DoAndReturn(func(ctx context.Context, userID, cartID int64) (*cart.Cart, error) {
+			expectVersions(nil, false)(ctx)
+			return &cart.Cart{ID: 1, Version: 5}, nil
+		})
+	serviceMock.EXPECT().ChangeCartStatus(gomock.Any(), int64(1), int64(1), cart.StatusAbandoned).
+		Return(&cart.Cart{ID: 1, UserID: 1, Status: cart.StatusAbandoned, Currency: "EUR", Version: 4}, nil)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:            "add item to the version read - ETag of the new version",
+			Method:          http.MethodPost,
+			Target:          "/carts/1/items",
+			ReqBody:         `{"product_id":1, "quantity":1}`,
+			AccessKey:       "abc123456",
+			Headers:         map[string]string{"If-Match": `"3"`},
+			ExpectedHeaders: map[string]string{"ETag": `"4"`},
+			ExpectedStatus:  http.StatusCreated,
+		},
+		{
+			Name:           "add item to a changed cart - 412",
+			Method:         http.MethodPost,
+			Target:         "/carts/1/items",
+			ReqBody:        `{"product_id":1, "quantity":1}`,
+			AccessKey:      "abc123456",
+			Headers:        map[string]string{"If-Match": `"2"`},
+			ExpectedStatus: http.StatusPreconditionFailed,
+			ExpectedBody:   `{"error":{"code":100412, "details":"Precondition failed - cart has changed since it was read"}}`,
+		},
+		{
+			Name:            "remove item with a weak entity tag - ok",
+			Method:          http.MethodDelete,
+			Target:          "/items/1",
+			AccessKey:       "abc123456",
+			Headers:         map[string]string{"If-Match": `W/"3"`},
+			ExpectedHeaders: map[string]string{"ETag": `"4"`},
+			ExpectedStatus:  http.StatusNoContent,
+		},
+		{
+			Name:            "update item with a list of entity tags - ok",
+			Method:          http.MethodPatch,
+			Target:          "/items/1",
+			ReqBody:         `{"quantity":2}`,
+			AccessKey:       "abc123456",
+			Headers:         map[string]string{"If-Match": `"2", W/"3", "other"`},
+			ExpectedHeaders: map[string]string{"ETag": `"4"`},
+			ExpectedStatus:  http.StatusOK,
+		},
+		{
+			Name:           "remove item with entity tags of no cart - 412",
+			Method:         http.MethodDelete,
+			Target:         "/items/1",
+			AccessKey:      "abc123456",
+			Headers:        map[string]string{"If-Match": `"a", W/"b"`},
+			ExpectedStatus: http.StatusPreconditionFailed,
+			ExpectedBody:   `{"error":{"code":100412, "details":"Precondition failed - cart has changed since it was read"}}`,
+		},
+		{
+			Name:           "remove item with a malformed If-Match - 412",
+			Method:         http.MethodDelete,
+			Target:         "/items/1",
+			AccessKey:      "abc123456",
+			Headers:        map[string]string{"If-Match": `"3" "4"`},
+			ExpectedStatus: http.StatusPreconditionFailed,
+			ExpectedBody:   `{"error":{"code":100412, "details":"Precondition failed - cart has changed since it was read"}}`,
+		},
+		{
+			Name:            "empty cart of any version - ok",
+			Method:          http.MethodDelete,
+			Target:          "/carts/1/items",
+			AccessKey:       "abc123456",
+			Headers:         map[string]string{"If-Match": "*"},
+			ExpectedHeaders: map[string]string{"ETag": `"5"`},
+			ExpectedStatus:  http.StatusNoContent,
+		},
+		{
+			Name:            "change status - ETag of the new version",
+			Method:          http.MethodPatch,
+			Target:          "/carts/1",
+			ReqBody:         `{"status":"abandoned"}`,
+			AccessKey:       "abc123456",
+			ExpectedHeaders: map[string]string{"ETag": `"4"`},
+			ExpectedStatus:  http.StatusOK,
+		},
 	}
 	execHTTPTestCases(t, serviceMock, authMock, testsCases)
 }
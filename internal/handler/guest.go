@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/jsonerror"
+	"github.com/cubny/cart/internal/service"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// claimCartRequest is the body of POST /carts/:cartID/claim, it can be empty
+type claimCartRequest struct {
+	MergeRule cart.MergeRule `json:"merge_rule" validate:"oneof=sum newest max"`
+}
+
+// claimCart gives the guest cart to the logged in user, the user proves the
+// cart was theirs as a guest by sending its token. the items of the cart are
+// merged into the open cart of the user by the merge_rule of the body, sum by
+// default
+func (h *Handler) claimCart(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	accessKey, err := ctxutil.GetUserAuthAccessKey(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("claimCart: GetUserAuthAccessKey %s", err)
+		api500Count.With(prometheus.Labels{"method": "claimCart", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
+		return
+	}
+
+	cartID, err := strconv.Atoi(p.ByName("cartID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "cart_id param is not a valid number", jsonerror.Extensions{"param": "cart_id"})
+		return
+	}
+
+	token := auth.CartTokenFromClientRequest(r)
+	if token == "" || h.cartTokens == nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "cart token is required", jsonerror.Extensions{"param": auth.CartTokenHeader})
+		return
+	}
+	tokenCartID, err := h.cartTokens.VerifyCartToken(token)
+	if err != nil || tokenCartID != int64(cartID) {
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", jsonerror.Extensions{"cart_id": cartID})
+		return
+	}
+
+	claimReq := &claimCartRequest{}
+	if !decodeBody(w, r, claimReq, true) {
+		return
+	}
+	if claimReq.MergeRule == "" {
+		claimReq.MergeRule = cart.MergeSum
+	}
+
+	details, err := h.service.ClaimCart(r.Context(), accessKey.UserID, int64(cartID), claimReq.MergeRule)
+	switch {
+	case err == cart.ErrInvalidMergeRule:
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "merge_rule must be one of sum, newest or max", jsonerror.Extensions{"param": "merge_rule"})
+		return
+	case err == service.ErrCartNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == service.ErrCartNotOpen:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotOpen, "cart is not open", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == cart.ErrCurrencyMismatch:
+		_ = jsonerror.Write(w, r, jsonerror.CurrencyMismatch, "cart is not in the currency of the open cart of the user", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == cart.ErrAmountOverflow:
+		_ = jsonerror.Write(w, r, jsonerror.AmountOutOfRange, "price of a merged item is out of range", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("claimCart: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "claimCart", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not claim cart", nil)
+		return
+	}
+
+	w.Header().Set("ETag", cartETag(details.Version))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("claimCart: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "claimCart", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
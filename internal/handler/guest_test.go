@@ -0,0 +1,268 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_GuestCart(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	cartTokensMock := handler.NewMockCartTokenProvider(ctrl)
+	cartTokensMock.EXPECT().IssueCartToken(int64(2)).Return("token-2").AnyTimes()
+	cartTokensMock.EXPECT().VerifyCartToken("token-2").Return(int64(2), nil).AnyTimes()
+	cartTokensMock.EXPECT().VerifyCartToken("forged").Return(int64(0), auth.ErrInvalidCartToken).AnyTimes()
+
+	guestCart := &cart.Cart{ID: 2, Status: cart.StatusOpen, Currency: "EUR"}
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().CreateCart(gomock.Any(), service.GuestUserID).Return(guestCart, nil)
+	serviceMock.EXPECT().CreateCart(gomock.Any(), int64(1)).Return(&cart.Cart{
+		ID:       1,
+		UserID:   1,
+		Status:   cart.StatusOpen,
+		Currency: "EUR",
+	}, nil)
+	serviceMock.EXPECT().
+		CartDetails(gomock.Any(), service.GuestUserID, int64(2)).
+		DoAndReturn(func(ctx context.Context, userID, cartID int64) (*cart.Details, error) {
+			// the service is told which cart the guest holds the token of
+			guestCartID, ok := ctxutil.GetGuestCartID(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, int64(2), guestCartID)
+			return cart.NewDetails(guestCart, []*cart.Item{})
+		})
+
+	testCases := []tests.TestCase{
+		{
+			Name:            "guest creates a cart - ok",
+			Method:          http.MethodPost,
+			Target:          "/carts",
+			ExpectedBody:    `{"id":2, "status":"open", "currency":"EUR"}`,
+			ExpectedHeaders: map[string]string{"X-Cart-Token": "token-2"},
+			ExpectedStatus:  http.StatusCreated,
+		},
+		{
+			Name:            "user creates a cart - no cart token",
+			Method:          http.MethodPost,
+			Target:          "/carts",
+			AccessKey:       "abc123456",
+			ExpectedBody:    `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`,
+			ExpectedHeaders: map[string]string{"X-Cart-Token": ""},
+			ExpectedStatus:  http.StatusCreated,
+		},
+		{
+			Name:           "guest gets its cart - ok",
+			Method:         http.MethodGet,
+			Target:         "/carts/2",
+			CartToken:      "token-2",
+			ExpectedBody:   `{"id":2, "status":"open", "currency":"EUR", "items":[], "item_count":0, "total_quantity":0, "subtotal":0}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "guest without a cart token - error",
+			Method:         http.MethodGet,
+			Target:         "/carts/2",
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "forged cart token - error",
+			Method:         http.MethodGet,
+			Target:         "/carts/2",
+			CartToken:      "forged",
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect cart token"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "guest checks out - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/checkout",
+			CartToken:      "token-2",
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			h, err := handler.New(serviceMock, authMock, handler.Options{CartTokens: cartTokensMock})
+			assert.Nil(t, err)
+			tests.HandlerTest(t, h, &tc)
+		})
+	}
+
+	t.Run("guests are not let in without cart tokens - error", func(t *testing.T) {
+		h, err := handler.New(serviceMock, authMock, handler.Options{})
+		assert.Nil(t, err)
+		tests.HandlerTest(t, h, &tests.TestCase{
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		})
+	})
+}
+
+func TestHandler_ClaimCart(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	cartTokensMock := handler.NewMockCartTokenProvider(ctrl)
+	cartTokensMock.EXPECT().VerifyCartToken("token-2").Return(int64(2), nil).AnyTimes()
+	cartTokensMock.EXPECT().VerifyCartToken("token-3").Return(int64(3), nil).AnyTimes()
+	cartTokensMock.EXPECT().VerifyCartToken("token-4").Return(int64(4), nil).AnyTimes()
+	cartTokensMock.EXPECT().VerifyCartToken("token-5").Return(int64(5), nil).AnyTimes()
+	cartTokensMock.EXPECT().VerifyCartToken("token-6").Return(int64(6), nil).AnyTimes()
+	cartTokensMock.EXPECT().VerifyCartToken("forged").Return(int64(0), auth.ErrInvalidCartToken).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	details, err := cart.NewDetails(&cart.Cart{
+		ID:       1,
+		UserID:   1,
+		Status:   cart.StatusOpen,
+		Currency: "EUR",
+	}, []*cart.Item{
+		{ID: 1, CartID: 1, ProductID: 1, Quantity: 3, Price: cart.NewMoney(30000, "EUR")},
+	})
+	assert.Nil(t, err)
+
+	serviceMock.EXPECT().ClaimCart(gomock.Any(), int64(1), int64(2), cart.MergeSum).Return(details, nil)
+	serviceMock.EXPECT().ClaimCart(gomock.Any(), int64(1), int64(3), cart.MergeMax).Return(nil, service.ErrCartNotFound)
+	serviceMock.EXPECT().ClaimCart(gomock.Any(), int64(1), int64(4), cart.MergeSum).Return(nil, service.ErrCartNotOpen)
+	serviceMock.EXPECT().ClaimCart(gomock.Any(), int64(1), int64(5), cart.MergeSum).Return(nil, cart.ErrCurrencyMismatch)
+	serviceMock.EXPECT().ClaimCart(gomock.Any(), int64(1), int64(6), cart.MergeSum).Return(nil, assert.AnError)
+
+	testCases := []tests.TestCase{
+		{
+			Name:           "merge by sum by default - ok",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "token-2",
+			ExpectedBody:   `{"id":1, "user_id":1, "status":"open", "currency":"EUR", "items":[{"id":1, "cart_id":1, "product_id":1, "quantity":3, "price":300}], "item_count":1, "total_quantity":3, "subtotal":300}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "guest claims the cart - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/claim",
+			CartToken:      "token-2",
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "without a cart token - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/claim",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"error":{"code":100422, "details": "Invalid params - cart token is required"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "forged cart token - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "forged",
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "token of another cart - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "token-3",
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "invalid json - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "token-2",
+			ReqBody:        `{"merge_rule":`,
+			ExpectedBody:   `{"error":{"code":100400, "details":"Bad Request - body has invalid json format"}}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "unknown merge rule - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "token-2",
+			ReqBody:        `{"merge_rule":"oldest"}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[{"field":"merge_rule", "rule":"oneof", "message":"merge_rule must be one of sum, newest, max"}]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "cart does not exist - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/3/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "token-3",
+			ReqBody:        `{"merge_rule":"max"}`,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "cart is not open - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/4/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "token-4",
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart is not open"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+		{
+			Name:           "currency mismatch - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/5/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "token-5",
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - cart is not in the currency of the open cart of the user"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "service error - error",
+			Method:         http.MethodPost,
+			Target:         "/carts/6/claim",
+			AccessKey:      "abc123456",
+			CartToken:      "token-6",
+			ExpectedBody:   `{"error":{"code":100500, "details":"Internal error - could not claim cart"}}`,
+			ExpectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			h, err := handler.New(serviceMock, authMock, handler.Options{CartTokens: cartTokensMock})
+			assert.Nil(t, err)
+			tests.HandlerTest(t, h, &tc)
+		})
+	}
+}
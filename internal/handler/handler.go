@@ -7,6 +7,9 @@ import (
 
 	"github.com/cubny/cart"
 	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/idempotency"
+	"github.com/cubny/cart/internal/validation"
+	"github.com/cubny/cart/internal/webhook"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,15 +26,21 @@ var (
 
 func init() {
 	prometheus.MustRegister(api500Count)
+	validation.SetLimit("maxQuantity", cart.MaxQuantity)
 }
 
 // ServiceProvided contains all the business logic
 type ServiceProvider interface {
 	CreateCart(ctx context.Context, userID int64) (*cart.Cart, error)
-	AddItem(ctx context.Context, userID int64, item *cart.Item) error
-	RemoveItem(ctx context.Context, userID, itemID int64) error
-	EmptyCart(ctx context.Context, userID, cartID int64) error
-	//CartDetails(ctx context.Context, cartID int) (*cart.Cart, []cart.Item, error)
+	AddItem(ctx context.Context, userID int64, item *cart.Item, merge bool) (*cart.Cart, error)
+	UpdateItem(ctx context.Context, userID, itemID, quantity int64) (*cart.Item, *cart.Cart, error)
+	RemoveItem(ctx context.Context, userID, itemID int64) (*cart.Cart, error)
+	EmptyCart(ctx context.Context, userID, cartID int64) (*cart.Cart, error)
+	CartDetails(ctx context.Context, userID, cartID int64) (*cart.Details, error)
+	ChangeCartStatus(ctx context.Context, userID, cartID int64, status cart.Status) (*cart.Cart, error)
+	Checkout(ctx context.Context, userID, cartID int64) (*cart.Order, error)
+	GetOrder(ctx context.Context, userID, orderID int64) (*cart.Order, error)
+	ClaimCart(ctx context.Context, userID, cartID int64, rule cart.MergeRule) (*cart.Details, error)
 }
 
 // AuthProvider provides the client to interact with the auth service
@@ -39,14 +48,70 @@ type AuthProvider interface {
 	VerifyKey(ctx context.Context, accessKey string) (*auth.AccessKey, error)
 }
 
+// TokenVerifier verifies the bearer tokens, e.g. the JWTs of an OIDC provider
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (*auth.AccessKey, error)
+}
+
+// CartTokenProvider issues and verifies the tokens of the guest carts
+type CartTokenProvider interface {
+	IssueCartToken(cartID int64) string
+	VerifyCartToken(token string) (int64, error)
+}
+
+// IdempotencyStore keeps the responses to the requests made with an
+// Idempotency-Key, so that they are replayed to the retries
+type IdempotencyStore interface {
+	CreateIdempotencyRecord(ctx context.Context, r *idempotency.Record) error
+	GetIdempotencyRecord(ctx context.Context, scope, key string) (*idempotency.Record, error)
+	UpdateIdempotencyRecord(ctx context.Context, r *idempotency.Record) error
+	RemoveIdempotencyRecord(ctx context.Context, scope, key string) error
+}
+
+// WebhookProvider manages the webhook subscriptions of the tenants
+type WebhookProvider interface {
+	CreateSubscription(ctx context.Context, tenant, url string, eventTypes []cart.EventType) (*webhook.Subscription, error)
+	GetSubscription(ctx context.Context, tenant string, id int64) (*webhook.Subscription, error)
+	ListSubscriptions(ctx context.Context, tenant string) ([]*webhook.Subscription, error)
+	RemoveSubscription(ctx context.Context, tenant string, id int64) error
+	ListDeliveries(ctx context.Context, tenant string, subscriptionID int64, limit int) ([]*webhook.Delivery, error)
+	Redeliver(ctx context.Context, tenant string, subscriptionID, deliveryID int64) (*webhook.Delivery, error)
+}
+
+// AdminVerifier verifies the keys of the admins and returns their tenants
+type AdminVerifier interface {
+	VerifyAdminKey(ctx context.Context, key string) (string, error)
+}
+
 // Handler handles http requests
 type Handler struct {
-	service ServiceProvider
+	service    ServiceProvider
+	cartTokens CartTokenProvider
+	webhooks   WebhookProvider
+	routes     []Route
 	http.Handler
 }
 
-// New creates a new handler to handle http requests
-func New(service ServiceProvider, authClient AuthProvider) (*Handler, error) {
+// Options are the optional dependencies of the handler, what a dependency
+// serves is off when it is not given
+type Options struct {
+	// TokenVerifier verifies the bearer tokens, they are only accepted with it
+	TokenVerifier TokenVerifier
+	// CartTokens sign the tokens of the guest carts, the guests are only served with them
+	CartTokens CartTokenProvider
+	// IdempotencyKeys keep the responses to the requests made with an
+	// Idempotency-Key, the keys are ignored without them
+	IdempotencyKeys IdempotencyStore
+	// Webhooks manages the webhook subscriptions of the tenants, the admin api of
+	// the webhooks is served only when both Webhooks and AdminKeys are given
+	Webhooks WebhookProvider
+	// AdminKeys verifies the keys of the admins of the tenants
+	AdminKeys AdminVerifier
+}
+
+// New creates a new handler to handle http requests, the service and the
+// authClient are required, see Options for the others
+func New(service ServiceProvider, authClient AuthProvider, opts Options) (*Handler, error) {
 
 	switch {
 	case authClient == nil:
@@ -56,25 +121,59 @@ func New(service ServiceProvider, authClient AuthProvider) (*Handler, error) {
 	}
 
 	h := &Handler{
-		service: service,
+		service:    service,
+		cartTokens: opts.CartTokens,
+		webhooks:   opts.Webhooks,
 	}
-	router := httprouter.New()
+	router := newRouter()
 
-	middleware := NewMiddleware(authClient)
+	middleware := NewMiddleware(authClient, opts)
 	chain := middleware.Chain(middleware.ContentTypeJSON, middleware.Authorise)
+	// guests can fill a cart, checking it out needs a user
+	guestChain := middleware.Chain(middleware.ContentTypeJSON, middleware.AllowGuests(false))
+	newGuestChain := middleware.Chain(middleware.ContentTypeJSON, middleware.AllowGuests(true))
+	// the changes of a cart can be made conditional on its version
+	ifMatchChain := chain.With(middleware.IfMatch)
+	guestIfMatchChain := guestChain.With(middleware.IfMatch)
 
 	router.GET("/health", h.health)
-	router.POST("/carts", chain.Wrap(h.createCart))
-	router.POST("/carts/:cartID/items", chain.Wrap(h.addItem))
-	router.DELETE("/items/:itemID", chain.Wrap(h.removeItem))
+	// the document of the api, openapi.json is kept in step with the routes by the tests
+	router.GET("/openapi.json", h.getOpenAPI)
+	router.GET("/docs", h.getDocs)
+	// the catalog of the errors, the type uris of the problem details are its paths
+	catalogChain := middleware.Chain(middleware.ContentTypeJSON)
+	router.GET("/problems", catalogChain.Wrap(h.listProblems))
+	router.GET("/problems/:problemID", catalogChain.Wrap(h.getProblem))
+	// the carts and the items can be added again safely by the retries made with an Idempotency-Key
+	router.POST("/carts", newGuestChain.With(middleware.Idempotent).Wrap(h.createCart))
+	router.GET("/carts/:cartID", guestChain.Wrap(h.getCart))
+	router.PATCH("/carts/:cartID", ifMatchChain.Wrap(h.changeCartStatus))
+	router.POST("/carts/:cartID/items", guestIfMatchChain.With(middleware.Idempotent).Wrap(h.addItem))
+	router.PATCH("/items/:itemID", guestIfMatchChain.Wrap(h.updateItem))
+	router.DELETE("/items/:itemID", guestIfMatchChain.Wrap(h.removeItem))
 	// to find out why I chose DELETE for emptying the cart read the comments in the handler
-	router.DELETE("/carts/:cartID/items", chain.Wrap(h.emptyCart))
+	router.DELETE("/carts/:cartID/items", guestIfMatchChain.Wrap(h.emptyCart))
+	router.POST("/carts/:cartID/claim", chain.Wrap(h.claimCart))
+	router.POST("/carts/:cartID/checkout", chain.Wrap(h.checkout))
+	router.GET("/orders/:orderID", chain.Wrap(h.getOrder))
+
+	if opts.Webhooks != nil && opts.AdminKeys != nil {
+		adminChain := middleware.Chain(middleware.ContentTypeJSON, middleware.AuthoriseAdmin)
+		router.POST("/admin/webhooks", adminChain.Wrap(h.createWebhook))
+		router.GET("/admin/webhooks", adminChain.Wrap(h.listWebhooks))
+		router.GET("/admin/webhooks/:subscriptionID", adminChain.Wrap(h.getWebhook))
+		router.DELETE("/admin/webhooks/:subscriptionID", adminChain.Wrap(h.removeWebhook))
+		router.GET("/admin/webhooks/:subscriptionID/deliveries", adminChain.Wrap(h.listDeliveries))
+		router.POST("/admin/webhooks/:subscriptionID/deliveries/:deliveryID/redeliver", adminChain.Wrap(h.redeliver))
+	}
 
 	h.Handler = router
+	h.routes = router.routes
 	return h, nil
 }
 
 func (h *Handler) health(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
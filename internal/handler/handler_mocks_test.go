@@ -8,6 +8,8 @@ import (
 	context "context"
 	cart "github.com/cubny/cart"
 	auth "github.com/cubny/cart/internal/auth"
+	idempotency "github.com/cubny/cart/internal/idempotency"
+	webhook "github.com/cubny/cart/internal/webhook"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
 )
@@ -51,25 +53,43 @@ func (mr *MockServiceProviderMockRecorder) CreateCart(ctx, userID interface{}) *
 }
 
 // AddItem mocks base method.
-func (m *MockServiceProvider) AddItem(ctx context.Context, userID int64, item *cart.Item) error {
+func (m *MockServiceProvider) AddItem(ctx context.Context, userID int64, item *cart.Item, merge bool) (*cart.Cart, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddItem", ctx, userID, item)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "AddItem", ctx, userID, item, merge)
+	ret0, _ := ret[0].(*cart.Cart)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // AddItem indicates an expected call of AddItem.
-func (mr *MockServiceProviderMockRecorder) AddItem(ctx, userID, item interface{}) *gomock.Call {
+func (mr *MockServiceProviderMockRecorder) AddItem(ctx, userID, item, merge interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddItem", reflect.TypeOf((*MockServiceProvider)(nil).AddItem), ctx, userID, item)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddItem", reflect.TypeOf((*MockServiceProvider)(nil).AddItem), ctx, userID, item, merge)
+}
+
+// UpdateItem mocks base method.
+func (m *MockServiceProvider) UpdateItem(ctx context.Context, userID, itemID, quantity int64) (*cart.Item, *cart.Cart, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateItem", ctx, userID, itemID, quantity)
+	ret0, _ := ret[0].(*cart.Item)
+	ret1, _ := ret[1].(*cart.Cart)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// UpdateItem indicates an expected call of UpdateItem.
+func (mr *MockServiceProviderMockRecorder) UpdateItem(ctx, userID, itemID, quantity interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateItem", reflect.TypeOf((*MockServiceProvider)(nil).UpdateItem), ctx, userID, itemID, quantity)
 }
 
 // RemoveItem mocks base method.
-func (m *MockServiceProvider) RemoveItem(ctx context.Context, userID, itemID int64) error {
+func (m *MockServiceProvider) RemoveItem(ctx context.Context, userID, itemID int64) (*cart.Cart, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "RemoveItem", ctx, userID, itemID)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(*cart.Cart)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // RemoveItem indicates an expected call of RemoveItem.
@@ -79,11 +99,12 @@ func (mr *MockServiceProviderMockRecorder) RemoveItem(ctx, userID, itemID interf
 }
 
 // EmptyCart mocks base method.
-func (m *MockServiceProvider) EmptyCart(ctx context.Context, userID, cartID int64) error {
+func (m *MockServiceProvider) EmptyCart(ctx context.Context, userID, cartID int64) (*cart.Cart, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "EmptyCart", ctx, userID, cartID)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(*cart.Cart)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // EmptyCart indicates an expected call of EmptyCart.
@@ -92,6 +113,81 @@ func (mr *MockServiceProviderMockRecorder) EmptyCart(ctx, userID, cartID interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EmptyCart", reflect.TypeOf((*MockServiceProvider)(nil).EmptyCart), ctx, userID, cartID)
 }
 
+// CartDetails mocks base method.
+func (m *MockServiceProvider) CartDetails(ctx context.Context, userID, cartID int64) (*cart.Details, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CartDetails", ctx, userID, cartID)
+	ret0, _ := ret[0].(*cart.Details)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CartDetails indicates an expected call of CartDetails.
+func (mr *MockServiceProviderMockRecorder) CartDetails(ctx, userID, cartID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CartDetails", reflect.TypeOf((*MockServiceProvider)(nil).CartDetails), ctx, userID, cartID)
+}
+
+// ChangeCartStatus mocks base method.
+func (m *MockServiceProvider) ChangeCartStatus(ctx context.Context, userID, cartID int64, status cart.Status) (*cart.Cart, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangeCartStatus", ctx, userID, cartID, status)
+	ret0, _ := ret[0].(*cart.Cart)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChangeCartStatus indicates an expected call of ChangeCartStatus.
+func (mr *MockServiceProviderMockRecorder) ChangeCartStatus(ctx, userID, cartID, status interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeCartStatus", reflect.TypeOf((*MockServiceProvider)(nil).ChangeCartStatus), ctx, userID, cartID, status)
+}
+
+// Checkout mocks base method.
+func (m *MockServiceProvider) Checkout(ctx context.Context, userID, cartID int64) (*cart.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Checkout", ctx, userID, cartID)
+	ret0, _ := ret[0].(*cart.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Checkout indicates an expected call of Checkout.
+func (mr *MockServiceProviderMockRecorder) Checkout(ctx, userID, cartID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Checkout", reflect.TypeOf((*MockServiceProvider)(nil).Checkout), ctx, userID, cartID)
+}
+
+// GetOrder mocks base method.
+func (m *MockServiceProvider) GetOrder(ctx context.Context, userID, orderID int64) (*cart.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrder", ctx, userID, orderID)
+	ret0, _ := ret[0].(*cart.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrder indicates an expected call of GetOrder.
+func (mr *MockServiceProviderMockRecorder) GetOrder(ctx, userID, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockServiceProvider)(nil).GetOrder), ctx, userID, orderID)
+}
+
+// ClaimCart mocks base method.
+func (m *MockServiceProvider) ClaimCart(ctx context.Context, userID, cartID int64, rule cart.MergeRule) (*cart.Details, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimCart", ctx, userID, cartID, rule)
+	ret0, _ := ret[0].(*cart.Details)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimCart indicates an expected call of ClaimCart.
+func (mr *MockServiceProviderMockRecorder) ClaimCart(ctx, userID, cartID, rule interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimCart", reflect.TypeOf((*MockServiceProvider)(nil).ClaimCart), ctx, userID, cartID, rule)
+}
+
 // MockAuthProvider is a mock of AuthProvider interface.
 type MockAuthProvider struct {
 	ctrl     *gomock.Controller
@@ -129,3 +225,323 @@ func (mr *MockAuthProviderMockRecorder) VerifyAccessKey(ctx, accessKey interface
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyKey", reflect.TypeOf((*MockAuthProvider)(nil).VerifyKey), ctx, accessKey)
 }
+
+// MockTokenVerifier is a mock of TokenVerifier interface.
+type MockTokenVerifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockTokenVerifierMockRecorder
+}
+
+// MockTokenVerifierMockRecorder is the mock recorder for MockTokenVerifier.
+type MockTokenVerifierMockRecorder struct {
+	mock *MockTokenVerifier
+}
+
+// NewMockTokenVerifier creates a new mock instance.
+func NewMockTokenVerifier(ctrl *gomock.Controller) *MockTokenVerifier {
+	mock := &MockTokenVerifier{ctrl: ctrl}
+	mock.recorder = &MockTokenVerifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTokenVerifier) EXPECT() *MockTokenVerifierMockRecorder {
+	return m.recorder
+}
+
+// VerifyToken mocks base method.
+func (m *MockTokenVerifier) VerifyToken(ctx context.Context, token string) (*auth.AccessKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyToken", ctx, token)
+	ret0, _ := ret[0].(*auth.AccessKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyToken indicates an expected call of VerifyToken.
+func (mr *MockTokenVerifierMockRecorder) VerifyToken(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyToken", reflect.TypeOf((*MockTokenVerifier)(nil).VerifyToken), ctx, token)
+}
+
+// MockCartTokenProvider is a mock of CartTokenProvider interface.
+type MockCartTokenProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockCartTokenProviderMockRecorder
+}
+
+// MockCartTokenProviderMockRecorder is the mock recorder for MockCartTokenProvider.
+type MockCartTokenProviderMockRecorder struct {
+	mock *MockCartTokenProvider
+}
+
+// NewMockCartTokenProvider creates a new mock instance.
+func NewMockCartTokenProvider(ctrl *gomock.Controller) *MockCartTokenProvider {
+	mock := &MockCartTokenProvider{ctrl: ctrl}
+	mock.recorder = &MockCartTokenProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCartTokenProvider) EXPECT() *MockCartTokenProviderMockRecorder {
+	return m.recorder
+}
+
+// IssueCartToken mocks base method.
+func (m *MockCartTokenProvider) IssueCartToken(cartID int64) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IssueCartToken", cartID)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// IssueCartToken indicates an expected call of IssueCartToken.
+func (mr *MockCartTokenProviderMockRecorder) IssueCartToken(cartID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IssueCartToken", reflect.TypeOf((*MockCartTokenProvider)(nil).IssueCartToken), cartID)
+}
+
+// VerifyCartToken mocks base method.
+func (m *MockCartTokenProvider) VerifyCartToken(token string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyCartToken", token)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyCartToken indicates an expected call of VerifyCartToken.
+func (mr *MockCartTokenProviderMockRecorder) VerifyCartToken(token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyCartToken", reflect.TypeOf((*MockCartTokenProvider)(nil).VerifyCartToken), token)
+}
+
+// MockIdempotencyStore is a mock of IdempotencyStore interface.
+type MockIdempotencyStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdempotencyStoreMockRecorder
+}
+
+// MockIdempotencyStoreMockRecorder is the mock recorder for MockIdempotencyStore.
+type MockIdempotencyStoreMockRecorder struct {
+	mock *MockIdempotencyStore
+}
+
+// NewMockIdempotencyStore creates a new mock instance.
+func NewMockIdempotencyStore(ctrl *gomock.Controller) *MockIdempotencyStore {
+	mock := &MockIdempotencyStore{ctrl: ctrl}
+	mock.recorder = &MockIdempotencyStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIdempotencyStore) EXPECT() *MockIdempotencyStoreMockRecorder {
+	return m.recorder
+}
+
+// CreateIdempotencyRecord mocks base method.
+func (m *MockIdempotencyStore) CreateIdempotencyRecord(ctx context.Context, r *idempotency.Record) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIdempotencyRecord", ctx, r)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateIdempotencyRecord indicates an expected call of CreateIdempotencyRecord.
+func (mr *MockIdempotencyStoreMockRecorder) CreateIdempotencyRecord(ctx, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIdempotencyRecord", reflect.TypeOf((*MockIdempotencyStore)(nil).CreateIdempotencyRecord), ctx, r)
+}
+
+// GetIdempotencyRecord mocks base method.
+func (m *MockIdempotencyStore) GetIdempotencyRecord(ctx context.Context, scope, key string) (*idempotency.Record, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIdempotencyRecord", ctx, scope, key)
+	ret0, _ := ret[0].(*idempotency.Record)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIdempotencyRecord indicates an expected call of GetIdempotencyRecord.
+func (mr *MockIdempotencyStoreMockRecorder) GetIdempotencyRecord(ctx, scope, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdempotencyRecord", reflect.TypeOf((*MockIdempotencyStore)(nil).GetIdempotencyRecord), ctx, scope, key)
+}
+
+// RemoveIdempotencyRecord mocks base method.
+func (m *MockIdempotencyStore) RemoveIdempotencyRecord(ctx context.Context, scope, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveIdempotencyRecord", ctx, scope, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveIdempotencyRecord indicates an expected call of RemoveIdempotencyRecord.
+func (mr *MockIdempotencyStoreMockRecorder) RemoveIdempotencyRecord(ctx, scope, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveIdempotencyRecord", reflect.TypeOf((*MockIdempotencyStore)(nil).RemoveIdempotencyRecord), ctx, scope, key)
+}
+
+// UpdateIdempotencyRecord mocks base method.
+func (m *MockIdempotencyStore) UpdateIdempotencyRecord(ctx context.Context, r *idempotency.Record) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateIdempotencyRecord", ctx, r)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateIdempotencyRecord indicates an expected call of UpdateIdempotencyRecord.
+func (mr *MockIdempotencyStoreMockRecorder) UpdateIdempotencyRecord(ctx, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateIdempotencyRecord", reflect.TypeOf((*MockIdempotencyStore)(nil).UpdateIdempotencyRecord), ctx, r)
+}
+
+// MockWebhookProvider is a mock of WebhookProvider interface.
+type MockWebhookProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebhookProviderMockRecorder
+}
+
+// MockWebhookProviderMockRecorder is the mock recorder for MockWebhookProvider.
+type MockWebhookProviderMockRecorder struct {
+	mock *MockWebhookProvider
+}
+
+// NewMockWebhookProvider creates a new mock instance.
+func NewMockWebhookProvider(ctrl *gomock.Controller) *MockWebhookProvider {
+	mock := &MockWebhookProvider{ctrl: ctrl}
+	mock.recorder = &MockWebhookProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWebhookProvider) EXPECT() *MockWebhookProviderMockRecorder {
+	return m.recorder
+}
+
+// CreateSubscription mocks base method.
+func (m *MockWebhookProvider) CreateSubscription(ctx context.Context, tenant, url string, eventTypes []cart.EventType) (*webhook.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSubscription", ctx, tenant, url, eventTypes)
+	ret0, _ := ret[0].(*webhook.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateSubscription indicates an expected call of CreateSubscription.
+func (mr *MockWebhookProviderMockRecorder) CreateSubscription(ctx, tenant, url, eventTypes interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSubscription", reflect.TypeOf((*MockWebhookProvider)(nil).CreateSubscription), ctx, tenant, url, eventTypes)
+}
+
+// GetSubscription mocks base method.
+func (m *MockWebhookProvider) GetSubscription(ctx context.Context, tenant string, id int64) (*webhook.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSubscription", ctx, tenant, id)
+	ret0, _ := ret[0].(*webhook.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubscription indicates an expected call of GetSubscription.
+func (mr *MockWebhookProviderMockRecorder) GetSubscription(ctx, tenant, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubscription", reflect.TypeOf((*MockWebhookProvider)(nil).GetSubscription), ctx, tenant, id)
+}
+
+// ListSubscriptions mocks base method.
+func (m *MockWebhookProvider) ListSubscriptions(ctx context.Context, tenant string) ([]*webhook.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSubscriptions", ctx, tenant)
+	ret0, _ := ret[0].([]*webhook.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSubscriptions indicates an expected call of ListSubscriptions.
+func (mr *MockWebhookProviderMockRecorder) ListSubscriptions(ctx, tenant interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSubscriptions", reflect.TypeOf((*MockWebhookProvider)(nil).ListSubscriptions), ctx, tenant)
+}
+
+// RemoveSubscription mocks base method.
+func (m *MockWebhookProvider) RemoveSubscription(ctx context.Context, tenant string, id int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveSubscription", ctx, tenant, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveSubscription indicates an expected call of RemoveSubscription.
+func (mr *MockWebhookProviderMockRecorder) RemoveSubscription(ctx, tenant, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveSubscription", reflect.TypeOf((*MockWebhookProvider)(nil).RemoveSubscription), ctx, tenant, id)
+}
+
+// ListDeliveries mocks base method.
+func (m *MockWebhookProvider) ListDeliveries(ctx context.Context, tenant string, subscriptionID int64, limit int) ([]*webhook.Delivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListDeliveries", ctx, tenant, subscriptionID, limit)
+	ret0, _ := ret[0].([]*webhook.Delivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDeliveries indicates an expected call of ListDeliveries.
+func (mr *MockWebhookProviderMockRecorder) ListDeliveries(ctx, tenant, subscriptionID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDeliveries", reflect.TypeOf((*MockWebhookProvider)(nil).ListDeliveries), ctx, tenant, subscriptionID, limit)
+}
+
+// Redeliver mocks base method.
+func (m *MockWebhookProvider) Redeliver(ctx context.Context, tenant string, subscriptionID, deliveryID int64) (*webhook.Delivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Redeliver", ctx, tenant, subscriptionID, deliveryID)
+	ret0, _ := ret[0].(*webhook.Delivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Redeliver indicates an expected call of Redeliver.
+func (mr *MockWebhookProviderMockRecorder) Redeliver(ctx, tenant, subscriptionID, deliveryID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Redeliver", reflect.TypeOf((*MockWebhookProvider)(nil).Redeliver), ctx, tenant, subscriptionID, deliveryID)
+}
+
+// MockAdminVerifier is a mock of AdminVerifier interface.
+type MockAdminVerifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockAdminVerifierMockRecorder
+}
+
+// MockAdminVerifierMockRecorder is the mock recorder for MockAdminVerifier.
+type MockAdminVerifierMockRecorder struct {
+	mock *MockAdminVerifier
+}
+
+// NewMockAdminVerifier creates a new mock instance.
+func NewMockAdminVerifier(ctrl *gomock.Controller) *MockAdminVerifier {
+	mock := &MockAdminVerifier{ctrl: ctrl}
+	mock.recorder = &MockAdminVerifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAdminVerifier) EXPECT() *MockAdminVerifierMockRecorder {
+	return m.recorder
+}
+
+// VerifyAdminKey mocks base method.
+func (m *MockAdminVerifier) VerifyAdminKey(ctx context.Context, key string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyAdminKey", ctx, key)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyAdminKey indicates an expected call of VerifyAdminKey.
+func (mr *MockAdminVerifierMockRecorder) VerifyAdminKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyAdminKey", reflect.TypeOf((*MockAdminVerifier)(nil).VerifyAdminKey), ctx, key)
+}
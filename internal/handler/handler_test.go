@@ -13,7 +13,7 @@ import (
 func execHTTPTestCases(t *testing.T, sp handler.ServiceProvider, ap handler.AuthProvider, tcs []tests.TestCase) {
 	for _, tc := range tcs {
 		t.Run(tc.Name, func(t *testing.T) {
-			handler, err := handler.New(sp, ap)
+			handler, err := handler.New(sp, ap, handler.Options{})
 			assert.Nil(t, err)
 			tests.HandlerTest(t, handler, &tc)
 		})
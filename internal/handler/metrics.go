@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/jsonerror"
+	"github.com/cubny/cart/internal/tracing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute is the route of the requests that match no route, the paths
+// are not used as routes so that any path does not make a new series
+const unmatchedRoute = "unmatched"
+
+var (
+	requestsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cart",
+			Name:      "http_requests_counter",
+			Help:      "Counter of the requests of cart api by route, method and status class",
+		}, []string{"route", "method", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "cart",
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of the requests of cart api by route, method and status class",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsCount, requestDuration)
+}
+
+// router is the httprouter whose routes record the rate, the errors and the
+// duration of their requests by the route templates, e.g. /carts/:cartID/items,
+// and trace them in the spans named after the templates
+type router struct {
+	*httprouter.Router
+	routes []Route
+}
+
+func newRouter() *router {
+	r := &router{Router: httprouter.New()}
+	r.NotFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		instrument(unmatchedRoute, func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+			_ = jsonerror.Write(w, req, jsonerror.RouteNotFound, "no route matches the path", nil)
+		})(w, req, nil)
+	})
+	r.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		instrument(unmatchedRoute, func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+			_ = jsonerror.Write(w, req, jsonerror.MethodNotAllowed, "the route does not serve the method", nil)
+		})(w, req, nil)
+	})
+	return r
+}
+
+func (r *router) Handle(method, path string, handle httprouter.Handle) {
+	r.routes = append(r.routes, Route{Method: method, Path: path})
+	r.Router.Handle(method, path, instrument(path, handle))
+}
+
+func (r *router) GET(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodGet, path, handle)
+}
+
+func (r *router) POST(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPost, path, handle)
+}
+
+func (r *router) PATCH(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPatch, path, handle)
+}
+
+func (r *router) DELETE(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodDelete, path, handle)
+}
+
+// instrument records the request served by next under the route, next serves
+// it in the span of the request with the id of the request in its context and
+// its response. the access log line of the request is written once it is served
+func instrument(route string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		ctx, span := tracing.StartServer(r, route)
+		r = r.WithContext(ctxutil.SetRequestID(ctx, id))
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r, p)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		tracing.EndServer(span, status)
+
+		labels := prometheus.Labels{"route": route, "method": methodLabel(r.Method), "status": statusClass(status)}
+		requestsCount.With(labels).Inc()
+		requestDuration.With(labels).Observe(time.Since(start).Seconds())
+		logAccess(r, route, status, rec.bytes, time.Since(start))
+	}
+}
+
+// statusRecorder keeps the status and the size of the body of the response, a
+// response that is never written is 200 as net/http sends it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// methodLabel is the method of the request in the metrics, the methods that are
+// not of http are other so that any method does not make a new series
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// statusClass is the class of the status, e.g. 4xx
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
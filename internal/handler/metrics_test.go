@@ -0,0 +1,97 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Metrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().CartDetails(gomock.Any(), int64(1), int64(404)).Return(nil, service.ErrCartNotFound).Times(2)
+
+	h, err := handler.New(serviceMock, authMock, handler.Options{})
+	assert.Nil(t, err)
+
+	tcs := []struct {
+		name     string
+		method   string
+		target   string
+		expected map[string]string
+		count    float64
+	}{
+		{
+			name:     "routes are recorded by their templates",
+			method:   http.MethodGet,
+			target:   "/carts/404",
+			expected: map[string]string{"route": "/carts/:cartID", "method": "GET", "status": "4xx"},
+			count:    2,
+		},
+		{
+			name:     "ok",
+			method:   http.MethodGet,
+			target:   "/health",
+			expected: map[string]string{"route": "/health", "method": "GET", "status": "2xx"},
+			count:    1,
+		},
+		{
+			name:     "paths of no route are unmatched",
+			method:   http.MethodGet,
+			target:   "/no/such/route",
+			expected: map[string]string{"route": "unmatched", "method": "GET", "status": "4xx"},
+			count:    1,
+		},
+		{
+			name:     "methods of no route are unmatched",
+			method:   http.MethodPut,
+			target:   "/health",
+			expected: map[string]string{"route": "unmatched", "method": "PUT", "status": "4xx"},
+			count:    1,
+		},
+		{
+			name:     "methods that are not of http are other",
+			method:   "PURGE",
+			target:   "/health",
+			expected: map[string]string{"route": "unmatched", "method": "other", "status": "4xx"},
+			count:    2,
+		},
+		{
+			name:     "methods that are not of http are other on no route too",
+			method:   "X-RANDOM-METHOD",
+			target:   "/no/such/route",
+			expected: map[string]string{"route": "unmatched", "method": "other", "status": "4xx"},
+			count:    1,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := tests.MetricValue(t, "cart_http_requests_counter", tc.expected)
+			durations := tests.MetricValue(t, "cart_http_request_duration_seconds", tc.expected)
+
+			for i := 0; i < int(tc.count); i++ {
+				req := httptest.NewRequest(tc.method, tc.target, nil)
+				auth.AddKeyToRequest(req, "abc123456")
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			assert.Equal(t, requests+tc.count, tests.MetricValue(t, "cart_http_requests_counter", tc.expected))
+			assert.Equal(t, durations+tc.count, tests.MetricValue(t, "cart_http_request_duration_seconds", tc.expected))
+		})
+	}
+}
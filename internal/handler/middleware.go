@@ -1,24 +1,61 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cubny/cart/internal/auth"
 	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/idempotency"
 	"github.com/cubny/cart/internal/jsonerror"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/storage"
 
 	"github.com/julienschmidt/httprouter"
-	log "github.com/sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// IdempotencyKeyHeader is the header of the key the retries of a request are made with
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader tells the response is the one recorded for the first request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// idempotencyKeyTTL is how long the response to an Idempotency-Key is replayed
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTTL is how long a request being handled holds its key, so
+	// that a key is not held forever by a request the server stopped handling
+	idempotencyLockTTL = time.Minute
+	// maxIdempotencyKeyLength is the length of the longest Idempotency-Key
+	maxIdempotencyKeyLength = 255
 )
 
 // Middleware defines information needed by authentication middleware.
 type Middleware struct {
-	auth AuthProvider
+	auth            AuthProvider
+	tokens          TokenVerifier
+	cartTokens      CartTokenProvider
+	idempotencyKeys IdempotencyStore
+	adminKeys       AdminVerifier
 }
 
-// NewMiddleware instantiates new authentication middleware.
-func NewMiddleware(ap AuthProvider) *Middleware {
-	return &Middleware{auth: ap}
+// NewMiddleware instantiates new authentication middleware, the options can be left out.
+func NewMiddleware(ap AuthProvider, opts Options) *Middleware {
+	return &Middleware{
+		auth:            ap,
+		tokens:          opts.TokenVerifier,
+		cartTokens:      opts.CartTokens,
+		idempotencyKeys: opts.IdempotencyKeys,
+		adminKeys:       opts.AdminKeys,
+	}
 }
 
 // MiddlewareHandle is a method type that represents Middleware Handle function.
@@ -58,27 +95,42 @@ func (middleware *Middleware) Chain(middlewares ...MiddlewareHandle) MiddlewareC
 	return MiddlewareChain{middlewares: middlewares}
 }
 
-// Authorise checks whether client is auth to make the request.
+// Authorise checks whether client is auth to make the request. the client is
+// identified either by an access key or, when tokens are verified, by a bearer token.
 func (middleware *Middleware) Authorise(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		key := auth.KeyFromClientRequest(r)
+		var (
+			accessKey *auth.AccessKey
+			err       error
+		)
 
-		if key == "" {
-			_ = jsonerror.Unauthorised(w, "incorrect access_key")
+		key := auth.KeyFromClientRequest(r)
+		token := auth.TokenFromClientRequest(r)
+		switch {
+		case key != "":
+			// Received an Key, verify it.
+			accessKey, err = middleware.auth.VerifyKey(r.Context(), key)
+		case token != "" && middleware.tokens != nil:
+			accessKey, err = middleware.tokens.VerifyToken(r.Context(), token)
+		default:
+			_ = jsonerror.Write(w, r, jsonerror.UnauthorisedAccess, "incorrect access_key", nil)
 			return
 		}
 
-		// Received an Key, verify it.
-		accessKey, err := middleware.auth.VerifyKey(r.Context(), key)
-		if err != nil {
-			_ = jsonerror.Unauthorised(w, "incorrect access_key")
+		switch {
+		case err == auth.ErrUnavailable:
+			ctxutil.Logger(r.Context()).WithError(err).Errorf("handler.middleware.auth: cannot verify access key %s", err)
+			_ = jsonerror.Write(w, r, jsonerror.AuthUnavailable, "cannot verify access_key", nil)
+			return
+		case err != nil:
+			_ = jsonerror.Write(w, r, jsonerror.UnauthorisedAccess, "incorrect access_key", nil)
 			return
 		}
 
 		ctx, err := ctxutil.SetUserAuthAccessKey(r.Context(), accessKey)
 		if err != nil {
-			log.Errorf("handler.middleware.auth: could not set user(%d) access key in context %s", accessKey.UserID, err)
-			_ = jsonerror.InternalError(w, "")
+			ctxutil.Logger(r.Context()).Errorf("handler.middleware.auth: could not set user(%d) access key in context %s", accessKey.UserID, err)
+			_ = jsonerror.Write(w, r, jsonerror.ServerError, "", nil)
 			return
 		}
 
@@ -86,6 +138,62 @@ func (middleware *Middleware) Authorise(next httprouter.Handle) httprouter.Handl
 	}
 }
 
+// AuthoriseAdmin checks whether the client is an admin, the admins are identified
+// by their admin keys and act for their tenants. the keys of the users are not
+// accepted
+func (middleware *Middleware) AuthoriseAdmin(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		key := auth.AdminKeyFromClientRequest(r)
+		if key == "" || middleware.adminKeys == nil {
+			_ = jsonerror.Write(w, r, jsonerror.UnauthorisedAccess, "incorrect admin key", nil)
+			return
+		}
+
+		tenant, err := middleware.adminKeys.VerifyAdminKey(r.Context(), key)
+		if err != nil {
+			_ = jsonerror.Write(w, r, jsonerror.UnauthorisedAccess, "incorrect admin key", nil)
+			return
+		}
+
+		next(w, r.WithContext(ctxutil.SetTenant(r.Context(), tenant)), ps)
+	}
+}
+
+// AllowGuests lets the guests, i.e. the visitors who are not logged in, make the
+// request too. a request with the credentials of a user is authorised as usual,
+// otherwise the guest is identified by the token of its cart. with newCart the
+// guests without a cart token are let in as well, so they can create a cart.
+// guests are let in only when the cart tokens are given.
+func (middleware *Middleware) AllowGuests(newCart bool) MiddlewareHandle {
+	return func(next httprouter.Handle) httprouter.Handle {
+		authorise := middleware.Authorise(next)
+
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			if middleware.cartTokens == nil || auth.KeyFromClientRequest(r) != "" || auth.TokenFromClientRequest(r) != "" {
+				authorise(w, r, ps)
+				return
+			}
+
+			var cartID int64
+			token := auth.CartTokenFromClientRequest(r)
+			switch {
+			case token != "":
+				id, err := middleware.cartTokens.VerifyCartToken(token)
+				if err != nil {
+					_ = jsonerror.Write(w, r, jsonerror.UnauthorisedAccess, "incorrect cart token", nil)
+					return
+				}
+				cartID = id
+			case !newCart:
+				_ = jsonerror.Write(w, r, jsonerror.UnauthorisedAccess, "incorrect access_key", nil)
+				return
+			}
+
+			next(w, r.WithContext(ctxutil.SetGuestCartID(r.Context(), cartID)), ps)
+		}
+	}
+}
+
 // ContentTypeJSON for the HTTP response.
 func (middleware *Middleware) ContentTypeJSON(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -93,3 +201,263 @@ func (middleware *Middleware) ContentTypeJSON(next httprouter.Handle) httprouter
 		next(w, r, ps)
 	}
 }
+
+// IfMatch makes the change conditional on the version of the cart, the versions
+// the client read are given by the ETags of the cart in the If-Match header. the
+// change is refused by the service if the cart is of none of them. the tags are
+// compared weakly, W/"3" matches version 3 as "3" does. If-Match: * and no
+// If-Match at all let the change through whatever the version is. a list of
+// entity tags none of which is one of a cart never matches
+func (middleware *Middleware) IfMatch(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ifMatch := strings.TrimSpace(strings.Join(r.Header.Values("If-Match"), ","))
+		if ifMatch == "" || ifMatch == "*" {
+			next(w, r, ps)
+			return
+		}
+
+		versions := parseCartETags(ifMatch)
+		if len(versions) == 0 {
+			_ = jsonerror.Write(w, r, jsonerror.CartVersionMismatch, "cart has changed since it was read", nil)
+			return
+		}
+
+		next(w, r.WithContext(ctxutil.SetCartVersions(r.Context(), versions)), ps)
+	}
+}
+
+// Idempotent lets the clients retry the request safely with an Idempotency-Key.
+// the first response to a key is recorded and replayed to the retries, which are
+// not handled again. the keys are of the user making the request, or of the guest
+// cart, and are kept for idempotencyKeyTTL. a key reused for another request is
+// refused, as is a retry made while the first request is still being handled.
+// the server errors are not recorded, so that the request can be retried. the
+// requests without a key, or when the keys are not stored, are handled as usual,
+// as are the requests of the guests without a cart, who have nothing to scope
+// their keys by. it must follow the authorisation, the scope of the keys is the user
+func (middleware *Middleware) Idempotent(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || middleware.idempotencyKeys == nil {
+			next(w, r, ps)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "Idempotency-Key is longer than 255 characters", jsonerror.Extensions{"param": IdempotencyKeyHeader})
+			return
+		}
+
+		scope, err := idempotencyScope(r.Context())
+		switch {
+		case err != nil:
+			ctxutil.Logger(r.Context()).WithError(err).Errorf("handler.middleware.idempotent: idempotencyScope %s", err)
+			api500Count.With(prometheus.Labels{"method": "idempotent", "reason": "context"}).Inc()
+			_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
+			return
+		case scope == "":
+			next(w, r, ps)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			_ = jsonerror.Write(w, r, jsonerror.MalformedBody, "cannot read body", nil)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		record := &idempotency.Record{
+			Scope:       scope,
+			Key:         key,
+			RequestHash: requestHash(r, body),
+			ExpiresAt:   time.Now().Add(idempotencyLockTTL),
+		}
+		err = middleware.idempotencyKeys.CreateIdempotencyRecord(r.Context(), record)
+		switch {
+		case err == storage.ErrDuplicate:
+			middleware.replay(w, r, record)
+			return
+		case err != nil:
+			ctxutil.Logger(r.Context()).WithError(err).Errorf("handler.middleware.idempotent: storage %s", err)
+			api500Count.With(prometheus.Labels{"method": "idempotent", "reason": "storage"}).Inc()
+			_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot record Idempotency-Key", nil)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r, ps)
+
+		// the response is recorded even if the client is gone meanwhile, the
+		// retries are what the key is for
+		ctx := context.Background()
+		if recorder.statusCode() >= http.StatusInternalServerError {
+			if err := middleware.idempotencyKeys.RemoveIdempotencyRecord(ctx, record.Scope, record.Key); err != nil {
+				ctxutil.Logger(r.Context()).WithError(err).Errorf("handler.middleware.idempotent: cannot remove key %s", err)
+			}
+			return
+		}
+
+		record.StatusCode = recorder.statusCode()
+		record.Header = recorder.header
+		if record.Header == nil {
+			record.Header = w.Header().Clone()
+		}
+		// the token of a cart is a credential, it is given to the client that
+		// made the cart only
+		record.Header.Del(auth.CartTokenHeader)
+		record.Body = recorder.body.Bytes()
+		record.ExpiresAt = time.Now().Add(idempotencyKeyTTL)
+		if err := middleware.idempotencyKeys.UpdateIdempotencyRecord(ctx, record); err != nil {
+			ctxutil.Logger(r.Context()).WithError(err).Errorf("handler.middleware.idempotent: cannot record response %s", err)
+			api500Count.With(prometheus.Labels{"method": "idempotent", "reason": "storage"}).Inc()
+		}
+	}
+}
+
+// replay writes the response recorded for the first request made with the key
+// of the retry
+func (middleware *Middleware) replay(w http.ResponseWriter, r *http.Request, retry *idempotency.Record) {
+	first, err := middleware.idempotencyKeys.GetIdempotencyRecord(r.Context(), retry.Scope, retry.Key)
+	switch {
+	case err == storage.ErrRecordNotFound:
+		// the first request has failed or expired just now, the retry is up to the client
+		_ = jsonerror.Write(w, r, jsonerror.IdempotencyKeyInProgress, "a request with the same Idempotency-Key is in progress", nil)
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("handler.middleware.idempotent: storage %s", err)
+		api500Count.With(prometheus.Labels{"method": "idempotent", "reason": "storage"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot read Idempotency-Key", nil)
+		return
+	}
+
+	switch {
+	case first.RequestHash != retry.RequestHash:
+		_ = jsonerror.Write(w, r, jsonerror.IdempotencyKeyReused, "Idempotency-Key is already used for another request", nil)
+		return
+	case !first.Done():
+		_ = jsonerror.Write(w, r, jsonerror.IdempotencyKeyInProgress, "a request with the same Idempotency-Key is in progress", nil)
+		return
+	}
+
+	for name, values := range first.Header {
+		// the retry is a request of its own, with an id of its own, and never
+		// gets the token of a cart
+		if name == http.CanonicalHeaderKey(RequestIDHeader) || name == http.CanonicalHeaderKey(auth.CartTokenHeader) {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(first.StatusCode)
+	_, _ = w.Write(first.Body)
+}
+
+// idempotencyScope is the scope of the Idempotency-Keys of the request, the
+// user or the guest cart. it is empty for the guests without a cart, they are
+// anonymous and a scope of theirs would be shared by all of them
+func idempotencyScope(ctx context.Context) (string, error) {
+	if cartID, ok := ctxutil.GetGuestCartID(ctx); ok {
+		if cartID == 0 {
+			return "", nil
+		}
+		return "guest:" + strconv.FormatInt(cartID, 10), nil
+	}
+
+	accessKey, err := ctxutil.GetUserAuthAccessKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return "user:" + strconv.FormatInt(accessKey.UserID, 10), nil
+}
+
+// requestHash tells the requests made with the same Idempotency-Key apart
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes the response through while recording it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// statusCode is the status of the response, the handlers that write nothing respond 200
+func (rec *responseRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// cartETag is the entity tag of the version of a cart
+func cartETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseCartETags returns the versions of the cart of a list of entity tags
+// (RFC 9110, 8.8.3), e.g. "2", W/"3". the weak tags are versions too, the tags
+// that are not of a cart are skipped and a malformed list has no versions
+func parseCartETags(list string) []int64 {
+	var versions []int64
+	rest := list
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			return versions
+		}
+
+		rest = strings.TrimPrefix(rest, "W/")
+		if rest == "" || rest[0] != '"' {
+			return nil
+		}
+		end := strings.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return nil
+		}
+		if version, err := strconv.ParseInt(rest[1:end+1], 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+
+		rest = strings.TrimLeft(rest[end+2:], " \t")
+		if rest != "" && rest[0] != ',' {
+			return nil
+		}
+	}
+}
+
+// requestUserID returns the id of the user making the request, the guests are
+// service.GuestUserID
+func requestUserID(ctx context.Context) (int64, error) {
+	if _, ok := ctxutil.GetGuestCartID(ctx); ok {
+		return service.GuestUserID, nil
+	}
+
+	accessKey, err := ctxutil.GetUserAuthAccessKey(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return accessKey.UserID, nil
+}
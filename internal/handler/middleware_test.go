@@ -0,0 +1,311 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/idempotency"
+	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_Authorise_BearerToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	tokenMock := handler.NewMockTokenVerifier(ctrl)
+	tokenMock.EXPECT().
+		VerifyToken(gomock.Any(), "valid.jwt.token").
+		Return(&auth.AccessKey{UserID: 1, Key: "valid.jwt.token"}, nil).AnyTimes()
+	tokenMock.EXPECT().
+		VerifyToken(gomock.Any(), "expired.jwt.token").
+		Return(nil, auth.ErrInvalidToken).AnyTimes()
+	tokenMock.EXPECT().
+		VerifyToken(gomock.Any(), "unknown.jwks.key").
+		Return(nil, auth.ErrUnavailable).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().CreateCart(gomock.Any(), int64(1)).Return(&cart.Cart{
+		ID:       1,
+		UserID:   1,
+		Status:   cart.StatusOpen,
+		Currency: "EUR",
+	}, nil).AnyTimes()
+
+	testsCases := []tests.TestCase{
+		{
+			Name:           "access key - ok",
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`,
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "bearer token - ok",
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			BearerToken:    "valid.jwt.token",
+			ExpectedBody:   `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`,
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "invalid bearer token - error",
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			BearerToken:    "expired.jwt.token",
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "keys of the issuer are unavailable - error",
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			BearerToken:    "unknown.jwks.key",
+			ExpectedBody:   `{"error":{"code":100503, "details": "Service unavailable - cannot verify access_key"}}`,
+			ExpectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testsCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			h, err := handler.New(serviceMock, authMock, handler.Options{TokenVerifier: tokenMock})
+			assert.Nil(t, err)
+			tests.HandlerTest(t, h, &tc)
+		})
+	}
+
+	t.Run("bearer token without a token verifier - error", func(t *testing.T) {
+		h, err := handler.New(serviceMock, authMock, handler.Options{})
+		assert.Nil(t, err)
+		tests.HandlerTest(t, h, &tests.TestCase{
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			BearerToken:    "valid.jwt.token",
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		})
+	})
+}
+
+func TestMiddleware_Idempotent(t *testing.T) {
+	created := &cart.Cart{ID: 1, UserID: 1, Status: cart.StatusOpen, Currency: "EUR", Version: 1}
+	createdBody := `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`
+
+	testCases := []struct {
+		tests.TestCase
+		adjust func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider)
+	}{
+		{
+			TestCase: tests.TestCase{
+				Name:           "no key - ok",
+				ExpectedBody:   createdBody,
+				ExpectedStatus: http.StatusCreated,
+			},
+			adjust: func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider) {
+				sp.EXPECT().CreateCart(gomock.Any(), int64(1)).Return(created, nil)
+			},
+		},
+		{
+			TestCase: tests.TestCase{
+				Name:           "first request - response is recorded",
+				Headers:        map[string]string{handler.IdempotencyKeyHeader: "key-1"},
+				ExpectedBody:   createdBody,
+				ExpectedStatus: http.StatusCreated,
+			},
+			adjust: func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider) {
+				store.EXPECT().CreateIdempotencyRecord(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, r *idempotency.Record) error {
+						assert.Equal(t, "user:1", r.Scope)
+						assert.Equal(t, "key-1", r.Key)
+						assert.NotEmpty(t, r.RequestHash)
+						assert.False(t, r.Done())
+						return nil
+					})
+				sp.EXPECT().CreateCart(gomock.Any(), int64(1)).Return(created, nil)
+				store.EXPECT().UpdateIdempotencyRecord(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, r *idempotency.Record) error {
+						assert.Equal(t, http.StatusCreated, r.StatusCode)
+						assert.Equal(t, `"1"`, r.Header.Get("ETag"))
+						assert.JSONEq(t, createdBody, string(r.Body))
+						assert.True(t, r.ExpiresAt.After(time.Now().Add(time.Hour)))
+						return nil
+					})
+			},
+		},
+		{
+			TestCase: tests.TestCase{
+				Name:           "retry - response is replayed",
+				Headers:        map[string]string{handler.IdempotencyKeyHeader: "key-1", handler.RequestIDHeader: "retry-request"},
+				ExpectedBody:   createdBody,
+				ExpectedStatus: http.StatusCreated,
+				ExpectedHeaders: map[string]string{
+					"ETag":                           `"1"`,
+					handler.IdempotentReplayedHeader: "true",
+					// the retry keeps its own request id
+					handler.RequestIDHeader: "retry-request",
+					// and never gets the token of a cart
+					auth.CartTokenHeader: "",
+				},
+			},
+			adjust: func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider) {
+				var hash string
+				store.EXPECT().CreateIdempotencyRecord(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, r *idempotency.Record) error {
+						hash = r.RequestHash
+						return storage.ErrDuplicate
+					})
+				store.EXPECT().GetIdempotencyRecord(gomock.Any(), "user:1", "key-1").
+					DoAndReturn(func(_ context.Context, scope, key string) (*idempotency.Record, error) {
+						return &idempotency.Record{
+							Scope:       scope,
+							Key:         key,
+							RequestHash: hash,
+							StatusCode:  http.StatusCreated,
+							Header:      http.Header{"Content-Type": {"application/json"}, "Etag": {`"1"`}, "X-Request-Id": {"first-request"}, "X-Cart-Token": {"token-1"}},
+							Body:        []byte(createdBody),
+						}, nil
+					})
+			},
+		},
+		{
+			TestCase: tests.TestCase{
+				Name:           "retry while the first request is handled - error",
+				Headers:        map[string]string{handler.IdempotencyKeyHeader: "key-1"},
+				ExpectedBody:   `{"error":{"code":100409, "details": "Conflict - a request with the same Idempotency-Key is in progress"}}`,
+				ExpectedStatus: http.StatusConflict,
+			},
+			adjust: func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider) {
+				var hash string
+				store.EXPECT().CreateIdempotencyRecord(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, r *idempotency.Record) error {
+						hash = r.RequestHash
+						return storage.ErrDuplicate
+					})
+				store.EXPECT().GetIdempotencyRecord(gomock.Any(), "user:1", "key-1").
+					DoAndReturn(func(_ context.Context, scope, key string) (*idempotency.Record, error) {
+						return &idempotency.Record{Scope: scope, Key: key, RequestHash: hash}, nil
+					})
+			},
+		},
+		{
+			TestCase: tests.TestCase{
+				Name:           "key reused for another request - error",
+				Headers:        map[string]string{handler.IdempotencyKeyHeader: "key-1"},
+				ExpectedBody:   `{"error":{"code":100422, "details": "Invalid params - Idempotency-Key is already used for another request"}}`,
+				ExpectedStatus: http.StatusUnprocessableEntity,
+			},
+			adjust: func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider) {
+				store.EXPECT().CreateIdempotencyRecord(gomock.Any(), gomock.Any()).Return(storage.ErrDuplicate)
+				store.EXPECT().GetIdempotencyRecord(gomock.Any(), "user:1", "key-1").Return(&idempotency.Record{
+					Scope:       "user:1",
+					Key:         "key-1",
+					RequestHash: "another request",
+					StatusCode:  http.StatusCreated,
+				}, nil)
+			},
+		},
+		{
+			TestCase: tests.TestCase{
+				Name:           "server error - key is removed",
+				Headers:        map[string]string{handler.IdempotencyKeyHeader: "key-1"},
+				ExpectedBody:   `{"error":{"code":100500, "details": "Internal error - cannot create cart"}}`,
+				ExpectedStatus: http.StatusInternalServerError,
+			},
+			adjust: func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider) {
+				store.EXPECT().CreateIdempotencyRecord(gomock.Any(), gomock.Any()).Return(nil)
+				sp.EXPECT().CreateCart(gomock.Any(), int64(1)).Return(nil, assert.AnError)
+				store.EXPECT().RemoveIdempotencyRecord(gomock.Any(), "user:1", "key-1").Return(nil)
+			},
+		},
+		{
+			TestCase: tests.TestCase{
+				Name:           "key too long - error",
+				Headers:        map[string]string{handler.IdempotencyKeyHeader: strings.Repeat("k", 256)},
+				ExpectedBody:   `{"error":{"code":100422, "details": "Invalid params - Idempotency-Key is longer than 255 characters"}}`,
+				ExpectedStatus: http.StatusUnprocessableEntity,
+			},
+			adjust: func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider) {},
+		},
+		{
+			TestCase: tests.TestCase{
+				Name:           "storage error - error",
+				Headers:        map[string]string{handler.IdempotencyKeyHeader: "key-1"},
+				ExpectedBody:   `{"error":{"code":100500, "details": "Internal error - cannot record Idempotency-Key"}}`,
+				ExpectedStatus: http.StatusInternalServerError,
+			},
+			adjust: func(store *handler.MockIdempotencyStore, sp *handler.MockServiceProvider) {
+				store.EXPECT().CreateIdempotencyRecord(gomock.Any(), gomock.Any()).Return(assert.AnError)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			authMock := handler.NewMockAuthProvider(ctrl)
+			authMock.EXPECT().
+				VerifyAccessKey(gomock.Any(), "abc123456").
+				Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+			serviceMock := handler.NewMockServiceProvider(ctrl)
+			storeMock := handler.NewMockIdempotencyStore(ctrl)
+			tc.adjust(storeMock, serviceMock)
+
+			tc.Method = http.MethodPost
+			tc.Target = "/carts"
+			tc.AccessKey = "abc123456"
+
+			h, err := handler.New(serviceMock, authMock, handler.Options{IdempotencyKeys: storeMock})
+			assert.Nil(t, err)
+			tests.HandlerTest(t, h, &tc.TestCase)
+		})
+	}
+}
+
+func TestMiddleware_IdempotentGuests(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	guestCart := &cart.Cart{ID: 2, Status: cart.StatusOpen, Currency: "EUR", Version: 1}
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	// the store is not called, the guests without a cart have no scope
+	storeMock := handler.NewMockIdempotencyStore(ctrl)
+	cartTokensMock := handler.NewMockCartTokenProvider(ctrl)
+	cartTokensMock.EXPECT().IssueCartToken(int64(2)).Return("token-2").AnyTimes()
+
+	h, err := handler.New(serviceMock, handler.NewMockAuthProvider(ctrl), handler.Options{CartTokens: cartTokensMock, IdempotencyKeys: storeMock})
+	assert.Nil(t, err)
+
+	// every retry of a guest without a cart creates a cart of its own
+	serviceMock.EXPECT().CreateCart(gomock.Any(), int64(0)).Return(guestCart, nil).Times(2)
+	for i := 0; i < 2; i++ {
+		tests.HandlerTest(t, h, &tests.TestCase{
+			Name:           "guest without a cart",
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			Headers:        map[string]string{handler.IdempotencyKeyHeader: "key-1"},
+			ExpectedBody:   `{"id":2, "status":"open", "currency":"EUR"}`,
+			ExpectedStatus: http.StatusCreated,
+			ExpectedHeaders: map[string]string{
+				auth.CartTokenHeader:             "token-2",
+				handler.IdempotentReplayedHeader: "",
+			},
+		})
+	}
+}
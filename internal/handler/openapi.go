@@ -0,0 +1,45 @@
+package handler
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// openAPI is the OpenAPI 3 document of the api, it documents every route of New
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// docs is the Swagger UI page of the document, the assets of Swagger UI are of its CDN
+//
+//go:embed docs.html
+var docs []byte
+
+// Route is a route of the handler, Path is its template, e.g. /carts/:cartID/items
+type Route struct {
+	Method string
+	Path   string
+}
+
+// Routes returns the routes of the handler in the order they are registered
+func (h *Handler) Routes() []Route {
+	return append([]Route(nil), h.routes...)
+}
+
+// getOpenAPI is the handler for
+// GET /openapi.json
+func (h *Handler) getOpenAPI(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPI)
+}
+
+// getDocs is the handler for
+// GET /docs
+func (h *Handler) getDocs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(docs)
+}
@@ -0,0 +1,54 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cubny/cart/internal/handler"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_OpenAPI(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	h, err := handler.New(handler.NewMockServiceProvider(ctrl), handler.NewMockAuthProvider(ctrl), handler.Options{})
+	assert.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	doc := struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Contains(t, doc.Paths, "/carts/{cartID}/items")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"/openapi.json"`)
+}
+
+func TestHandler_Routes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	h, err := handler.New(handler.NewMockServiceProvider(ctrl), handler.NewMockAuthProvider(ctrl), handler.Options{})
+	assert.Nil(t, err)
+
+	routes := h.Routes()
+	assert.Equal(t, handler.Route{Method: http.MethodGet, Path: "/health"}, routes[0])
+	assert.Contains(t, routes, handler.Route{Method: http.MethodDelete, Path: "/carts/:cartID/items"})
+	// the admin api is not served without the webhooks
+	assert.NotContains(t, routes, handler.Route{Method: http.MethodGet, Path: "/admin/webhooks"})
+}
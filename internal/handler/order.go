@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/jsonerror"
+	"github.com/cubny/cart/internal/service"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// checkout is the handler for
+// POST /carts/:cartID/checkout
+func (h *Handler) checkout(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	accessKey, err := ctxutil.GetUserAuthAccessKey(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("checkout: GetUserAuthAccessKey %s", err)
+		api500Count.With(prometheus.Labels{"method": "checkout", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
+		return
+	}
+
+	cartID, err := strconv.Atoi(p.ByName("cartID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "cart_id param is not a valid number", jsonerror.Extensions{"param": "cart_id"})
+		return
+	}
+
+	order, err := h.service.Checkout(r.Context(), accessKey.UserID, int64(cartID))
+	switch {
+	case err == service.ErrCartNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == service.ErrCartNotOpen:
+		_ = jsonerror.Write(w, r, jsonerror.CartNotOpen, "cart is not open", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == cart.ErrEmptyCart:
+		_ = jsonerror.Write(w, r, jsonerror.CartEmpty, "cart has no items", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err == cart.ErrAmountOverflow:
+		_ = jsonerror.Write(w, r, jsonerror.AmountOutOfRange, "total of the cart is out of range", jsonerror.Extensions{"cart_id": cartID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("checkout: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "checkout", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not checkout cart", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("checkout: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "checkout", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// getOrder is the handler for
+// GET /orders/:orderID
+func (h *Handler) getOrder(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	accessKey, err := ctxutil.GetUserAuthAccessKey(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getOrder: GetUserAuthAccessKey %s", err)
+		api500Count.With(prometheus.Labels{"method": "getOrder", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve user from accessKey", nil)
+		return
+	}
+
+	orderID, err := strconv.Atoi(p.ByName("orderID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "order_id param is not a valid number", jsonerror.Extensions{"param": "order_id"})
+		return
+	}
+
+	order, err := h.service.GetOrder(r.Context(), accessKey.UserID, int64(orderID))
+	switch {
+	case err == service.ErrOrderNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.OrderNotFound, "order does not exist", jsonerror.Extensions{"order_id": orderID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getOrder: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "getOrder", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not get order", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getOrder: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "getOrder", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
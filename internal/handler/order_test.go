@@ -0,0 +1,142 @@
+package handler_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Checkout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().Checkout(gomock.Any(), int64(1), int64(1)).Return(&cart.Order{
+		ID:     3,
+		CartID: 1,
+		UserID: 1,
+		Lines: []*cart.OrderLine{
+			{ID: 1, OrderID: 3, ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")},
+		},
+		ItemCount:     1,
+		TotalQuantity: 2,
+		Total:         cart.NewMoney(2000, "EUR"),
+		Currency:      "EUR",
+		CheckedOutAt:  time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC),
+	}, nil)
+	serviceMock.EXPECT().Checkout(gomock.Any(), int64(1), int64(2)).Return(nil, service.ErrCartNotFound)
+	serviceMock.EXPECT().Checkout(gomock.Any(), int64(1), int64(3)).Return(nil, service.ErrCartNotOpen)
+	serviceMock.EXPECT().Checkout(gomock.Any(), int64(1), int64(4)).Return(nil, cart.ErrEmptyCart)
+	serviceMock.EXPECT().Checkout(gomock.Any(), int64(1), int64(5)).Return(nil, assert.AnError)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:      "ok - 201",
+			Method:    http.MethodPost,
+			Target:    "/carts/1/checkout",
+			AccessKey: "abc123456",
+			ExpectedBody: `{"id":3, "cart_id":1, "user_id":1, "item_count":1, "total_quantity":2, "total":20, "currency":"EUR",
+				"checked_out_at":"2020-07-01T10:00:00Z",
+				"lines":[{"id":1, "order_id":3, "product_id":1, "quantity":2, "price":20}]}`,
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "cart not found - 404",
+			Method:         http.MethodPost,
+			Target:         "/carts/2/checkout",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "cart not open - 409",
+			Method:         http.MethodPost,
+			Target:         "/carts/3/checkout",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart is not open"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+		{
+			Name:           "cart is empty - 422",
+			Method:         http.MethodPost,
+			Target:         "/carts/4/checkout",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - cart has no items"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "service error - 500",
+			Method:         http.MethodPost,
+			Target:         "/carts/5/checkout",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"error":{"code":100500, "details":"Internal error - could not checkout cart"}}`,
+			ExpectedStatus: http.StatusInternalServerError,
+		},
+	}
+	execHTTPTestCases(t, serviceMock, authMock, testsCases)
+}
+
+func TestHandler_GetOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().GetOrder(gomock.Any(), int64(1), int64(3)).Return(&cart.Order{
+		ID:           3,
+		CartID:       1,
+		UserID:       1,
+		Lines:        []*cart.OrderLine{},
+		Total:        cart.NewMoney(0, "EUR"),
+		Currency:     "EUR",
+		CheckedOutAt: time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC),
+	}, nil)
+	serviceMock.EXPECT().GetOrder(gomock.Any(), int64(1), int64(4)).Return(nil, service.ErrOrderNotFound)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:      "ok - 200",
+			Method:    http.MethodGet,
+			Target:    "/orders/3",
+			AccessKey: "abc123456",
+			ExpectedBody: `{"id":3, "cart_id":1, "user_id":1, "item_count":0, "total_quantity":0, "total":0, "currency":"EUR",
+				"checked_out_at":"2020-07-01T10:00:00Z", "lines":[]}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "order not found - 404",
+			Method:         http.MethodGet,
+			Target:         "/orders/4",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - order does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "order id string - invalid param",
+			Method:         http.MethodGet,
+			Target:         "/orders/order",
+			AccessKey:      "abc123456",
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - order_id param is not a valid number"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+	execHTTPTestCases(t, serviceMock, authMock, testsCases)
+}
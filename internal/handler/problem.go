@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/jsonerror"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// listProblems is the handler for
+// GET /problems
+// it lists every error the api can respond with, so that the clients can
+// generate the handling of the errors from it
+func (h *Handler) listProblems(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(jsonerror.Catalog()); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("listProblems: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "listProblems", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// getProblem is the handler for
+// GET /problems/:problemID
+// the type uri of a problem leads here
+func (h *Handler) getProblem(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	problem, ok := jsonerror.LookupProblem(p.ByName("problemID"))
+	if !ok {
+		_ = jsonerror.Write(w, r, jsonerror.RouteNotFound, "problem does not exist", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getProblem: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "getProblem", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
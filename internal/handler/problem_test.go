@@ -0,0 +1,180 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/jsonerror"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Problems(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "incorrect").
+		Return(nil, auth.ErrNotFound).AnyTimes()
+
+	serviceMock := handler.NewMockServiceProvider(ctrl)
+	serviceMock.EXPECT().CartDetails(gomock.Any(), int64(1), int64(404)).Return(nil, service.ErrCartNotFound).AnyTimes()
+
+	h, err := handler.New(serviceMock, authMock, handler.Options{})
+	assert.Nil(t, err)
+
+	problemHeaders := map[string]string{"Accept": jsonerror.ProblemContentType, handler.RequestIDHeader: "request-1"}
+	testCases := []tests.TestCase{
+		{
+			Name:           "error format by default",
+			Method:         http.MethodGet,
+			Target:         "/carts/404",
+			AccessKey:      "abc123456",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+		},
+		{
+			Name:           "problem details when accepted",
+			Method:         http.MethodGet,
+			Target:         "/carts/404",
+			AccessKey:      "abc123456",
+			Headers:        problemHeaders,
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody: `{
+				"type": "/problems/cart-not-found",
+				"title": "Cart not found",
+				"status": 404,
+				"detail": "cart does not exist",
+				"instance": "/carts/404",
+				"request_id": "request-1",
+				"cart_id": 404
+			}`,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonerror.ProblemContentType},
+		},
+		{
+			Name:           "invalid param is named",
+			Method:         http.MethodGet,
+			Target:         "/carts/abc",
+			AccessKey:      "abc123456",
+			Headers:        problemHeaders,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+			ExpectedBody: `{
+				"type": "/problems/invalid-param",
+				"title": "Invalid parameter",
+				"status": 422,
+				"detail": "cart_id param is not a valid number",
+				"instance": "/carts/abc",
+				"request_id": "request-1",
+				"param": "cart_id"
+			}`,
+		},
+		{
+			Name:           "violations of the fields",
+			Method:         http.MethodPatch,
+			Target:         "/items/1",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"quantity":-1, "price":1}`,
+			Headers:        problemHeaders,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+			ExpectedBody: `{
+				"type": "/problems/validation-failed",
+				"title": "Validation failed",
+				"status": 422,
+				"detail": "the fields of the request are invalid",
+				"instance": "/items/1",
+				"request_id": "request-1",
+				"violations": [
+					{"field":"price", "rule":"unknown", "message":"price is not a known field"},
+					{"field":"quantity", "rule":"min", "message":"quantity must be at least 0"}
+				]
+			}`,
+		},
+		{
+			Name:           "unauthorised",
+			Method:         http.MethodGet,
+			Target:         "/carts/404",
+			AccessKey:      "incorrect",
+			Headers:        problemHeaders,
+			ExpectedStatus: http.StatusUnauthorized,
+			ExpectedBody: `{
+				"type": "/problems/unauthorised",
+				"title": "Unauthorised access",
+				"status": 401,
+				"detail": "incorrect access_key",
+				"instance": "/carts/404",
+				"request_id": "request-1"
+			}`,
+		},
+		{
+			Name:           "path of no route",
+			Method:         http.MethodGet,
+			Target:         "/no/such/route",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - no route matches the path"}}`,
+		},
+		{
+			Name:           "method of no route",
+			Method:         http.MethodPut,
+			Target:         "/carts/404",
+			Headers:        problemHeaders,
+			ExpectedStatus: http.StatusMethodNotAllowed,
+			ExpectedBody: `{
+				"type": "/problems/method-not-allowed",
+				"title": "Method not allowed",
+				"status": 405,
+				"detail": "the route does not serve the method",
+				"instance": "/carts/404",
+				"request_id": "request-1"
+			}`,
+		},
+		{
+			Name:           "problem of the type uri",
+			Method:         http.MethodGet,
+			Target:         "/problems/cart-not-found",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody: `{
+				"id": "cart-not-found",
+				"type": "/problems/cart-not-found",
+				"title": "Cart not found",
+				"status": 404,
+				"code": 100404,
+				"description": "The cart does not exist or is not of the user or the guest of the request.",
+				"extensions": ["cart_id"]
+			}`,
+		},
+		{
+			Name:           "problem of no type",
+			Method:         http.MethodGet,
+			Target:         "/problems/no-such-problem",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - problem does not exist"}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tests.HandlerTest(t, h, &tc)
+		})
+	}
+
+	t.Run("catalog", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/problems", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		var problems []jsonerror.Problem
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&problems))
+		assert.Equal(t, jsonerror.Catalog(), problems)
+	})
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/cubny/cart/internal/jsonerror"
+	"github.com/cubny/cart/internal/validation"
+)
+
+// decodeBody decodes the json body of the request into req, a pointer to one of
+// the request types, and validates it by the rules of its fields. the request
+// is responded to if the body is malformed or invalid, ok is false then. an
+// empty body is the zero request when it is allowed
+func decodeBody(w http.ResponseWriter, r *http.Request, req interface{}, emptyAllowed bool) (ok bool) {
+	err := validation.Decode(r.Body, req)
+	violations, isViolations := err.(validation.Violations)
+	switch {
+	case err == nil:
+		return true
+	case err == validation.ErrEmptyBody && emptyAllowed:
+		return true
+	case isViolations:
+		_ = jsonerror.WriteViolations(w, r, violations)
+		return false
+	default:
+		_ = jsonerror.Write(w, r, jsonerror.MalformedBody, "body has invalid json format", nil)
+		return false
+	}
+}
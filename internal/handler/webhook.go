@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/jsonerror"
+	"github.com/cubny/cart/internal/webhook"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaultDeliveriesLimit is how many deliveries are listed when no limit is given
+	defaultDeliveriesLimit = 50
+	// maxDeliveriesLimit is the most deliveries listed at once
+	maxDeliveriesLimit = 100
+)
+
+// createWebhookRequest is the body of POST /admin/webhooks, the url and the event
+// types are checked further by the webhooks
+type createWebhookRequest struct {
+	URL        string           `json:"url" validate:"required"`
+	EventTypes []cart.EventType `json:"event_types" validate:"required,min=1"`
+}
+
+// createWebhook is the handler for
+// POST /admin/webhooks
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	tenant, err := ctxutil.GetTenant(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("createWebhook: GetTenant %s", err)
+		api500Count.With(prometheus.Labels{"method": "createWebhook", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve tenant from admin key", nil)
+		return
+	}
+
+	subReq := &createWebhookRequest{}
+	if !decodeBody(w, r, subReq, false) {
+		return
+	}
+
+	sub, err := h.webhooks.CreateSubscription(r.Context(), tenant, subReq.URL, subReq.EventTypes)
+	switch {
+	case err == webhook.ErrInvalidURL:
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "url must be an absolute http or https url", jsonerror.Extensions{"param": "url"})
+		return
+	case err == webhook.ErrNoEventTypes:
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "event_types is required", jsonerror.Extensions{"param": "event_types"})
+		return
+	case err == webhook.ErrInvalidEventType:
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "event_types has an unknown event type", jsonerror.Extensions{"param": "event_types"})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("createWebhook: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "createWebhook", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot create webhook", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(sub); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("createWebhook: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "createWebhook", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// listWebhooks is the handler for
+// GET /admin/webhooks
+func (h *Handler) listWebhooks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	tenant, err := ctxutil.GetTenant(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("listWebhooks: GetTenant %s", err)
+		api500Count.With(prometheus.Labels{"method": "listWebhooks", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve tenant from admin key", nil)
+		return
+	}
+
+	subs, err := h.webhooks.ListSubscriptions(r.Context(), tenant)
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("listWebhooks: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "listWebhooks", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not list webhooks", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(subs); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("listWebhooks: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "listWebhooks", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// getWebhook is the handler for
+// GET /admin/webhooks/:subscriptionID
+func (h *Handler) getWebhook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	tenant, err := ctxutil.GetTenant(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getWebhook: GetTenant %s", err)
+		api500Count.With(prometheus.Labels{"method": "getWebhook", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve tenant from admin key", nil)
+		return
+	}
+
+	subscriptionID, err := strconv.Atoi(p.ByName("subscriptionID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "subscription_id param is not a valid number", jsonerror.Extensions{"param": "subscription_id"})
+		return
+	}
+
+	sub, err := h.webhooks.GetSubscription(r.Context(), tenant, int64(subscriptionID))
+	switch {
+	case err == webhook.ErrSubscriptionNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.WebhookNotFound, "webhook does not exist", jsonerror.Extensions{"subscription_id": subscriptionID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getWebhook: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "getWebhook", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not get webhook", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(sub); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("getWebhook: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "getWebhook", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// removeWebhook is the handler for
+// DELETE /admin/webhooks/:subscriptionID
+func (h *Handler) removeWebhook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	tenant, err := ctxutil.GetTenant(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("removeWebhook: GetTenant %s", err)
+		api500Count.With(prometheus.Labels{"method": "removeWebhook", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve tenant from admin key", nil)
+		return
+	}
+
+	subscriptionID, err := strconv.Atoi(p.ByName("subscriptionID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "subscription_id param is not a valid number", jsonerror.Extensions{"param": "subscription_id"})
+		return
+	}
+
+	err = h.webhooks.RemoveSubscription(r.Context(), tenant, int64(subscriptionID))
+	switch {
+	case err == webhook.ErrSubscriptionNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.WebhookNotFound, "webhook does not exist", jsonerror.Extensions{"subscription_id": subscriptionID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("removeWebhook: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "removeWebhook", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not remove webhook", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listDeliveries is the handler for
+// GET /admin/webhooks/:subscriptionID/deliveries?limit=50
+func (h *Handler) listDeliveries(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	tenant, err := ctxutil.GetTenant(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("listDeliveries: GetTenant %s", err)
+		api500Count.With(prometheus.Labels{"method": "listDeliveries", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve tenant from admin key", nil)
+		return
+	}
+
+	subscriptionID, err := strconv.Atoi(p.ByName("subscriptionID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "subscription_id param is not a valid number", jsonerror.Extensions{"param": "subscription_id"})
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+			_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "limit must be a number from 1 to 100", jsonerror.Extensions{"param": "limit"})
+			return
+		}
+	}
+
+	deliveries, err := h.webhooks.ListDeliveries(r.Context(), tenant, int64(subscriptionID), limit)
+	switch {
+	case err == webhook.ErrSubscriptionNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.WebhookNotFound, "webhook does not exist", jsonerror.Extensions{"subscription_id": subscriptionID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("listDeliveries: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "listDeliveries", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not list deliveries", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("listDeliveries: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "listDeliveries", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
+
+// redeliver is the handler for
+// POST /admin/webhooks/:subscriptionID/deliveries/:deliveryID/redeliver
+// the delivery is posted again by the dispatcher, hence 202
+func (h *Handler) redeliver(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	tenant, err := ctxutil.GetTenant(r.Context())
+	if err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("redeliver: GetTenant %s", err)
+		api500Count.With(prometheus.Labels{"method": "redeliver", "reason": "context"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot retrieve tenant from admin key", nil)
+		return
+	}
+
+	subscriptionID, err := strconv.Atoi(p.ByName("subscriptionID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "subscription_id param is not a valid number", jsonerror.Extensions{"param": "subscription_id"})
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(p.ByName("deliveryID"))
+	if err != nil {
+		_ = jsonerror.Write(w, r, jsonerror.InvalidParam, "delivery_id param is not a valid number", jsonerror.Extensions{"param": "delivery_id"})
+		return
+	}
+
+	d, err := h.webhooks.Redeliver(r.Context(), tenant, int64(subscriptionID), int64(deliveryID))
+	switch {
+	case err == webhook.ErrSubscriptionNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.WebhookNotFound, "webhook does not exist", jsonerror.Extensions{"subscription_id": subscriptionID})
+		return
+	case err == webhook.ErrDeliveryNotFound:
+		_ = jsonerror.Write(w, r, jsonerror.DeliveryNotFound, "delivery does not exist", jsonerror.Extensions{"subscription_id": subscriptionID, "delivery_id": deliveryID})
+		return
+	case err != nil:
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("redeliver: service %s", err)
+		api500Count.With(prometheus.Labels{"method": "redeliver", "reason": "service"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "could not redeliver", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		ctxutil.Logger(r.Context()).WithError(err).Errorf("redeliver: encoder %s", err)
+		api500Count.With(prometheus.Labels{"method": "redeliver", "reason": "encoder"}).Inc()
+		_ = jsonerror.Write(w, r, jsonerror.ServerError, "cannot encode response", nil)
+		return
+	}
+}
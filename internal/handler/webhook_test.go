@@ -0,0 +1,332 @@
+package handler_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/tests"
+	"github.com/cubny/cart/internal/webhook"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+var adminHeaders = map[string]string{"Authorisation": "Admin acme-key"}
+
+// execWebhookTestCases runs the test cases against the admin api, the admin key
+// acme-key is of the tenant acme
+func execWebhookTestCases(t *testing.T, ctrl *gomock.Controller, webhooks handler.WebhookProvider, tcs []tests.TestCase) {
+	authMock := handler.NewMockAuthProvider(ctrl)
+	authMock.EXPECT().
+		VerifyAccessKey(gomock.Any(), "abc123456").
+		Return(&auth.AccessKey{UserID: 1, Key: "abc123456"}, nil).AnyTimes()
+	adminMock := handler.NewMockAdminVerifier(ctrl)
+	adminMock.EXPECT().VerifyAdminKey(gomock.Any(), "acme-key").Return("acme", nil).AnyTimes()
+	adminMock.EXPECT().VerifyAdminKey(gomock.Any(), gomock.Any()).Return("", auth.ErrInvalidAdminKey).AnyTimes()
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			h, err := handler.New(handler.NewMockServiceProvider(ctrl), authMock, handler.Options{Webhooks: webhooks, AdminKeys: adminMock})
+			assert.Nil(t, err)
+			tests.HandlerTest(t, h, &tc)
+		})
+	}
+}
+
+func TestHandler_CreateWebhook(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	created := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	webhooksMock := handler.NewMockWebhookProvider(ctrl)
+	webhooksMock.EXPECT().
+		CreateSubscription(gomock.Any(), "acme", "https://acme.example.com", []cart.EventType{cart.EventItemAdded}).
+		Return(&webhook.Subscription{ID: 1, Tenant: "acme", URL: "https://acme.example.com", EventTypes: []cart.EventType{cart.EventItemAdded}, Secret: "whsec_1", CreatedAt: created}, nil)
+	webhooksMock.EXPECT().
+		CreateSubscription(gomock.Any(), "acme", "ftp://acme.example.com", gomock.Any()).
+		Return(nil, webhook.ErrInvalidURL)
+	webhooksMock.EXPECT().
+		CreateSubscription(gomock.Any(), "acme", "https://acme.example.com/unknown", gomock.Any()).
+		Return(nil, webhook.ErrInvalidEventType)
+	webhooksMock.EXPECT().
+		CreateSubscription(gomock.Any(), "acme", "https://acme.example.com/error", gomock.Any()).
+		Return(nil, assert.AnError)
+
+	testCases := []tests.TestCase{
+		{
+			Name:           "ok - 201",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks",
+			Headers:        adminHeaders,
+			ReqBody:        `{"url":"https://acme.example.com", "event_types":["ItemAdded"]}`,
+			ExpectedBody:   `{"id":1, "url":"https://acme.example.com", "event_types":["ItemAdded"], "secret":"whsec_1", "created_at":"2020-07-01T10:00:00Z"}`,
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "invalid json - 400",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks",
+			Headers:        adminHeaders,
+			ReqBody:        `{"url":`,
+			ExpectedBody:   `{"error":{"code":100400, "details":"Bad Request - body has invalid json format"}}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "invalid url - 422",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks",
+			Headers:        adminHeaders,
+			ReqBody:        `{"url":"ftp://acme.example.com", "event_types":["ItemAdded"]}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - url must be an absolute http or https url"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "no event types - 422",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks",
+			Headers:        adminHeaders,
+			ReqBody:        `{"url":"https://acme.example.com/none"}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[{"field":"event_types", "rule":"required", "message":"event_types is required"}]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "unknown event type - 422",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks",
+			Headers:        adminHeaders,
+			ReqBody:        `{"url":"https://acme.example.com/unknown", "event_types":["CartShipped"]}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - event_types has an unknown event type"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "service error - 500",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks",
+			Headers:        adminHeaders,
+			ReqBody:        `{"url":"https://acme.example.com/error", "event_types":["ItemAdded"]}`,
+			ExpectedBody:   `{"error":{"code":100500, "details":"Internal error - cannot create webhook"}}`,
+			ExpectedStatus: http.StatusInternalServerError,
+		},
+		{
+			Name:           "access key of a user - 401",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks",
+			AccessKey:      "abc123456",
+			ReqBody:        `{"url":"https://acme.example.com", "event_types":["ItemAdded"]}`,
+			ExpectedBody:   `{"error":{"code":100401, "details":"Unauthorised access - incorrect admin key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "incorrect admin key - 401",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks",
+			Headers:        map[string]string{"Authorisation": "Admin other-key"},
+			ReqBody:        `{"url":"https://acme.example.com", "event_types":["ItemAdded"]}`,
+			ExpectedBody:   `{"error":{"code":100401, "details":"Unauthorised access - incorrect admin key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	execWebhookTestCases(t, ctrl, webhooksMock, testCases)
+}
+
+func TestHandler_Webhooks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	created := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	sub := &webhook.Subscription{ID: 1, Tenant: "acme", URL: "https://acme.example.com", EventTypes: []cart.EventType{cart.EventItemAdded}, CreatedAt: created}
+	webhooksMock := handler.NewMockWebhookProvider(ctrl)
+	webhooksMock.EXPECT().ListSubscriptions(gomock.Any(), "acme").Return([]*webhook.Subscription{sub}, nil)
+	webhooksMock.EXPECT().GetSubscription(gomock.Any(), "acme", int64(1)).Return(sub, nil)
+	webhooksMock.EXPECT().GetSubscription(gomock.Any(), "acme", int64(2)).Return(nil, webhook.ErrSubscriptionNotFound)
+	webhooksMock.EXPECT().RemoveSubscription(gomock.Any(), "acme", int64(1)).Return(nil)
+	webhooksMock.EXPECT().RemoveSubscription(gomock.Any(), "acme", int64(2)).Return(webhook.ErrSubscriptionNotFound)
+
+	testCases := []tests.TestCase{
+		{
+			Name:           "list - 200",
+			Method:         http.MethodGet,
+			Target:         "/admin/webhooks",
+			Headers:        adminHeaders,
+			ExpectedBody:   `[{"id":1, "url":"https://acme.example.com", "event_types":["ItemAdded"], "created_at":"2020-07-01T10:00:00Z"}]`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "get - 200",
+			Method:         http.MethodGet,
+			Target:         "/admin/webhooks/1",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"id":1, "url":"https://acme.example.com", "event_types":["ItemAdded"], "created_at":"2020-07-01T10:00:00Z"}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "get not found - 404",
+			Method:         http.MethodGet,
+			Target:         "/admin/webhooks/2",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - webhook does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "get invalid id - 422",
+			Method:         http.MethodGet,
+			Target:         "/admin/webhooks/abc",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - subscription_id param is not a valid number"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "remove - 204",
+			Method:         http.MethodDelete,
+			Target:         "/admin/webhooks/1",
+			Headers:        adminHeaders,
+			ExpectedStatus: http.StatusNoContent,
+		},
+		{
+			Name:           "remove not found - 404",
+			Method:         http.MethodDelete,
+			Target:         "/admin/webhooks/2",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - webhook does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+	}
+
+	execWebhookTestCases(t, ctrl, webhooksMock, testCases)
+}
+
+func TestHandler_Deliveries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	at := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	delivery := &webhook.Delivery{
+		ID:             3,
+		SubscriptionID: 1,
+		Event:          &cart.Event{ID: 7, Type: cart.EventItemAdded, CartID: 2, Data: []byte(`{"id":5}`), OccurredAt: at},
+		Status:         outbox.StatusDead,
+		Attempts:       15,
+		ResponseStatus: 500,
+		LastError:      "webhook: unexpected status 500",
+		NextAttemptAt:  at,
+		CreatedAt:      at,
+		UpdatedAt:      at,
+	}
+	redelivered := *delivery
+	redelivered.Status = outbox.StatusPending
+	redelivered.Attempts = 0
+
+	webhooksMock := handler.NewMockWebhookProvider(ctrl)
+	webhooksMock.EXPECT().ListDeliveries(gomock.Any(), "acme", int64(1), 50).Return([]*webhook.Delivery{delivery}, nil)
+	webhooksMock.EXPECT().ListDeliveries(gomock.Any(), "acme", int64(1), 10).Return([]*webhook.Delivery{}, nil)
+	webhooksMock.EXPECT().ListDeliveries(gomock.Any(), "acme", int64(2), 50).Return(nil, webhook.ErrSubscriptionNotFound)
+	webhooksMock.EXPECT().Redeliver(gomock.Any(), "acme", int64(1), int64(3)).Return(&redelivered, nil)
+	webhooksMock.EXPECT().Redeliver(gomock.Any(), "acme", int64(1), int64(4)).Return(nil, webhook.ErrDeliveryNotFound)
+	webhooksMock.EXPECT().Redeliver(gomock.Any(), "acme", int64(2), int64(3)).Return(nil, webhook.ErrSubscriptionNotFound)
+	webhooksMock.EXPECT().Redeliver(gomock.Any(), "acme", int64(1), int64(5)).Return(nil, assert.AnError)
+
+	testCases := []tests.TestCase{
+		{
+			Name:    "list - 200",
+			Method:  http.MethodGet,
+			Target:  "/admin/webhooks/1/deliveries",
+			Headers: adminHeaders,
+			ExpectedBody: `[{"id":3, "subscription_id":1, "status":"dead", "attempts":15, "response_status":500,
+				"last_error":"webhook: unexpected status 500", "next_attempt_at":"2020-07-01T10:00:00Z",
+				"created_at":"2020-07-01T10:00:00Z", "updated_at":"2020-07-01T10:00:00Z",
+				"event":{"id":7, "type":"ItemAdded", "cart_id":2, "data":{"id":5}, "occurred_at":"2020-07-01T10:00:00Z"}}]`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "list with limit - 200",
+			Method:         http.MethodGet,
+			Target:         "/admin/webhooks/1/deliveries?limit=10",
+			Headers:        adminHeaders,
+			ExpectedBody:   `[]`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "list with invalid limit - 422",
+			Method:         http.MethodGet,
+			Target:         "/admin/webhooks/1/deliveries?limit=101",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - limit must be a number from 1 to 100"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "list of another tenant - 404",
+			Method:         http.MethodGet,
+			Target:         "/admin/webhooks/2/deliveries",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - webhook does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:    "redeliver - 202",
+			Method:  http.MethodPost,
+			Target:  "/admin/webhooks/1/deliveries/3/redeliver",
+			Headers: adminHeaders,
+			ExpectedBody: `{"id":3, "subscription_id":1, "status":"pending", "attempts":0, "response_status":500,
+				"last_error":"webhook: unexpected status 500", "next_attempt_at":"2020-07-01T10:00:00Z",
+				"created_at":"2020-07-01T10:00:00Z", "updated_at":"2020-07-01T10:00:00Z",
+				"event":{"id":7, "type":"ItemAdded", "cart_id":2, "data":{"id":5}, "occurred_at":"2020-07-01T10:00:00Z"}}`,
+			ExpectedStatus: http.StatusAccepted,
+		},
+		{
+			Name:           "redeliver delivery not found - 404",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks/1/deliveries/4/redeliver",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - delivery does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "redeliver webhook not found - 404",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks/2/deliveries/3/redeliver",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - webhook does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "redeliver invalid id - 422",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks/1/deliveries/abc/redeliver",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - delivery_id param is not a valid number"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "redeliver service error - 500",
+			Method:         http.MethodPost,
+			Target:         "/admin/webhooks/1/deliveries/5/redeliver",
+			Headers:        adminHeaders,
+			ExpectedBody:   `{"error":{"code":100500, "details":"Internal error - could not redeliver"}}`,
+			ExpectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	execWebhookTestCases(t, ctrl, webhooksMock, testCases)
+}
+
+func TestHandler_WebhooksDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// without the admin keys there is no admin api
+	h, err := handler.New(handler.NewMockServiceProvider(ctrl), handler.NewMockAuthProvider(ctrl), handler.Options{Webhooks: handler.NewMockWebhookProvider(ctrl)})
+	assert.Nil(t, err)
+	tests.HandlerTest(t, h, &tests.TestCase{
+		Name:           "not found - 404",
+		Method:         http.MethodGet,
+		Target:         "/admin/webhooks",
+		Headers:        adminHeaders,
+		ExpectedStatus: http.StatusNotFound,
+	})
+}
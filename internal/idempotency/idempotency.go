@@ -0,0 +1,31 @@
+// Package idempotency holds the records of the requests made with an
+// Idempotency-Key, the first response to a key is replayed to the retries
+package idempotency
+
+import (
+	"net/http"
+	"time"
+)
+
+// Record is the request made with an Idempotency-Key and, once it is handled,
+// its response. the keys are chosen by the clients, so they are unique per scope,
+// i.e. per user, only
+type Record struct {
+	Scope string
+	Key   string
+	// RequestHash tells the retries from the other requests made with the key
+	RequestHash string
+
+	// StatusCode is 0 while the request is being handled
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Done tells whether the request is handled and its response is recorded
+func (r *Record) Done() bool {
+	return r.StatusCode != 0
+}
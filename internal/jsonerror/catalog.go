@@ -0,0 +1,114 @@
+package jsonerror
+
+import "net/http"
+
+// the problems of the requests
+var (
+	ServerError = newProblem("server-error", http.StatusInternalServerError, errInternalError,
+		"Server error",
+		"The request could not be served because of an error of the server, it can be retried.")
+	AuthUnavailable = newProblem("auth-unavailable", http.StatusServiceUnavailable, errUnavailable,
+		"Auth service unavailable",
+		"The credentials could not be verified because the auth service is down, the request can be retried.")
+	UnauthorisedAccess = newProblem("unauthorised", http.StatusUnauthorized, errUnauthorised,
+		"Unauthorised access",
+		"The access key, the bearer token, the cart token or the admin key of the request is missing or incorrect.")
+	MalformedBody = newProblem("malformed-body", http.StatusBadRequest, errBadRequest,
+		"Malformed body",
+		"The body of the request cannot be read or is not valid json.")
+	InvalidParam = newProblem("invalid-param", http.StatusUnprocessableEntity, errInvalidParams,
+		"Invalid parameter",
+		"A parameter of the path, of the query, of a header or of the body is missing or invalid, param is its name.",
+		"param")
+	ValidationFailed = newProblem("validation-failed", http.StatusUnprocessableEntity, errInvalidParams,
+		"Validation failed",
+		"Fields of the body of the request are unknown, of the wrong type or break their rules, violations lists them all with their fields, the rules and the messages.",
+		"violations")
+	RouteNotFound = newProblem("route-not-found", http.StatusNotFound, errNotFound,
+		"Route not found",
+		"No route of the api matches the path of the request, or the catalog has no problem of the type.")
+	MethodNotAllowed = newProblem("method-not-allowed", http.StatusMethodNotAllowed, errMethodNotAllowed,
+		"Method not allowed",
+		"The route of the path of the request does not serve its method.")
+)
+
+// the problems of the carts, the items and the orders
+var (
+	CartNotFound = newProblem("cart-not-found", http.StatusNotFound, errNotFound,
+		"Cart not found",
+		"The cart does not exist or is not of the user or the guest of the request.",
+		"cart_id")
+	ItemNotFound = newProblem("item-not-found", http.StatusNotFound, errNotFound,
+		"Item not found",
+		"The item does not exist or is not in a cart of the user or the guest of the request.",
+		"item_id")
+	OrderNotFound = newProblem("order-not-found", http.StatusNotFound, errNotFound,
+		"Order not found",
+		"The order does not exist or is not of the user of the request.",
+		"order_id")
+	CartNotOpen = newProblem("cart-not-open", http.StatusConflict, errConflict,
+		"Cart not open",
+		"The cart is checked out or abandoned, its items cannot be changed.",
+		"cart_id")
+	OpenCartExists = newProblem("open-cart-exists", http.StatusConflict, errConflict,
+		"Open cart exists",
+		"The user has an open cart already, a user has one open cart at most.",
+		"cart_id")
+	InvalidStatusTransition = newProblem("invalid-status-transition", http.StatusConflict, errConflict,
+		"Invalid status transition",
+		"The cart cannot move from its status to the requested one.",
+		"cart_id", "status")
+	CheckoutRequired = newProblem("checkout-required", http.StatusUnprocessableEntity, errInvalidParams,
+		"Checkout required",
+		"A cart is checked out by POST /carts/:cartID/checkout, not by changing its status.",
+		"cart_id")
+	CartVersionMismatch = newProblem("cart-version-mismatch", http.StatusPreconditionFailed, errPrecondition,
+		"Cart version mismatch",
+		"The cart has changed since the version in the If-Match header of the request was read.")
+	ProductAlreadyInCart = newProblem("product-already-in-cart", http.StatusBadRequest, errBadRequest,
+		"Product already in cart",
+		"The cart has an item of the product already, the quantities are added up when merge is requested.",
+		"cart_id", "product_id")
+	ProductNotFound = newProblem("product-not-found", http.StatusUnprocessableEntity, errInvalidParams,
+		"Product not found",
+		"The product is not in the catalog.",
+		"product_id")
+	ProductUnavailable = newProblem("product-unavailable", http.StatusUnprocessableEntity, errInvalidParams,
+		"Product unavailable",
+		"The product is in the catalog but cannot be purchased.",
+		"product_id")
+	CurrencyMismatch = newProblem("currency-mismatch", http.StatusUnprocessableEntity, errInvalidParams,
+		"Currency mismatch",
+		"The product or the claimed cart is not priced in the currency of the cart.",
+		"cart_id")
+	CartEmpty = newProblem("cart-empty", http.StatusUnprocessableEntity, errInvalidParams,
+		"Cart empty",
+		"The cart has no items to check out.",
+		"cart_id")
+	AmountOutOfRange = newProblem("amount-out-of-range", http.StatusUnprocessableEntity, errInvalidParams,
+		"Amount out of range",
+		"The price of an item or the total of the cart is too large to be kept.",
+		"cart_id")
+)
+
+// the problems of the retries made with an Idempotency-Key
+var (
+	IdempotencyKeyInProgress = newProblem("idempotency-key-in-progress", http.StatusConflict, errConflict,
+		"Idempotency-Key in progress",
+		"The first request made with the Idempotency-Key is still being served, the retry can be made later.")
+	IdempotencyKeyReused = newProblem("idempotency-key-reused", http.StatusUnprocessableEntity, errInvalidParams,
+		"Idempotency-Key reused",
+		"The Idempotency-Key is used for another request already, a key is for the retries of a request.")
+)
+
+// the problems of the webhooks
+var (
+	WebhookNotFound = newProblem("webhook-not-found", http.StatusNotFound, errNotFound,
+		"Webhook not found",
+		"The webhook subscription does not exist or is not of the tenant of the admin key.",
+		"subscription_id")
+	DeliveryNotFound = newProblem("delivery-not-found", http.StatusNotFound, errNotFound,
+		"Delivery not found",
+		"The delivery is not one of the webhook subscription.",
+		"subscription_id", "delivery_id")
+)
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/cubny/cart/internal/validation"
 )
 
 // errorType is used for error codes
@@ -14,11 +16,15 @@ var (
 	// error codes for Rest API can help users understand and troubleshoot more efficiently
 	// note that there is no standard for these numbers, they're usually defined by
 	// convention in the company
-	errInternalError errorType = 100500
-	errUnauthorised  errorType = 100401
-	errBadRequest    errorType = 100400
-	errInvalidParams errorType = 100422
-	errNotFound      errorType = 100404
+	errInternalError    errorType = 100500
+	errUnauthorised     errorType = 100401
+	errBadRequest       errorType = 100400
+	errInvalidParams    errorType = 100422
+	errNotFound         errorType = 100404
+	errConflict         errorType = 100409
+	errPrecondition     errorType = 100412
+	errUnavailable      errorType = 100503
+	errMethodNotAllowed errorType = 100405
 )
 
 // JsonError is used to return http errors encoded in json
@@ -27,6 +33,8 @@ type JsonError struct {
 	Code int `json:"code"`
 	// Details of the error
 	Details string `json:"details"`
+	// Violations are the rules the fields of the request violate, if any
+	Violations validation.Violations `json:"violations,omitempty"`
 }
 
 func New(errorType errorType, details string) JsonError {
@@ -44,6 +52,14 @@ func New(errorType errorType, details string) JsonError {
 		e.Details = "Invalid params"
 	case errNotFound:
 		e.Details = "Not found"
+	case errConflict:
+		e.Details = "Conflict"
+	case errPrecondition:
+		e.Details = "Precondition failed"
+	case errUnavailable:
+		e.Details = "Service unavailable"
+	case errMethodNotAllowed:
+		e.Details = "Method not allowed"
 	default:
 		e.Code = 100999
 		e.Details = "Unknown error"
@@ -93,3 +109,18 @@ func InvalidParams(w http.ResponseWriter, details string) error {
 func NotFound(w http.ResponseWriter, details string) error {
 	return New(errNotFound, details).write(w, http.StatusNotFound)
 }
+
+// Conflict writes the Conflict error details in json with the provided details
+func Conflict(w http.ResponseWriter, details string) error {
+	return New(errConflict, details).write(w, http.StatusConflict)
+}
+
+// PreconditionFailed writes the PreconditionFailed error details in json with the provided details
+func PreconditionFailed(w http.ResponseWriter, details string) error {
+	return New(errPrecondition, details).write(w, http.StatusPreconditionFailed)
+}
+
+// ServiceUnavailable writes the ServiceUnavailable error details in json with the provided details
+func ServiceUnavailable(w http.ResponseWriter, details string) error {
+	return New(errUnavailable, details).write(w, http.StatusServiceUnavailable)
+}
@@ -61,6 +61,36 @@ func TestNotFound(t *testing.T) {
 	assertBody(t, expectedBody, w.Body)
 }
 
+func TestConflict(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	jsonerror.Conflict(w, "test")
+	assert.Equal(t, w.Code, http.StatusConflict)
+
+	expectedBody := `{"error":{"code":100409, "details":"Conflict - test"}}`
+	assertBody(t, expectedBody, w.Body)
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	jsonerror.PreconditionFailed(w, "test")
+	assert.Equal(t, w.Code, http.StatusPreconditionFailed)
+
+	expectedBody := `{"error":{"code":100412, "details":"Precondition failed - test"}}`
+	assertBody(t, expectedBody, w.Body)
+}
+
+func TestServiceUnavailable(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	jsonerror.ServiceUnavailable(w, "test")
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+
+	expectedBody := `{"error":{"code":100503, "details":"Service unavailable - test"}}`
+	assertBody(t, expectedBody, w.Body)
+}
+
 func assertBody(t *testing.T, expectedBody string, actualBody *bytes.Buffer) {
 	t.Helper()
 
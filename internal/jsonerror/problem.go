@@ -0,0 +1,148 @@
+package jsonerror
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/validation"
+)
+
+const (
+	// ProblemContentType is the media type of the RFC 7807 problem details, the
+	// clients that accept it get the errors in it
+	ProblemContentType = "application/problem+json"
+
+	// ProblemTypePath is the path the type uris of the problems are under, the
+	// uri of a type is where the catalog serves it from, e.g. /problems/cart-not-found
+	ProblemTypePath = "/problems/"
+)
+
+// Problem is an error the api can respond with, the ID of a problem is stable
+// so that the clients can branch on it
+type Problem struct {
+	// ID of the problem, e.g. cart-not-found
+	ID string `json:"id"`
+	// Type is the uri of the problem
+	Type string `json:"type"`
+	// Title is the summary of the problem, it is the same for every occurrence
+	Title string `json:"title"`
+	// Status is the http status of the responses of the problem
+	Status int `json:"status"`
+	// Code of the problem in the error format
+	Code int `json:"code"`
+	// Description tells when the problem occurs
+	Description string `json:"description"`
+	// Extensions are the members the problem details may have besides the
+	// standard ones, e.g. cart_id
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// Extensions are the members of the details of an occurrence of a problem that
+// are specific to its type, e.g. cart_id
+type Extensions map[string]interface{}
+
+// catalog of the problems by their ids
+var catalog = map[string]*Problem{}
+
+// newProblem adds the problem to the catalog
+func newProblem(id string, status int, code errorType, title, description string, extensions ...string) *Problem {
+	if _, ok := catalog[id]; ok {
+		panic("jsonerror: problem " + id + " is defined twice")
+	}
+
+	p := &Problem{
+		ID:          id,
+		Type:        ProblemTypePath + id,
+		Title:       title,
+		Status:      status,
+		Code:        int(code),
+		Description: description,
+		Extensions:  extensions,
+	}
+	catalog[id] = p
+	return p
+}
+
+// Catalog returns every problem the api can respond with, sorted by their ids
+func Catalog() []Problem {
+	problems := make([]Problem, 0, len(catalog))
+	for _, p := range catalog {
+		problems = append(problems, *p)
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].ID < problems[j].ID })
+	return problems
+}
+
+// LookupProblem returns the problem of the id, ok is false if there is none
+func LookupProblem(id string) (p Problem, ok bool) {
+	found, ok := catalog[id]
+	if !ok {
+		return Problem{}, false
+	}
+	return *found, true
+}
+
+// AcceptsProblem tells whether the client of the request accepts the problem
+// details, the errors are in the error format otherwise
+func AcceptsProblem(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || mediaType != ProblemContentType {
+			continue
+		}
+		// q=0 means not acceptable
+		if q := params["q"]; q != "" && strings.Trim(q, "0.") == "" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// WriteViolations writes the ValidationFailed problem with every rule the fields
+// of the request violate, in the violations of the error or of the problem
+// details
+func WriteViolations(w http.ResponseWriter, r *http.Request, violations validation.Violations) error {
+	const detail = "the fields of the request are invalid"
+	if !AcceptsProblem(r) {
+		e := New(errorType(ValidationFailed.Code), detail)
+		e.Violations = violations
+		return e.write(w, ValidationFailed.Status)
+	}
+
+	return Write(w, r, ValidationFailed, detail, Extensions{"violations": violations})
+}
+
+// Write writes the problem with the detail of its occurrence in the format the
+// client accepts, either the problem details, with the extensions, or the error
+// format
+func Write(w http.ResponseWriter, r *http.Request, p *Problem, detail string, ext Extensions) error {
+	if !AcceptsProblem(r) {
+		return New(errorType(p.Code), detail).write(w, p.Status)
+	}
+
+	body := map[string]interface{}{}
+	for name, value := range ext {
+		body[name] = value
+	}
+	if id := ctxutil.GetRequestID(r.Context()); id != "" {
+		body["request_id"] = id
+	}
+	// the standard members are not overridden by the extensions
+	body["type"] = p.Type
+	body["title"] = p.Title
+	body["status"] = p.Status
+	body["instance"] = r.URL.Path
+	if detail != "" {
+		body["detail"] = detail
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+
+	return json.NewEncoder(w).Encode(body)
+}
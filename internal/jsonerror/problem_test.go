@@ -0,0 +1,97 @@
+package jsonerror_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/jsonerror"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsProblem(t *testing.T) {
+	tcs := []struct {
+		accept   string
+		expected bool
+	}{
+		{accept: "", expected: false},
+		{accept: "application/json", expected: false},
+		{accept: "*/*", expected: false},
+		{accept: "application/problem+json", expected: true},
+		{accept: "application/json, application/problem+json;q=0.9", expected: true},
+		{accept: "application/problem+json;q=0", expected: false},
+		{accept: "application/problem+json;q=0.000", expected: false},
+		{accept: "text/html, application/problem+json ; q=0.5", expected: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+			r.Header.Set("Accept", tc.accept)
+			assert.Equal(t, tc.expected, jsonerror.AcceptsProblem(r))
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("error format by default", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+
+		assert.Nil(t, jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", jsonerror.Extensions{"cart_id": 1}))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assertBody(t, `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`, w.Body)
+	})
+
+	t.Run("problem details when accepted", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+		r.Header.Set("Accept", jsonerror.ProblemContentType)
+		r = r.WithContext(ctxutil.SetRequestID(r.Context(), "request-1"))
+
+		ext := jsonerror.Extensions{"cart_id": 1, "status": "overridden"}
+		assert.Nil(t, jsonerror.Write(w, r, jsonerror.CartNotFound, "cart does not exist", ext))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, jsonerror.ProblemContentType, w.Header().Get("Content-Type"))
+		// the standard members are not overridden by the extensions
+		assertBody(t, `{
+			"type": "/problems/cart-not-found",
+			"title": "Cart not found",
+			"status": 404,
+			"detail": "cart does not exist",
+			"instance": "/carts/1",
+			"request_id": "request-1",
+			"cart_id": 1
+		}`, w.Body)
+	})
+}
+
+func TestCatalog(t *testing.T) {
+	problems := jsonerror.Catalog()
+	assert.NotEmpty(t, problems)
+
+	id := regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)
+	for i, p := range problems {
+		assert.Regexp(t, id, p.ID)
+		assert.Equal(t, jsonerror.ProblemTypePath+p.ID, p.Type)
+		assert.NotEmpty(t, p.Title, p.ID)
+		assert.NotEmpty(t, p.Description, p.ID)
+		assert.NotEmpty(t, http.StatusText(p.Status), p.ID)
+		// the code of the error format is of the status of the problem
+		assert.Equal(t, p.Status, p.Code%1000, p.ID)
+		if i > 0 {
+			assert.Less(t, problems[i-1].ID, p.ID)
+		}
+	}
+
+	p, ok := jsonerror.LookupProblem("cart-not-found")
+	assert.True(t, ok)
+	assert.Equal(t, *jsonerror.CartNotFound, p)
+	assert.Equal(t, []string{"cart_id"}, p.Extensions)
+
+	_, ok = jsonerror.LookupProblem("no-such-problem")
+	assert.False(t, ok)
+}
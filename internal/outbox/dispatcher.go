@@ -0,0 +1,143 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	eventsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cart",
+			Name:      "outbox_events_counter",
+			Help:      "Counter of the attempts to publish the events of the outbox by result",
+		}, []string{"type", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsCount)
+}
+
+// Options of the dispatcher
+type Options struct {
+	// Interval is how often the outbox is checked for the messages that are due
+	Interval time.Duration
+	// BatchSize is how many messages are read from the outbox at once
+	BatchSize int
+	// MaxAttempts is how many times an event is tried before it is dead-lettered
+	MaxAttempts int
+	// MinBackoff is the wait after the first failure, it doubles with every
+	// failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lease is how long the messages read by a dispatcher are held by it, the
+	// other dispatchers take them only if it has not updated them by then, e.g.
+	// when it stopped while publishing them
+	Lease time.Duration
+}
+
+// DefaultOptions retry an event for about 3 hours before it is dead-lettered
+var DefaultOptions = Options{
+	Interval:    time.Second,
+	BatchSize:   100,
+	MaxAttempts: 15,
+	MinBackoff:  time.Second,
+	MaxBackoff:  time.Hour,
+	Lease:       5 * time.Minute,
+}
+
+// Dispatcher publishes the events of the outbox to the sink
+type Dispatcher struct {
+	storage Storage
+	sink    Sink
+	opts    Options
+}
+
+// NewDispatcher creates a dispatcher of the outbox of the storage, see Sinks
+// to publish to more than one sink
+func NewDispatcher(storage Storage, sink Sink, opts Options) *Dispatcher {
+	return &Dispatcher{storage: storage, sink: sink, opts: opts}
+}
+
+// Run publishes the events until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		delivered, err := d.Dispatch(ctx)
+		if err != nil {
+			log.WithError(err).Errorf("outbox: dispatch %s", err)
+		}
+
+		// a delivered event may be followed by the next event of its cart,
+		// which is due right away
+		if delivered == 0 || err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Dispatch publishes the messages that are due once and returns how many were
+// delivered. a failed message is retried after the backoff, or dead-lettered
+// when it has failed MaxAttempts times
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	messages, err := d.storage.ClaimDueOutboxMessages(ctx, d.opts.BatchSize, d.opts.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, m := range messages {
+		m.Attempts++
+
+		err := d.sink.Publish(ctx, m.Event)
+		switch {
+		case err == nil:
+			m.Status = StatusDelivered
+			m.LastError = ""
+			delivered++
+			eventsCount.With(prometheus.Labels{"type": string(m.Event.Type), "result": "delivered"}).Inc()
+		case m.Attempts >= d.opts.MaxAttempts:
+			m.Status = StatusDead
+			m.LastError = err.Error()
+			log.WithError(err).Errorf("outbox: event %d dead-lettered after %d attempts, %s", m.Event.ID, m.Attempts, err)
+			eventsCount.With(prometheus.Labels{"type": string(m.Event.Type), "result": "dead"}).Inc()
+		default:
+			m.LastError = err.Error()
+			m.NextAttemptAt = time.Now().Add(d.opts.Backoff(m.Attempts))
+			log.WithError(err).Warnf("outbox: event %d failed at attempt %d, %s", m.Event.ID, m.Attempts, err)
+			eventsCount.With(prometheus.Labels{"type": string(m.Event.Type), "result": "failed"}).Inc()
+		}
+
+		if err := d.storage.UpdateOutboxMessage(ctx, m); err != nil {
+			return delivered, err
+		}
+	}
+
+	return delivered, nil
+}
+
+// Backoff is the wait after the failed attempt, it starts at MinBackoff and
+// doubles with every attempt up to MaxBackoff
+func (o Options) Backoff(attempt int) time.Duration {
+	wait := o.MinBackoff
+	for i := 1; i < attempt && wait < o.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > o.MaxBackoff {
+		wait = o.MaxBackoff
+	}
+
+	return wait
+}
@@ -0,0 +1,205 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sink records the events it publishes, it fails the first failures of them.
+// publishing an event takes delay
+type sink struct {
+	mu        sync.Mutex
+	failures  int
+	delay     time.Duration
+	published []*cart.Event
+}
+
+func (s *sink) Publish(_ context.Context, e *cart.Event) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink is down")
+	}
+	s.published = append(s.published, e)
+	return nil
+}
+
+func (s *sink) types() []cart.EventType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	types := []cart.EventType{}
+	for _, e := range s.published {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+var testOptions = outbox.Options{
+	Interval:    time.Millisecond,
+	BatchSize:   10,
+	MaxAttempts: 3,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  5 * time.Millisecond,
+	Lease:       time.Minute,
+}
+
+func newEvent(t *testing.T, db *memory.Memory, eventType cart.EventType, cartID int64) {
+	t.Helper()
+
+	e, err := cart.NewEvent(eventType, &cart.Cart{ID: cartID}, map[string]int64{"id": cartID})
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateEvent(context.TODO(), e))
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	newEvent(t, db, cart.EventCartCreated, 1)
+	newEvent(t, db, cart.EventItemAdded, 1)
+	newEvent(t, db, cart.EventCartCreated, 2)
+
+	s := &sink{}
+	d := outbox.NewDispatcher(db, s, testOptions)
+
+	// the first event of each cart, then the next one of the first cart
+	delivered, err := d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, delivered)
+	delivered, err = d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, delivered)
+	delivered, err = d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+
+	assert.Equal(t, []cart.EventType{cart.EventCartCreated, cart.EventCartCreated, cart.EventItemAdded}, s.types())
+}
+
+func TestDispatcher_Concurrent(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	for cartID := int64(1); cartID <= 50; cartID++ {
+		newEvent(t, db, cart.EventCartCreated, cartID)
+	}
+
+	// the dispatchers of the instances of the server share the outbox, an event
+	// is published by one of them only
+	s := &sink{delay: time.Millisecond}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := outbox.NewDispatcher(db, s, testOptions)
+			for {
+				delivered, err := d.Dispatch(ctx)
+				assert.Nil(t, err)
+				if delivered == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, s.types(), 50)
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	newEvent(t, db, cart.EventCartCreated, 1)
+	newEvent(t, db, cart.EventItemAdded, 1)
+
+	s := &sink{failures: 2}
+	d := outbox.NewDispatcher(db, s, testOptions)
+
+	// the failed event waits for the backoff, the next event of the cart waits for it
+	delivered, err := d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+	messages, err := db.ClaimDueOutboxMessages(ctx, 10, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+
+	time.Sleep(5 * time.Millisecond)
+	delivered, err = d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+
+	time.Sleep(5 * time.Millisecond)
+	delivered, err = d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, delivered)
+	delivered, err = d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, delivered)
+
+	assert.Equal(t, []cart.EventType{cart.EventCartCreated, cart.EventItemAdded}, s.types())
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	newEvent(t, db, cart.EventCartCreated, 1)
+	newEvent(t, db, cart.EventItemAdded, 1)
+
+	s := &sink{failures: 3}
+	d := outbox.NewDispatcher(db, s, testOptions)
+
+	for i := 0; i < 3; i++ {
+		delivered, err := d.Dispatch(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, delivered)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the event is dead-lettered after MaxAttempts, the cart moves on
+	delivered, err := d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, []cart.EventType{cart.EventItemAdded}, s.types())
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	db := memory.New()
+	for cartID := int64(1); cartID <= 3; cartID++ {
+		newEvent(t, db, cart.EventCartCreated, cartID)
+		newEvent(t, db, cart.EventItemAdded, cartID)
+	}
+
+	s := &sink{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		outbox.NewDispatcher(db, s, testOptions).Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return len(s.types()) == 6 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestOptions_Backoff(t *testing.T) {
+	opts := outbox.Options{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	assert.Equal(t, time.Second, opts.Backoff(1))
+	assert.Equal(t, 2*time.Second, opts.Backoff(2))
+	assert.Equal(t, 8*time.Second, opts.Backoff(4))
+	assert.Equal(t, 10*time.Second, opts.Backoff(5))
+	assert.Equal(t, 10*time.Second, opts.Backoff(100))
+}
@@ -0,0 +1,56 @@
+// Package outbox publishes the events of the cart changes. the events are
+// written to the outbox in the transaction of the change and the dispatcher
+// publishes them to the sinks afterwards, at least once: an event is retried
+// with backoff until a sink takes it and is dead-lettered after too many failures
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/cubny/cart"
+)
+
+// Status is the delivery state of a message
+type Status string
+
+const (
+	// StatusPending is of the messages that are not published yet
+	StatusPending Status = "pending"
+	// StatusDelivered is of the messages that are published
+	StatusDelivered Status = "delivered"
+	// StatusDead is of the messages that failed too many times, they are kept
+	// in the outbox for inspection and are not retried
+	StatusDead Status = "dead"
+)
+
+// Message is an event in the outbox along with its delivery
+type Message struct {
+	Event  *cart.Event
+	Status Status
+	// Attempts is how many times the event was tried to be published
+	Attempts int
+	// NextAttemptAt is when a pending message is due
+	NextAttemptAt time.Time
+	// LastError is why the last attempt failed
+	LastError string
+	UpdatedAt time.Time
+}
+
+// Storage provides the messages of the outbox, the events are written by the
+// service
+type Storage interface {
+	// ClaimDueOutboxMessages returns the pending messages that are due, in the
+	// order of their events, and holds them for the lease: they are not due for
+	// the other dispatchers until the lease has passed or they are updated. only
+	// the first pending message of a cart is returned, so that the events of a
+	// cart are published in order
+	ClaimDueOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*Message, error)
+	// UpdateOutboxMessage writes the delivery of the message
+	UpdateOutboxMessage(ctx context.Context, m *Message) error
+}
+
+// Sink publishes the events, an event that a sink returns an error for is retried
+type Sink interface {
+	Publish(ctx context.Context, e *cart.Event) error
+}
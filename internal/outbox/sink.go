@@ -0,0 +1,139 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cubny/cart"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultWebhookTimeout is the timeout of a request to a webhook
+const DefaultWebhookTimeout = 5 * time.Second
+
+// Sinks publishes the events to all of the sinks. an event that one of them
+// fails is retried on all of them, so a sink may get an event more than once
+type Sinks []Sink
+
+// Publish publishes the event to every sink, it returns the first error
+func (sinks Sinks) Publish(ctx context.Context, e *cart.Event) error {
+	var first error
+	for _, sink := range sinks {
+		if err := sink.Publish(ctx, e); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// LogSink logs the events
+type LogSink struct{}
+
+// NewLogSink creates a sink that logs the events
+func NewLogSink() *LogSink {
+	return &LogSink{}
+}
+
+// Publish logs the event
+func (s *LogSink) Publish(_ context.Context, e *cart.Event) error {
+	log.WithFields(log.Fields{
+		"event_id": e.ID,
+		"type":     e.Type,
+		"cart_id":  e.CartID,
+		"user_id":  e.UserID,
+		"data":     string(e.Data),
+	}).Info("outbox: event")
+
+	return nil
+}
+
+// WebhookSink posts the events to a URL, the body is the event in json. the
+// event is published once the webhook responds with a 2xx status
+type WebhookSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewWebhookSink creates a sink that posts the events to the url
+// if httpClient is nil a client with DefaultWebhookTimeout is used
+func NewWebhookSink(url string, httpClient *http.Client) *WebhookSink {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+
+	return &WebhookSink{url: url, httpClient: httpClient}
+}
+
+// Publish posts the event to the webhook
+func (s *WebhookSink) Publish(ctx context.Context, e *cart.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cart-Event-Type", string(e.Type))
+	req.Header.Set("X-Cart-Event-ID", strconv.FormatInt(e.ID, 10))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: request failed, %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// FileSink appends the events to a file, one json encoded event per line
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink creates a sink that appends the events to the file at path, the
+// file is created if it does not exist
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{file: file}, nil
+}
+
+// Publish appends the event to the file
+func (s *FileSink) Publish(_ context.Context, e *cart.Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(line)
+	return err
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,85 @@
+package outbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent() *cart.Event {
+	return &cart.Event{ID: 7, Type: cart.EventItemAdded, CartID: 1, UserID: 2, Data: []byte(`{"id":3}`)}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received *cart.Event
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "ItemAdded", r.Header.Get("X-Cart-Event-Type"))
+		assert.Equal(t, "7", r.Header.Get("X-Cart-Event-ID"))
+
+		received = &cart.Event{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(received))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := outbox.NewWebhookSink(srv.URL, nil)
+	assert.Nil(t, s.Publish(context.TODO(), testEvent()))
+	if assert.NotNil(t, received) {
+		assert.Equal(t, int64(7), received.ID)
+		assert.JSONEq(t, `{"id":3}`, string(received.Data))
+	}
+
+	// the event is not published until the webhook takes it
+	status = http.StatusServiceUnavailable
+	assert.EqualError(t, s.Publish(context.TODO(), testEvent()), "webhook: unexpected status 503")
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	s, err := outbox.NewFileSink(path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Publish(context.TODO(), testEvent()))
+	assert.Nil(t, s.Publish(context.TODO(), testEvent()))
+	assert.Nil(t, s.Close())
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.JSONEq(t, `{"id":7, "type":"ItemAdded", "cart_id":1, "user_id":2, "data":{"id":3}, "occurred_at":"0001-01-01T00:00:00Z"}`, lines[0])
+	}
+}
+
+func TestSinks(t *testing.T) {
+	ok := &sink{}
+	down := &sink{failures: 1}
+
+	// every sink gets the event, the first error is returned
+	sinks := outbox.Sinks{down, ok}
+	assert.Equal(t, errors.New("sink is down"), sinks.Publish(context.TODO(), testEvent()))
+	assert.Len(t, ok.types(), 1)
+	assert.Nil(t, sinks.Publish(context.TODO(), testEvent()))
+	assert.Len(t, ok.types(), 2)
+	assert.Len(t, down.types(), 1)
+}
@@ -0,0 +1,51 @@
+package product
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cubny/cart"
+)
+
+var ErrNotFound = errors.New("product not found")
+
+// Product is what the cart needs to know about a product of the catalog
+type Product struct {
+	ID          int64
+	Price       cart.Money
+	Purchasable bool
+}
+
+// Client is the client for Product service, usually we enquiry this service
+// using http (see HTTPClient), but the stub here keeps a fixed catalog so the
+// service can run and be tested without the product service
+type Client struct {
+	products []Product
+}
+
+// New stubs an actual product server and populates a small catalog of sample
+// products, the prices match the sample requests of the Makefile
+func New() *Client {
+	products := []Product{
+		{ID: 1, Price: cart.NewMoney(10000, cart.DefaultCurrency), Purchasable: true},
+		{ID: 2, Price: cart.NewMoney(1000, cart.DefaultCurrency), Purchasable: true},
+		{ID: 3, Price: cart.NewMoney(1200, cart.DefaultCurrency), Purchasable: true},
+		{ID: 4, Price: cart.NewMoney(250, cart.DefaultCurrency), Purchasable: true},
+		{ID: 5, Price: cart.NewMoney(99, cart.DefaultCurrency), Purchasable: true},
+		{ID: 6, Price: cart.NewMoney(10, cart.DefaultCurrency), Purchasable: true},
+		{ID: 7, Price: cart.NewMoney(20, cart.DefaultCurrency), Purchasable: true},
+		// a discontinued product
+		{ID: 8, Price: cart.NewMoney(500, cart.DefaultCurrency), Purchasable: false},
+	}
+	return &Client{products: products}
+}
+
+// GetProduct returns a product of the catalog
+func (c *Client) GetProduct(ctx context.Context, productID int64) (*Product, error) {
+	for _, p := range c.products {
+		if p.ID == productID {
+			return &p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
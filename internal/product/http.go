@@ -0,0 +1,89 @@
+package product
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/tracing"
+)
+
+// DefaultTimeout is the timeout of a request to the product service
+const DefaultTimeout = 2 * time.Second
+
+// HTTPClient is the client for Product service over http. it reads a product
+// with GET {baseURL}/products/{productID}, the service responds with
+//
+//	{"id": 1, "price": "10.50", "currency": "EUR", "purchasable": true}
+type HTTPClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewHTTPClient creates a client of the product service running at baseURL
+// if httpClient is nil a client with DefaultTimeout is used, it passes the
+// trace context on in the requests as tracing.Transport does
+func NewHTTPClient(baseURL string, httpClient *http.Client) *HTTPClient {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout, Transport: tracing.Transport(nil)}
+	}
+
+	return &HTTPClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// productResponse is the product as the product service encodes it
+type productResponse struct {
+	ID          int64         `json:"id"`
+	Price       json.Number   `json:"price"`
+	Currency    cart.Currency `json:"currency"`
+	Purchasable bool          `json:"purchasable"`
+}
+
+// GetProduct returns a product of the catalog
+func (c *HTTPClient) GetProduct(ctx context.Context, productID int64) (*Product, error) {
+	url := fmt.Sprintf("%s/products/%d", c.baseURL, productID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("product: request failed, %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("product: unexpected status %d", resp.StatusCode)
+	}
+
+	pr := &productResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(pr); err != nil {
+		return nil, fmt.Errorf("product: cannot decode response, %s", err)
+	}
+
+	if pr.Currency == "" {
+		pr.Currency = cart.DefaultCurrency
+	}
+	price, err := cart.ParseMoney(pr.Price.String(), pr.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("product: invalid price %q %s, %s", pr.Price, pr.Currency, err)
+	}
+
+	return &Product{
+		ID:          pr.ID,
+		Price:       price,
+		Purchasable: pr.Purchasable,
+	}, nil
+}
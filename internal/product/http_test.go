@@ -0,0 +1,73 @@
+package product_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/product"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPClient_GetProduct(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/products/1":
+			_, _ = w.Write([]byte(`{"id": 1, "price": "10.50", "currency": "EUR", "purchasable": true}`))
+		case "/products/2":
+			_, _ = w.Write([]byte(`{"id": 2, "price": 0.1, "purchasable": false}`))
+		case "/products/3":
+			_, _ = w.Write([]byte(`{"id": 3, "price": "ten"}`))
+		case "/products/4":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name            string
+		productID       int64
+		expectedProduct *product.Product
+		expectedError   bool
+	}{
+		{
+			name:            "ok",
+			productID:       1,
+			expectedProduct: &product.Product{ID: 1, Price: cart.NewMoney(1050, "EUR"), Purchasable: true},
+		},
+		{
+			name:            "number price in the default currency - ok",
+			productID:       2,
+			expectedProduct: &product.Product{ID: 2, Price: cart.NewMoney(10, "EUR")},
+		},
+		{
+			name:          "invalid price - error",
+			productID:     3,
+			expectedError: true,
+		},
+		{
+			name:          "product service is unavailable - error",
+			productID:     4,
+			expectedError: true,
+		},
+	}
+
+	client := product.NewHTTPClient(srv.URL+"/", nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := client.GetProduct(context.TODO(), test.productID)
+			assert.Equal(t, test.expectedError, err != nil)
+			assert.Equal(t, test.expectedProduct, p)
+		})
+	}
+
+	t.Run("product does not exist - ErrNotFound", func(t *testing.T) {
+		_, err := client.GetProduct(context.TODO(), 5)
+		assert.Equal(t, product.ErrNotFound, err)
+	})
+}
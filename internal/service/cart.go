@@ -5,125 +5,651 @@ import (
 	"errors"
 
 	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/product"
 	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/tracing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
 	ErrCartNotFound         = errors.New("cart not found")
 	ErrItemNotFound         = errors.New("item not found")
 	ErrProductAlreadyInCart = errors.New("product is already in the cart")
+	ErrCartNotOpen          = errors.New("cart is not open")
+	ErrOpenCartExists       = errors.New("user already has an open cart")
+	ErrCheckoutRequired     = errors.New("cart can only be checked out by checkout")
+	ErrOrderNotFound        = errors.New("order not found")
+	ErrProductNotFound      = errors.New("product not found")
+	ErrProductUnavailable   = errors.New("product is not purchasable")
+	ErrCartVersionMismatch  = errors.New("cart has changed since the expected version")
 )
 
+// the business counters are incremented once the changes are committed
+var (
+	cartsCreatedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "cart",
+			Name:      "carts_created_counter",
+			Help:      "Counter of the carts created, of the users and the guests",
+		})
+	itemsAddedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "cart",
+			Name:      "items_added_counter",
+			Help:      "Counter of the items added to the carts, merged ones included",
+		})
+	checkoutsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "cart",
+			Name:      "checkouts_counter",
+			Help:      "Counter of the carts checked out to orders",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(cartsCreatedCount, itemsAddedCount, checkoutsCount)
+}
+
 // Service contains all the business logic of the shopping cart
 type Service struct {
-	storage Storage
+	storage  Storage
+	products ProductProvider
 }
 
 // Storage provides the methods to CRUD resources in database
 type Storage interface {
 	CreateCart(ctx context.Context, cart *cart.Cart) error
 	GetCart(ctx context.Context, userID, cartID int64) (*cart.Cart, error)
+	GetOpenCartByUserID(ctx context.Context, userID int64) (*cart.Cart, error)
+	UpdateCartStatus(ctx context.Context, cart *cart.Cart) error
+	ClaimCart(ctx context.Context, cart *cart.Cart) error
+	IncrementCartVersion(ctx context.Context, cart *cart.Cart) error
 	FindItemByProductID(ctx context.Context, cartID, productID int64) (*cart.Item, error)
 	CreateItem(ctx context.Context, item *cart.Item) error
+	MergeItem(ctx context.Context, item *cart.Item) error
 	GetItem(ctx context.Context, itemID int64) (*cart.Item, error)
+	ListItemsByCartID(ctx context.Context, cartID int64) ([]*cart.Item, error)
+	UpdateItem(ctx context.Context, item *cart.Item) error
 	RemoveItem(ctx context.Context, itemID int64) error
 	RemoveItemsByCartID(ctx context.Context, cartID int64) error
+	CheckoutCart(ctx context.Context, order *cart.Order) error
+	GetOrder(ctx context.Context, orderID int64) (*cart.Order, error)
+	GetOrderByCartID(ctx context.Context, cartID int64) (*cart.Order, error)
+	// CreateEvent writes the event to the outbox, from which it is published
+	CreateEvent(ctx context.Context, event *cart.Event) error
+	// WithTx runs fn in a transaction, the storage given to fn is the one in the
+	// transaction. it is committed if fn returns no error and rolled back otherwise
+	WithTx(ctx context.Context, fn func(Storage) error) error
 	Close() error
 }
 
+// ProductProvider provides the products of the catalog
+type ProductProvider interface {
+	GetProduct(ctx context.Context, productID int64) (*product.Product, error)
+}
+
 // New creates a new Service
-func New(db Storage) (*Service, error) {
-	return &Service{storage: db}, nil
+func New(db Storage, products ProductProvider) (*Service, error) {
+	return &Service{storage: db, products: products}, nil
+}
+
+// inTx runs fn with a copy of the service whose storage is in a transaction, so
+// the steps of fn are applied all or none
+func (s *Service) inTx(ctx context.Context, fn func(tx *Service) error) error {
+	return s.storage.WithTx(ctx, func(db Storage) error {
+		tx := *s
+		tx.storage = db
+		return fn(&tx)
+	})
+}
+
+// getProduct gets the product of the catalog, the product must be purchasable
+func (s *Service) getProduct(ctx context.Context, productID int64) (*product.Product, error) {
+	p, err := s.products.GetProduct(ctx, productID)
+	switch {
+	case err == product.ErrNotFound:
+		return nil, ErrProductNotFound
+	case err != nil:
+		return nil, err
+	case !p.Purchasable:
+		return nil, ErrProductUnavailable
+	}
+
+	return p, nil
+}
+
+// versionMatches reports whether the cart is of one of the versions that the
+// change requested in the context expects, see ctxutil.SetCartVersions. a change
+// that expects no version matches any
+func versionMatches(ctx context.Context, c *cart.Cart) bool {
+	versions, ok := ctxutil.GetCartVersions(ctx)
+	if !ok {
+		return true
+	}
+
+	for _, version := range versions {
+		if version == c.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// recordEvent writes the event of the change of the cart within the transaction
+// of the change, so that the event is published if and only if the change is made
+func (s *Service) recordEvent(ctx context.Context, eventType cart.EventType, c *cart.Cart, data interface{}) error {
+	event, err := cart.NewEvent(eventType, c, data)
+	if err != nil {
+		return err
+	}
+
+	return s.storage.CreateEvent(ctx, event)
 }
 
 // CreateCart creates and persists a new cart for the given user
-// this is only for demonstration, in real life, it should first check
-// if the user has a open cart already for that we would need to mark the
-// cart as closed when it's converted to order
+// a user can have only one open cart, so if the user already has one
+// the existing cart is returned instead of creating a new one
 func (s *Service) CreateCart(ctx context.Context, userID int64) (*cart.Cart, error) {
-	cart, err := cart.NewCart(userID)
+	ctx, span := tracing.Start(ctx, "service.CreateCart", attribute.Int64("user.id", userID))
+	defer span.End()
+
+	if userID == GuestUserID {
+		return s.createGuestCart(ctx)
+	}
+
+	c, err := cart.NewCart(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	created := false
+	err = s.inTx(ctx, func(tx *Service) error {
+		open, err := tx.storage.GetOpenCartByUserID(ctx, userID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+		case err != nil:
+			return err
+		default:
+			c = open
+			return nil
+		}
+
+		if err := tx.storage.CreateCart(ctx, c); err != nil {
+			return err
+		}
+		created = true
+
+		return tx.recordEvent(ctx, cart.EventCartCreated, c, c)
+	})
 	if err != nil {
 		return nil, err
 	}
+	if created {
+		cartsCreatedCount.Inc()
+	}
+
+	return c, nil
+}
+
+// ChangeCartStatus moves the user's cart to the given state
+// it first checks the ownership of the cart, then makes sure the transition
+// is allowed. reopening a cart is refused while the user has another open cart
+// and a cart can be checked out only by Checkout, which also writes the order
+func (s *Service) ChangeCartStatus(ctx context.Context, userID, cartID int64, status cart.Status) (*cart.Cart, error) {
+	ctx, span := tracing.Start(ctx, "service.ChangeCartStatus", attribute.Int64("user.id", userID), attribute.Int64("cart.id", cartID))
+	defer span.End()
+
+	if status == cart.StatusCheckedOut {
+		return nil, ErrCheckoutRequired
+	}
+
+	var c *cart.Cart
+	err := s.inTx(ctx, func(tx *Service) error {
+		// check the ownership of the cart
+		var err error
+		c, err = tx.getCart(ctx, userID, cartID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrCartNotFound
+		case err != nil:
+			return err
+		case !versionMatches(ctx, c):
+			return ErrCartVersionMismatch
+		}
 
-	if err := s.storage.CreateCart(ctx, cart); err != nil {
+		if err := c.TransitionTo(status); err != nil {
+			return err
+		}
+
+		if status == cart.StatusOpen {
+			_, err := tx.storage.GetOpenCartByUserID(ctx, userID)
+			switch {
+			case err == storage.ErrRecordNotFound:
+			case err != nil:
+				return err
+			default:
+				return ErrOpenCartExists
+			}
+		}
+
+		if err := tx.storage.UpdateCartStatus(ctx, c); err != nil {
+			return err
+		}
+
+		if err := tx.storage.IncrementCartVersion(ctx, c); err != nil {
+			return err
+		}
+
+		return tx.recordEvent(ctx, cart.EventCartStatusChanged, c, c)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return cart, nil
+	return c, nil
 }
 
 // AddItem, adds a product to the user's cart, it first checks if the cart belongs
-// to the user. it then checks if the product is already added to the cart, if the
-// product was already added it returns error, if not it adds the item to the cart
-func (s *Service) AddItem(ctx context.Context, userID int64, item *cart.Item) error {
-	// check the ownership of the cart
-	_, err := s.storage.GetCart(ctx, userID, item.CartID)
-	switch {
-	case err == storage.ErrRecordNotFound:
-		return ErrCartNotFound
-	case err != nil:
-		return err
+// to the user and is open. the product must be purchasable in the catalog, which sets the
+// price of the item. it then checks if the product is already added to the cart, if the
+// product was already added it returns error, if not it adds the item to the cart.
+// with merge, the quantity and the price of the item are added to the item of the
+// same product instead, the item then holds the merged result.
+// the product must be priced in the currency of the cart
+func (s *Service) AddItem(ctx context.Context, userID int64, item *cart.Item, merge bool) (*cart.Cart, error) {
+	ctx, span := tracing.Start(ctx, "service.AddItem",
+		attribute.Int64("user.id", userID),
+		attribute.Int64("cart.id", item.CartID),
+		attribute.Int64("product.id", item.ProductID),
+	)
+	defer span.End()
+
+	// the price is always set by the catalog, never by the client. the catalog
+	// is asked before the transaction so that a slow catalog holds no lock, its
+	// error is returned once the cart is known to be of the user
+	p, productErr := s.getProduct(ctx, item.ProductID)
+
+	var c *cart.Cart
+	err := s.inTx(ctx, func(tx *Service) error {
+		// check the ownership of the cart
+		var err error
+		c, err = tx.getCart(ctx, userID, item.CartID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrCartNotFound
+		case err != nil:
+			return err
+		case !versionMatches(ctx, c):
+			return ErrCartVersionMismatch
+		case !c.IsOpen():
+			return ErrCartNotOpen
+		}
+
+		switch {
+		case productErr != nil:
+			return productErr
+		case p.Price.Currency != c.Currency:
+			return cart.ErrCurrencyMismatch
+		}
+		item.Price, err = p.Price.Multiply(item.Quantity)
+		if err != nil {
+			return err
+		}
+
+		if merge {
+			if err := tx.storage.MergeItem(ctx, item); err != nil {
+				return err
+			}
+			if err := tx.storage.IncrementCartVersion(ctx, c); err != nil {
+				return err
+			}
+			return tx.recordEvent(ctx, cart.EventItemAdded, c, item)
+		}
+
+		// check if the product already exists in the cart
+		t, err := tx.storage.FindItemByProductID(ctx, item.CartID, item.ProductID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+		case err != nil:
+			return err
+		case t != nil:
+			return ErrProductAlreadyInCart
+		}
+
+		// persist the item in the storage, the storage refuses a second item
+		// of the product that a concurrent add got in first
+		err = tx.storage.CreateItem(ctx, item)
+		switch {
+		case err == storage.ErrDuplicate:
+			return ErrProductAlreadyInCart
+		case err != nil:
+			return err
+		}
+
+		if err := tx.storage.IncrementCartVersion(ctx, c); err != nil {
+			return err
+		}
+
+		return tx.recordEvent(ctx, cart.EventItemAdded, c, item)
+	})
+	if err != nil {
+		return nil, err
 	}
+	itemsAddedCount.Inc()
 
-	// check if the product already exists in the cart
-	t, err := s.storage.FindItemByProductID(ctx, item.CartID, item.ProductID)
-	switch {
-	case err == storage.ErrRecordNotFound:
-	case err != nil:
-		return err
-	case t != nil:
-		return ErrProductAlreadyInCart
+	return c, nil
+}
+
+// UpdateItem, sets the quantity of an item, the item is priced by the catalog
+// for the new quantity. quantity 0 removes the item from the cart, in that case no item
+// is returned. it first checks if the cart belongs to the user and is open
+func (s *Service) UpdateItem(ctx context.Context, userID, itemID, quantity int64) (*cart.Item, *cart.Cart, error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateItem", attribute.Int64("user.id", userID), attribute.Int64("item.id", itemID))
+	defer span.End()
+
+	if quantity < 0 {
+		return nil, nil, cart.ErrInvalidQuantity
 	}
 
-	// persist the item in the storage
-	if err := s.storage.CreateItem(ctx, item); err != nil {
-		return err
+	// the item is priced again by the catalog like a new item, the catalog is
+	// asked before the transaction so that a slow catalog holds no lock. the
+	// product of an item never changes
+	var (
+		p          *product.Product
+		productErr error
+	)
+	if quantity > 0 {
+		current, err := s.storage.GetItem(ctx, itemID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return nil, nil, ErrItemNotFound
+		case err != nil:
+			return nil, nil, err
+		}
+		p, productErr = s.getProduct(ctx, current.ProductID)
+	}
+
+	var (
+		item *cart.Item
+		c    *cart.Cart
+	)
+	err := s.inTx(ctx, func(tx *Service) error {
+		var err error
+		item, err = tx.storage.GetItem(ctx, itemID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrItemNotFound
+		case err != nil:
+			return err
+		}
+
+		// check the ownership of the cart
+		c, err = tx.getCart(ctx, userID, item.CartID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrCartNotFound
+		case err != nil:
+			return err
+		case !versionMatches(ctx, c):
+			return ErrCartVersionMismatch
+		case !c.IsOpen():
+			return ErrCartNotOpen
+		}
+
+		if quantity == 0 {
+			removed := item
+			item = nil
+			if err := tx.storage.RemoveItem(ctx, itemID); err != nil {
+				return err
+			}
+			if err := tx.storage.IncrementCartVersion(ctx, c); err != nil {
+				return err
+			}
+			return tx.recordEvent(ctx, cart.EventItemRemoved, c, removed)
+		}
+
+		switch {
+		case productErr != nil:
+			return productErr
+		case p.Price.Currency != c.Currency:
+			return cart.ErrCurrencyMismatch
+		}
+
+		if err := item.SetQuantity(quantity, p.Price); err != nil {
+			return err
+		}
+
+		err = tx.storage.UpdateItem(ctx, item)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrItemNotFound
+		case err != nil:
+			return err
+		}
+
+		if err := tx.storage.IncrementCartVersion(ctx, c); err != nil {
+			return err
+		}
+
+		return tx.recordEvent(ctx, cart.EventItemUpdated, c, item)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return item, c, nil
 }
 
 // RemoveItem, removes an item from the cart
-// it first checks if the cart belongs to the user and then removes the item
-func (s *Service) RemoveItem(ctx context.Context, userID, itemID int64) error {
-	item, err := s.storage.GetItem(ctx, itemID)
-	switch {
-	case err == storage.ErrRecordNotFound:
-		return ErrItemNotFound
-	case err != nil:
-		return err
+// it first checks if the cart belongs to the user and is open and then removes the item
+func (s *Service) RemoveItem(ctx context.Context, userID, itemID int64) (*cart.Cart, error) {
+	ctx, span := tracing.Start(ctx, "service.RemoveItem", attribute.Int64("user.id", userID), attribute.Int64("item.id", itemID))
+	defer span.End()
+
+	var c *cart.Cart
+	err := s.inTx(ctx, func(tx *Service) error {
+		item, err := tx.storage.GetItem(ctx, itemID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrItemNotFound
+		case err != nil:
+			return err
+		}
+
+		// check the ownership of the cart
+		c, err = tx.getCart(ctx, userID, item.CartID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrCartNotFound
+		case err != nil:
+			return err
+		case !versionMatches(ctx, c):
+			return ErrCartVersionMismatch
+		case !c.IsOpen():
+			return ErrCartNotOpen
+		}
+
+		if err := tx.storage.RemoveItem(ctx, item.ID); err != nil {
+			return err
+		}
+
+		if err := tx.storage.IncrementCartVersion(ctx, c); err != nil {
+			return err
+		}
+
+		return tx.recordEvent(ctx, cart.EventItemRemoved, c, item)
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	return c, nil
+}
+
+// EmptyCart remove all items of a cart
+// it first checks the ownership and the status of the cart and then delete all items
+func (s *Service) EmptyCart(ctx context.Context, userID, cartID int64) (*cart.Cart, error) {
+	ctx, span := tracing.Start(ctx, "service.EmptyCart", attribute.Int64("user.id", userID), attribute.Int64("cart.id", cartID))
+	defer span.End()
+
+	var c *cart.Cart
+	err := s.inTx(ctx, func(tx *Service) error {
+		// check the ownership of the cart
+		var err error
+		c, err = tx.getCart(ctx, userID, cartID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrCartNotFound
+		case err != nil:
+			return err
+		case !versionMatches(ctx, c):
+			return ErrCartVersionMismatch
+		case !c.IsOpen():
+			return ErrCartNotOpen
+		}
+
+		if err := tx.storage.RemoveItemsByCartID(ctx, cartID); err != nil {
+			return err
+		}
+
+		if err := tx.storage.IncrementCartVersion(ctx, c); err != nil {
+			return err
+		}
+
+		return tx.recordEvent(ctx, cart.EventCartEmptied, c, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// CartDetails collects all the data about a cart
+// it first checks the ownership of the cart and then loads its items and
+// computes the totals
+func (s *Service) CartDetails(ctx context.Context, userID, cartID int64) (*cart.Details, error) {
+	ctx, span := tracing.Start(ctx, "service.CartDetails", attribute.Int64("user.id", userID), attribute.Int64("cart.id", cartID))
+	defer span.End()
+
 	// check the ownership of the cart
-	_, err = s.storage.GetCart(ctx, userID, item.CartID)
+	c, err := s.getCart(ctx, userID, cartID)
 	switch {
 	case err == storage.ErrRecordNotFound:
-		return ErrCartNotFound
+		return nil, ErrCartNotFound
 	case err != nil:
-		return err
+		return nil, err
+	}
+
+	items, err := s.storage.ListItemsByCartID(ctx, c.ID)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.storage.RemoveItem(ctx, item.ID)
+	return cart.NewDetails(c, items)
 }
 
-// EmptyCart remove all items of a cart
-// it first checks the ownership of the cart and then delete all items
-func (s *Service) EmptyCart(ctx context.Context, userID, cartID int64) error {
-	// check the ownership of the cart
-	_, err := s.storage.GetCart(ctx, userID, cartID)
+// Checkout converts the user's open cart into an immutable order
+// it first checks the ownership of the cart, then freezes the cart and its items
+// into an order. checking out a cart that is already checked out returns the
+// existing order, so retrying a checkout never creates a second order
+func (s *Service) Checkout(ctx context.Context, userID, cartID int64) (*cart.Order, error) {
+	ctx, span := tracing.Start(ctx, "service.Checkout", attribute.Int64("user.id", userID), attribute.Int64("cart.id", cartID))
+	defer span.End()
+
+	var (
+		order      *cart.Order
+		checkedOut bool
+	)
+	err := s.inTx(ctx, func(tx *Service) error {
+		// check the ownership of the cart
+		c, err := tx.getCart(ctx, userID, cartID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrCartNotFound
+		case err != nil:
+			return err
+		case c.Status == cart.StatusCheckedOut:
+			order, err = tx.orderOfCart(ctx, c.ID)
+			return err
+		case !c.IsOpen():
+			return ErrCartNotOpen
+		}
+
+		items, err := tx.storage.ListItemsByCartID(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+
+		details, err := cart.NewDetails(c, items)
+		if err != nil {
+			return err
+		}
+
+		order, err = cart.NewOrder(details)
+		if err != nil {
+			return err
+		}
+
+		err = tx.storage.CheckoutCart(ctx, order)
+		switch {
+		case err == storage.ErrConflict:
+			// the cart is not open anymore, most likely a concurrent checkout
+			// got there first, in that case its order is returned
+			order, err = tx.orderOfCart(ctx, c.ID)
+			return err
+		case err != nil:
+			return err
+		}
+
+		if err := tx.storage.IncrementCartVersion(ctx, c); err != nil {
+			return err
+		}
+
+		checkedOut = true
+		return tx.recordEvent(ctx, cart.EventCartCheckedOut, c, order)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the retries of a checkout get the order of the first one
+	if checkedOut {
+		checkoutsCount.Inc()
+	}
+
+	return order, nil
+}
+
+// orderOfCart returns the order a cart was checked out to
+func (s *Service) orderOfCart(ctx context.Context, cartID int64) (*cart.Order, error) {
+	order, err := s.storage.GetOrderByCartID(ctx, cartID)
 	switch {
 	case err == storage.ErrRecordNotFound:
-		return ErrCartNotFound
+		return nil, ErrCartNotOpen
 	case err != nil:
-		return err
+		return nil, err
 	}
 
-	return s.storage.RemoveItemsByCartID(ctx, cartID)
+	return order, nil
 }
 
-// CartDetails collects all the data about a cart
-func (s *Service) CartDetails(ctx context.Context, cartID int) (*cart.Cart, []cart.Item, error) {
-	// TODO implement this
-	return nil, nil, nil
+// GetOrder returns an order of the user
+func (s *Service) GetOrder(ctx context.Context, userID, orderID int64) (*cart.Order, error) {
+	ctx, span := tracing.Start(ctx, "service.GetOrder", attribute.Int64("user.id", userID), attribute.Int64("order.id", orderID))
+	defer span.End()
+
+	order, err := s.storage.GetOrder(ctx, orderID)
+	switch {
+	case err == storage.ErrRecordNotFound:
+		return nil, ErrOrderNotFound
+	case err != nil:
+		return nil, err
+	case order.UserID != userID:
+		return nil, ErrOrderNotFound
+	}
+
+	return order, nil
 }
@@ -4,8 +4,11 @@ package service_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/product"
 	"github.com/cubny/cart/internal/service"
 	"github.com/cubny/cart/internal/storage"
 
@@ -17,24 +20,69 @@ func TestService_CreateCart(t *testing.T) {
 	tests := []struct {
 		name          string
 		userID        int64
+		guestCartID   int64
 		adjust        func(db *service.MockStorage)
+		expectedCart  *cart.Cart
 		expectedError error
 	}{
 		{
 			name:          "ok",
 			expectedError: nil,
 			userID:        int64(1),
+			expectedCart:  &cart.Cart{UserID: int64(1), Status: cart.StatusOpen, Currency: "EUR"},
 			adjust: func(db *service.MockStorage) {
 				db.EXPECT().
-					CreateCart(gomock.Any(), &cart.Cart{UserID: int64(1)}).
+					GetOpenCartByUserID(gomock.Any(), int64(1)).
+					Return(nil, storage.ErrRecordNotFound)
+				db.EXPECT().
+					CreateCart(gomock.Any(), &cart.Cart{UserID: int64(1), Status: cart.StatusOpen, Currency: "EUR"}).
+					Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:          "user has an open cart - returns the open cart",
+			expectedError: nil,
+			userID:        int64(1),
+			expectedCart:  &cart.Cart{ID: 5, UserID: int64(1), Status: cart.StatusOpen},
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().
+					GetOpenCartByUserID(gomock.Any(), int64(1)).
+					Return(&cart.Cart{ID: 5, UserID: int64(1), Status: cart.StatusOpen}, nil)
+			},
+		},
+		{
+			name:          "guest - creates a cart with no owner",
+			userID:        service.GuestUserID,
+			expectedError: nil,
+			expectedCart:  &cart.Cart{Status: cart.StatusOpen, Currency: "EUR"},
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().
+					CreateCart(gomock.Any(), &cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}).
 					Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:          "guest with the token of an open cart - returns the cart",
+			userID:        service.GuestUserID,
+			guestCartID:   5,
+			expectedError: nil,
+			expectedCart:  &cart.Cart{ID: 5, Status: cart.StatusOpen, Currency: "EUR"},
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().
+					GetCart(gomock.Any(), service.GuestUserID, int64(5)).
+					Return(&cart.Cart{ID: 5, Status: cart.StatusOpen, Currency: "EUR"}, nil)
 			},
 		},
 		{
-			name:          "invalid userID",
-			userID:        int64(0),
-			expectedError: cart.ErrInvalidUserID,
+			name:          "storage error on GetOpenCartByUserID - bubbles up",
+			expectedError: assert.AnError,
+			userID:        int64(1),
 			adjust: func(db *service.MockStorage) {
+				db.EXPECT().
+					GetOpenCartByUserID(gomock.Any(), int64(1)).
+					Return(nil, assert.AnError)
 			},
 		},
 		{
@@ -43,7 +91,10 @@ func TestService_CreateCart(t *testing.T) {
 			userID:        int64(1),
 			adjust: func(db *service.MockStorage) {
 				db.EXPECT().
-					CreateCart(gomock.Any(), &cart.Cart{UserID: int64(1)}).
+					GetOpenCartByUserID(gomock.Any(), int64(1)).
+					Return(nil, storage.ErrRecordNotFound)
+				db.EXPECT().
+					CreateCart(gomock.Any(), &cart.Cart{UserID: int64(1), Status: cart.StatusOpen, Currency: "EUR"}).
 					Return(assert.AnError)
 			},
 		},
@@ -54,38 +105,176 @@ func TestService_CreateCart(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			dbMock := service.NewMockStorage(ctrl)
+			dbMock := newMockStorage(ctrl)
 			test.adjust(dbMock)
-			svc, err := service.New(dbMock)
+			svc, err := service.New(dbMock, nil)
+			assert.Nil(t, err)
+			ctx := context.TODO()
+			if test.guestCartID != 0 {
+				ctx = ctxutil.SetGuestCartID(ctx, test.guestCartID)
+			}
+			c, err := svc.CreateCart(ctx, test.userID)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedCart, c)
+		})
+	}
+}
+
+func TestService_ChangeCartStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        int64
+		cartID        int64
+		status        cart.Status
+		expectedCart  *cart.Cart
+		expectedError error
+		adjust        func(db *service.MockStorage, userID, cartID int64)
+	}{
+		{
+			name:          "open to abandoned - ok",
+			userID:        1,
+			cartID:        1,
+			status:        cart.StatusAbandoned,
+			expectedCart:  &cart.Cart{ID: 1, UserID: 1, Status: cart.StatusAbandoned},
+			expectedError: nil,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen}, nil)
+				db.EXPECT().UpdateCartStatus(gomock.Any(), &cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusAbandoned}).
+					Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:          "abandoned to open - ok",
+			userID:        1,
+			cartID:        1,
+			status:        cart.StatusOpen,
+			expectedCart:  &cart.Cart{ID: 1, UserID: 1, Status: cart.StatusOpen},
+			expectedError: nil,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusAbandoned}, nil)
+				db.EXPECT().GetOpenCartByUserID(gomock.Any(), userID).Return(nil, storage.ErrRecordNotFound)
+				db.EXPECT().UpdateCartStatus(gomock.Any(), &cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen}).
+					Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:          "abandoned to open while another cart is open - ErrOpenCartExists",
+			userID:        1,
+			cartID:        1,
+			status:        cart.StatusOpen,
+			expectedError: service.ErrOpenCartExists,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusAbandoned}, nil)
+				db.EXPECT().GetOpenCartByUserID(gomock.Any(), userID).
+					Return(&cart.Cart{ID: 2, UserID: userID, Status: cart.StatusOpen}, nil)
+			},
+		},
+		{
+			name:          "checked out to open - ErrInvalidStatusTransition",
+			userID:        1,
+			cartID:        1,
+			status:        cart.StatusOpen,
+			expectedError: cart.ErrInvalidStatusTransition,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusCheckedOut}, nil)
+			},
+		},
+		{
+			name:          "unknown status - ErrInvalidStatus",
+			userID:        1,
+			cartID:        1,
+			status:        cart.Status("paid"),
+			expectedError: cart.ErrInvalidStatus,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen}, nil)
+			},
+		},
+		{
+			name:          "checked out - ErrCheckoutRequired",
+			userID:        1,
+			cartID:        1,
+			status:        cart.StatusCheckedOut,
+			expectedError: service.ErrCheckoutRequired,
+			adjust:        func(db *service.MockStorage, userID, cartID int64) {},
+		},
+		{
+			name:          "cart does not belong to the user - ErrCartNotFound",
+			userID:        1,
+			cartID:        2,
+			status:        cart.StatusAbandoned,
+			expectedError: service.ErrCartNotFound,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(nil, storage.ErrRecordNotFound)
+			},
+		},
+		{
+			name:          "storage UpdateCartStatus returns error - error",
+			userID:        1,
+			cartID:        1,
+			status:        cart.StatusAbandoned,
+			expectedError: assert.AnError,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen}, nil)
+				db.EXPECT().UpdateCartStatus(gomock.Any(), gomock.Any()).Return(assert.AnError)
+			},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			test.adjust(dbMock, test.userID, test.cartID)
+			svc, err := service.New(dbMock, nil)
 			assert.Nil(t, err)
-			_, err = svc.CreateCart(context.TODO(), test.userID)
+			c, err := svc.ChangeCartStatus(context.TODO(), test.userID, test.cartID, test.status)
 			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedCart, c)
 		})
 	}
 }
 
 func TestService_AddItem(t *testing.T) {
+	purchasable := &product.Product{ID: 1, Price: cart.NewMoney(1000, "EUR"), Purchasable: true}
+
 	tests := []struct {
 		name          string
 		userID        int64
 		item          *cart.Item
+		merge         bool
 		expectedError error
-		adjust        func(db *service.MockStorage, item *cart.Item, userID int64)
+		expectedPrice cart.Money
+		adjust        func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64)
 	}{
 		{
-			name:   "ok",
+			name:   "ok - price is set by the catalog",
 			userID: 1,
 			item: &cart.Item{
 				CartID:    1,
 				ProductID: 1,
-				Price:     cart.Price(10.00),
-				Quantity:  1,
+				Price:     cart.NewMoney(1, "EUR"),
+				Quantity:  2,
 			},
 			expectedError: nil,
-			adjust: func(db *service.MockStorage, item *cart.Item, userID int64) {
-				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{}, nil)
+			expectedPrice: cart.NewMoney(2000, "EUR"),
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
 				db.EXPECT().FindItemByProductID(gomock.Any(), item.CartID, item.ProductID).Return(nil, nil)
 				db.EXPECT().CreateItem(gomock.Any(), item).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
 			},
 		},
 		{
@@ -94,11 +283,11 @@ func TestService_AddItem(t *testing.T) {
 			item: &cart.Item{
 				CartID:    2,
 				ProductID: 1,
-				Price:     cart.Price(10.00),
 				Quantity:  1,
 			},
 			expectedError: service.ErrCartNotFound,
-			adjust: func(db *service.MockStorage, item *cart.Item, userID int64) {
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
 				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(nil, storage.ErrRecordNotFound)
 			},
 		},
@@ -108,42 +297,198 @@ func TestService_AddItem(t *testing.T) {
 			item: &cart.Item{
 				CartID:    2,
 				ProductID: 1,
-				Price:     cart.Price(10.00),
 				Quantity:  1,
 			},
 			expectedError: assert.AnError,
-			adjust: func(db *service.MockStorage, item *cart.Item, userID int64) {
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
 				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(nil, assert.AnError)
 			},
 		},
+		{
+			name:   "cart is not open - ErrCartNotOpen",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 1,
+				Quantity:  1,
+			},
+			expectedError: service.ErrCartNotOpen,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusCheckedOut}, nil)
+			},
+		},
+		{
+			name:   "product is not in the catalog - ErrProductNotFound",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 9,
+				Quantity:  1,
+			},
+			expectedError: service.ErrProductNotFound,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(nil, product.ErrNotFound)
+			},
+		},
+		{
+			name:   "product is not purchasable - ErrProductUnavailable",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 8,
+				Quantity:  1,
+			},
+			expectedError: service.ErrProductUnavailable,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).
+					Return(&product.Product{ID: 8, Price: cart.NewMoney(500, "EUR")}, nil)
+			},
+		},
+		{
+			name:   "product service returns error - error",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 1,
+				Quantity:  1,
+			},
+			expectedError: assert.AnError,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(nil, assert.AnError)
+			},
+		},
+		{
+			name:   "price does not fit in the amount - ErrAmountOverflow",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 1,
+				Quantity:  92233720368547758,
+			},
+			expectedError: cart.ErrAmountOverflow,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
+			},
+		},
+		{
+			name:   "product currency differs from the cart - ErrCurrencyMismatch",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 1,
+				Quantity:  1,
+			},
+			expectedError: cart.ErrCurrencyMismatch,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).
+					Return(&product.Product{ID: 1, Price: cart.NewMoney(1000, "USD"), Purchasable: true}, nil)
+			},
+		},
 		{
 			name:   "product already exists - ErrProductAlreadyInCart",
 			userID: 1,
 			item: &cart.Item{
 				CartID:    1,
 				ProductID: 1,
-				Price:     cart.Price(10.00),
 				Quantity:  1,
 			},
 			expectedError: service.ErrProductAlreadyInCart,
-			adjust: func(db *service.MockStorage, item *cart.Item, userID int64) {
-				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{}, nil)
+			expectedPrice: cart.NewMoney(1000, "EUR"),
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
 				db.EXPECT().FindItemByProductID(gomock.Any(), item.CartID, item.ProductID).
 					Return(&cart.Item{}, nil)
 			},
 		},
+		{
+			name:   "product added meanwhile - ErrProductAlreadyInCart",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 1,
+				Quantity:  1,
+			},
+			expectedError: service.ErrProductAlreadyInCart,
+			expectedPrice: cart.NewMoney(1000, "EUR"),
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
+				db.EXPECT().FindItemByProductID(gomock.Any(), item.CartID, item.ProductID).Return(nil, storage.ErrRecordNotFound)
+				db.EXPECT().CreateItem(gomock.Any(), item).Return(storage.ErrDuplicate)
+			},
+		},
+		{
+			name:   "product already exists with merge - merges the item",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 1,
+				Quantity:  1,
+			},
+			merge:         true,
+			expectedError: nil,
+			expectedPrice: cart.NewMoney(1000, "EUR"),
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
+				db.EXPECT().MergeItem(gomock.Any(), item).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:   "merge into a cart that is not open - ErrCartNotOpen",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 1,
+				Quantity:  1,
+			},
+			merge:         true,
+			expectedError: service.ErrCartNotOpen,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusAbandoned}, nil)
+			},
+		},
+		{
+			name:   "storage returns error on MergeItem - error",
+			userID: 1,
+			item: &cart.Item{
+				CartID:    1,
+				ProductID: 1,
+				Quantity:  1,
+			},
+			merge:         true,
+			expectedError: assert.AnError,
+			expectedPrice: cart.NewMoney(1000, "EUR"),
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
+				db.EXPECT().MergeItem(gomock.Any(), item).Return(assert.AnError)
+			},
+		},
 		{
 			name:   "storage returns error on createItem - error",
 			userID: 1,
 			item: &cart.Item{
 				CartID:    1,
 				ProductID: 1,
-				Price:     cart.Price(10.00),
 				Quantity:  1,
 			},
 			expectedError: assert.AnError,
-			adjust: func(db *service.MockStorage, item *cart.Item, userID int64) {
-				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{}, nil)
+			expectedPrice: cart.NewMoney(1000, "EUR"),
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, item *cart.Item, userID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), item.ProductID).Return(purchasable, nil)
 				db.EXPECT().FindItemByProductID(gomock.Any(), item.CartID, item.ProductID).
 					Return(nil, nil)
 				db.EXPECT().CreateItem(gomock.Any(), item).Return(assert.AnError)
@@ -156,61 +501,180 @@ func TestService_AddItem(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			dbMock := service.NewMockStorage(ctrl)
-			test.adjust(dbMock, test.item, test.userID)
-			svc, err := service.New(dbMock)
+			dbMock := newMockStorage(ctrl)
+			productsMock := service.NewMockProductProvider(ctrl)
+			test.adjust(dbMock, productsMock, test.item, test.userID)
+			svc, err := service.New(dbMock, productsMock)
 			assert.Nil(t, err)
-			err = svc.AddItem(context.TODO(), test.userID, test.item)
+			c, err := svc.AddItem(context.TODO(), test.userID, test.item, test.merge)
 			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedError == nil, c != nil)
+			if test.expectedPrice.Currency != "" {
+				assert.Equal(t, test.expectedPrice, test.item.Price)
+			}
 		})
 	}
 }
 
-func TestService_RemoveItem(t *testing.T) {
+func TestService_AddItemInTransaction(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dbMock := service.NewMockStorage(ctrl)
+	txMock := service.NewMockStorage(ctrl)
+	productsMock := service.NewMockProductProvider(ctrl)
+	item := &cart.Item{CartID: 1, ProductID: 1, Quantity: 1}
+
+	// the catalog is asked before the transaction, the steps run on the storage
+	// of the transaction and a failed commit fails the add
+	gomock.InOrder(
+		productsMock.EXPECT().GetProduct(gomock.Any(), item.ProductID).
+			Return(&product.Product{ID: 1, Price: cart.NewMoney(1000, "EUR"), Purchasable: true}, nil),
+		dbMock.EXPECT().WithTx(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, fn func(service.Storage) error) error {
+			assert.Nil(t, fn(txMock))
+			return assert.AnError
+		}),
+	)
+	txMock.EXPECT().GetCart(gomock.Any(), int64(1), item.CartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+	txMock.EXPECT().FindItemByProductID(gomock.Any(), item.CartID, item.ProductID).Return(nil, storage.ErrRecordNotFound)
+	txMock.EXPECT().CreateItem(gomock.Any(), item).Return(nil)
+	txMock.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+	txMock.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+
+	svc, err := service.New(dbMock, productsMock)
+	assert.Nil(t, err)
+	_, err = svc.AddItem(context.TODO(), 1, item, false)
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestService_UpdateItem(t *testing.T) {
+	purchasable := &product.Product{ID: 1, Price: cart.NewMoney(1000, "EUR"), Purchasable: true}
+
 	tests := []struct {
 		name          string
 		userID        int64
 		itemID        int64
+		quantity      int64
+		expectedItem  *cart.Item
 		expectedError error
-		adjust        func(db *service.MockStorage, userID, itemID int64)
+		adjust        func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64)
 	}{
 		{
-			name:          "ok",
+			name:         "ok",
+			userID:       1,
+			itemID:       1,
+			quantity:     3,
+			expectedItem: &cart.Item{ID: 1, CartID: 1, ProductID: 1, Quantity: 3, Price: cart.NewMoney(3000, "EUR")},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).
+					Return(&cart.Item{ID: itemID, CartID: 1, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}, nil).Times(2)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), int64(1)).Return(purchasable, nil)
+				db.EXPECT().UpdateItem(gomock.Any(), &cart.Item{ID: itemID, CartID: 1, ProductID: 1, Quantity: 3, Price: cart.NewMoney(3000, "EUR")}).
+					Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:         "ok - an item merged at two prices is priced by the catalog",
+			userID:       1,
+			itemID:       1,
+			quantity:     1,
+			expectedItem: &cart.Item{ID: 1, CartID: 1, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).
+					Return(&cart.Item{ID: itemID, CartID: 1, ProductID: 1, Quantity: 3, Price: cart.NewMoney(3002, "EUR")}, nil).Times(2)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), int64(1)).Return(purchasable, nil)
+				db.EXPECT().UpdateItem(gomock.Any(), &cart.Item{ID: itemID, CartID: 1, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}).
+					Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:          "product is not purchasable - ErrProductUnavailable",
 			userID:        1,
 			itemID:        1,
-			expectedError: nil,
-			adjust: func(db *service.MockStorage, userID, itemID int64) {
-				db.EXPECT().GetItem(gomock.Any(), itemID).Return(&cart.Item{CartID: 1, ID: itemID}, nil)
-				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{}, nil)
+			quantity:      2,
+			expectedError: service.ErrProductUnavailable,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).
+					Return(&cart.Item{ID: itemID, CartID: 1, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}, nil).Times(2)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), int64(1)).
+					Return(&product.Product{ID: 1, Price: cart.NewMoney(1000, "EUR")}, nil)
+			},
+		},
+		{
+			name:     "quantity 0 - removes the item",
+			userID:   1,
+			itemID:   1,
+			quantity: 0,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).
+					Return(&cart.Item{ID: itemID, CartID: 1, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}, nil)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
 				db.EXPECT().RemoveItem(gomock.Any(), itemID).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
 			},
 		},
+		{
+			name:          "negative quantity - ErrInvalidQuantity",
+			userID:        1,
+			itemID:        1,
+			quantity:      -1,
+			expectedError: cart.ErrInvalidQuantity,
+			adjust:        func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {},
+		},
 		{
 			name:          "item not found - ErrItemNotFound",
 			userID:        1,
 			itemID:        1,
+			quantity:      2,
 			expectedError: service.ErrItemNotFound,
-			adjust: func(db *service.MockStorage, userID, itemID int64) {
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {
 				db.EXPECT().GetItem(gomock.Any(), itemID).Return(nil, storage.ErrRecordNotFound)
 			},
 		},
 		{
-			name:          "storage getItem returns error - error",
+			name:          "cart does not belong to the user - ErrCartNotFound",
 			userID:        1,
 			itemID:        1,
-			expectedError: assert.AnError,
-			adjust: func(db *service.MockStorage, userID, itemID int64) {
-				db.EXPECT().GetItem(gomock.Any(), itemID).Return(nil, assert.AnError)
+			quantity:      2,
+			expectedError: service.ErrCartNotFound,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).Return(&cart.Item{ID: itemID, CartID: 1}, nil).Times(2)
+				products.EXPECT().GetProduct(gomock.Any(), int64(0)).Return(purchasable, nil)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(nil, storage.ErrRecordNotFound)
 			},
 		},
 		{
-			name:          "storage getCart returns record not found - ErrCartNotFound",
+			name:          "cart is not open - ErrCartNotOpen",
 			userID:        1,
 			itemID:        1,
-			expectedError: service.ErrCartNotFound,
-			adjust: func(db *service.MockStorage, userID, itemID int64) {
-				db.EXPECT().GetItem(gomock.Any(), itemID).Return(&cart.Item{CartID: 1, ID: itemID}, nil)
-				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(nil, storage.ErrRecordNotFound)
+			quantity:      2,
+			expectedError: service.ErrCartNotOpen,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).Return(&cart.Item{ID: itemID, CartID: 1}, nil).Times(2)
+				products.EXPECT().GetProduct(gomock.Any(), int64(0)).Return(purchasable, nil)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{Status: cart.StatusCheckedOut}, nil)
+			},
+		},
+		{
+			name:          "storage UpdateItem returns error - error",
+			userID:        1,
+			itemID:        1,
+			quantity:      2,
+			expectedError: assert.AnError,
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).
+					Return(&cart.Item{ID: itemID, CartID: 1, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}, nil).Times(2)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				products.EXPECT().GetProduct(gomock.Any(), int64(1)).Return(purchasable, nil)
+				db.EXPECT().UpdateItem(gomock.Any(), gomock.Any()).Return(assert.AnError)
 			},
 		},
 	}
@@ -219,43 +683,133 @@ func TestService_RemoveItem(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			dbMock := service.NewMockStorage(ctrl)
-			test.adjust(dbMock, test.userID, test.itemID)
-			svc, err := service.New(dbMock)
+			dbMock := newMockStorage(ctrl)
+			productsMock := service.NewMockProductProvider(ctrl)
+			test.adjust(dbMock, productsMock, test.userID, test.itemID)
+			svc, err := service.New(dbMock, productsMock)
 			assert.Nil(t, err)
-			err = svc.RemoveItem(context.TODO(), test.userID, test.itemID)
+			item, c, err := svc.UpdateItem(context.TODO(), test.userID, test.itemID, test.quantity)
 			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedError == nil, c != nil)
+			assert.Equal(t, test.expectedItem, item)
 		})
 	}
 }
 
-func TestService_EmptyCart(t *testing.T) {
+func TestService_RemoveItem(t *testing.T) {
 	tests := []struct {
 		name          string
 		userID        int64
-		cartID        int64
+		itemID        int64
 		expectedError error
-		adjust        func(db *service.MockStorage, userID, cartID int64)
+		adjust        func(db *service.MockStorage, userID, itemID int64)
 	}{
 		{
 			name:          "ok",
 			userID:        1,
-			cartID:        1,
+			itemID:        1,
 			expectedError: nil,
-			adjust: func(db *service.MockStorage, userID, cartID int64) {
-				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(&cart.Cart{}, nil)
-				db.EXPECT().RemoveItemsByCartID(gomock.Any(), cartID).Return(nil)
+			adjust: func(db *service.MockStorage, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).Return(&cart.Item{CartID: 1, ID: itemID}, nil)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				db.EXPECT().RemoveItem(gomock.Any(), itemID).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
 			},
 		},
 		{
-			name:          "storage getCart returns ErrCartNotFound - ErrCartNotFound",
+			name:          "item not found - ErrItemNotFound",
 			userID:        1,
-			cartID:        1,
-			expectedError: service.ErrCartNotFound,
+			itemID:        1,
+			expectedError: service.ErrItemNotFound,
+			adjust: func(db *service.MockStorage, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).Return(nil, storage.ErrRecordNotFound)
+			},
+		},
+		{
+			name:          "storage getItem returns error - error",
+			userID:        1,
+			itemID:        1,
+			expectedError: assert.AnError,
+			adjust: func(db *service.MockStorage, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).Return(nil, assert.AnError)
+			},
+		},
+		{
+			name:          "cart is not open - ErrCartNotOpen",
+			userID:        1,
+			itemID:        1,
+			expectedError: service.ErrCartNotOpen,
+			adjust: func(db *service.MockStorage, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).Return(&cart.Item{CartID: 1, ID: itemID}, nil)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(&cart.Cart{Status: cart.StatusAbandoned}, nil)
+			},
+		},
+		{
+			name:          "storage getCart returns record not found - ErrCartNotFound",
+			userID:        1,
+			itemID:        1,
+			expectedError: service.ErrCartNotFound,
+			adjust: func(db *service.MockStorage, userID, itemID int64) {
+				db.EXPECT().GetItem(gomock.Any(), itemID).Return(&cart.Item{CartID: 1, ID: itemID}, nil)
+				db.EXPECT().GetCart(gomock.Any(), userID, int64(1)).Return(nil, storage.ErrRecordNotFound)
+			},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			test.adjust(dbMock, test.userID, test.itemID)
+			svc, err := service.New(dbMock, nil)
+			assert.Nil(t, err)
+			c, err := svc.RemoveItem(context.TODO(), test.userID, test.itemID)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedError == nil, c != nil)
+		})
+	}
+}
+
+func TestService_EmptyCart(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        int64
+		cartID        int64
+		expectedError error
+		adjust        func(db *service.MockStorage, userID, cartID int64)
+	}{
+		{
+			name:          "ok",
+			userID:        1,
+			cartID:        1,
+			expectedError: nil,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(&cart.Cart{Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				db.EXPECT().RemoveItemsByCartID(gomock.Any(), cartID).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:          "storage getCart returns ErrCartNotFound - ErrCartNotFound",
+			userID:        1,
+			cartID:        1,
+			expectedError: service.ErrCartNotFound,
 			adjust: func(db *service.MockStorage, userID, cartID int64) {
 				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(nil, storage.ErrRecordNotFound)
 			},
 		},
+		{
+			name:          "cart is not open - ErrCartNotOpen",
+			userID:        1,
+			cartID:        1,
+			expectedError: service.ErrCartNotOpen,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(&cart.Cart{Status: cart.StatusCheckedOut}, nil)
+			},
+		},
 		{
 			name:          "storage getCart returns error - error",
 			userID:        1,
@@ -271,12 +825,564 @@ func TestService_EmptyCart(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			dbMock := service.NewMockStorage(ctrl)
+			dbMock := newMockStorage(ctrl)
 			test.adjust(dbMock, test.userID, test.cartID)
-			svc, err := service.New(dbMock)
+			svc, err := service.New(dbMock, nil)
 			assert.Nil(t, err)
-			err = svc.EmptyCart(context.TODO(), test.userID, test.cartID)
+			c, err := svc.EmptyCart(context.TODO(), test.userID, test.cartID)
 			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedError == nil, c != nil)
+		})
+	}
+}
+
+func TestService_CartVersion(t *testing.T) {
+	openCart := func() *cart.Cart {
+		return &cart.Cart{ID: 1, UserID: 1, Status: cart.StatusOpen, Currency: "EUR", Version: 2}
+	}
+	item := func() *cart.Item {
+		return &cart.Item{ID: 10, CartID: 1, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}
+	}
+	purchasable := &product.Product{ID: 1, Price: cart.NewMoney(1000, "EUR"), Purchasable: true}
+
+	tests := []struct {
+		name   string
+		change func(ctx context.Context, svc *service.Service) error
+		adjust func(db *service.MockStorage, products *service.MockProductProvider)
+	}{
+		{
+			name: "change status",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.ChangeCartStatus(ctx, 1, 1, cart.StatusAbandoned)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+			},
+		},
+		{
+			name: "add item",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.AddItem(ctx, 1, &cart.Item{CartID: 1, ProductID: 1, Quantity: 1}, false)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				products.EXPECT().GetProduct(gomock.Any(), int64(1)).Return(purchasable, nil)
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+			},
+		},
+		{
+			name: "update item",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, _, err := svc.UpdateItem(ctx, 1, 10, 2)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetItem(gomock.Any(), int64(10)).Return(item(), nil).Times(2)
+				products.EXPECT().GetProduct(gomock.Any(), int64(1)).Return(purchasable, nil)
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+			},
+		},
+		{
+			name: "remove item",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.RemoveItem(ctx, 1, 10)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetItem(gomock.Any(), int64(10)).Return(item(), nil)
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+			},
+		},
+		{
+			name: "empty cart",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.EmptyCart(ctx, 1, 1)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+			},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, test := range tests {
+		t.Run(test.name+" - ErrCartVersionMismatch", func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			productsMock := service.NewMockProductProvider(ctrl)
+			test.adjust(dbMock, productsMock)
+			svc, err := service.New(dbMock, productsMock)
+			assert.Nil(t, err)
+			// the cart is at version 2, the change expects it at 1 or 3
+			ctx := ctxutil.SetCartVersions(context.TODO(), []int64{1, 3})
+			assert.Equal(t, service.ErrCartVersionMismatch, test.change(ctx, svc))
 		})
 	}
 }
+
+func TestService_Events(t *testing.T) {
+	openCart := func() *cart.Cart {
+		return &cart.Cart{ID: 1, UserID: 1, Status: cart.StatusOpen, Currency: "EUR", Version: 2}
+	}
+	item := func() *cart.Item {
+		return &cart.Item{ID: 10, CartID: 1, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}
+	}
+	purchasable := &product.Product{ID: 1, Price: cart.NewMoney(1000, "EUR"), Purchasable: true}
+
+	tests := []struct {
+		name         string
+		change       func(ctx context.Context, svc *service.Service) error
+		adjust       func(db *service.MockStorage, products *service.MockProductProvider)
+		expectedType cart.EventType
+		expectedData string
+	}{
+		{
+			name: "create cart",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.CreateCart(ctx, 1)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetOpenCartByUserID(gomock.Any(), int64(1)).Return(nil, storage.ErrRecordNotFound)
+				db.EXPECT().CreateCart(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, c *cart.Cart) error {
+					c.ID = 1
+					return nil
+				})
+			},
+			expectedType: cart.EventCartCreated,
+			expectedData: `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`,
+		},
+		{
+			name: "add item",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.AddItem(ctx, 1, &cart.Item{CartID: 1, ProductID: 1, Quantity: 1}, false)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+				products.EXPECT().GetProduct(gomock.Any(), int64(1)).Return(purchasable, nil)
+				db.EXPECT().FindItemByProductID(gomock.Any(), int64(1), int64(1)).Return(nil, storage.ErrRecordNotFound)
+				db.EXPECT().CreateItem(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, i *cart.Item) error {
+					i.ID = 10
+					return nil
+				})
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedType: cart.EventItemAdded,
+			expectedData: `{"id":10, "cart_id":1, "product_id":1, "quantity":1, "price":10}`,
+		},
+		{
+			name: "remove item by quantity 0",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, _, err := svc.UpdateItem(ctx, 1, 10, 0)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetItem(gomock.Any(), int64(10)).Return(item(), nil)
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+				db.EXPECT().RemoveItem(gomock.Any(), int64(10)).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedType: cart.EventItemRemoved,
+			expectedData: `{"id":10, "cart_id":1, "product_id":1, "quantity":1, "price":10}`,
+		},
+		{
+			name: "update item",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, _, err := svc.UpdateItem(ctx, 1, 10, 2)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetItem(gomock.Any(), int64(10)).Return(item(), nil).Times(2)
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+				products.EXPECT().GetProduct(gomock.Any(), int64(1)).Return(purchasable, nil)
+				db.EXPECT().UpdateItem(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedType: cart.EventItemUpdated,
+			expectedData: `{"id":10, "cart_id":1, "product_id":1, "quantity":2, "price":20}`,
+		},
+		{
+			name: "remove item",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.RemoveItem(ctx, 1, 10)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetItem(gomock.Any(), int64(10)).Return(item(), nil)
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+				db.EXPECT().RemoveItem(gomock.Any(), int64(10)).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedType: cart.EventItemRemoved,
+			expectedData: `{"id":10, "cart_id":1, "product_id":1, "quantity":1, "price":10}`,
+		},
+		{
+			name: "empty cart",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.EmptyCart(ctx, 1, 1)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+				db.EXPECT().RemoveItemsByCartID(gomock.Any(), int64(1)).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedType: cart.EventCartEmptied,
+			expectedData: `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`,
+		},
+		{
+			name: "abandon cart",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.ChangeCartStatus(ctx, 1, 1, cart.StatusAbandoned)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+				db.EXPECT().UpdateCartStatus(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedType: cart.EventCartStatusChanged,
+			expectedData: `{"id":1, "user_id":1, "status":"abandoned", "currency":"EUR"}`,
+		},
+		{
+			name: "checkout",
+			change: func(ctx context.Context, svc *service.Service) error {
+				_, err := svc.Checkout(ctx, 1, 1)
+				return err
+			},
+			adjust: func(db *service.MockStorage, products *service.MockProductProvider) {
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(openCart(), nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), int64(1)).Return([]*cart.Item{item()}, nil)
+				db.EXPECT().CheckoutCart(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, o *cart.Order) error {
+					o.ID = 7
+					o.CheckedOutAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+					return nil
+				})
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedType: cart.EventCartCheckedOut,
+			expectedData: `{"id":7, "cart_id":1, "user_id":1, "lines":[{"id":0, "order_id":0, "product_id":1, "quantity":1, "price":10}],
+				"item_count":1, "total_quantity":1, "total":10, "currency":"EUR", "checked_out_at":"2020-01-01T00:00:00Z"}`,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			productsMock := service.NewMockProductProvider(ctrl)
+			test.adjust(dbMock, productsMock)
+			dbMock.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, e *cart.Event) error {
+				assert.Equal(t, test.expectedType, e.Type)
+				assert.Equal(t, int64(1), e.CartID)
+				assert.Equal(t, int64(1), e.UserID)
+				assert.JSONEq(t, test.expectedData, string(e.Data))
+				return nil
+			})
+			svc, err := service.New(dbMock, productsMock)
+			assert.Nil(t, err)
+			assert.Nil(t, test.change(context.TODO(), svc))
+		})
+
+		// the change is rolled back when its event cannot be written
+		t.Run(test.name+" - event error bubbles up", func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			productsMock := service.NewMockProductProvider(ctrl)
+			test.adjust(dbMock, productsMock)
+			dbMock.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(assert.AnError)
+			svc, err := service.New(dbMock, productsMock)
+			assert.Nil(t, err)
+			assert.Equal(t, assert.AnError, test.change(context.TODO(), svc))
+		})
+	}
+}
+
+func TestService_CartDetails(t *testing.T) {
+	tests := []struct {
+		name            string
+		userID          int64
+		cartID          int64
+		expectedDetails *cart.Details
+		expectedError   error
+		adjust          func(db *service.MockStorage, userID, cartID int64)
+	}{
+		{
+			name:   "ok",
+			userID: 1,
+			cartID: 1,
+			expectedDetails: &cart.Details{
+				Cart: &cart.Cart{ID: 1, UserID: 1},
+				Items: []*cart.Item{
+					{ID: 1, CartID: 1, ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")},
+					{ID: 2, CartID: 1, ProductID: 2, Quantity: 1, Price: cart.NewMoney(550, "EUR")},
+				},
+				ItemCount:     2,
+				TotalQuantity: 3,
+				Subtotal:      cart.NewMoney(2550, "EUR"),
+			},
+			expectedError: nil,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(&cart.Cart{ID: cartID, UserID: userID}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), cartID).Return([]*cart.Item{
+					{ID: 1, CartID: 1, ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")},
+					{ID: 2, CartID: 1, ProductID: 2, Quantity: 1, Price: cart.NewMoney(550, "EUR")},
+				}, nil)
+			},
+		},
+		{
+			name:   "empty cart - ok",
+			userID: 1,
+			cartID: 1,
+			expectedDetails: &cart.Details{
+				Cart:  &cart.Cart{ID: 1, UserID: 1},
+				Items: []*cart.Item{},
+			},
+			expectedError: nil,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(&cart.Cart{ID: cartID, UserID: userID}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), cartID).Return(nil, nil)
+			},
+		},
+		{
+			name:          "cart does not belong to the user - ErrCartNotFound",
+			userID:        1,
+			cartID:        2,
+			expectedError: service.ErrCartNotFound,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(nil, storage.ErrRecordNotFound)
+			},
+		},
+		{
+			name:          "storage ListItemsByCartID returns error - error",
+			userID:        1,
+			cartID:        1,
+			expectedError: assert.AnError,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(&cart.Cart{ID: cartID, UserID: userID}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), cartID).Return(nil, assert.AnError)
+			},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			test.adjust(dbMock, test.userID, test.cartID)
+			svc, err := service.New(dbMock, nil)
+			assert.Nil(t, err)
+			details, err := svc.CartDetails(context.TODO(), test.userID, test.cartID)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedDetails, details)
+		})
+	}
+}
+
+func TestService_Checkout(t *testing.T) {
+	items := []*cart.Item{
+		{ID: 1, CartID: 1, ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")},
+		{ID: 2, CartID: 1, ProductID: 2, Quantity: 1, Price: cart.NewMoney(550, "EUR")},
+	}
+	order := &cart.Order{
+		CartID: 1,
+		UserID: 1,
+		Lines: []*cart.OrderLine{
+			{ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")},
+			{ProductID: 2, Quantity: 1, Price: cart.NewMoney(550, "EUR")},
+		},
+		ItemCount:     2,
+		TotalQuantity: 3,
+		Total:         cart.NewMoney(2550, "EUR"),
+		Currency:      "EUR",
+	}
+	existingOrder := &cart.Order{ID: 7, CartID: 1, UserID: 1}
+
+	tests := []struct {
+		name          string
+		userID        int64
+		cartID        int64
+		expectedOrder *cart.Order
+		expectedError error
+		adjust        func(db *service.MockStorage, userID, cartID int64)
+	}{
+		{
+			name:          "ok",
+			userID:        1,
+			cartID:        1,
+			expectedOrder: order,
+			expectedError: nil,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), cartID).Return(items, nil)
+				db.EXPECT().CheckoutCart(gomock.Any(), order).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name:          "already checked out - returns the existing order",
+			userID:        1,
+			cartID:        1,
+			expectedOrder: existingOrder,
+			expectedError: nil,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusCheckedOut}, nil)
+				db.EXPECT().GetOrderByCartID(gomock.Any(), cartID).Return(existingOrder, nil)
+			},
+		},
+		{
+			name:          "concurrent checkout locked the cart first - returns its order",
+			userID:        1,
+			cartID:        1,
+			expectedOrder: existingOrder,
+			expectedError: nil,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), cartID).Return(items, nil)
+				db.EXPECT().CheckoutCart(gomock.Any(), order).Return(storage.ErrConflict)
+				db.EXPECT().GetOrderByCartID(gomock.Any(), cartID).Return(existingOrder, nil)
+			},
+		},
+		{
+			name:          "cart abandoned concurrently - ErrCartNotOpen",
+			userID:        1,
+			cartID:        1,
+			expectedError: service.ErrCartNotOpen,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), cartID).Return(items, nil)
+				db.EXPECT().CheckoutCart(gomock.Any(), order).Return(storage.ErrConflict)
+				db.EXPECT().GetOrderByCartID(gomock.Any(), cartID).Return(nil, storage.ErrRecordNotFound)
+			},
+		},
+		{
+			name:          "cart is abandoned - ErrCartNotOpen",
+			userID:        1,
+			cartID:        1,
+			expectedError: service.ErrCartNotOpen,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusAbandoned}, nil)
+			},
+		},
+		{
+			name:          "cart is empty - ErrEmptyCart",
+			userID:        1,
+			cartID:        1,
+			expectedError: cart.ErrEmptyCart,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), cartID).Return([]*cart.Item{}, nil)
+			},
+		},
+		{
+			name:          "cart does not belong to the user - ErrCartNotFound",
+			userID:        1,
+			cartID:        2,
+			expectedError: service.ErrCartNotFound,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).Return(nil, storage.ErrRecordNotFound)
+			},
+		},
+		{
+			name:          "storage CheckoutCart returns error - error",
+			userID:        1,
+			cartID:        1,
+			expectedError: assert.AnError,
+			adjust: func(db *service.MockStorage, userID, cartID int64) {
+				db.EXPECT().GetCart(gomock.Any(), userID, cartID).
+					Return(&cart.Cart{ID: cartID, UserID: userID, Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), cartID).Return(items, nil)
+				db.EXPECT().CheckoutCart(gomock.Any(), order).Return(assert.AnError)
+			},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			test.adjust(dbMock, test.userID, test.cartID)
+			svc, err := service.New(dbMock, nil)
+			assert.Nil(t, err)
+			o, err := svc.Checkout(context.TODO(), test.userID, test.cartID)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedOrder, o)
+		})
+	}
+}
+
+func TestService_GetOrder(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        int64
+		orderID       int64
+		expectedOrder *cart.Order
+		expectedError error
+		adjust        func(db *service.MockStorage, orderID int64)
+	}{
+		{
+			name:          "ok",
+			userID:        1,
+			orderID:       1,
+			expectedOrder: &cart.Order{ID: 1, CartID: 1, UserID: 1},
+			adjust: func(db *service.MockStorage, orderID int64) {
+				db.EXPECT().GetOrder(gomock.Any(), orderID).Return(&cart.Order{ID: 1, CartID: 1, UserID: 1}, nil)
+			},
+		},
+		{
+			name:          "order of another user - ErrOrderNotFound",
+			userID:        1,
+			orderID:       1,
+			expectedError: service.ErrOrderNotFound,
+			adjust: func(db *service.MockStorage, orderID int64) {
+				db.EXPECT().GetOrder(gomock.Any(), orderID).Return(&cart.Order{ID: 1, CartID: 1, UserID: 2}, nil)
+			},
+		},
+		{
+			name:          "order does not exist - ErrOrderNotFound",
+			userID:        1,
+			orderID:       1,
+			expectedError: service.ErrOrderNotFound,
+			adjust: func(db *service.MockStorage, orderID int64) {
+				db.EXPECT().GetOrder(gomock.Any(), orderID).Return(nil, storage.ErrRecordNotFound)
+			},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			test.adjust(dbMock, test.orderID)
+			svc, err := service.New(dbMock, nil)
+			assert.Nil(t, err)
+			o, err := svc.GetOrder(context.TODO(), test.userID, test.orderID)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedOrder, o)
+		})
+	}
+}
+
+// newMockStorage returns a storage mock whose transactions run on the mock itself
+func newMockStorage(ctrl *gomock.Controller) *service.MockStorage {
+	db := service.NewMockStorage(ctrl)
+	db.EXPECT().WithTx(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, fn func(service.Storage) error) error {
+		return fn(db)
+	}).AnyTimes()
+	return db
+}
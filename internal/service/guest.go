@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GuestUserID is the user id that the guests, i.e. the visitors who are not logged
+// in, call the service with. a guest has access only to the cart of the token
+// it holds, which is told by ctxutil.GetGuestCartID
+const GuestUserID int64 = 0
+
+// getCart returns the cart if it belongs to the user, a guest owns only the
+// cart of its token
+func (s *Service) getCart(ctx context.Context, userID, cartID int64) (*cart.Cart, error) {
+	if userID == GuestUserID {
+		guestCartID, ok := ctxutil.GetGuestCartID(ctx)
+		if !ok || guestCartID != cartID {
+			return nil, storage.ErrRecordNotFound
+		}
+	}
+
+	return s.storage.GetCart(ctx, userID, cartID)
+}
+
+// createGuestCart creates a cart with no owner, a guest that holds the token of
+// an open cart gets that cart back instead
+func (s *Service) createGuestCart(ctx context.Context) (*cart.Cart, error) {
+	if cartID, ok := ctxutil.GetGuestCartID(ctx); ok && cartID != 0 {
+		c, err := s.getCart(ctx, GuestUserID, cartID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+		case err != nil:
+			return nil, err
+		case c.IsOpen():
+			return c, nil
+		}
+	}
+
+	c := cart.NewGuestCart()
+	err := s.inTx(ctx, func(tx *Service) error {
+		if err := tx.storage.CreateCart(ctx, c); err != nil {
+			return err
+		}
+
+		return tx.recordEvent(ctx, cart.EventCartCreated, c, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	cartsCreatedCount.Inc()
+
+	return c, nil
+}
+
+// ClaimCart gives the guest cart to the user once the guest logs in. when the
+// user has no open cart the guest cart simply becomes the user's cart, otherwise
+// its items are merged into the open cart of the user, the items of the same
+// product by the rule, and the guest cart is abandoned. it returns the details
+// of the cart the user ends up with
+func (s *Service) ClaimCart(ctx context.Context, userID, cartID int64, rule cart.MergeRule) (*cart.Details, error) {
+	ctx, span := tracing.Start(ctx, "service.ClaimCart", attribute.Int64("user.id", userID), attribute.Int64("cart.id", cartID))
+	defer span.End()
+
+	if !rule.Valid() {
+		return nil, cart.ErrInvalidMergeRule
+	}
+
+	var details *cart.Details
+	err := s.inTx(ctx, func(tx *Service) error {
+		guest, err := tx.storage.GetCart(ctx, GuestUserID, cartID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			return ErrCartNotFound
+		case err != nil:
+			return err
+		case !guest.IsOpen():
+			return ErrCartNotOpen
+		}
+
+		open, err := tx.storage.GetOpenCartByUserID(ctx, userID)
+		switch {
+		case err == storage.ErrRecordNotFound:
+			guest.UserID = userID
+			if err := tx.claim(ctx, guest); err != nil {
+				return err
+			}
+			if err := tx.storage.IncrementCartVersion(ctx, guest); err != nil {
+				return err
+			}
+			if err := tx.recordEvent(ctx, cart.EventCartClaimed, guest, guest); err != nil {
+				return err
+			}
+			details, err = tx.CartDetails(ctx, userID, guest.ID)
+			return err
+		case err != nil:
+			return err
+		case open.Currency != guest.Currency:
+			return cart.ErrCurrencyMismatch
+		}
+
+		// the guest cart is claimed before its items are moved, so that a cart
+		// claimed twice at the same time is merged only once
+		guest.UserID = userID
+		if err := guest.TransitionTo(cart.StatusAbandoned); err != nil {
+			return err
+		}
+		if err := tx.claim(ctx, guest); err != nil {
+			return err
+		}
+
+		items, err := tx.storage.ListItemsByCartID(ctx, guest.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if err := tx.mergeItem(ctx, open, item, rule); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.storage.RemoveItemsByCartID(ctx, guest.ID); err != nil {
+			return err
+		}
+		if err := tx.storage.IncrementCartVersion(ctx, guest); err != nil {
+			return err
+		}
+		if err := tx.storage.IncrementCartVersion(ctx, open); err != nil {
+			return err
+		}
+		if err := tx.recordEvent(ctx, cart.EventCartClaimed, guest, guest); err != nil {
+			return err
+		}
+
+		details, err = tx.CartDetails(ctx, userID, open.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// claim writes the new owner of the guest cart
+func (s *Service) claim(ctx context.Context, guest *cart.Cart) error {
+	err := s.storage.ClaimCart(ctx, guest)
+	switch {
+	case err == storage.ErrConflict:
+		// another user claimed the cart meanwhile
+		return ErrCartNotFound
+	case err != nil:
+		return err
+	}
+
+	return nil
+}
+
+// mergeItem moves the item of the guest cart to the cart, or merges it into the
+// item of the same product by the rule
+func (s *Service) mergeItem(ctx context.Context, c *cart.Cart, item *cart.Item, rule cart.MergeRule) error {
+	existing, err := s.storage.FindItemByProductID(ctx, c.ID, item.ProductID)
+	switch {
+	case err == storage.ErrRecordNotFound, err == nil && existing == nil:
+		moved := &cart.Item{
+			ProductID: item.ProductID,
+			CartID:    c.ID,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		}
+		if err := s.storage.CreateItem(ctx, moved); err != nil {
+			return err
+		}
+		return s.recordEvent(ctx, cart.EventItemAdded, c, moved)
+	case err != nil:
+		return err
+	}
+
+	if err := rule.Merge(existing, item); err != nil {
+		return err
+	}
+
+	if err := s.storage.UpdateItem(ctx, existing); err != nil {
+		return err
+	}
+	return s.recordEvent(ctx, cart.EventItemUpdated, c, existing)
+}
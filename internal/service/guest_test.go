@@ -0,0 +1,180 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/ctxutil"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/storage"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_ClaimCart(t *testing.T) {
+	guestCart := func() *cart.Cart {
+		return &cart.Cart{ID: 2, Status: cart.StatusOpen, Currency: "EUR"}
+	}
+	userCart := &cart.Cart{ID: 1, UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	// event checks the event the claim records
+	event := func(eventType cart.EventType, cartID int64) func(context.Context, *cart.Event) error {
+		return func(_ context.Context, e *cart.Event) error {
+			assert.Equal(t, eventType, e.Type)
+			assert.Equal(t, cartID, e.CartID)
+			assert.Equal(t, int64(1), e.UserID)
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name            string
+		rule            cart.MergeRule
+		expectedDetails *cart.Details
+		expectedError   error
+		adjust          func(db *service.MockStorage)
+	}{
+		{
+			name:          "unknown merge rule - ErrInvalidMergeRule",
+			rule:          "oldest",
+			expectedError: cart.ErrInvalidMergeRule,
+			adjust:        func(db *service.MockStorage) {},
+		},
+		{
+			name:          "guest cart does not exist - ErrCartNotFound",
+			rule:          cart.MergeSum,
+			expectedError: service.ErrCartNotFound,
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().GetCart(gomock.Any(), service.GuestUserID, int64(2)).Return(nil, storage.ErrRecordNotFound)
+			},
+		},
+		{
+			name:          "guest cart is not open - ErrCartNotOpen",
+			rule:          cart.MergeSum,
+			expectedError: service.ErrCartNotOpen,
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().GetCart(gomock.Any(), service.GuestUserID, int64(2)).
+					Return(&cart.Cart{ID: 2, Status: cart.StatusAbandoned}, nil)
+			},
+		},
+		{
+			name: "user has no open cart - the guest cart becomes the user's",
+			rule: cart.MergeSum,
+			expectedDetails: &cart.Details{
+				Cart:     &cart.Cart{ID: 2, UserID: 1, Status: cart.StatusOpen, Currency: "EUR"},
+				Items:    []*cart.Item{},
+				Subtotal: cart.NewMoney(0, "EUR"),
+			},
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().GetCart(gomock.Any(), service.GuestUserID, int64(2)).Return(guestCart(), nil)
+				db.EXPECT().GetOpenCartByUserID(gomock.Any(), int64(1)).Return(nil, storage.ErrRecordNotFound)
+				db.EXPECT().ClaimCart(gomock.Any(), &cart.Cart{ID: 2, UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).DoAndReturn(event(cart.EventCartClaimed, 2))
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(2)).
+					Return(&cart.Cart{ID: 2, UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), int64(2)).Return([]*cart.Item{}, nil)
+			},
+		},
+		{
+			name: "user has an open cart - the items are merged into it",
+			rule: cart.MergeSum,
+			expectedDetails: &cart.Details{
+				Cart: userCart,
+				Items: []*cart.Item{
+					{ID: 10, CartID: 1, ProductID: 1, Quantity: 3, Price: cart.NewMoney(300, "EUR")},
+					{ID: 12, CartID: 1, ProductID: 2, Quantity: 2, Price: cart.NewMoney(400, "EUR")},
+				},
+				ItemCount:     2,
+				TotalQuantity: 5,
+				Subtotal:      cart.NewMoney(700, "EUR"),
+			},
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().GetCart(gomock.Any(), service.GuestUserID, int64(2)).Return(guestCart(), nil)
+				db.EXPECT().GetOpenCartByUserID(gomock.Any(), int64(1)).Return(userCart, nil)
+				db.EXPECT().ClaimCart(gomock.Any(), &cart.Cart{ID: 2, UserID: 1, Status: cart.StatusAbandoned, Currency: "EUR"}).Return(nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), int64(2)).Return([]*cart.Item{
+					{ID: 20, CartID: 2, ProductID: 1, Quantity: 1, Price: cart.NewMoney(100, "EUR")},
+					{ID: 21, CartID: 2, ProductID: 2, Quantity: 2, Price: cart.NewMoney(400, "EUR")},
+				}, nil)
+				db.EXPECT().FindItemByProductID(gomock.Any(), int64(1), int64(1)).
+					Return(&cart.Item{ID: 10, CartID: 1, ProductID: 1, Quantity: 2, Price: cart.NewMoney(200, "EUR")}, nil)
+				db.EXPECT().UpdateItem(gomock.Any(), &cart.Item{ID: 10, CartID: 1, ProductID: 1, Quantity: 3, Price: cart.NewMoney(300, "EUR")}).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).DoAndReturn(event(cart.EventItemUpdated, 1))
+				db.EXPECT().FindItemByProductID(gomock.Any(), int64(1), int64(2)).Return(nil, storage.ErrRecordNotFound)
+				db.EXPECT().CreateItem(gomock.Any(), &cart.Item{CartID: 1, ProductID: 2, Quantity: 2, Price: cart.NewMoney(400, "EUR")}).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).DoAndReturn(event(cart.EventItemAdded, 1))
+				db.EXPECT().RemoveItemsByCartID(gomock.Any(), int64(2)).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().IncrementCartVersion(gomock.Any(), gomock.Any()).Return(nil)
+				db.EXPECT().CreateEvent(gomock.Any(), gomock.Any()).DoAndReturn(event(cart.EventCartClaimed, 2))
+				db.EXPECT().GetCart(gomock.Any(), int64(1), int64(1)).Return(userCart, nil)
+				db.EXPECT().ListItemsByCartID(gomock.Any(), int64(1)).Return([]*cart.Item{
+					{ID: 10, CartID: 1, ProductID: 1, Quantity: 3, Price: cart.NewMoney(300, "EUR")},
+					{ID: 12, CartID: 1, ProductID: 2, Quantity: 2, Price: cart.NewMoney(400, "EUR")},
+				}, nil)
+			},
+		},
+		{
+			name:          "open cart of another currency - ErrCurrencyMismatch",
+			rule:          cart.MergeSum,
+			expectedError: cart.ErrCurrencyMismatch,
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().GetCart(gomock.Any(), service.GuestUserID, int64(2)).Return(guestCart(), nil)
+				db.EXPECT().GetOpenCartByUserID(gomock.Any(), int64(1)).
+					Return(&cart.Cart{ID: 1, UserID: 1, Status: cart.StatusOpen, Currency: "USD"}, nil)
+			},
+		},
+		{
+			name:          "cart is claimed by another user meanwhile - ErrCartNotFound",
+			rule:          cart.MergeSum,
+			expectedError: service.ErrCartNotFound,
+			adjust: func(db *service.MockStorage) {
+				db.EXPECT().GetCart(gomock.Any(), service.GuestUserID, int64(2)).Return(guestCart(), nil)
+				db.EXPECT().GetOpenCartByUserID(gomock.Any(), int64(1)).Return(userCart, nil)
+				db.EXPECT().ClaimCart(gomock.Any(), gomock.Any()).Return(storage.ErrConflict)
+			},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbMock := newMockStorage(ctrl)
+			test.adjust(dbMock)
+			svc, err := service.New(dbMock, nil)
+			assert.Nil(t, err)
+			details, err := svc.ClaimCart(context.TODO(), 1, 2, test.rule)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedDetails, details)
+		})
+	}
+}
+
+func TestService_GuestCartScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dbMock := newMockStorage(ctrl)
+	dbMock.EXPECT().GetCart(gomock.Any(), service.GuestUserID, int64(2)).
+		Return(&cart.Cart{ID: 2, Status: cart.StatusOpen, Currency: "EUR"}, nil)
+	dbMock.EXPECT().ListItemsByCartID(gomock.Any(), int64(2)).Return(nil, nil)
+
+	svc, err := service.New(dbMock, nil)
+	assert.Nil(t, err)
+
+	ctx := ctxutil.SetGuestCartID(context.TODO(), 2)
+
+	// a guest has access to the cart of its token
+	_, err = svc.CartDetails(ctx, service.GuestUserID, 2)
+	assert.Nil(t, err)
+
+	// but not to any other guest cart, and not to any cart without a token
+	_, err = svc.CartDetails(ctx, service.GuestUserID, 3)
+	assert.Equal(t, service.ErrCartNotFound, err)
+	_, err = svc.CartDetails(context.TODO(), service.GuestUserID, 2)
+	assert.Equal(t, service.ErrCartNotFound, err)
+}
@@ -0,0 +1,50 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/product"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/storage/memory"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_BusinessCounters(t *testing.T) {
+	ctx := context.TODO()
+	svc, err := service.New(memory.New(), product.New())
+	assert.Nil(t, err)
+
+	count := func(name string) float64 {
+		return tests.MetricValue(t, name, nil)
+	}
+	carts, items, checkouts := count("cart_carts_created_counter"), count("cart_items_added_counter"), count("cart_checkouts_counter")
+
+	c, err := svc.CreateCart(ctx, 1)
+	assert.Nil(t, err)
+	// the open cart of the user is not a new one
+	_, err = svc.CreateCart(ctx, 1)
+	assert.Nil(t, err)
+	_, err = svc.CreateCart(ctx, service.GuestUserID)
+	assert.Nil(t, err)
+	assert.Equal(t, carts+2, count("cart_carts_created_counter"))
+
+	_, err = svc.AddItem(ctx, 1, &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1}, false)
+	assert.Nil(t, err)
+	_, err = svc.AddItem(ctx, 1, &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1}, true)
+	assert.Nil(t, err)
+	// the refused items are not counted
+	_, err = svc.AddItem(ctx, 1, &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1}, false)
+	assert.Equal(t, service.ErrProductAlreadyInCart, err)
+	assert.Equal(t, items+2, count("cart_items_added_counter"))
+
+	_, err = svc.Checkout(ctx, 1, c.ID)
+	assert.Nil(t, err)
+	// the retries of the checkout are not counted
+	_, err = svc.Checkout(ctx, 1, c.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, checkouts+1, count("cart_checkouts_counter"))
+}
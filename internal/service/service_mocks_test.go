@@ -7,6 +7,7 @@ package service
 import (
 	context "context"
 	cart "github.com/cubny/cart"
+	product "github.com/cubny/cart/internal/product"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
 )
@@ -63,6 +64,63 @@ func (mr *MockStorageMockRecorder) GetCart(ctx, userID, cartID interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCart", reflect.TypeOf((*MockStorage)(nil).GetCart), ctx, userID, cartID)
 }
 
+// GetOpenCartByUserID mocks base method.
+func (m *MockStorage) GetOpenCartByUserID(ctx context.Context, userID int64) (*cart.Cart, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOpenCartByUserID", ctx, userID)
+	ret0, _ := ret[0].(*cart.Cart)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOpenCartByUserID indicates an expected call of GetOpenCartByUserID.
+func (mr *MockStorageMockRecorder) GetOpenCartByUserID(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOpenCartByUserID", reflect.TypeOf((*MockStorage)(nil).GetOpenCartByUserID), ctx, userID)
+}
+
+// UpdateCartStatus mocks base method.
+func (m *MockStorage) UpdateCartStatus(ctx context.Context, cart *cart.Cart) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateCartStatus", ctx, cart)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateCartStatus indicates an expected call of UpdateCartStatus.
+func (mr *MockStorageMockRecorder) UpdateCartStatus(ctx, cart interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCartStatus", reflect.TypeOf((*MockStorage)(nil).UpdateCartStatus), ctx, cart)
+}
+
+// ClaimCart mocks base method.
+func (m *MockStorage) ClaimCart(ctx context.Context, cart *cart.Cart) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimCart", ctx, cart)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClaimCart indicates an expected call of ClaimCart.
+func (mr *MockStorageMockRecorder) ClaimCart(ctx, cart interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimCart", reflect.TypeOf((*MockStorage)(nil).ClaimCart), ctx, cart)
+}
+
+// IncrementCartVersion mocks base method.
+func (m *MockStorage) IncrementCartVersion(ctx context.Context, cart *cart.Cart) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementCartVersion", ctx, cart)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IncrementCartVersion indicates an expected call of IncrementCartVersion.
+func (mr *MockStorageMockRecorder) IncrementCartVersion(ctx, cart interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementCartVersion", reflect.TypeOf((*MockStorage)(nil).IncrementCartVersion), ctx, cart)
+}
+
 // FindItemByProductID mocks base method.
 func (m *MockStorage) FindItemByProductID(ctx context.Context, cartID, productID int64) (*cart.Item, error) {
 	m.ctrl.T.Helper()
@@ -92,6 +150,20 @@ func (mr *MockStorageMockRecorder) CreateItem(ctx, item interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateItem", reflect.TypeOf((*MockStorage)(nil).CreateItem), ctx, item)
 }
 
+// MergeItem mocks base method.
+func (m *MockStorage) MergeItem(ctx context.Context, item *cart.Item) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergeItem", ctx, item)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MergeItem indicates an expected call of MergeItem.
+func (mr *MockStorageMockRecorder) MergeItem(ctx, item interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeItem", reflect.TypeOf((*MockStorage)(nil).MergeItem), ctx, item)
+}
+
 // GetItem mocks base method.
 func (m *MockStorage) GetItem(ctx context.Context, itemID int64) (*cart.Item, error) {
 	m.ctrl.T.Helper()
@@ -107,6 +179,35 @@ func (mr *MockStorageMockRecorder) GetItem(ctx, itemID interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetItem", reflect.TypeOf((*MockStorage)(nil).GetItem), ctx, itemID)
 }
 
+// ListItemsByCartID mocks base method.
+func (m *MockStorage) ListItemsByCartID(ctx context.Context, cartID int64) ([]*cart.Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListItemsByCartID", ctx, cartID)
+	ret0, _ := ret[0].([]*cart.Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListItemsByCartID indicates an expected call of ListItemsByCartID.
+func (mr *MockStorageMockRecorder) ListItemsByCartID(ctx, cartID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListItemsByCartID", reflect.TypeOf((*MockStorage)(nil).ListItemsByCartID), ctx, cartID)
+}
+
+// UpdateItem mocks base method.
+func (m *MockStorage) UpdateItem(ctx context.Context, item *cart.Item) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateItem", ctx, item)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateItem indicates an expected call of UpdateItem.
+func (mr *MockStorageMockRecorder) UpdateItem(ctx, item interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateItem", reflect.TypeOf((*MockStorage)(nil).UpdateItem), ctx, item)
+}
+
 // RemoveItem mocks base method.
 func (m *MockStorage) RemoveItem(ctx context.Context, itemID int64) error {
 	m.ctrl.T.Helper()
@@ -135,6 +236,78 @@ func (mr *MockStorageMockRecorder) RemoveItemsByCartID(ctx, cartID interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveItemsByCartID", reflect.TypeOf((*MockStorage)(nil).RemoveItemsByCartID), ctx, cartID)
 }
 
+// CheckoutCart mocks base method.
+func (m *MockStorage) CheckoutCart(ctx context.Context, order *cart.Order) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckoutCart", ctx, order)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckoutCart indicates an expected call of CheckoutCart.
+func (mr *MockStorageMockRecorder) CheckoutCart(ctx, order interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckoutCart", reflect.TypeOf((*MockStorage)(nil).CheckoutCart), ctx, order)
+}
+
+// GetOrder mocks base method.
+func (m *MockStorage) GetOrder(ctx context.Context, orderID int64) (*cart.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrder", ctx, orderID)
+	ret0, _ := ret[0].(*cart.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrder indicates an expected call of GetOrder.
+func (mr *MockStorageMockRecorder) GetOrder(ctx, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockStorage)(nil).GetOrder), ctx, orderID)
+}
+
+// GetOrderByCartID mocks base method.
+func (m *MockStorage) GetOrderByCartID(ctx context.Context, cartID int64) (*cart.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderByCartID", ctx, cartID)
+	ret0, _ := ret[0].(*cart.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderByCartID indicates an expected call of GetOrderByCartID.
+func (mr *MockStorageMockRecorder) GetOrderByCartID(ctx, cartID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderByCartID", reflect.TypeOf((*MockStorage)(nil).GetOrderByCartID), ctx, cartID)
+}
+
+// CreateEvent mocks base method.
+func (m *MockStorage) CreateEvent(ctx context.Context, event *cart.Event) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateEvent", ctx, event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateEvent indicates an expected call of CreateEvent.
+func (mr *MockStorageMockRecorder) CreateEvent(ctx, event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateEvent", reflect.TypeOf((*MockStorage)(nil).CreateEvent), ctx, event)
+}
+
+// WithTx mocks base method.
+func (m *MockStorage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithTx", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WithTx indicates an expected call of WithTx.
+func (mr *MockStorageMockRecorder) WithTx(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithTx", reflect.TypeOf((*MockStorage)(nil).WithTx), ctx, fn)
+}
+
 // Close mocks base method.
 func (m *MockStorage) Close() error {
 	m.ctrl.T.Helper()
@@ -148,3 +321,41 @@ func (mr *MockStorageMockRecorder) Close() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStorage)(nil).Close))
 }
+
+// MockProductProvider is a mock of ProductProvider interface.
+type MockProductProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockProductProviderMockRecorder
+}
+
+// MockProductProviderMockRecorder is the mock recorder for MockProductProvider.
+type MockProductProviderMockRecorder struct {
+	mock *MockProductProvider
+}
+
+// NewMockProductProvider creates a new mock instance.
+func NewMockProductProvider(ctrl *gomock.Controller) *MockProductProvider {
+	mock := &MockProductProvider{ctrl: ctrl}
+	mock.recorder = &MockProductProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockProductProvider) EXPECT() *MockProductProviderMockRecorder {
+	return m.recorder
+}
+
+// GetProduct mocks base method.
+func (m *MockProductProvider) GetProduct(ctx context.Context, productID int64) (*product.Product, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProduct", ctx, productID)
+	ret0, _ := ret[0].(*product.Product)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProduct indicates an expected call of GetProduct.
+func (mr *MockProductProviderMockRecorder) GetProduct(ctx, productID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProduct", reflect.TypeOf((*MockProductProvider)(nil).GetProduct), ctx, productID)
+}
@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/cubny/cart/internal/idempotency"
+	"github.com/cubny/cart/internal/storage"
+)
+
+// idempotencyKeyID is what the unique scope and key of the idempotency keys are
+// in the sql backends
+func idempotencyKeyID(scope, key string) string {
+	return scope + "\x00" + key
+}
+
+// CreateIdempotencyRecord records the request made with an Idempotency-Key
+// before it is handled, the expired keys are removed on the way. if the key is
+// already recorded storage.ErrDuplicate is returned
+func (m *Memory) CreateIdempotencyRecord(_ context.Context, r *idempotency.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, stored := range m.idempotencyKeys {
+		if !stored.ExpiresAt.After(now) {
+			delete(m.idempotencyKeys, id)
+		}
+	}
+
+	id := idempotencyKeyID(r.Scope, r.Key)
+	if _, ok := m.idempotencyKeys[id]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.CreatedAt = now
+	m.idempotencyKeys[id] = copyIdempotencyRecord(r)
+
+	return nil
+}
+
+// GetIdempotencyRecord returns the record of the key, the expired keys are
+// storage.ErrRecordNotFound
+func (m *Memory) GetIdempotencyRecord(_ context.Context, scope, key string) (*idempotency.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.idempotencyKeys[idempotencyKeyID(scope, key)]
+	if !ok || !r.ExpiresAt.After(time.Now()) {
+		return nil, storage.ErrRecordNotFound
+	}
+
+	return copyIdempotencyRecord(r), nil
+}
+
+// UpdateIdempotencyRecord records the response to the request and when it expires
+func (m *Memory) UpdateIdempotencyRecord(_ context.Context, r *idempotency.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.idempotencyKeys[idempotencyKeyID(r.Scope, r.Key)]
+	if !ok {
+		return storage.ErrRecordNotFound
+	}
+
+	updated := copyIdempotencyRecord(r)
+	stored.StatusCode = updated.StatusCode
+	stored.Header = updated.Header
+	stored.Body = updated.Body
+	stored.ExpiresAt = updated.ExpiresAt
+
+	return nil
+}
+
+// RemoveIdempotencyRecord removes the key, so that the request can be made again
+func (m *Memory) RemoveIdempotencyRecord(_ context.Context, scope, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotencyKeys, idempotencyKeyID(scope, key))
+	return nil
+}
+
+// copyIdempotencyRecord copies the record along with its response
+func copyIdempotencyRecord(r *idempotency.Record) *idempotency.Record {
+	copied := *r
+	copied.Header = r.Header.Clone()
+	if r.Body != nil {
+		copied.Body = append([]byte{}, r.Body...)
+	}
+	return &copied
+}
@@ -0,0 +1,480 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/idempotency"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/webhook"
+)
+
+// errOpenCartExists is what the unique index of the open carts of a user is in
+// the sql backends
+var errOpenCartExists = errors.New("memory: user already has an open cart")
+
+// Memory keeps the data in memory, it is meant for tests and demos. it behaves
+// like the sqlite3 backend: the ids are auto incremented, the timestamps are set
+// on writes and the missing records are storage.ErrRecordNotFound. the records
+// are copied in and out, so the callers never share them with the storage
+type Memory struct {
+	// mu guards the tables, it is db except within WithTx, which holds db
+	// until the transaction ends
+	mu   sync.Locker
+	db   *sync.Mutex
+	inTx bool
+
+	*tables
+}
+
+type tables struct {
+	carts      map[int64]*cart.Cart
+	items      map[int64]*cart.Item
+	orders     map[int64]*cart.Order
+	orderLines map[int64]*cart.OrderLine
+	// idempotencyKeys are by idempotencyKeyID
+	idempotencyKeys map[string]*idempotency.Record
+	// outbox is by the ids of the events
+	outbox map[int64]*outbox.Message
+	// subscriptions and deliveries are of the webhooks
+	subscriptions map[int64]*webhook.Subscription
+	deliveries    map[int64]*webhook.Delivery
+
+	lastCartID         int64
+	lastItemID         int64
+	lastOrderID        int64
+	lastOrderLineID    int64
+	lastEventID        int64
+	lastSubscriptionID int64
+	lastDeliveryID     int64
+}
+
+// New creates an empty storage
+func New() *Memory {
+	db := &sync.Mutex{}
+	m := &Memory{mu: db, db: db, tables: &tables{}}
+	m.reset()
+	return m
+}
+
+func (t *tables) reset() {
+	t.carts = map[int64]*cart.Cart{}
+	t.items = map[int64]*cart.Item{}
+	t.orders = map[int64]*cart.Order{}
+	t.orderLines = map[int64]*cart.OrderLine{}
+	t.idempotencyKeys = map[string]*idempotency.Record{}
+	t.outbox = map[int64]*outbox.Message{}
+	t.subscriptions = map[int64]*webhook.Subscription{}
+	t.deliveries = map[int64]*webhook.Delivery{}
+	t.lastCartID = 0
+	t.lastItemID = 0
+	t.lastOrderID = 0
+	t.lastOrderLineID = 0
+	t.lastEventID = 0
+	t.lastSubscriptionID = 0
+	t.lastDeliveryID = 0
+}
+
+// clone copies the tables and their records
+func (t *tables) clone() *tables {
+	c := *t
+	c.carts = make(map[int64]*cart.Cart, len(t.carts))
+	for id, r := range t.carts {
+		copied := *r
+		c.carts[id] = &copied
+	}
+	c.items = make(map[int64]*cart.Item, len(t.items))
+	for id, r := range t.items {
+		copied := *r
+		c.items[id] = &copied
+	}
+	c.orders = make(map[int64]*cart.Order, len(t.orders))
+	for id, r := range t.orders {
+		copied := *r
+		c.orders[id] = &copied
+	}
+	c.orderLines = make(map[int64]*cart.OrderLine, len(t.orderLines))
+	for id, r := range t.orderLines {
+		copied := *r
+		c.orderLines[id] = &copied
+	}
+	c.idempotencyKeys = make(map[string]*idempotency.Record, len(t.idempotencyKeys))
+	for id, r := range t.idempotencyKeys {
+		copied := *r
+		c.idempotencyKeys[id] = &copied
+	}
+	c.outbox = make(map[int64]*outbox.Message, len(t.outbox))
+	for id, m := range t.outbox {
+		c.outbox[id] = copyOutboxMessage(m)
+	}
+	c.subscriptions = make(map[int64]*webhook.Subscription, len(t.subscriptions))
+	for id, s := range t.subscriptions {
+		c.subscriptions[id] = copySubscription(s)
+	}
+	c.deliveries = make(map[int64]*webhook.Delivery, len(t.deliveries))
+	for id, d := range t.deliveries {
+		c.deliveries[id] = copyDelivery(d)
+	}
+
+	return &c
+}
+
+// WithTx runs fn with the storage locked, the tables are restored as they were
+// if fn returns an error. within a transaction fn simply joins it
+func (m *Memory) WithTx(_ context.Context, fn func(service.Storage) error) (err error) {
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.db.Lock()
+	defer m.db.Unlock()
+
+	saved := m.tables.clone()
+	defer func() {
+		if p := recover(); p != nil {
+			*m.tables = *saved
+			panic(p)
+		}
+		if err != nil {
+			*m.tables = *saved
+		}
+	}()
+
+	return fn(&Memory{mu: noLock{}, db: m.db, inTx: true, tables: m.tables})
+}
+
+// noLock is the lock of the storage within WithTx, which already holds it
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
+
+// Migrate does nothing, there is no schema in memory
+func (m *Memory) Migrate() error {
+	return nil
+}
+
+// TruncateAllTables removes all records, the ids start over from 1
+// it is meant to be used for integration tests
+func (m *Memory) TruncateAllTables() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reset()
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) CreateCart(_ context.Context, c *cart.Cart) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.hasOtherOpenCart(c) {
+		return errOpenCartExists
+	}
+
+	now := time.Now()
+	c.Version = 1
+	c.CreatedAt = now
+	c.UpdatedAt = now
+
+	m.lastCartID++
+	c.ID = m.lastCartID
+
+	stored := *c
+	m.carts[c.ID] = &stored
+
+	return nil
+}
+
+func (m *Memory) GetCart(_ context.Context, userID, cartID int64) (*cart.Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.carts[cartID]
+	if !ok || c.UserID != userID {
+		return nil, storage.ErrRecordNotFound
+	}
+
+	found := *c
+	return &found, nil
+}
+
+func (m *Memory) GetOpenCartByUserID(_ context.Context, userID int64) (*cart.Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var open *cart.Cart
+	for _, c := range m.carts {
+		if c.UserID != userID || c.IsGuest() || c.Status != cart.StatusOpen {
+			continue
+		}
+		if open == nil || c.ID > open.ID {
+			open = c
+		}
+	}
+	if open == nil {
+		return nil, storage.ErrRecordNotFound
+	}
+
+	found := *open
+	return &found, nil
+}
+
+func (m *Memory) UpdateCartStatus(_ context.Context, c *cart.Cart) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.carts[c.ID]
+	if !ok {
+		return storage.ErrRecordNotFound
+	}
+
+	updated := *stored
+	updated.Status = c.Status
+	if m.hasOtherOpenCart(&updated) {
+		return errOpenCartExists
+	}
+
+	c.UpdatedAt = time.Now()
+	stored.Status = c.Status
+	stored.UpdatedAt = c.UpdatedAt
+
+	return nil
+}
+
+// ClaimCart gives the guest cart to its user, the status of the cart is written
+// too. it returns storage.ErrConflict if the cart is no longer a guest cart
+func (m *Memory) ClaimCart(_ context.Context, c *cart.Cart) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.carts[c.ID]
+	if !ok || !stored.IsGuest() {
+		return storage.ErrConflict
+	}
+
+	updated := *stored
+	updated.UserID = c.UserID
+	updated.Status = c.Status
+	if m.hasOtherOpenCart(&updated) {
+		return errOpenCartExists
+	}
+
+	c.UpdatedAt = time.Now()
+	stored.UserID = c.UserID
+	stored.Status = c.Status
+	stored.UpdatedAt = c.UpdatedAt
+
+	return nil
+}
+
+// IncrementCartVersion increments the version of the cart, the cart gets the
+// new version
+func (m *Memory) IncrementCartVersion(_ context.Context, c *cart.Cart) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.carts[c.ID]
+	if !ok {
+		return storage.ErrRecordNotFound
+	}
+
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	c.Version = stored.Version
+	c.UpdatedAt = stored.UpdatedAt
+
+	return nil
+}
+
+// hasOtherOpenCart reports whether the cart is open and its user has another
+// open cart, which the unique index of the sql backends refuses
+func (m *Memory) hasOtherOpenCart(c *cart.Cart) bool {
+	if c.IsGuest() || c.Status != cart.StatusOpen {
+		return false
+	}
+
+	for _, other := range m.carts {
+		if other.ID != c.ID && other.UserID == c.UserID && other.Status == cart.StatusOpen {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Memory) FindItemByProductID(_ context.Context, cartID, productID int64) (*cart.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, item := range m.sortedItems() {
+		if item.CartID == cartID && item.ProductID == productID {
+			found := *item
+			return &found, nil
+		}
+	}
+
+	return nil, storage.ErrRecordNotFound
+}
+
+// CreateItem adds the item to its cart, it returns storage.ErrDuplicate if the
+// cart already has the product
+func (m *Memory) CreateItem(_ context.Context, item *cart.Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.hasProduct(item.CartID, item.ProductID) {
+		return storage.ErrDuplicate
+	}
+
+	now := time.Now()
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	m.insertItem(item)
+
+	return nil
+}
+
+func (m *Memory) insertItem(item *cart.Item) {
+	m.lastItemID++
+	item.ID = m.lastItemID
+
+	stored := *item
+	m.items[item.ID] = &stored
+}
+
+// hasProduct reports whether the cart has an item of the product, which the
+// unique index of the items of a cart in the sql backends refuses to add again
+func (m *Memory) hasProduct(cartID, productID int64) bool {
+	for _, item := range m.items {
+		if item.CartID == cartID && item.ProductID == productID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MergeItem adds the quantity and the price of the item to the item of the same
+// product in the cart, or creates the item if the cart does not have the product
+// in the currency of the item
+func (m *Memory) MergeItem(_ context.Context, item *cart.Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	var merged *cart.Item
+	for _, stored := range m.sortedItems() {
+		if stored.CartID != item.CartID || stored.ProductID != item.ProductID || stored.Price.Currency != item.Price.Currency {
+			continue
+		}
+		stored.Quantity += item.Quantity
+		stored.Price.Amount += item.Price.Amount
+		stored.UpdatedAt = now
+		if merged == nil {
+			merged = stored
+		}
+	}
+
+	if merged == nil {
+		if m.hasProduct(item.CartID, item.ProductID) {
+			return storage.ErrDuplicate
+		}
+
+		item.CreatedAt = now
+		item.UpdatedAt = now
+		m.insertItem(item)
+		return nil
+	}
+
+	*item = *merged
+	return nil
+}
+
+func (m *Memory) GetItem(_ context.Context, itemID int64) (*cart.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[itemID]
+	if !ok {
+		return nil, storage.ErrRecordNotFound
+	}
+
+	found := *item
+	return &found, nil
+}
+
+func (m *Memory) ListItemsByCartID(_ context.Context, cartID int64) ([]*cart.Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []*cart.Item{}
+	for _, item := range m.sortedItems() {
+		if item.CartID == cartID {
+			found := *item
+			items = append(items, &found)
+		}
+	}
+
+	return items, nil
+}
+
+func (m *Memory) UpdateItem(_ context.Context, item *cart.Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.items[item.ID]
+	if !ok {
+		return storage.ErrRecordNotFound
+	}
+
+	item.UpdatedAt = time.Now()
+	stored.Quantity = item.Quantity
+	stored.Price.Amount = item.Price.Amount
+	stored.UpdatedAt = item.UpdatedAt
+
+	return nil
+}
+
+func (m *Memory) RemoveItem(_ context.Context, itemID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.items, itemID)
+	return nil
+}
+
+func (m *Memory) RemoveItemsByCartID(_ context.Context, cartID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, item := range m.items {
+		if item.CartID == cartID {
+			delete(m.items, id)
+		}
+	}
+
+	return nil
+}
+
+// sortedItems returns the stored items in the order of their ids
+func (m *Memory) sortedItems() []*cart.Item {
+	items := make([]*cart.Item, 0, len(m.items))
+	for _, item := range m.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	return items
+}
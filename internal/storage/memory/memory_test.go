@@ -0,0 +1,14 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/cubny/cart/internal/storage/memory"
+	"github.com/cubny/cart/internal/storage/storagetest"
+)
+
+func TestMemory(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return memory.New()
+	})
+}
@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/storage"
+)
+
+// errOrderExists is what the unique cart_id of the orders is in the sql backends
+var errOrderExists = errors.New("memory: cart already has an order")
+
+// CheckoutCart locks the cart by moving it out of the open state and writes
+// the order snapshot at once. if the cart is no longer open nothing is written
+// and storage.ErrConflict is returned
+func (m *Memory) CheckoutCart(_ context.Context, order *cart.Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.carts[order.CartID]
+	if !ok || c.Status != cart.StatusOpen {
+		return storage.ErrConflict
+	}
+	for _, o := range m.orders {
+		if o.CartID == order.CartID {
+			return errOrderExists
+		}
+	}
+
+	order.CheckedOutAt = time.Now()
+	c.Status = cart.StatusCheckedOut
+	c.UpdatedAt = order.CheckedOutAt
+
+	m.lastOrderID++
+	order.ID = m.lastOrderID
+
+	stored := *order
+	stored.Lines = nil
+	m.orders[order.ID] = &stored
+
+	for _, line := range order.Lines {
+		line.OrderID = order.ID
+
+		m.lastOrderLineID++
+		line.ID = m.lastOrderLineID
+
+		storedLine := *line
+		m.orderLines[line.ID] = &storedLine
+	}
+
+	return nil
+}
+
+func (m *Memory) GetOrder(_ context.Context, orderID int64) (*cart.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[orderID]
+	if !ok {
+		return nil, storage.ErrRecordNotFound
+	}
+
+	return m.orderWithLines(o), nil
+}
+
+func (m *Memory) GetOrderByCartID(_ context.Context, cartID int64) (*cart.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, o := range m.orders {
+		if o.CartID == cartID {
+			return m.orderWithLines(o), nil
+		}
+	}
+
+	return nil, storage.ErrRecordNotFound
+}
+
+// orderWithLines returns a copy of the order with its lines in the order of
+// their ids
+func (m *Memory) orderWithLines(o *cart.Order) *cart.Order {
+	found := *o
+	found.Currency = found.Total.Currency
+
+	found.Lines = []*cart.OrderLine{}
+	for _, line := range m.orderLines {
+		if line.OrderID == o.ID {
+			l := *line
+			found.Lines = append(found.Lines, &l)
+		}
+	}
+	sort.Slice(found.Lines, func(i, j int) bool { return found.Lines[i].ID < found.Lines[j].ID })
+
+	return &found
+}
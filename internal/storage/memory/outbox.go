@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/storage"
+)
+
+// CreateEvent writes the event to the outbox, it is due right away
+func (m *Memory) CreateEvent(_ context.Context, event *cart.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastEventID++
+	event.ID = m.lastEventID
+
+	m.outbox[event.ID] = copyOutboxMessage(&outbox.Message{
+		Event:         event,
+		Status:        outbox.StatusPending,
+		NextAttemptAt: event.OccurredAt,
+		UpdatedAt:     time.Now(),
+	})
+
+	return nil
+}
+
+func (m *Memory) ClaimDueOutboxMessages(_ context.Context, limit int, lease time.Duration) ([]*outbox.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := []*outbox.Message{}
+	for _, msg := range m.outbox {
+		if msg.Status == outbox.StatusPending {
+			pending = append(pending, msg)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Event.ID < pending[j].Event.ID })
+
+	// only the first pending message of a cart is due
+	now := time.Now()
+	seen := map[int64]bool{}
+	messages := []*outbox.Message{}
+	for _, msg := range pending {
+		first := !seen[msg.Event.CartID]
+		seen[msg.Event.CartID] = true
+		if !first || msg.NextAttemptAt.After(now) {
+			continue
+		}
+
+		msg.NextAttemptAt = now.Add(lease)
+		messages = append(messages, copyOutboxMessage(msg))
+		if len(messages) == limit {
+			break
+		}
+	}
+
+	return messages, nil
+}
+
+func (m *Memory) UpdateOutboxMessage(_ context.Context, msg *outbox.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.outbox[msg.Event.ID]
+	if !ok {
+		return storage.ErrRecordNotFound
+	}
+
+	msg.UpdatedAt = time.Now()
+	stored.Status = msg.Status
+	stored.Attempts = msg.Attempts
+	stored.NextAttemptAt = msg.NextAttemptAt
+	stored.LastError = msg.LastError
+	stored.UpdatedAt = msg.UpdatedAt
+
+	return nil
+}
+
+// copyOutboxMessage copies the message along with its event
+func copyOutboxMessage(msg *outbox.Message) *outbox.Message {
+	copied := *msg
+	event := *msg.Event
+	event.Data = append([]byte{}, msg.Event.Data...)
+	copied.Event = &event
+	return &copied
+}
@@ -0,0 +1,203 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/webhook"
+)
+
+func (m *Memory) CreateSubscription(_ context.Context, s *webhook.Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastSubscriptionID++
+	s.ID = m.lastSubscriptionID
+	s.CreatedAt = time.Now()
+	m.subscriptions[s.ID] = copySubscription(s)
+
+	return nil
+}
+
+func (m *Memory) GetSubscription(_ context.Context, tenant string, id int64) (*webhook.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subscriptions[id]
+	if !ok || s.Tenant != tenant {
+		return nil, storage.ErrRecordNotFound
+	}
+
+	return copySubscription(s), nil
+}
+
+func (m *Memory) ListSubscriptions(_ context.Context, tenant string) ([]*webhook.Subscription, error) {
+	return m.listSubscriptions(func(s *webhook.Subscription) bool { return s.Tenant == tenant }), nil
+}
+
+// ListSubscriptionsByEventType returns the subscriptions of all the tenants to the event type
+func (m *Memory) ListSubscriptionsByEventType(_ context.Context, eventType cart.EventType) ([]*webhook.Subscription, error) {
+	return m.listSubscriptions(func(s *webhook.Subscription) bool { return s.Subscribed(eventType) }), nil
+}
+
+// listSubscriptions returns the subscriptions that match, in the order of their ids
+func (m *Memory) listSubscriptions(match func(s *webhook.Subscription) bool) []*webhook.Subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subs := []*webhook.Subscription{}
+	for _, s := range m.subscriptions {
+		if match(s) {
+			subs = append(subs, copySubscription(s))
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+
+	return subs
+}
+
+// RemoveSubscription removes the subscription along with its deliveries
+func (m *Memory) RemoveSubscription(_ context.Context, tenant string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subscriptions[id]
+	if !ok || s.Tenant != tenant {
+		return storage.ErrRecordNotFound
+	}
+
+	delete(m.subscriptions, id)
+	for deliveryID, d := range m.deliveries {
+		if d.SubscriptionID == id {
+			delete(m.deliveries, deliveryID)
+		}
+	}
+
+	return nil
+}
+
+// CreateDelivery returns storage.ErrDuplicate if the event is already delivered
+// to the subscription
+func (m *Memory) CreateDelivery(_ context.Context, d *webhook.Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.deliveries {
+		if stored.SubscriptionID == d.SubscriptionID && stored.Event.ID == d.Event.ID {
+			return storage.ErrDuplicate
+		}
+	}
+
+	now := time.Now()
+	d.CreatedAt = now
+	d.UpdatedAt = now
+
+	m.lastDeliveryID++
+	d.ID = m.lastDeliveryID
+	m.deliveries[d.ID] = copyDelivery(d)
+
+	return nil
+}
+
+func (m *Memory) GetDelivery(_ context.Context, subscriptionID, id int64) (*webhook.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[id]
+	if !ok || d.SubscriptionID != subscriptionID {
+		return nil, storage.ErrRecordNotFound
+	}
+
+	return copyDelivery(d), nil
+}
+
+// ListDeliveries returns the latest deliveries of the subscription, the newest first
+func (m *Memory) ListDeliveries(_ context.Context, subscriptionID int64, limit int) ([]*webhook.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deliveries := []*webhook.Delivery{}
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	for i, d := range deliveries {
+		deliveries[i] = copyDelivery(d)
+	}
+	return deliveries, nil
+}
+
+// ClaimDueDeliveries returns the pending deliveries that are due along with
+// their subscriptions, the oldest first, they are not due again until the lease
+// has passed
+func (m *Memory) ClaimDueDeliveries(_ context.Context, limit int, lease time.Duration) ([]*webhook.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	deliveries := []*webhook.Delivery{}
+	for _, d := range m.deliveries {
+		if d.Status == outbox.StatusPending && !d.NextAttemptAt.After(now) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	for i, d := range deliveries {
+		d.NextAttemptAt = now.Add(lease)
+		deliveries[i] = copyDelivery(d)
+		deliveries[i].Subscription = copySubscription(m.subscriptions[d.SubscriptionID])
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery writes the result of the last attempt of the delivery
+func (m *Memory) UpdateDelivery(_ context.Context, d *webhook.Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.deliveries[d.ID]
+	if !ok {
+		return storage.ErrRecordNotFound
+	}
+
+	d.UpdatedAt = time.Now()
+	stored.Status = d.Status
+	stored.Attempts = d.Attempts
+	stored.ResponseStatus = d.ResponseStatus
+	stored.LastError = d.LastError
+	stored.NextAttemptAt = d.NextAttemptAt
+	stored.UpdatedAt = d.UpdatedAt
+
+	return nil
+}
+
+// copySubscription copies the subscription along with its event types
+func copySubscription(s *webhook.Subscription) *webhook.Subscription {
+	copied := *s
+	copied.EventTypes = append([]cart.EventType{}, s.EventTypes...)
+	return &copied
+}
+
+// copyDelivery copies the delivery along with its event, not its subscription
+func copyDelivery(d *webhook.Delivery) *webhook.Delivery {
+	copied := *d
+	event := *d.Event
+	event.Data = append([]byte{}, d.Event.Data...)
+	copied.Event = &event
+	copied.Subscription = nil
+	return &copied
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cubny/cart/internal/tracing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "cart",
+			Name:      "storage_query_duration_seconds",
+			Help:      "Duration of the methods of the storage by backend and method",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(queryDuration)
+}
+
+// Observe starts the span of the method of the backend, it returns the context
+// of the span and the func that ends it and records the duration of the method.
+// it is meant to be called as the method begins, e.g.
+// ctx, done := storage.Observe(ctx, "sqlite3", "GetCart")
+// defer done()
+func Observe(ctx context.Context, backend, method string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, backend+"."+method,
+		attribute.String("db.system.name", backend),
+		attribute.String("db.operation.name", method),
+	)
+
+	return ctx, func() {
+		queryDuration.With(prometheus.Labels{"backend": backend, "method": method}).Observe(time.Since(start).Seconds())
+		span.End()
+	}
+}
+
+// Pool is a storage that runs on a pool of database connections
+type Pool interface {
+	Stats() sql.DBStats
+}
+
+// poolCollector exports the stats of the connection pool of a backend
+type poolCollector struct {
+	pool Pool
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+// NewPoolCollector returns the collector of the stats of the connection pool,
+// it is registered once per pool, e.g.
+// prometheus.MustRegister(storage.NewPoolCollector("sqlite3", db))
+func NewPoolCollector(backend string, pool Pool) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("cart", "db", name), help, nil, prometheus.Labels{"backend": backend})
+	}
+
+	return &poolCollector{
+		pool:              pool,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database"),
+		open:              desc("open_connections", "Number of established connections, both in use and idle"),
+		inUse:             desc("in_use_connections", "Number of connections currently in use"),
+		idle:              desc("idle_connections", "Number of idle connections"),
+		waitCount:         desc("wait_count_total", "Number of connections waited for"),
+		waitDuration:      desc("wait_duration_seconds_total", "Time blocked waiting for a new connection"),
+		maxIdleClosed:     desc("max_idle_closed_total", "Number of connections closed due to the maximum of idle connections"),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Number of connections closed due to the maximum lifetime of a connection"),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxLifetimeClosed
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
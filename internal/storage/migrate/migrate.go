@@ -0,0 +1,296 @@
+// Package migrate runs the versioned migrations of the sql storage backends.
+// the versions applied are recorded in the schema_migrations table and every
+// migration runs in a transaction of its own, together with its record, so a
+// failed migration leaves the database as it was
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrSchemaBehind   = errors.New("database schema is behind, run the pending migrations with -migrate up")
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// Migration changes the schema from the previous version to its version with
+// Up, and back with Down
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is the state of a migration in the database
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Dialect is what differs between the databases for the migrator
+type Dialect struct {
+	// Placeholder is the bind parameter of the nth argument of a query
+	Placeholder func(n int) string
+	// TimestampType is the column type of the applied_at timestamps
+	TimestampType string
+}
+
+var (
+	SQLite = Dialect{
+		Placeholder:   func(int) string { return "?" },
+		TimestampType: "datetime",
+	}
+	Postgres = Dialect{
+		Placeholder:   func(n int) string { return fmt.Sprintf("$%d", n) },
+		TimestampType: "timestamptz",
+	}
+)
+
+// Migrator applies and reverts the migrations of a database
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// New creates a migrator of the migrations, the versions must be unique
+func New(db *sql.DB, dialect Dialect, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	return &Migrator{db: db, dialect: dialect, migrations: sorted}
+}
+
+// Latest is the version of the last migration
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version is the last version applied to the database, 0 if none is
+func (m *Migrator) Version() (int64, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+// Status reports which migrations are applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		at, ok := applied[mig.Version]
+		statuses = append(statuses, Status{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+
+	return statuses, nil
+}
+
+// Check returns ErrSchemaBehind if any migration is not applied
+func (m *Migrator) Check() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			return ErrSchemaBehind
+		}
+	}
+
+	return nil
+}
+
+// Up applies all the migrations that are not applied
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down reverts the last migration applied, it does nothing if none is
+func (m *Migrator) Down() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.migrations[i].Version]; ok {
+			return m.down(m.migrations[i])
+		}
+	}
+
+	return nil
+}
+
+// To migrates the database to the version, the migrations after it are
+// reverted and the ones up to it are applied. version 0 reverts all of them
+func (m *Migrator) To(version int64) error {
+	if version != 0 && !m.known(version) {
+		return ErrUnknownVersion
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; ok && mig.Version > version {
+			if err := m.down(mig); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok && mig.Version <= version {
+			if err := m.up(mig); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Baseline records the migrations up to the version as applied without running
+// them, it is meant for the databases migrated before the versions were recorded
+func (m *Migrator) Baseline(version int64) error {
+	if !m.known(version) {
+		return ErrUnknownVersion
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok || mig.Version > version {
+			continue
+		}
+		if _, err := m.db.Exec(m.insertQuery(), mig.Version, time.Now()); err != nil {
+			return fmt.Errorf("baseline failed at version %d, %s", mig.Version, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) up(mig Migration) error {
+	return m.inTx(mig, "up", mig.Up, func(tx *sql.Tx) error {
+		_, err := tx.Exec(m.insertQuery(), mig.Version, time.Now())
+		return err
+	})
+}
+
+func (m *Migrator) down(mig Migration) error {
+	return m.inTx(mig, "down", mig.Down, func(tx *sql.Tx) error {
+		_, err := tx.Exec(fmt.Sprintf("DELETE FROM schema_migrations WHERE version = %s", m.dialect.Placeholder(1)), mig.Version)
+		return err
+	})
+}
+
+// inTx runs the statements of the migration and records it in one transaction
+func (m *Migrator) inTx(mig Migration, direction, statements string, record func(tx *sql.Tx) error) (err error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			err = fmt.Errorf("migration %d %s %s failed, %s", mig.Version, mig.Name, direction, err)
+		}
+	}()
+
+	if statements != "" {
+		if _, err = tx.Exec(statements); err != nil {
+			return err
+		}
+	}
+	if err = record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) insertQuery() string {
+	return fmt.Sprintf("INSERT INTO schema_migrations (version, applied_at) VALUES (%s, %s)",
+		m.dialect.Placeholder(1), m.dialect.Placeholder(2))
+}
+
+// applied returns the versions applied and when, the schema_migrations table is
+// created when it does not exist yet
+func (m *Migrator) applied() (map[int64]time.Time, error) {
+	_, err := m.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS schema_migrations (
+  version bigint PRIMARY KEY NOT NULL,
+  applied_at %s NOT NULL
+)`, m.dialect.TimestampType))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("schema_migrations scan error, %s", err)
+		}
+		applied[version] = at
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) known(version int64) bool {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
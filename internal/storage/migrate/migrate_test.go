@@ -0,0 +1,138 @@
+package migrate_test
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cubny/cart/internal/storage/migrate"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+var migrations = []migrate.Migration{
+	{Version: 1, Name: "create a", Up: `CREATE TABLE a (id integer);`, Down: `DROP TABLE a;`},
+	{Version: 2, Name: "create b", Up: `CREATE TABLE b (id integer); CREATE INDEX b_id ON b (id);`, Down: `DROP TABLE b;`},
+	{Version: 3, Name: "create c", Up: `CREATE TABLE c (id integer);`, Down: `DROP TABLE c;`},
+}
+
+func newDB(t *testing.T) (*sql.DB, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func tables(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('a', 'b', 'c') ORDER BY name`)
+	assert.Nil(t, err)
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		assert.Nil(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestMigrator(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	m := migrate.New(db, migrate.SQLite, migrations)
+	assert.Equal(t, int64(3), m.Latest())
+	assert.Equal(t, migrate.ErrSchemaBehind, m.Check())
+
+	// up applies all, twice is fine
+	assert.Nil(t, m.Up())
+	assert.Nil(t, m.Up())
+	assert.Nil(t, m.Check())
+	assert.Equal(t, []string{"a", "b", "c"}, tables(t, db))
+
+	statuses, err := m.Status()
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 3)
+	for _, st := range statuses {
+		assert.True(t, st.Applied)
+		assert.False(t, st.AppliedAt.IsZero())
+	}
+
+	// down reverts the last one only
+	assert.Nil(t, m.Down())
+	assert.Equal(t, []string{"a", "b"}, tables(t, db))
+	version, err := m.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), version)
+	assert.Equal(t, migrate.ErrSchemaBehind, m.Check())
+
+	// to goes both ways
+	assert.Nil(t, m.To(1))
+	assert.Equal(t, []string{"a"}, tables(t, db))
+	assert.Nil(t, m.To(3))
+	assert.Equal(t, []string{"a", "b", "c"}, tables(t, db))
+	assert.Nil(t, m.To(0))
+	assert.Equal(t, []string{}, tables(t, db))
+	assert.Equal(t, migrate.ErrUnknownVersion, m.To(4))
+
+	// down of an empty schema does nothing
+	assert.Nil(t, m.Down())
+	version, err = m.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), version)
+}
+
+func TestMigrator_FailedMigration(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	broken := append([]migrate.Migration{}, migrations[:2]...)
+	broken = append(broken, migrate.Migration{
+		Version: 3,
+		Name:    "broken",
+		Up:      `CREATE TABLE c (id integer); CREATE TABLE a (id integer);`,
+		Down:    `DROP TABLE c;`,
+	})
+
+	m := migrate.New(db, migrate.SQLite, broken)
+	assert.NotNil(t, m.Up())
+
+	// the migrations before it are kept, the failed one is rolled back as a whole
+	assert.Equal(t, []string{"a", "b"}, tables(t, db))
+	version, err := m.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), version)
+}
+
+func TestMigrator_Baseline(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	// the first two were applied before the versions were recorded
+	_, err := db.Exec(migrations[0].Up + migrations[1].Up)
+	assert.Nil(t, err)
+
+	m := migrate.New(db, migrate.SQLite, migrations)
+	assert.Nil(t, m.Baseline(2))
+	assert.Nil(t, m.Up())
+	assert.Nil(t, m.Check())
+	assert.Equal(t, []string{"a", "b", "c"}, tables(t, db))
+	assert.Equal(t, migrate.ErrUnknownVersion, m.Baseline(5))
+}
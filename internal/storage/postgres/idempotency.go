@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cubny/cart/internal/idempotency"
+	"github.com/cubny/cart/internal/storage"
+)
+
+// CreateIdempotencyRecord records the request made with an Idempotency-Key
+// before it is handled, the expired keys are removed on the way. if the key is
+// already recorded storage.ErrDuplicate is returned
+func (s *Postgres) CreateIdempotencyRecord(ctx context.Context, r *idempotency.Record) error {
+	ctx, done := storage.Observe(ctx, "postgres", "CreateIdempotencyRecord")
+	defer done()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		if _, err := tx.ExecContext(ctx, queryRemoveExpiredIdempotencyKeys, now); err != nil {
+			return err
+		}
+
+		r.CreatedAt = now
+		_, err := tx.ExecContext(ctx, queryInsertIdempotencyKey,
+			r.Scope,
+			r.Key,
+			r.RequestHash,
+			r.StatusCode,
+			r.CreatedAt,
+			r.ExpiresAt,
+		)
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+
+		return err
+	})
+}
+
+// GetIdempotencyRecord returns the record of the key, the expired keys are
+// storage.ErrRecordNotFound
+func (s *Postgres) GetIdempotencyRecord(ctx context.Context, scope, key string) (*idempotency.Record, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "GetIdempotencyRecord")
+	defer done()
+
+	var (
+		r      = &idempotency.Record{}
+		header sql.NullString
+	)
+
+	err := s.q.QueryRowContext(ctx, queryIdempotencyKeyByScopeAndKey, scope, key, time.Now()).Scan(
+		&r.Scope,
+		&r.Key,
+		&r.RequestHash,
+		&r.StatusCode,
+		&header,
+		&r.Body,
+		&r.CreatedAt,
+		&r.ExpiresAt,
+	)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, storage.ErrRecordNotFound
+	case err != nil:
+		return nil, fmt.Errorf("postgres: GetIdempotencyRecord result scan error, %s", err)
+	}
+	if header.Valid {
+		if err := json.Unmarshal([]byte(header.String), &r.Header); err != nil {
+			return nil, fmt.Errorf("postgres: GetIdempotencyRecord header decode error, %s", err)
+		}
+	}
+
+	return r, nil
+}
+
+// UpdateIdempotencyRecord records the response to the request and when it expires
+func (s *Postgres) UpdateIdempotencyRecord(ctx context.Context, r *idempotency.Record) error {
+	ctx, done := storage.Observe(ctx, "postgres", "UpdateIdempotencyRecord")
+	defer done()
+
+	header, err := json.Marshal(r.Header)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.q.ExecContext(ctx, queryUpdateIdempotencyKey,
+		r.StatusCode,
+		string(header),
+		r.Body,
+		r.ExpiresAt,
+		r.Scope,
+		r.Key,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RemoveIdempotencyRecord removes the key, so that the request can be made again
+func (s *Postgres) RemoveIdempotencyRecord(ctx context.Context, scope, key string) error {
+	ctx, done := storage.Observe(ctx, "postgres", "RemoveIdempotencyRecord")
+	defer done()
+
+	_, err := s.q.ExecContext(ctx, queryRemoveIdempotencyKey, scope, key)
+	return err
+}
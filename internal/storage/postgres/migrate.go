@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/cubny/cart/internal/storage/migrate"
+)
+
+// migrations of the schema, a migration is never changed once released, the
+// schema is changed by a new one
+var migrations = []migrate.Migration{
+	{Version: 1, Name: "create carts table", Up: migration01CreateCartsTable, Down: migration01Down},
+	{Version: 2, Name: "add carts user_id index", Up: migration02AddCartIndex, Down: migration02Down},
+	{Version: 3, Name: "add carts open status unique index", Up: migration03AddCartOpenStatusUniqueIndex, Down: migration03Down},
+	{Version: 4, Name: "create line_items table", Up: migration04CreateLineItemsTable, Down: migration04Down},
+	{Version: 5, Name: "add line_items cart_id index", Up: migration05AddLineItemsIndex, Down: migration05Down},
+	{Version: 6, Name: "add line_items product_id index", Up: migration06AddLineItemsIndex2, Down: migration06Down},
+	{Version: 7, Name: "create orders table", Up: migration07CreateOrdersTable, Down: migration07Down},
+	{Version: 8, Name: "create order_lines table", Up: migration08CreateOrderLinesTable, Down: migration08Down},
+	{Version: 9, Name: "add order_lines order_id index", Up: migration09AddOrderLinesIndex, Down: migration09Down},
+	{Version: 10, Name: "add line_items cart_id product_id unique index", Up: migration10AddLineItemsCartIDProductIDUniqueIndex, Down: migration10Down},
+	{Version: 11, Name: "add carts version", Up: migration11AddCartVersion, Down: migration11Down},
+	{Version: 12, Name: "create idempotency_keys table", Up: migration12CreateIdempotencyKeysTable, Down: migration12Down},
+	{Version: 13, Name: "create outbox table", Up: migration13CreateOutboxTable, Down: migration13Down},
+	{Version: 14, Name: "create webhook tables", Up: migration14CreateWebhookTables, Down: migration14Down},
+}
+
+// Migrator returns the migrator of the database
+func (s *Postgres) Migrator() (*migrate.Migrator, error) {
+	return migrate.New(s.db, migrate.Postgres, migrations), nil
+}
+
+// Migrate applies all the migrations that are not applied yet
+func (s *Postgres) Migrate() error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	return m.Up()
+}
+
+// TruncateAllTables truncates all tables
+// it is meant to be used for integration tests
+func (s *Postgres) TruncateAllTables() error {
+	if _, err := s.db.Exec(truncateAllTables); err != nil {
+		return fmt.Errorf("truncate failed, %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/storage"
+)
+
+// CheckoutCart locks the cart by moving it out of the open state and writes
+// the order snapshot in the same transaction. if the cart is no longer open
+// nothing is written and storage.ErrConflict is returned
+func (s *Postgres) CheckoutCart(ctx context.Context, order *cart.Order) error {
+	ctx, done := storage.Observe(ctx, "postgres", "CheckoutCart")
+	defer done()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		order.CheckedOutAt = time.Now()
+
+		res, err := tx.ExecContext(ctx, queryCheckoutCart, order.CheckedOutAt, order.CartID)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return storage.ErrConflict
+		}
+
+		err = tx.QueryRowContext(ctx, queryInsertOrder,
+			order.CartID,
+			order.UserID,
+			order.ItemCount,
+			order.TotalQuantity,
+			order.Total.Amount,
+			order.Total.Currency,
+			order.CheckedOutAt,
+		).Scan(&order.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range order.Lines {
+			line.OrderID = order.ID
+			err = tx.QueryRowContext(ctx, queryInsertOrderLine,
+				line.OrderID,
+				line.ProductID,
+				line.Quantity,
+				line.Price.Amount,
+				line.Price.Currency,
+			).Scan(&line.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Postgres) GetOrder(ctx context.Context, orderID int64) (*cart.Order, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "GetOrder")
+	defer done()
+
+	return s.getOrder(ctx, queryOrderByID, orderID)
+}
+
+func (s *Postgres) GetOrderByCartID(ctx context.Context, cartID int64) (*cart.Order, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "GetOrderByCartID")
+	defer done()
+
+	return s.getOrder(ctx, queryOrderByCartID, cartID)
+}
+
+func (s *Postgres) getOrder(ctx context.Context, query string, arg int64) (*cart.Order, error) {
+	o := &cart.Order{}
+
+	err := s.q.QueryRowContext(ctx, query, arg).Scan(
+		&o.ID,
+		&o.CartID,
+		&o.UserID,
+		&o.ItemCount,
+		&o.TotalQuantity,
+		&o.Total.Amount,
+		&o.Total.Currency,
+		&o.CheckedOutAt,
+	)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, storage.ErrRecordNotFound
+	case err != nil:
+		return nil, fmt.Errorf("postgres: getOrder result scan error, %s", err)
+	}
+	o.Currency = o.Total.Currency
+
+	rows, err := s.q.QueryContext(ctx, queryOrderLinesByOrderID, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	o.Lines = []*cart.OrderLine{}
+	for rows.Next() {
+		line := &cart.OrderLine{}
+		if err := rows.Scan(
+			&line.ID,
+			&line.OrderID,
+			&line.ProductID,
+			&line.Quantity,
+			&line.Price.Amount,
+			&line.Price.Currency,
+		); err != nil {
+			return nil, fmt.Errorf("postgres: getOrder lines scan error, %s", err)
+		}
+		o.Lines = append(o.Lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
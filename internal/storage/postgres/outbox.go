@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/storage"
+)
+
+// CreateEvent writes the event to the outbox, it is due right away
+func (s *Postgres) CreateEvent(ctx context.Context, event *cart.Event) error {
+	ctx, done := storage.Observe(ctx, "postgres", "CreateEvent")
+	defer done()
+
+	return s.q.QueryRowContext(ctx, queryInsertOutboxMessage,
+		event.Type,
+		event.CartID,
+		event.UserID,
+		string(event.Data),
+		event.OccurredAt,
+		outbox.StatusPending,
+		0,
+		event.OccurredAt,
+		time.Now(),
+	).Scan(&event.ID)
+}
+
+func (s *Postgres) ClaimDueOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*outbox.Message, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "ClaimDueOutboxMessages")
+	defer done()
+
+	now := time.Now()
+	rows, err := s.q.QueryContext(ctx, queryClaimDueOutboxMessages, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*outbox.Message{}
+	for rows.Next() {
+		var (
+			m    = &outbox.Message{Event: &cart.Event{}}
+			data string
+		)
+		if err := rows.Scan(
+			&m.Event.ID,
+			&m.Event.Type,
+			&m.Event.CartID,
+			&m.Event.UserID,
+			&data,
+			&m.Event.OccurredAt,
+			&m.Status,
+			&m.Attempts,
+			&m.NextAttemptAt,
+			&m.LastError,
+			&m.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("postgres: ClaimDueOutboxMessages scan error, %s", err)
+		}
+		m.Event.Data = []byte(data)
+		messages = append(messages, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the rows returned by an update are in no particular order
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Event.ID < messages[j].Event.ID })
+
+	return messages, nil
+}
+
+func (s *Postgres) UpdateOutboxMessage(ctx context.Context, m *outbox.Message) error {
+	ctx, done := storage.Observe(ctx, "postgres", "UpdateOutboxMessage")
+	defer done()
+
+	updatedAt := time.Now()
+
+	res, err := s.q.ExecContext(ctx, queryUpdateOutboxMessage,
+		m.Status,
+		m.Attempts,
+		m.NextAttemptAt,
+		m.LastError,
+		updatedAt,
+		m.Event.ID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrRecordNotFound
+	}
+	m.UpdatedAt = updatedAt
+
+	return nil
+}
@@ -0,0 +1,417 @@
+package postgres
+
+const queryInsertCart = `
+INSERT INTO carts(user_id, status, currency, created_at, updated_at) values ($1,$2,$3,$4,$5)
+RETURNING id
+`
+const queryCartsByIDAndUserID = `
+SELECT id, user_id, status, currency, version, created_at, updated_at FROM carts
+WHERE id = $1 AND COALESCE(user_id, 0) = $2
+`
+const queryCartsByUserIDAndStatus = `
+SELECT id, user_id, status, currency, version, created_at, updated_at FROM carts
+WHERE user_id = $1 AND status = $2
+ORDER BY id DESC LIMIT 1
+`
+const queryUpdateCartStatus = `
+UPDATE carts SET status = $1, updated_at = $2 WHERE id = $3
+`
+const queryClaimCart = `
+UPDATE carts SET user_id = $1, status = $2, updated_at = $3 WHERE id = $4 AND user_id IS NULL
+`
+const queryIncrementCartVersion = `
+UPDATE carts SET version = version + 1, updated_at = $1 WHERE id = $2
+RETURNING version
+`
+
+// lockForUpdate locks the rows read within a transaction until it ends
+const lockForUpdate = ` FOR UPDATE`
+const queryLockCart = `
+SELECT id FROM carts WHERE id = $1 FOR UPDATE
+`
+
+const queryInsertItem = `
+INSERT INTO line_items (cart_id, product_id, quantity, price, currency, created_at, updated_at)
+values ($1,$2,$3,$4,$5,$6,$7)
+RETURNING id
+`
+const queryItemsByCartIDAndProductID = `
+SELECT id, cart_id, product_id, quantity, price, currency, created_at, updated_at FROM line_items
+WHERE cart_id = $1 and product_id = $2
+`
+const queryItemByID = `
+SELECT id, cart_id, product_id, quantity, price, currency, created_at, updated_at FROM line_items
+WHERE id = $1
+`
+const queryItemsByCartID = `
+SELECT id, cart_id, product_id, quantity, price, currency, created_at, updated_at FROM line_items
+WHERE cart_id = $1 ORDER BY id
+`
+const queryUpdateItem = `
+UPDATE line_items SET quantity = $1, price = $2, updated_at = $3 WHERE id = $4
+`
+const queryMergeItem = `
+UPDATE line_items SET quantity = quantity + $1, price = price + $2, updated_at = $3
+WHERE cart_id = $4 AND product_id = $5 AND currency = $6
+RETURNING id, cart_id, product_id, quantity, price, currency, created_at, updated_at
+`
+const queryRemoveItem = `
+DELETE FROM line_items where id = $1
+`
+
+const queryRemoveItemsByCartID = `
+DELETE FROM line_items where cart_id = $1
+`
+
+const queryCheckoutCart = `
+UPDATE carts SET status = 'checked_out', updated_at = $1 WHERE id = $2 AND status = 'open'
+`
+const queryInsertOrder = `
+INSERT INTO orders (cart_id, user_id, item_count, total_quantity, total, currency, checked_out_at)
+values ($1,$2,$3,$4,$5,$6,$7)
+RETURNING id
+`
+const queryInsertOrderLine = `
+INSERT INTO order_lines (order_id, product_id, quantity, price, currency) values ($1,$2,$3,$4,$5)
+RETURNING id
+`
+const queryOrderByID = `
+SELECT id, cart_id, user_id, item_count, total_quantity, total, currency, checked_out_at FROM orders
+WHERE id = $1
+`
+const queryOrderByCartID = `
+SELECT id, cart_id, user_id, item_count, total_quantity, total, currency, checked_out_at FROM orders
+WHERE cart_id = $1
+`
+const queryOrderLinesByOrderID = `
+SELECT id, order_id, product_id, quantity, price, currency FROM order_lines
+WHERE order_id = $1 ORDER BY id
+`
+
+const queryRemoveExpiredIdempotencyKeys = `
+DELETE FROM idempotency_keys WHERE expires_at <= $1
+`
+const queryInsertIdempotencyKey = `
+INSERT INTO idempotency_keys (scope, idempotency_key, request_hash, status_code, created_at, expires_at)
+values ($1,$2,$3,$4,$5,$6)
+`
+const queryIdempotencyKeyByScopeAndKey = `
+SELECT scope, idempotency_key, request_hash, status_code, header, body, created_at, expires_at FROM idempotency_keys
+WHERE scope = $1 AND idempotency_key = $2 AND expires_at > $3
+`
+const queryUpdateIdempotencyKey = `
+UPDATE idempotency_keys SET status_code = $1, header = $2, body = $3, expires_at = $4
+WHERE scope = $5 AND idempotency_key = $6
+`
+const queryRemoveIdempotencyKey = `
+DELETE FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2
+`
+
+const queryInsertOutboxMessage = `
+INSERT INTO outbox (event_type, cart_id, user_id, data, occurred_at, status, attempts, next_attempt_at, updated_at)
+values ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+RETURNING id
+`
+
+// the due messages are claimed by moving their next attempt to the end of the
+// lease, the rows that another dispatcher is claiming meanwhile are skipped
+const queryClaimDueOutboxMessages = `
+UPDATE outbox SET next_attempt_at = $1
+WHERE next_attempt_at <= $2 AND id IN (
+SELECT id FROM outbox o
+WHERE status = 'pending' AND next_attempt_at <= $2
+AND NOT EXISTS (SELECT 1 FROM outbox e WHERE e.cart_id = o.cart_id AND e.status = 'pending' AND e.id < o.id)
+ORDER BY id LIMIT $3
+FOR UPDATE SKIP LOCKED
+)
+RETURNING id, event_type, cart_id, user_id, data, occurred_at, status, attempts, next_attempt_at, last_error, updated_at
+`
+const queryUpdateOutboxMessage = `
+UPDATE outbox SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, updated_at = $5 WHERE id = $6
+`
+
+// the event types of a subscription are comma separated, so that a type is
+// matched by LIKE '%,<type>,%' on the types wrapped in commas
+const queryInsertSubscription = `
+INSERT INTO webhook_subscriptions (tenant, url, event_types, secret, created_at) values ($1,$2,$3,$4,$5)
+RETURNING id
+`
+const querySubscriptionByTenantAndID = `
+SELECT id, tenant, url, event_types, secret, created_at FROM webhook_subscriptions WHERE tenant = $1 AND id = $2
+`
+const querySubscriptionsByTenant = `
+SELECT id, tenant, url, event_types, secret, created_at FROM webhook_subscriptions WHERE tenant = $1 ORDER BY id
+`
+const querySubscriptionsByEventType = `
+SELECT id, tenant, url, event_types, secret, created_at FROM webhook_subscriptions
+WHERE ',' || event_types || ',' LIKE $1 ORDER BY id
+`
+const queryRemoveSubscription = `
+DELETE FROM webhook_subscriptions WHERE tenant = $1 AND id = $2
+`
+const queryRemoveDeliveriesBySubscription = `
+DELETE FROM webhook_deliveries WHERE subscription_id IN (SELECT id FROM webhook_subscriptions WHERE tenant = $1 AND id = $2)
+`
+
+const queryInsertDelivery = `
+INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at, updated_at)
+values ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+RETURNING id
+`
+const queryDeliveryBySubscriptionIDAndID = `
+SELECT id, subscription_id, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, updated_at
+FROM webhook_deliveries WHERE subscription_id = $1 AND id = $2
+`
+const queryDeliveriesBySubscriptionID = `
+SELECT id, subscription_id, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, updated_at
+FROM webhook_deliveries WHERE subscription_id = $1 ORDER BY id DESC LIMIT $2
+`
+
+// the due deliveries are claimed by moving their next attempt to the end of the
+// lease, the rows that another dispatcher is claiming meanwhile are skipped
+const queryClaimDueDeliveries = `
+UPDATE webhook_deliveries d SET next_attempt_at = $1
+FROM webhook_subscriptions s
+WHERE s.id = d.subscription_id AND d.next_attempt_at <= $2 AND d.id IN (
+SELECT id FROM webhook_deliveries
+WHERE status = 'pending' AND next_attempt_at <= $2
+ORDER BY id LIMIT $3
+FOR UPDATE SKIP LOCKED
+)
+RETURNING d.id, d.subscription_id, d.payload, d.status, d.attempts, d.response_status, d.last_error, d.next_attempt_at, d.created_at, d.updated_at,
+s.tenant, s.url, s.event_types, s.secret, s.created_at
+`
+const queryUpdateDelivery = `
+UPDATE webhook_deliveries SET status = $1, attempts = $2, response_status = $3, last_error = $4, next_attempt_at = $5, updated_at = $6
+WHERE id = $7
+`
+
+// Migrations -----------------------
+
+// the schema is the one the sqlite3 migrations end up with, written in one go
+// since there is no postgres database of the earlier versions to migrate. the
+// first migrations can be run again, as the databases created before the
+// versions were recorded already have them
+
+const migration01CreateCartsTable = `
+CREATE TABLE IF NOT EXISTS carts (
+  id BIGSERIAL PRIMARY KEY,
+  user_id bigint,
+  status varchar(20) NOT NULL DEFAULT 'open',
+  currency varchar(3) NOT NULL DEFAULT 'EUR',
+  created_at timestamptz NOT NULL,
+  updated_at timestamptz NOT NULL
+);
+`
+
+const migration01Down = `
+DROP TABLE carts;
+`
+
+const migration02AddCartIndex = `
+CREATE INDEX IF NOT EXISTS index_cart_on_user_id ON carts (user_id);
+`
+
+const migration02Down = `
+DROP INDEX index_cart_on_user_id;
+`
+
+// a user can have only one open cart at a time, the open carts a user had
+// before are abandoned but the newest of them
+const migration03AddCartOpenStatusUniqueIndex = `
+UPDATE carts SET status = 'abandoned'
+WHERE status = 'open' AND user_id IS NOT NULL AND id <> (
+  SELECT newest.id FROM carts AS newest WHERE newest.user_id = carts.user_id AND newest.status = 'open'
+  ORDER BY newest.created_at DESC, newest.id DESC LIMIT 1
+);
+CREATE UNIQUE INDEX IF NOT EXISTS index_carts_on_user_id_open ON carts (user_id) WHERE status = 'open';
+`
+
+const migration03Down = `
+DROP INDEX index_carts_on_user_id_open;
+`
+
+// prices are integers in the minor units of their currency, e.g. cents
+const migration04CreateLineItemsTable = `
+CREATE TABLE IF NOT EXISTS line_items (
+  id BIGSERIAL PRIMARY KEY,
+  cart_id bigint REFERENCES carts (id),
+  product_id bigint,
+  quantity bigint DEFAULT 1,
+  price bigint NOT NULL DEFAULT 0,
+  currency varchar(3) NOT NULL DEFAULT 'EUR',
+  created_at timestamptz NOT NULL,
+  updated_at timestamptz NOT NULL
+);
+`
+
+const migration04Down = `
+DROP TABLE line_items;
+`
+
+const migration05AddLineItemsIndex = `
+CREATE INDEX IF NOT EXISTS index_line_items_on_cart_id ON line_items (cart_id);
+`
+
+const migration05Down = `
+DROP INDEX index_line_items_on_cart_id;
+`
+
+const migration06AddLineItemsIndex2 = `
+CREATE INDEX IF NOT EXISTS index_line_items_on_product_id ON line_items (product_id);
+`
+
+const migration06Down = `
+DROP INDEX index_line_items_on_product_id;
+`
+
+// an order is written once per cart, the unique cart_id makes checkout idempotent
+const migration07CreateOrdersTable = `
+CREATE TABLE IF NOT EXISTS orders (
+  id BIGSERIAL PRIMARY KEY,
+  cart_id bigint NOT NULL UNIQUE REFERENCES carts (id),
+  user_id bigint NOT NULL,
+  item_count bigint NOT NULL,
+  total_quantity bigint NOT NULL,
+  total bigint NOT NULL,
+  currency varchar(3) NOT NULL DEFAULT 'EUR',
+  checked_out_at timestamptz NOT NULL
+);
+`
+
+const migration07Down = `
+DROP TABLE orders;
+`
+
+const migration08CreateOrderLinesTable = `
+CREATE TABLE IF NOT EXISTS order_lines (
+  id BIGSERIAL PRIMARY KEY,
+  order_id bigint NOT NULL REFERENCES orders (id),
+  product_id bigint NOT NULL,
+  quantity bigint NOT NULL,
+  price bigint NOT NULL,
+  currency varchar(3) NOT NULL DEFAULT 'EUR'
+);
+`
+
+const migration08Down = `
+DROP TABLE order_lines;
+`
+
+const migration09AddOrderLinesIndex = `
+CREATE INDEX IF NOT EXISTS index_order_lines_on_order_id ON order_lines (order_id);
+`
+
+const migration09Down = `
+DROP INDEX index_order_lines_on_order_id;
+`
+
+// a product is added to a cart once, the items of the same product added before
+// are merged into the first of them
+const migration10AddLineItemsCartIDProductIDUniqueIndex = `
+UPDATE line_items SET
+  quantity = (SELECT SUM(d.quantity) FROM line_items d WHERE d.cart_id = line_items.cart_id AND d.product_id = line_items.product_id),
+  price = (SELECT SUM(d.price) FROM line_items d WHERE d.cart_id = line_items.cart_id AND d.product_id = line_items.product_id)
+WHERE id IN (SELECT MIN(id) FROM line_items GROUP BY cart_id, product_id HAVING COUNT(*) > 1);
+DELETE FROM line_items WHERE id NOT IN (SELECT MIN(id) FROM line_items GROUP BY cart_id, product_id);
+CREATE UNIQUE INDEX index_line_items_on_cart_id_product_id ON line_items (cart_id, product_id);
+`
+
+const migration10Down = `
+DROP INDEX index_line_items_on_cart_id_product_id;
+`
+
+// the version of a cart is incremented by every change of the cart or its items
+const migration11AddCartVersion = `
+ALTER TABLE carts ADD COLUMN version bigint NOT NULL DEFAULT 1;
+`
+
+const migration11Down = `
+ALTER TABLE carts DROP COLUMN version;
+`
+
+// the responses to the requests made with an Idempotency-Key, the key is the
+// client's own, so it is unique per scope, i.e. per user
+const migration12CreateIdempotencyKeysTable = `
+CREATE TABLE idempotency_keys (
+  id BIGSERIAL PRIMARY KEY,
+  scope varchar(64) NOT NULL,
+  idempotency_key varchar(255) NOT NULL,
+  request_hash varchar(64) NOT NULL,
+  status_code integer NOT NULL DEFAULT 0,
+  header text,
+  body bytea,
+  created_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL
+);
+CREATE UNIQUE INDEX index_idempotency_keys_on_scope_idempotency_key ON idempotency_keys (scope, idempotency_key);
+CREATE INDEX index_idempotency_keys_on_expires_at ON idempotency_keys (expires_at);
+`
+
+const migration12Down = `
+DROP TABLE idempotency_keys;
+`
+
+// the events of the cart changes, written in the transactions of the changes and
+// published by the dispatcher of the outbox
+const migration13CreateOutboxTable = `
+CREATE TABLE outbox (
+  id BIGSERIAL PRIMARY KEY,
+  event_type varchar(50) NOT NULL,
+  cart_id bigint NOT NULL,
+  user_id bigint NOT NULL DEFAULT 0,
+  data text NOT NULL,
+  occurred_at timestamptz NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  next_attempt_at timestamptz NOT NULL,
+  last_error text NOT NULL DEFAULT '',
+  updated_at timestamptz NOT NULL
+);
+CREATE INDEX index_outbox_on_status_next_attempt_at ON outbox (status, next_attempt_at);
+CREATE INDEX index_outbox_on_cart_id ON outbox (cart_id);
+`
+
+const migration13Down = `
+DROP TABLE outbox;
+`
+
+// the webhooks the tenants subscribe to and the deliveries of the events to
+// them, an event is delivered once per subscription
+const migration14CreateWebhookTables = `
+CREATE TABLE webhook_subscriptions (
+  id BIGSERIAL PRIMARY KEY,
+  tenant varchar(100) NOT NULL,
+  url text NOT NULL,
+  event_types text NOT NULL,
+  secret varchar(100) NOT NULL,
+  created_at timestamptz NOT NULL
+);
+CREATE INDEX index_webhook_subscriptions_on_tenant ON webhook_subscriptions (tenant);
+CREATE TABLE webhook_deliveries (
+  id BIGSERIAL PRIMARY KEY,
+  subscription_id bigint NOT NULL REFERENCES webhook_subscriptions (id),
+  event_id bigint NOT NULL,
+  event_type varchar(50) NOT NULL,
+  payload text NOT NULL,
+  status varchar(20) NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  response_status integer NOT NULL DEFAULT 0,
+  last_error text NOT NULL DEFAULT '',
+  next_attempt_at timestamptz NOT NULL,
+  created_at timestamptz NOT NULL,
+  updated_at timestamptz NOT NULL
+);
+CREATE UNIQUE INDEX index_webhook_deliveries_on_subscription_id_event_id ON webhook_deliveries (subscription_id, event_id);
+CREATE INDEX index_webhook_deliveries_on_status_next_attempt_at ON webhook_deliveries (status, next_attempt_at);
+`
+
+const migration14Down = `
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_subscriptions;
+`
+
+// the ids start over from 1 like they do in a new sqlite3 database
+const truncateAllTables = `
+TRUNCATE webhook_deliveries, webhook_subscriptions, outbox, idempotency_keys, order_lines, orders, line_items, carts RESTART IDENTITY;
+`
@@ -0,0 +1,36 @@
+package postgres_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/cubny/cart/internal/storage/postgres"
+	"github.com/cubny/cart/internal/storage/storagetest"
+)
+
+// TestPostgres runs against the postgres database of CART_TEST_POSTGRES_DSN,
+// it is skipped when no database is given. the tables of the database are
+// truncated
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("CART_TEST_POSTGRES_DSN")
+	if dsn == "" || testing.Short() {
+		t.Skip("CART_TEST_POSTGRES_DSN is not set")
+	}
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		db, err := postgres.New(dsn)
+		if err != nil {
+			t.Fatalf("cannot connect to postgres, %s", err)
+		}
+
+		// migrating a migrated database is fine
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.TruncateAllTables(); err != nil {
+			t.Fatal(err)
+		}
+
+		return db
+	})
+}
@@ -0,0 +1,335 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/webhook"
+)
+
+// rowScanner is a row of the result, *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (s *Postgres) CreateSubscription(ctx context.Context, sub *webhook.Subscription) error {
+	ctx, done := storage.Observe(ctx, "postgres", "CreateSubscription")
+	defer done()
+
+	sub.CreatedAt = time.Now()
+
+	return s.q.QueryRowContext(ctx, queryInsertSubscription,
+		sub.Tenant,
+		sub.URL,
+		joinEventTypes(sub.EventTypes),
+		sub.Secret,
+		sub.CreatedAt,
+	).Scan(&sub.ID)
+}
+
+func (s *Postgres) GetSubscription(ctx context.Context, tenant string, id int64) (*webhook.Subscription, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "GetSubscription")
+	defer done()
+
+	sub, err := scanSubscription(s.q.QueryRowContext(ctx, querySubscriptionByTenantAndID, tenant, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, storage.ErrRecordNotFound
+	case err != nil:
+		return nil, fmt.Errorf("postgres: GetSubscription result scan error, %s", err)
+	}
+
+	return sub, nil
+}
+
+func (s *Postgres) ListSubscriptions(ctx context.Context, tenant string) ([]*webhook.Subscription, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "ListSubscriptions")
+	defer done()
+
+	return s.listSubscriptions(ctx, querySubscriptionsByTenant, tenant)
+}
+
+// ListSubscriptionsByEventType returns the subscriptions of all the tenants to the event type
+func (s *Postgres) ListSubscriptionsByEventType(ctx context.Context, eventType cart.EventType) ([]*webhook.Subscription, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "ListSubscriptionsByEventType")
+	defer done()
+
+	return s.listSubscriptions(ctx, querySubscriptionsByEventType, "%,"+string(eventType)+",%")
+}
+
+func (s *Postgres) listSubscriptions(ctx context.Context, query string, args ...interface{}) ([]*webhook.Subscription, error) {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*webhook.Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: ListSubscriptions scan error, %s", err)
+		}
+		subs = append(subs, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// RemoveSubscription removes the subscription along with its deliveries
+func (s *Postgres) RemoveSubscription(ctx context.Context, tenant string, id int64) error {
+	ctx, done := storage.Observe(ctx, "postgres", "RemoveSubscription")
+	defer done()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, queryRemoveDeliveriesBySubscription, tenant, id); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, queryRemoveSubscription, tenant, id)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return storage.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// CreateDelivery returns storage.ErrDuplicate if the event is already delivered
+// to the subscription
+func (s *Postgres) CreateDelivery(ctx context.Context, d *webhook.Delivery) error {
+	ctx, done := storage.Observe(ctx, "postgres", "CreateDelivery")
+	defer done()
+
+	payload, err := json.Marshal(d.Event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	d.CreatedAt = now
+	d.UpdatedAt = now
+
+	err = s.q.QueryRowContext(ctx, queryInsertDelivery,
+		d.SubscriptionID,
+		d.Event.ID,
+		d.Event.Type,
+		string(payload),
+		d.Status,
+		d.Attempts,
+		d.NextAttemptAt,
+		d.CreatedAt,
+		d.UpdatedAt,
+	).Scan(&d.ID)
+	if isUniqueViolation(err) {
+		return storage.ErrDuplicate
+	}
+
+	return err
+}
+
+func (s *Postgres) GetDelivery(ctx context.Context, subscriptionID, id int64) (*webhook.Delivery, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "GetDelivery")
+	defer done()
+
+	d, err := scanDelivery(s.q.QueryRowContext(ctx, queryDeliveryBySubscriptionIDAndID, subscriptionID, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, storage.ErrRecordNotFound
+	case err != nil:
+		return nil, fmt.Errorf("postgres: GetDelivery result scan error, %s", err)
+	}
+
+	return d, nil
+}
+
+// ListDeliveries returns the latest deliveries of the subscription, the newest first
+func (s *Postgres) ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]*webhook.Delivery, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "ListDeliveries")
+	defer done()
+
+	rows, err := s.q.QueryContext(ctx, queryDeliveriesBySubscriptionID, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*webhook.Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: ListDeliveries scan error, %s", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// ClaimDueDeliveries returns the pending deliveries that are due along with
+// their subscriptions, the oldest first, they are not due again until the lease
+// has passed
+func (s *Postgres) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*webhook.Delivery, error) {
+	ctx, done := storage.Observe(ctx, "postgres", "ClaimDueDeliveries")
+	defer done()
+
+	now := time.Now()
+	rows, err := s.q.QueryContext(ctx, queryClaimDueDeliveries, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*webhook.Delivery{}
+	for rows.Next() {
+		var (
+			d          = &webhook.Delivery{Subscription: &webhook.Subscription{}}
+			payload    string
+			eventTypes string
+		)
+		if err := rows.Scan(
+			&d.ID,
+			&d.SubscriptionID,
+			&payload,
+			&d.Status,
+			&d.Attempts,
+			&d.ResponseStatus,
+			&d.LastError,
+			&d.NextAttemptAt,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&d.Subscription.Tenant,
+			&d.Subscription.URL,
+			&eventTypes,
+			&d.Subscription.Secret,
+			&d.Subscription.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("postgres: ClaimDueDeliveries scan error, %s", err)
+		}
+		if err := json.Unmarshal([]byte(payload), &d.Event); err != nil {
+			return nil, fmt.Errorf("postgres: ClaimDueDeliveries payload decode error, %s", err)
+		}
+		d.Subscription.ID = d.SubscriptionID
+		d.Subscription.EventTypes = splitEventTypes(eventTypes)
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the rows returned by an update are in no particular order
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+
+	return deliveries, nil
+}
+
+// UpdateDelivery writes the result of the last attempt of the delivery
+func (s *Postgres) UpdateDelivery(ctx context.Context, d *webhook.Delivery) error {
+	ctx, done := storage.Observe(ctx, "postgres", "UpdateDelivery")
+	defer done()
+
+	updatedAt := time.Now()
+
+	res, err := s.q.ExecContext(ctx, queryUpdateDelivery,
+		d.Status,
+		d.Attempts,
+		d.ResponseStatus,
+		d.LastError,
+		d.NextAttemptAt,
+		updatedAt,
+		d.ID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrRecordNotFound
+	}
+	d.UpdatedAt = updatedAt
+
+	return nil
+}
+
+func scanSubscription(row rowScanner) (*webhook.Subscription, error) {
+	var (
+		sub        = &webhook.Subscription{}
+		eventTypes string
+	)
+	if err := row.Scan(&sub.ID, &sub.Tenant, &sub.URL, &eventTypes, &sub.Secret, &sub.CreatedAt); err != nil {
+		return nil, err
+	}
+	sub.EventTypes = splitEventTypes(eventTypes)
+
+	return sub, nil
+}
+
+func scanDelivery(row rowScanner) (*webhook.Delivery, error) {
+	var (
+		d       = &webhook.Delivery{}
+		payload string
+	)
+	if err := row.Scan(
+		&d.ID,
+		&d.SubscriptionID,
+		&payload,
+		&d.Status,
+		&d.Attempts,
+		&d.ResponseStatus,
+		&d.LastError,
+		&d.NextAttemptAt,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(payload), &d.Event); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func joinEventTypes(eventTypes []cart.EventType) string {
+	types := make([]string, len(eventTypes))
+	for i, t := range eventTypes {
+		types[i] = string(t)
+	}
+	return strings.Join(types, ",")
+}
+
+func splitEventTypes(s string) []cart.EventType {
+	eventTypes := []cart.EventType{}
+	for _, t := range strings.Split(s, ",") {
+		if t != "" {
+			eventTypes = append(eventTypes, cart.EventType(t))
+		}
+	}
+	return eventTypes
+}
@@ -1,34 +1,120 @@
 package sqlite3
 
-import "fmt"
+import (
+	"fmt"
 
-// Migrate migrates the database
-// there are much better tools for performing migrations, but for
-// the purpose of the assignment, we keep things as simple as possible
-func (s *Sqlite3) Migrate() error {
-	migrations := []string{
-		migration01MigrationCreateCartsTable,
-		migration02AddCartIndex,
-		migration03CreateLineItemsTable,
-		migration04AddLineItemsIndex,
-		migration05AddLineItemsIndex2,
+	"github.com/cubny/cart/internal/storage/migrate"
+)
+
+// migrations of the schema, a migration is never changed once released, the
+// schema is changed by a new one
+var migrations = []migrate.Migration{
+	{Version: 1, Name: "create carts table", Up: migration01MigrationCreateCartsTable, Down: migration01Down},
+	{Version: 2, Name: "add carts user_id index", Up: migration02AddCartIndex, Down: migration02Down},
+	{Version: 3, Name: "create line_items table", Up: migration03CreateLineItemsTable, Down: migration03Down},
+	{Version: 4, Name: "add line_items cart_id index", Up: migration04AddLineItemsIndex, Down: migration04Down},
+	{Version: 5, Name: "add line_items product_id index", Up: migration05AddLineItemsIndex2, Down: migration05Down},
+	{Version: 6, Name: "add carts status", Up: migration06AddCartStatus, Down: migration06Down},
+	{Version: 7, Name: "add carts open status unique index", Up: migration07AddCartOpenStatusUniqueIndex, Down: migration07Down},
+	{Version: 8, Name: "create orders table", Up: migration08CreateOrdersTable, Down: migration08Down},
+	{Version: 9, Name: "create order_lines table", Up: migration09CreateOrderLinesTable, Down: migration09Down},
+	{Version: 10, Name: "add order_lines order_id index", Up: migration10AddOrderLinesIndex, Down: migration10Down},
+	{Version: 11, Name: "add carts currency", Up: migration11AddCartCurrency, Down: migration11Down},
+	{Version: 12, Name: "line_items price to minor units", Up: migration12LineItemsPriceToMinorUnits, Down: migration12Down},
+	{Version: 13, Name: "orders price to minor units", Up: migration13OrdersPriceToMinorUnits, Down: migration13Down},
+	{Version: 14, Name: "add line_items cart_id product_id unique index", Up: migration14AddLineItemsCartIDProductIDUniqueIndex, Down: migration14Down},
+	{Version: 15, Name: "add carts version", Up: migration15AddCartVersion, Down: migration15Down},
+	{Version: 16, Name: "create idempotency_keys table", Up: migration16CreateIdempotencyKeysTable, Down: migration16Down},
+	{Version: 17, Name: "create outbox table", Up: migration17CreateOutboxTable, Down: migration17Down},
+	{Version: 18, Name: "create webhook tables", Up: migration18CreateWebhookTables, Down: migration18Down},
+}
+
+// legacyProbes tell whether each migration was applied to a database migrated
+// before the versions were recorded, the nth probe is of version n
+var legacyProbes = []string{
+	`SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'carts'`,
+	`SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'index_cart_on_user_id'`,
+	`SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'line_items'`,
+	`SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'index_line_items_on_cart_id'`,
+	`SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'index_line_items_on_product_id'`,
+	`SELECT 1 FROM pragma_table_info('carts') WHERE name = 'status'`,
+	`SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'index_carts_on_user_id_open'`,
+	`SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'orders'`,
+	`SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'order_lines'`,
+	`SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'index_order_lines_on_order_id'`,
+	`SELECT 1 FROM pragma_table_info('carts') WHERE name = 'currency'`,
+	`SELECT 1 FROM pragma_table_info('line_items') WHERE name = 'currency'`,
+	`SELECT 1 FROM pragma_table_info('orders') WHERE name = 'currency'`,
+}
+
+// Migrator returns the migrator of the database. a database migrated before the
+// versions were recorded gets the versions of its schema recorded first
+func (s *Sqlite3) Migrator() (*migrate.Migrator, error) {
+	m := migrate.New(s.db, migrate.SQLite, migrations)
+
+	var recorded int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&recorded)
+	if err != nil {
+		return nil, err
+	}
+	if recorded > 0 {
+		return m, nil
 	}
 
-	for i, m := range migrations {
-		if _, err := s.db.Exec(m); err != nil {
-			return fmt.Errorf("migration failed at index of %d, %s", i, err)
+	version, err := s.legacyVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > 0 {
+		if err := m.Baseline(version); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	return m, nil
+}
+
+// legacyVersion is the version of the schema of a database migrated before the
+// versions were recorded, 0 for a new database
+func (s *Sqlite3) legacyVersion() (int64, error) {
+	var version int64
+	for i, probe := range legacyProbes {
+		var found int
+		err := s.db.QueryRow(`SELECT COUNT(*) FROM (` + probe + `)`).Scan(&found)
+		if err != nil {
+			return 0, fmt.Errorf("cannot probe the schema at version %d, %s", i+1, err)
+		}
+		if found == 0 {
+			break
+		}
+		version = int64(i + 1)
+	}
+
+	return version, nil
+}
+
+// Migrate applies all the migrations that are not applied yet
+func (s *Sqlite3) Migrate() error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	return m.Up()
 }
 
 // truncate truncates all tables
 // it is meant to be used for integration tests
 func (s *Sqlite3) TruncateAllTables() error {
 	truncates := []string{
-		truncateCartsTable,
+		truncateWebhookDeliveriesTable,
+		truncateWebhookSubscriptionsTable,
+		truncateOutboxTable,
+		truncateIdempotencyKeysTable,
+		truncateOrderLinesTable,
+		truncateOrdersTable,
 		truncateLineItemsTable,
+		truncateCartsTable,
 	}
 
 	for i, m := range truncates {
@@ -0,0 +1,183 @@
+package sqlite3_test
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/storage/sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDBFile(t *testing.T, dir string) *os.File {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(dir, "cart.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSqlite3_MigrateDownAndUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sqlite3.New(newDBFile(t, dir))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// migrating twice is fine
+	assert.Nil(t, db.Migrate())
+	assert.Nil(t, db.Migrate())
+
+	m, err := db.Migrator()
+	assert.Nil(t, err)
+	assert.Nil(t, m.Check())
+
+	// every migration can be reverted and applied again
+	assert.Nil(t, m.To(0))
+	version, err := m.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), version)
+	assert.Nil(t, m.Up())
+	assert.Nil(t, m.Check())
+}
+
+func TestSqlite3_MigrateLegacyDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newDBFile(t, dir)
+
+	// a database migrated up to the carts status before the versions were recorded
+	raw, err := sql.Open("sqlite3", f.Name())
+	assert.Nil(t, err)
+	_, err = raw.Exec(`
+CREATE TABLE "carts" ("id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, "user_id" integer, "created_at" datetime NOT NULL, "updated_at" datetime NOT NULL);
+CREATE INDEX "index_cart_on_user_id" ON "carts" ("user_id");
+CREATE TABLE "line_items" ("id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, "cart_id" integer, "product_id" integer, "quantity" integer DEFAULT 1, "price" decimal, "created_at" datetime NOT NULL, "updated_at" datetime NOT NULL);
+CREATE INDEX "index_line_items_on_cart_id" ON "line_items" ("cart_id");
+CREATE INDEX "index_line_items_on_product_id" ON "line_items" ("product_id");
+ALTER TABLE "carts" ADD COLUMN "status" varchar(20) NOT NULL DEFAULT 'open';
+INSERT INTO "line_items" (cart_id, product_id, quantity, price, created_at, updated_at) VALUES (1, 1, 2, 10.5, datetime('now'), datetime('now'));
+`)
+	assert.Nil(t, err)
+	assert.Nil(t, raw.Close())
+
+	db, err := sqlite3.New(f)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	m, err := db.Migrator()
+	assert.Nil(t, err)
+	version, err := m.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), version)
+
+	// the rest is migrated as usual
+	assert.Nil(t, db.Migrate())
+	assert.Nil(t, m.Check())
+
+	item, err := db.GetItem(context.TODO(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1050), item.Price.Amount)
+}
+
+func TestSqlite3_MigrateDuplicateItems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newDBFile(t, dir)
+	db, err := sqlite3.New(f)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	m, err := db.Migrator()
+	assert.Nil(t, err)
+	assert.Nil(t, m.To(13))
+
+	// the same product added twice before the items of a cart were unique
+	raw, err := sql.Open("sqlite3", f.Name())
+	assert.Nil(t, err)
+	_, err = raw.Exec(`
+INSERT INTO "line_items" (cart_id, product_id, quantity, price, currency, created_at, updated_at) VALUES (1, 1, 2, 2000, 'EUR', datetime('now'), datetime('now'));
+INSERT INTO "line_items" (cart_id, product_id, quantity, price, currency, created_at, updated_at) VALUES (1, 2, 1, 500, 'EUR', datetime('now'), datetime('now'));
+INSERT INTO "line_items" (cart_id, product_id, quantity, price, currency, created_at, updated_at) VALUES (1, 1, 1, 1000, 'EUR', datetime('now'), datetime('now'));
+`)
+	assert.Nil(t, err)
+	assert.Nil(t, raw.Close())
+
+	// the duplicates are merged into the first of them
+	assert.Nil(t, m.Up())
+	items, err := db.ListItemsByCartID(context.TODO(), 1)
+	assert.Nil(t, err)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, int64(1), items[0].ID)
+		assert.Equal(t, int64(3), items[0].Quantity)
+		assert.Equal(t, int64(3000), items[0].Price.Amount)
+		assert.Equal(t, int64(2), items[1].ID)
+	}
+}
+
+func TestSqlite3_MigrateDuplicateCarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newDBFile(t, dir)
+	db, err := sqlite3.New(f)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	m, err := db.Migrator()
+	assert.Nil(t, err)
+	assert.Nil(t, m.To(6))
+
+	// a user had several carts before the open cart of a user was unique
+	raw, err := sql.Open("sqlite3", f.Name())
+	assert.Nil(t, err)
+	_, err = raw.Exec(`
+INSERT INTO "carts" (user_id, created_at, updated_at) VALUES (1, '2019-01-01 10:00:00', '2019-01-01 10:00:00');
+INSERT INTO "carts" (user_id, created_at, updated_at) VALUES (1, '2019-01-03 10:00:00', '2019-01-03 10:00:00');
+INSERT INTO "carts" (user_id, created_at, updated_at) VALUES (1, '2019-01-02 10:00:00', '2019-01-02 10:00:00');
+INSERT INTO "carts" (user_id, created_at, updated_at) VALUES (2, '2019-01-01 10:00:00', '2019-01-01 10:00:00');
+INSERT INTO "carts" (user_id, created_at, updated_at) VALUES (NULL, '2019-01-01 10:00:00', '2019-01-01 10:00:00');
+INSERT INTO "carts" (user_id, created_at, updated_at) VALUES (NULL, '2019-01-01 10:00:00', '2019-01-01 10:00:00');
+`)
+	assert.Nil(t, err)
+	assert.Nil(t, raw.Close())
+
+	// the newest cart of each user stays open
+	assert.Nil(t, m.Up())
+	assert.Nil(t, m.Check())
+	open, err := db.GetOpenCartByUserID(context.TODO(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), open.ID)
+	open, err = db.GetOpenCartByUserID(context.TODO(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), open.ID)
+
+	expected := map[int64]cart.Status{1: cart.StatusAbandoned, 2: cart.StatusOpen, 3: cart.StatusAbandoned}
+	for cartID, status := range expected {
+		c, err := db.GetCart(context.TODO(), 1, cartID)
+		assert.Nil(t, err)
+		assert.Equal(t, status, c.Status)
+	}
+}
@@ -0,0 +1,113 @@
+package sqlite3
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/storage"
+)
+
+// CreateEvent writes the event to the outbox, it is due right away
+func (s *Sqlite3) CreateEvent(ctx context.Context, event *cart.Event) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "CreateEvent")
+	defer done()
+
+	res, err := s.q.ExecContext(ctx, queryInsertOutboxMessage,
+		event.Type,
+		event.CartID,
+		event.UserID,
+		string(event.Data),
+		event.OccurredAt,
+		outbox.StatusPending,
+		0,
+		event.OccurredAt.Unix(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	event.ID, err = res.LastInsertId()
+	return err
+}
+
+func (s *Sqlite3) ClaimDueOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*outbox.Message, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "ClaimDueOutboxMessages")
+	defer done()
+
+	now := time.Now()
+	rows, err := s.q.QueryContext(ctx, queryClaimDueOutboxMessages, now.Add(lease).Unix(), now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*outbox.Message{}
+	for rows.Next() {
+		var (
+			m             = &outbox.Message{Event: &cart.Event{}}
+			data          string
+			nextAttemptAt int64
+		)
+		if err := rows.Scan(
+			&m.Event.ID,
+			&m.Event.Type,
+			&m.Event.CartID,
+			&m.Event.UserID,
+			&data,
+			&m.Event.OccurredAt,
+			&m.Status,
+			&m.Attempts,
+			&nextAttemptAt,
+			&m.LastError,
+			&m.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("sqlite3: ClaimDueOutboxMessages scan error, %s", err)
+		}
+		m.Event.Data = []byte(data)
+		m.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+		messages = append(messages, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the rows returned by an update are in no particular order
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Event.ID < messages[j].Event.ID })
+
+	return messages, nil
+}
+
+func (s *Sqlite3) UpdateOutboxMessage(ctx context.Context, m *outbox.Message) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "UpdateOutboxMessage")
+	defer done()
+
+	updatedAt := time.Now()
+
+	res, err := s.q.ExecContext(ctx, queryUpdateOutboxMessage,
+		m.Status,
+		m.Attempts,
+		m.NextAttemptAt.Unix(),
+		m.LastError,
+		updatedAt,
+		m.Event.ID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrRecordNotFound
+	}
+	m.UpdatedAt = updatedAt
+
+	return nil
+}
@@ -1,25 +1,53 @@
 package sqlite3
 
 const queryInsertCart = `
-INSERT INTO carts(user_id, created_at, updated_at) values (?,?,?)
+INSERT INTO carts(user_id, status, currency, created_at, updated_at) values (?,?,?,?,?)
 `
 const queryCartsByIDAndUserID = `
-SELECT id, user_id, created_at, updated_at FROM carts 
-WHERE id = ? AND user_id = ?
+SELECT id, user_id, status, currency, version, created_at, updated_at FROM carts 
+WHERE id = ? AND IFNULL(user_id, 0) = ?
+`
+const queryCartsByUserIDAndStatus = `
+SELECT id, user_id, status, currency, version, created_at, updated_at FROM carts 
+WHERE user_id = ? AND status = ?
+ORDER BY id DESC LIMIT 1
+`
+const queryUpdateCartStatus = `
+UPDATE carts SET status = ?, updated_at = ? WHERE id = ?
+`
+const queryClaimCart = `
+UPDATE carts SET user_id = ?, status = ?, updated_at = ? WHERE id = ? AND user_id IS NULL
+`
+const queryIncrementCartVersion = `
+UPDATE carts SET version = version + 1, updated_at = ? WHERE id = ?
+`
+const queryCartVersion = `
+SELECT version FROM carts WHERE id = ?
 `
 
 const queryInsertItem = `
-INSERT INTO line_items (cart_id, product_id, quantity, price, created_at, updated_at) 
-values (?,?,?,?,?,?)
+INSERT INTO line_items (cart_id, product_id, quantity, price, currency, created_at, updated_at) 
+values (?,?,?,?,?,?,?)
 `
 const queryItemsByCartIDAndProductID = `
-SELECT id, cart_id, product_id, quantity, price, created_at, updated_at FROM line_items
+SELECT id, cart_id, product_id, quantity, price, currency, created_at, updated_at FROM line_items
 WHERE cart_id = ? and product_id = ?
 `
 const queryItemByID = `
-SELECT id, cart_id, product_id, quantity, price, created_at, updated_at FROM line_items
+SELECT id, cart_id, product_id, quantity, price, currency, created_at, updated_at FROM line_items
 WHERE id = ? 
 `
+const queryItemsByCartID = `
+SELECT id, cart_id, product_id, quantity, price, currency, created_at, updated_at FROM line_items
+WHERE cart_id = ? ORDER BY id
+`
+const queryUpdateItem = `
+UPDATE line_items SET quantity = ?, price = ?, updated_at = ? WHERE id = ?
+`
+const queryMergeItem = `
+UPDATE line_items SET quantity = quantity + ?, price = price + ?, updated_at = ?
+WHERE cart_id = ? AND product_id = ? AND currency = ?
+`
 const queryRemoveItem = `
 DELETE FROM line_items where id = ?;
 `
@@ -28,6 +56,130 @@ const queryRemoveItemsByCartID = `
 DELETE FROM line_items where cart_id = ?;
 `
 
+const queryCheckoutCart = `
+UPDATE carts SET status = 'checked_out', updated_at = ? WHERE id = ? AND status = 'open'
+`
+const queryInsertOrder = `
+INSERT INTO orders (cart_id, user_id, item_count, total_quantity, total, currency, checked_out_at)
+values (?,?,?,?,?,?,?)
+`
+const queryInsertOrderLine = `
+INSERT INTO order_lines (order_id, product_id, quantity, price, currency) values (?,?,?,?,?)
+`
+const queryOrderByID = `
+SELECT id, cart_id, user_id, item_count, total_quantity, total, currency, checked_out_at FROM orders
+WHERE id = ?
+`
+const queryOrderByCartID = `
+SELECT id, cart_id, user_id, item_count, total_quantity, total, currency, checked_out_at FROM orders
+WHERE cart_id = ?
+`
+const queryOrderLinesByOrderID = `
+SELECT id, order_id, product_id, quantity, price, currency FROM order_lines
+WHERE order_id = ? ORDER BY id
+`
+
+// the expiry of the idempotency keys is in unix seconds, so that it compares in sql
+const queryRemoveExpiredIdempotencyKeys = `
+DELETE FROM idempotency_keys WHERE expires_at <= ?
+`
+const queryInsertIdempotencyKey = `
+INSERT INTO idempotency_keys (scope, idempotency_key, request_hash, status_code, created_at, expires_at)
+values (?,?,?,?,?,?)
+`
+const queryIdempotencyKeyByScopeAndKey = `
+SELECT scope, idempotency_key, request_hash, status_code, header, body, created_at, expires_at FROM idempotency_keys
+WHERE scope = ? AND idempotency_key = ? AND expires_at > ?
+`
+const queryUpdateIdempotencyKey = `
+UPDATE idempotency_keys SET status_code = ?, header = ?, body = ?, expires_at = ?
+WHERE scope = ? AND idempotency_key = ?
+`
+const queryRemoveIdempotencyKey = `
+DELETE FROM idempotency_keys WHERE scope = ? AND idempotency_key = ?
+`
+
+// the next attempt of the outbox messages is in unix seconds, so that it compares in sql
+const queryInsertOutboxMessage = `
+INSERT INTO outbox (event_type, cart_id, user_id, data, occurred_at, status, attempts, next_attempt_at, updated_at)
+values (?,?,?,?,?,?,?,?,?)
+`
+
+// the due messages are claimed by moving their next attempt to the end of the
+// lease in the statement that selects them, so that no other dispatcher takes them
+const queryClaimDueOutboxMessages = `
+UPDATE outbox SET next_attempt_at = ?
+WHERE id IN (
+SELECT id FROM outbox o
+WHERE status = 'pending' AND next_attempt_at <= ?
+AND NOT EXISTS (SELECT 1 FROM outbox e WHERE e.cart_id = o.cart_id AND e.status = 'pending' AND e.id < o.id)
+ORDER BY id LIMIT ?
+)
+RETURNING id, event_type, cart_id, user_id, data, occurred_at, status, attempts, next_attempt_at, last_error, updated_at
+`
+const queryUpdateOutboxMessage = `
+UPDATE outbox SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, updated_at = ? WHERE id = ?
+`
+
+// the event types of a subscription are comma separated, so that a type is
+// matched by LIKE '%,<type>,%' on the types wrapped in commas
+const queryInsertSubscription = `
+INSERT INTO webhook_subscriptions (tenant, url, event_types, secret, created_at) values (?,?,?,?,?)
+`
+const querySubscriptionByTenantAndID = `
+SELECT id, tenant, url, event_types, secret, created_at FROM webhook_subscriptions WHERE tenant = ? AND id = ?
+`
+const querySubscriptionsByTenant = `
+SELECT id, tenant, url, event_types, secret, created_at FROM webhook_subscriptions WHERE tenant = ? ORDER BY id
+`
+const querySubscriptionsByEventType = `
+SELECT id, tenant, url, event_types, secret, created_at FROM webhook_subscriptions
+WHERE ',' || event_types || ',' LIKE ? ORDER BY id
+`
+const queryRemoveSubscription = `
+DELETE FROM webhook_subscriptions WHERE tenant = ? AND id = ?
+`
+const queryRemoveDeliveriesBySubscription = `
+DELETE FROM webhook_deliveries WHERE subscription_id IN (SELECT id FROM webhook_subscriptions WHERE tenant = ? AND id = ?)
+`
+
+// the next attempt of the deliveries is in unix seconds, so that it compares in sql
+const queryInsertDelivery = `
+INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at, updated_at)
+values (?,?,?,?,?,?,?,?,?)
+`
+const queryDeliveryBySubscriptionIDAndID = `
+SELECT id, subscription_id, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, updated_at
+FROM webhook_deliveries WHERE subscription_id = ? AND id = ?
+`
+const queryDeliveriesBySubscriptionID = `
+SELECT id, subscription_id, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, updated_at
+FROM webhook_deliveries WHERE subscription_id = ? ORDER BY id DESC LIMIT ?
+`
+
+// the due deliveries are claimed by moving their next attempt to the end of the
+// lease in the statement that selects them, so that no other dispatcher takes
+// them. the rows returned by an update cannot be joined, the subscription is
+// read by subqueries
+const queryClaimDueDeliveries = `
+UPDATE webhook_deliveries SET next_attempt_at = ?
+WHERE id IN (
+SELECT d.id FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.id = d.subscription_id
+WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+ORDER BY d.id LIMIT ?
+)
+RETURNING id, subscription_id, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, updated_at,
+(SELECT tenant FROM webhook_subscriptions s WHERE s.id = webhook_deliveries.subscription_id),
+(SELECT url FROM webhook_subscriptions s WHERE s.id = webhook_deliveries.subscription_id),
+(SELECT event_types FROM webhook_subscriptions s WHERE s.id = webhook_deliveries.subscription_id),
+(SELECT secret FROM webhook_subscriptions s WHERE s.id = webhook_deliveries.subscription_id),
+(SELECT created_at FROM webhook_subscriptions s WHERE s.id = webhook_deliveries.subscription_id)
+`
+const queryUpdateDelivery = `
+UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, last_error = ?, next_attempt_at = ?, updated_at = ?
+WHERE id = ?
+`
+
 // Migrations -----------------------
 
 const migration01MigrationCreateCartsTable = `
@@ -40,10 +192,18 @@ CREATE TABLE IF NOT EXISTS "carts" (
 );
 `
 
+const migration01Down = `
+DROP TABLE "carts";
+`
+
 const migration02AddCartIndex = `
 CREATE INDEX "index_cart_on_user_id" ON "carts" ("user_id");
 `
 
+const migration02Down = `
+DROP INDEX "index_cart_on_user_id";
+`
+
 const migration03CreateLineItemsTable = `
 CREATE TABLE IF NOT EXISTS "line_items" (
   "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
@@ -57,12 +217,360 @@ CREATE TABLE IF NOT EXISTS "line_items" (
 );
 `
 
+const migration03Down = `
+DROP TABLE "line_items";
+`
+
 const migration04AddLineItemsIndex = `
 CREATE INDEX "index_line_items_on_cart_id" ON "line_items" ("cart_id");
 `
+
+const migration04Down = `
+DROP INDEX "index_line_items_on_cart_id";
+`
 const migration05AddLineItemsIndex2 = `
 CREATE INDEX "index_line_items_on_product_id" ON "line_items" ("product_id");
 `
 
+const migration05Down = `
+DROP INDEX "index_line_items_on_product_id";
+`
+
+const migration06AddCartStatus = `
+ALTER TABLE "carts" ADD COLUMN "status" varchar(20) NOT NULL DEFAULT 'open';
+`
+
+// sqlite cannot drop a column, the table is rebuilt without it
+const migration06Down = `
+CREATE TABLE "carts_old" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "user_id" integer,
+  "created_at" datetime NOT NULL,
+  "updated_at" datetime NOT NULL,
+  CONSTRAINT "fk_32e17d5e33" FOREIGN KEY ("user_id") REFERENCES "users" ("id")
+);
+INSERT INTO "carts_old" (id, user_id, created_at, updated_at)
+SELECT id, user_id, created_at, updated_at FROM "carts";
+DROP TABLE "carts";
+ALTER TABLE "carts_old" RENAME TO "carts";
+CREATE INDEX "index_cart_on_user_id" ON "carts" ("user_id");
+`
+
+// a user can have only one open cart at a time, the open carts a user had
+// before are abandoned but the newest of them
+const migration07AddCartOpenStatusUniqueIndex = `
+UPDATE "carts" SET "status" = 'abandoned'
+WHERE "status" = 'open' AND "user_id" IS NOT NULL AND "id" <> (
+  SELECT "newest"."id" FROM "carts" AS "newest" WHERE "newest"."user_id" = "carts"."user_id" AND "newest"."status" = 'open'
+  ORDER BY "newest"."created_at" DESC, "newest"."id" DESC LIMIT 1
+);
+CREATE UNIQUE INDEX "index_carts_on_user_id_open" ON "carts" ("user_id") WHERE "status" = 'open';
+`
+
+const migration07Down = `
+DROP INDEX "index_carts_on_user_id_open";
+`
+
+// an order is written once per cart, the unique cart_id makes checkout idempotent
+const migration08CreateOrdersTable = `
+CREATE TABLE IF NOT EXISTS "orders" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "cart_id" integer NOT NULL UNIQUE,
+  "user_id" integer NOT NULL,
+  "item_count" integer NOT NULL,
+  "total_quantity" integer NOT NULL,
+  "total" decimal NOT NULL,
+  "checked_out_at" datetime NOT NULL,
+  CONSTRAINT "fk_orders_cart_id" FOREIGN KEY ("cart_id") REFERENCES "carts" ("id")
+);
+`
+
+const migration08Down = `
+DROP TABLE "orders";
+`
+
+const migration09CreateOrderLinesTable = `
+CREATE TABLE IF NOT EXISTS "order_lines" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "order_id" integer NOT NULL,
+  "product_id" integer NOT NULL,
+  "quantity" integer NOT NULL,
+  "price" decimal NOT NULL,
+  CONSTRAINT "fk_order_lines_order_id" FOREIGN KEY ("order_id") REFERENCES "orders" ("id")
+);
+`
+
+const migration09Down = `
+DROP TABLE "order_lines";
+`
+
+const migration10AddOrderLinesIndex = `
+CREATE INDEX "index_order_lines_on_order_id" ON "order_lines" ("order_id");
+`
+
+const migration10Down = `
+DROP INDEX "index_order_lines_on_order_id";
+`
+
+const migration11AddCartCurrency = `
+ALTER TABLE "carts" ADD COLUMN "currency" varchar(3) NOT NULL DEFAULT 'EUR';
+`
+
+const migration11Down = `
+CREATE TABLE "carts_old" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "user_id" integer,
+  "created_at" datetime NOT NULL,
+  "updated_at" datetime NOT NULL,
+  "status" varchar(20) NOT NULL DEFAULT 'open',
+  CONSTRAINT "fk_32e17d5e33" FOREIGN KEY ("user_id") REFERENCES "users" ("id")
+);
+INSERT INTO "carts_old" (id, user_id, created_at, updated_at, status)
+SELECT id, user_id, created_at, updated_at, status FROM "carts";
+DROP TABLE "carts";
+ALTER TABLE "carts_old" RENAME TO "carts";
+CREATE INDEX "index_cart_on_user_id" ON "carts" ("user_id");
+CREATE UNIQUE INDEX "index_carts_on_user_id_open" ON "carts" ("user_id") WHERE "status" = 'open';
+`
+
+// prices used to be stored as decimal, which is a floating point REAL in sqlite.
+// they are now stored as integers in the minor units of their currency, e.g. cents.
+// sqlite cannot change the type of a column, so the tables are rebuilt and the
+// existing prices, which were all in EUR, are converted to cents
+const migration12LineItemsPriceToMinorUnits = `
+CREATE TABLE "line_items_new" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "cart_id" integer,
+  "product_id" integer,
+  "quantity" integer DEFAULT 1,
+  "price" integer NOT NULL DEFAULT 0,
+  "currency" varchar(3) NOT NULL DEFAULT 'EUR',
+  "created_at" datetime NOT NULL,
+  "updated_at" datetime NOT NULL,
+  CONSTRAINT "fk_af645e8e5f" FOREIGN KEY ("cart_id") REFERENCES "carts" ("id")
+);
+INSERT INTO "line_items_new" (id, cart_id, product_id, quantity, price, currency, created_at, updated_at)
+SELECT id, cart_id, product_id, quantity, CAST(ROUND(COALESCE(price, 0) * 100) AS INTEGER), 'EUR', created_at, updated_at
+FROM "line_items";
+DROP TABLE "line_items";
+ALTER TABLE "line_items_new" RENAME TO "line_items";
+CREATE INDEX "index_line_items_on_cart_id" ON "line_items" ("cart_id");
+CREATE INDEX "index_line_items_on_product_id" ON "line_items" ("product_id");
+`
+
+const migration12Down = `
+CREATE TABLE "line_items_old" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "cart_id" integer,
+  "product_id" integer,
+  "quantity" integer DEFAULT 1,
+  "price" decimal,
+  "created_at" datetime NOT NULL,
+  "updated_at" datetime NOT NULL,
+  CONSTRAINT "fk_af645e8e5f" FOREIGN KEY ("cart_id") REFERENCES "carts" ("id")
+);
+INSERT INTO "line_items_old" (id, cart_id, product_id, quantity, price, created_at, updated_at)
+SELECT id, cart_id, product_id, quantity, price / 100.0, created_at, updated_at
+FROM "line_items";
+DROP TABLE "line_items";
+ALTER TABLE "line_items_old" RENAME TO "line_items";
+CREATE INDEX "index_line_items_on_cart_id" ON "line_items" ("cart_id");
+CREATE INDEX "index_line_items_on_product_id" ON "line_items" ("product_id");
+`
+
+const migration13OrdersPriceToMinorUnits = `
+CREATE TABLE "orders_new" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "cart_id" integer NOT NULL UNIQUE,
+  "user_id" integer NOT NULL,
+  "item_count" integer NOT NULL,
+  "total_quantity" integer NOT NULL,
+  "total" integer NOT NULL,
+  "currency" varchar(3) NOT NULL DEFAULT 'EUR',
+  "checked_out_at" datetime NOT NULL,
+  CONSTRAINT "fk_orders_cart_id" FOREIGN KEY ("cart_id") REFERENCES "carts" ("id")
+);
+INSERT INTO "orders_new" (id, cart_id, user_id, item_count, total_quantity, total, currency, checked_out_at)
+SELECT id, cart_id, user_id, item_count, total_quantity, CAST(ROUND(total * 100) AS INTEGER), 'EUR', checked_out_at
+FROM "orders";
+DROP TABLE "orders";
+ALTER TABLE "orders_new" RENAME TO "orders";
+
+CREATE TABLE "order_lines_new" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "order_id" integer NOT NULL,
+  "product_id" integer NOT NULL,
+  "quantity" integer NOT NULL,
+  "price" integer NOT NULL,
+  "currency" varchar(3) NOT NULL DEFAULT 'EUR',
+  CONSTRAINT "fk_order_lines_order_id" FOREIGN KEY ("order_id") REFERENCES "orders" ("id")
+);
+INSERT INTO "order_lines_new" (id, order_id, product_id, quantity, price, currency)
+SELECT id, order_id, product_id, quantity, CAST(ROUND(price * 100) AS INTEGER), 'EUR'
+FROM "order_lines";
+DROP TABLE "order_lines";
+ALTER TABLE "order_lines_new" RENAME TO "order_lines";
+CREATE INDEX "index_order_lines_on_order_id" ON "order_lines" ("order_id");
+`
+
+const migration13Down = `
+CREATE TABLE "orders_old" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "cart_id" integer NOT NULL UNIQUE,
+  "user_id" integer NOT NULL,
+  "item_count" integer NOT NULL,
+  "total_quantity" integer NOT NULL,
+  "total" decimal NOT NULL,
+  "checked_out_at" datetime NOT NULL,
+  CONSTRAINT "fk_orders_cart_id" FOREIGN KEY ("cart_id") REFERENCES "carts" ("id")
+);
+INSERT INTO "orders_old" (id, cart_id, user_id, item_count, total_quantity, total, checked_out_at)
+SELECT id, cart_id, user_id, item_count, total_quantity, total / 100.0, checked_out_at
+FROM "orders";
+DROP TABLE "orders";
+ALTER TABLE "orders_old" RENAME TO "orders";
+
+CREATE TABLE "order_lines_old" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "order_id" integer NOT NULL,
+  "product_id" integer NOT NULL,
+  "quantity" integer NOT NULL,
+  "price" decimal NOT NULL,
+  CONSTRAINT "fk_order_lines_order_id" FOREIGN KEY ("order_id") REFERENCES "orders" ("id")
+);
+INSERT INTO "order_lines_old" (id, order_id, product_id, quantity, price)
+SELECT id, order_id, product_id, quantity, price / 100.0
+FROM "order_lines";
+DROP TABLE "order_lines";
+ALTER TABLE "order_lines_old" RENAME TO "order_lines";
+CREATE INDEX "index_order_lines_on_order_id" ON "order_lines" ("order_id");
+`
+
+// a product is added to a cart once, the items of the same product added before
+// are merged into the first of them
+const migration14AddLineItemsCartIDProductIDUniqueIndex = `
+UPDATE "line_items" SET
+  quantity = (SELECT SUM(d.quantity) FROM "line_items" d WHERE d.cart_id = line_items.cart_id AND d.product_id = line_items.product_id),
+  price = (SELECT SUM(d.price) FROM "line_items" d WHERE d.cart_id = line_items.cart_id AND d.product_id = line_items.product_id)
+WHERE id IN (SELECT MIN(id) FROM "line_items" GROUP BY cart_id, product_id HAVING COUNT(*) > 1);
+DELETE FROM "line_items" WHERE id NOT IN (SELECT MIN(id) FROM "line_items" GROUP BY cart_id, product_id);
+CREATE UNIQUE INDEX "index_line_items_on_cart_id_product_id" ON "line_items" ("cart_id", "product_id");
+`
+
+const migration14Down = `
+DROP INDEX "index_line_items_on_cart_id_product_id";
+`
+
+// the version of a cart is incremented by every change of the cart or its items
+const migration15AddCartVersion = `
+ALTER TABLE "carts" ADD COLUMN "version" integer NOT NULL DEFAULT 1;
+`
+
+const migration15Down = `
+CREATE TABLE "carts_old" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "user_id" integer,
+  "created_at" datetime NOT NULL,
+  "updated_at" datetime NOT NULL,
+  "status" varchar(20) NOT NULL DEFAULT 'open',
+  "currency" varchar(3) NOT NULL DEFAULT 'EUR',
+  CONSTRAINT "fk_32e17d5e33" FOREIGN KEY ("user_id") REFERENCES "users" ("id")
+);
+INSERT INTO "carts_old" (id, user_id, created_at, updated_at, status, currency)
+SELECT id, user_id, created_at, updated_at, status, currency FROM "carts";
+DROP TABLE "carts";
+ALTER TABLE "carts_old" RENAME TO "carts";
+CREATE INDEX "index_cart_on_user_id" ON "carts" ("user_id");
+CREATE UNIQUE INDEX "index_carts_on_user_id_open" ON "carts" ("user_id") WHERE "status" = 'open';
+`
+
+// the responses to the requests made with an Idempotency-Key, the key is the
+// client's own, so it is unique per scope, i.e. per user
+const migration16CreateIdempotencyKeysTable = `
+CREATE TABLE "idempotency_keys" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "scope" varchar(64) NOT NULL,
+  "idempotency_key" varchar(255) NOT NULL,
+  "request_hash" varchar(64) NOT NULL,
+  "status_code" integer NOT NULL DEFAULT 0,
+  "header" text,
+  "body" blob,
+  "created_at" datetime NOT NULL,
+  "expires_at" integer NOT NULL
+);
+CREATE UNIQUE INDEX "index_idempotency_keys_on_scope_idempotency_key" ON "idempotency_keys" ("scope", "idempotency_key");
+CREATE INDEX "index_idempotency_keys_on_expires_at" ON "idempotency_keys" ("expires_at");
+`
+
+const migration16Down = `
+DROP TABLE "idempotency_keys";
+`
+
+// the events of the cart changes, written in the transactions of the changes and
+// published by the dispatcher of the outbox
+const migration17CreateOutboxTable = `
+CREATE TABLE "outbox" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "event_type" varchar(50) NOT NULL,
+  "cart_id" integer NOT NULL,
+  "user_id" integer NOT NULL DEFAULT 0,
+  "data" text NOT NULL,
+  "occurred_at" datetime NOT NULL,
+  "status" varchar(20) NOT NULL DEFAULT 'pending',
+  "attempts" integer NOT NULL DEFAULT 0,
+  "next_attempt_at" integer NOT NULL,
+  "last_error" text NOT NULL DEFAULT '',
+  "updated_at" datetime NOT NULL
+);
+CREATE INDEX "index_outbox_on_status_next_attempt_at" ON "outbox" ("status", "next_attempt_at");
+CREATE INDEX "index_outbox_on_cart_id" ON "outbox" ("cart_id");
+`
+
+const migration17Down = `
+DROP TABLE "outbox";
+`
+
+// the webhooks the tenants subscribe to and the deliveries of the events to
+// them, an event is delivered once per subscription
+const migration18CreateWebhookTables = `
+CREATE TABLE "webhook_subscriptions" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "tenant" varchar(100) NOT NULL,
+  "url" text NOT NULL,
+  "event_types" text NOT NULL,
+  "secret" varchar(100) NOT NULL,
+  "created_at" datetime NOT NULL
+);
+CREATE INDEX "index_webhook_subscriptions_on_tenant" ON "webhook_subscriptions" ("tenant");
+CREATE TABLE "webhook_deliveries" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "subscription_id" integer NOT NULL,
+  "event_id" integer NOT NULL,
+  "event_type" varchar(50) NOT NULL,
+  "payload" text NOT NULL,
+  "status" varchar(20) NOT NULL DEFAULT 'pending',
+  "attempts" integer NOT NULL DEFAULT 0,
+  "response_status" integer NOT NULL DEFAULT 0,
+  "last_error" text NOT NULL DEFAULT '',
+  "next_attempt_at" integer NOT NULL,
+  "created_at" datetime NOT NULL,
+  "updated_at" datetime NOT NULL,
+  CONSTRAINT "fk_webhook_deliveries_subscription_id" FOREIGN KEY ("subscription_id") REFERENCES "webhook_subscriptions" ("id")
+);
+CREATE UNIQUE INDEX "index_webhook_deliveries_on_subscription_id_event_id" ON "webhook_deliveries" ("subscription_id", "event_id");
+CREATE INDEX "index_webhook_deliveries_on_status_next_attempt_at" ON "webhook_deliveries" ("status", "next_attempt_at");
+`
+
+const migration18Down = `
+DROP TABLE "webhook_deliveries";
+DROP TABLE "webhook_subscriptions";
+`
+
 const truncateCartsTable = `DELETE FROM carts;`
 const truncateLineItemsTable = `DELETE FROM line_items;`
+const truncateOrdersTable = `DELETE FROM orders;`
+const truncateOrderLinesTable = `DELETE FROM order_lines;`
+const truncateIdempotencyKeysTable = `DELETE FROM idempotency_keys;`
+const truncateOutboxTable = `DELETE FROM outbox;`
+const truncateWebhookDeliveriesTable = `DELETE FROM webhook_deliveries;`
+const truncateWebhookSubscriptionsTable = `DELETE FROM webhook_subscriptions;`
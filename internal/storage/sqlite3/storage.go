@@ -5,40 +5,104 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/service"
 	"github.com/cubny/cart/internal/storage"
 	"os"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	sqlite "github.com/mattn/go-sqlite3"
 )
 
 type Sqlite3 struct {
 	db *sql.DB
+	// q runs the statements, it is the transaction within WithTx
+	q  querier
+	tx *sql.Tx
 }
 
+// querier is what the statements run on, the database or a transaction of it
+type querier interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// New opens the database in the file. the transactions take the write lock as
+// they begin, a transaction that reads before it writes would otherwise fail
+// with "database is locked" when another one wrote in between
 func New(dbfile *os.File) (*Sqlite3, error) {
-	db, err := sql.Open("sqlite3", dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name()+"?_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
-	return &Sqlite3{db: db}, nil
+	return &Sqlite3{db: db, q: db}, nil
 }
 
 func (s *Sqlite3) Close() error {
 	return s.db.Close()
 }
 
+// Stats returns the stats of the connection pool of the database
+func (s *Sqlite3) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// WithTx runs fn in a transaction, the storage given to fn runs its statements
+// in the transaction. the transaction is committed if fn returns no error and
+// rolled back otherwise. within a transaction fn simply joins it
+func (s *Sqlite3) WithTx(ctx context.Context, fn func(service.Storage) error) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "WithTx")
+	defer done()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return fn(&Sqlite3{db: s.db, q: tx, tx: tx})
+	})
+}
+
+// inTx runs fn in the transaction of the storage, or in a new one when the
+// storage is not in a transaction
+func (s *Sqlite3) inTx(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (s *Sqlite3) CreateCart(ctx context.Context, cart *cart.Cart) error {
-	stmt, err := s.db.Prepare(queryInsertCart)
+	ctx, done := storage.Observe(ctx, "sqlite3", "CreateCart")
+	defer done()
+
+	stmt, err := s.q.PrepareContext(ctx, queryInsertCart)
 	if err != nil {
 		return err
 	}
 
 	now := time.Now()
+	cart.Version = 1
 	cart.CreatedAt = now
 	cart.UpdatedAt = now
 
-	res, err := stmt.ExecContext(ctx, cart.UserID, cart.CreatedAt, cart.UpdatedAt)
+	res, err := stmt.ExecContext(ctx, ownerID(cart), cart.Status, cart.Currency, cart.CreatedAt, cart.UpdatedAt)
 	if err != nil {
 		return err
 	}
@@ -52,7 +116,10 @@ func (s *Sqlite3) CreateCart(ctx context.Context, cart *cart.Cart) error {
 }
 
 func (s *Sqlite3) GetCart(ctx context.Context, userID, cartID int64) (*cart.Cart, error) {
-	stmt, err := s.db.Prepare(queryCartsByIDAndUserID)
+	ctx, done := storage.Observe(ctx, "sqlite3", "GetCart")
+	defer done()
+
+	stmt, err := s.q.PrepareContext(ctx, queryCartsByIDAndUserID)
 	if err != nil {
 		return nil, err
 	}
@@ -66,17 +133,127 @@ func (s *Sqlite3) GetCart(ctx context.Context, userID, cartID int64) (*cart.Cart
 	defer rows.Close()
 
 	if rows.Next() {
-		if err := rows.Scan(&c.ID, &c.UserID, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		var userID sql.NullInt64
+		if err := rows.Scan(&c.ID, &userID, &c.Status, &c.Currency, &c.Version, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("sqlite3: GetCart result scan error, %s", err)
 		}
+		c.UserID = userID.Int64
+		return c, nil
+	}
+
+	return nil, storage.ErrRecordNotFound
+}
+
+func (s *Sqlite3) GetOpenCartByUserID(ctx context.Context, userID int64) (*cart.Cart, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "GetOpenCartByUserID")
+	defer done()
+
+	stmt, err := s.q.PrepareContext(ctx, queryCartsByUserIDAndStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cart.Cart{}
+
+	rows, err := stmt.QueryContext(ctx, userID, cart.StatusOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var userID sql.NullInt64
+		if err := rows.Scan(&c.ID, &userID, &c.Status, &c.Currency, &c.Version, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("sqlite3: GetOpenCartByUserID result scan error, %s", err)
+		}
+		c.UserID = userID.Int64
 		return c, nil
 	}
 
 	return nil, storage.ErrRecordNotFound
 }
 
+func (s *Sqlite3) UpdateCartStatus(ctx context.Context, c *cart.Cart) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "UpdateCartStatus")
+	defer done()
+
+	c.UpdatedAt = time.Now()
+
+	res, err := s.q.ExecContext(ctx, queryUpdateCartStatus, c.Status, c.UpdatedAt, c.ID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ClaimCart gives the guest cart to its user, the status of the cart is written
+// too. it returns storage.ErrConflict if the cart is no longer a guest cart
+func (s *Sqlite3) ClaimCart(ctx context.Context, c *cart.Cart) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "ClaimCart")
+	defer done()
+
+	c.UpdatedAt = time.Now()
+
+	res, err := s.q.ExecContext(ctx, queryClaimCart, c.UserID, c.Status, c.UpdatedAt, c.ID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrConflict
+	}
+
+	return nil
+}
+
+// IncrementCartVersion increments the version of the cart, the cart gets the
+// new version
+func (s *Sqlite3) IncrementCartVersion(ctx context.Context, c *cart.Cart) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "IncrementCartVersion")
+	defer done()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		updatedAt := time.Now()
+
+		res, err := tx.ExecContext(ctx, queryIncrementCartVersion, updatedAt, c.ID)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return storage.ErrRecordNotFound
+		}
+
+		if err := tx.QueryRowContext(ctx, queryCartVersion, c.ID).Scan(&c.Version); err != nil {
+			return fmt.Errorf("sqlite3: IncrementCartVersion result scan error, %s", err)
+		}
+		c.UpdatedAt = updatedAt
+
+		return nil
+	})
+}
+
 func (s *Sqlite3) FindItemByProductID(ctx context.Context, cartID, productID int64) (*cart.Item, error) {
-	stmt, err := s.db.Prepare(queryItemsByCartIDAndProductID)
+	ctx, done := storage.Observe(ctx, "sqlite3", "FindItemByProductID")
+	defer done()
+
+	stmt, err := s.q.PrepareContext(ctx, queryItemsByCartIDAndProductID)
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +272,8 @@ func (s *Sqlite3) FindItemByProductID(ctx context.Context, cartID, productID int
 			&item.CartID,
 			&item.ProductID,
 			&item.Quantity,
-			&item.Price,
+			&item.Price.Amount,
+			&item.Price.Currency,
 			&item.CreatedAt,
 			&item.UpdatedAt,
 		)
@@ -108,8 +286,13 @@ func (s *Sqlite3) FindItemByProductID(ctx context.Context, cartID, productID int
 	return nil, storage.ErrRecordNotFound
 }
 
+// CreateItem adds the item to its cart, it returns storage.ErrDuplicate if the
+// cart already has the product
 func (s *Sqlite3) CreateItem(ctx context.Context, item *cart.Item) error {
-	stmt, err := s.db.Prepare(queryInsertItem)
+	ctx, done := storage.Observe(ctx, "sqlite3", "CreateItem")
+	defer done()
+
+	stmt, err := s.q.PrepareContext(ctx, queryInsertItem)
 	if err != nil {
 		return err
 	}
@@ -122,10 +305,14 @@ func (s *Sqlite3) CreateItem(ctx context.Context, item *cart.Item) error {
 		item.CartID,
 		item.ProductID,
 		item.Quantity,
-		item.Price,
+		item.Price.Amount,
+		item.Price.Currency,
 		item.CreatedAt,
 		item.UpdatedAt,
 	)
+	if isUniqueViolation(err) {
+		return storage.ErrDuplicate
+	}
 	if err != nil {
 		return err
 	}
@@ -138,8 +325,84 @@ func (s *Sqlite3) CreateItem(ctx context.Context, item *cart.Item) error {
 	return nil
 }
 
+// MergeItem adds the quantity and the price of the item to the item of the same
+// product in the cart, or creates the item if the cart does not have the product.
+// the transaction takes the write lock as it begins, so concurrent merges cannot
+// lose an increment
+func (s *Sqlite3) MergeItem(ctx context.Context, item *cart.Item) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "MergeItem")
+	defer done()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+
+		res, err := tx.ExecContext(ctx, queryMergeItem,
+			item.Quantity,
+			item.Price.Amount,
+			now,
+			item.CartID,
+			item.ProductID,
+			item.Price.Currency,
+		)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			item.CreatedAt = now
+			item.UpdatedAt = now
+
+			res, err := tx.ExecContext(ctx, queryInsertItem,
+				item.CartID,
+				item.ProductID,
+				item.Quantity,
+				item.Price.Amount,
+				item.Price.Currency,
+				item.CreatedAt,
+				item.UpdatedAt,
+			)
+			if isUniqueViolation(err) {
+				return storage.ErrDuplicate
+			}
+			if err != nil {
+				return err
+			}
+
+			item.ID, err = res.LastInsertId()
+			if err != nil {
+				return err
+			}
+
+			return nil
+		}
+
+		err = tx.QueryRowContext(ctx, queryItemsByCartIDAndProductID, item.CartID, item.ProductID).Scan(
+			&item.ID,
+			&item.CartID,
+			&item.ProductID,
+			&item.Quantity,
+			&item.Price.Amount,
+			&item.Price.Currency,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("sqlite3: MergeItem result scan error, %s", err)
+		}
+
+		return nil
+	})
+}
+
 func (s *Sqlite3) GetItem(ctx context.Context, itemID int64) (*cart.Item, error) {
-	stmt, err := s.db.Prepare(queryItemByID)
+	ctx, done := storage.Observe(ctx, "sqlite3", "GetItem")
+	defer done()
+
+	stmt, err := s.q.PrepareContext(ctx, queryItemByID)
 	if err != nil {
 		return nil, err
 	}
@@ -158,7 +421,8 @@ func (s *Sqlite3) GetItem(ctx context.Context, itemID int64) (*cart.Item, error)
 			&item.CartID,
 			&item.ProductID,
 			&item.Quantity,
-			&item.Price,
+			&item.Price.Amount,
+			&item.Price.Currency,
 			&item.CreatedAt,
 			&item.UpdatedAt,
 		)
@@ -171,12 +435,95 @@ func (s *Sqlite3) GetItem(ctx context.Context, itemID int64) (*cart.Item, error)
 	return nil, storage.ErrRecordNotFound
 }
 
+func (s *Sqlite3) ListItemsByCartID(ctx context.Context, cartID int64) ([]*cart.Item, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "ListItemsByCartID")
+	defer done()
+
+	stmt, err := s.q.PrepareContext(ctx, queryItemsByCartID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*cart.Item{}
+	for rows.Next() {
+		item := &cart.Item{}
+		err := rows.Scan(
+			&item.ID,
+			&item.CartID,
+			&item.ProductID,
+			&item.Quantity,
+			&item.Price.Amount,
+			&item.Price.Currency,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: ListItemsByCartID result scan error, %s", err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (s *Sqlite3) UpdateItem(ctx context.Context, item *cart.Item) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "UpdateItem")
+	defer done()
+
+	item.UpdatedAt = time.Now()
+
+	res, err := s.q.ExecContext(ctx, queryUpdateItem, item.Quantity, item.Price.Amount, item.UpdatedAt, item.ID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrRecordNotFound
+	}
+
+	return nil
+}
+
 func (s *Sqlite3) RemoveItem(ctx context.Context, itemID int64) error {
-	_, err := s.db.ExecContext(ctx, queryRemoveItem, itemID)
+	ctx, done := storage.Observe(ctx, "sqlite3", "RemoveItem")
+	defer done()
+
+	_, err := s.q.ExecContext(ctx, queryRemoveItem, itemID)
 	return err
 }
 
 func (s *Sqlite3) RemoveItemsByCartID(ctx context.Context, cartID int64) error {
-	_, err := s.db.ExecContext(ctx, queryRemoveItemsByCartID, cartID)
+	ctx, done := storage.Observe(ctx, "sqlite3", "RemoveItemsByCartID")
+	defer done()
+
+	_, err := s.q.ExecContext(ctx, queryRemoveItemsByCartID, cartID)
 	return err
 }
+
+// isUniqueViolation reports whether the statement was refused by a unique index
+func isUniqueViolation(err error) bool {
+	sqliteErr, ok := err.(sqlite.Error)
+	return ok && sqliteErr.ExtendedCode == sqlite.ErrConstraintUnique
+}
+
+// ownerID is the user_id of the cart, the carts of the guests have no owner
+func ownerID(c *cart.Cart) interface{} {
+	if c.IsGuest() {
+		return nil
+	}
+	return c.UserID
+}
@@ -0,0 +1,76 @@
+package sqlite3_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/storage/sqlite3"
+	"github.com/cubny/cart/internal/storage/storagetest"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqlite3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		// every test gets a database file of its own
+		dbFile, err := ioutil.TempFile(dir, "cart-*.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := sqlite3.New(dbFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+
+		return db
+	})
+}
+
+func TestSqlite3_Metrics(t *testing.T) {
+	dbFile, err := ioutil.TempFile("", "cart-*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dbFile.Name())
+
+	db, err := sqlite3.New(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the methods are timed by their names
+	labels := map[string]string{"backend": "sqlite3", "method": "GetCart"}
+	observed := tests.MetricValue(t, "cart_storage_query_duration_seconds", labels)
+	_, err = db.GetCart(context.TODO(), 1, 1)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	assert.Equal(t, observed+1, tests.MetricValue(t, "cart_storage_query_duration_seconds", labels))
+
+	// the stats of the connection pool
+	pool := storage.NewPoolCollector("sqlite3", db)
+	assert.Equal(t, 8, testutil.CollectAndCount(pool))
+	assert.Nil(t, testutil.CollectAndCompare(pool, strings.NewReader(`
+# HELP cart_db_in_use_connections Number of connections currently in use
+# TYPE cart_db_in_use_connections gauge
+cart_db_in_use_connections{backend="sqlite3"} 0
+`), "cart_db_in_use_connections"))
+}
@@ -0,0 +1,349 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/webhook"
+)
+
+// rowScanner is a row of the result, *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (s *Sqlite3) CreateSubscription(ctx context.Context, sub *webhook.Subscription) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "CreateSubscription")
+	defer done()
+
+	sub.CreatedAt = time.Now()
+
+	res, err := s.q.ExecContext(ctx, queryInsertSubscription,
+		sub.Tenant,
+		sub.URL,
+		joinEventTypes(sub.EventTypes),
+		sub.Secret,
+		sub.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	sub.ID, err = res.LastInsertId()
+	return err
+}
+
+func (s *Sqlite3) GetSubscription(ctx context.Context, tenant string, id int64) (*webhook.Subscription, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "GetSubscription")
+	defer done()
+
+	sub, err := scanSubscription(s.q.QueryRowContext(ctx, querySubscriptionByTenantAndID, tenant, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, storage.ErrRecordNotFound
+	case err != nil:
+		return nil, fmt.Errorf("sqlite3: GetSubscription result scan error, %s", err)
+	}
+
+	return sub, nil
+}
+
+func (s *Sqlite3) ListSubscriptions(ctx context.Context, tenant string) ([]*webhook.Subscription, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "ListSubscriptions")
+	defer done()
+
+	return s.listSubscriptions(ctx, querySubscriptionsByTenant, tenant)
+}
+
+// ListSubscriptionsByEventType returns the subscriptions of all the tenants to the event type
+func (s *Sqlite3) ListSubscriptionsByEventType(ctx context.Context, eventType cart.EventType) ([]*webhook.Subscription, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "ListSubscriptionsByEventType")
+	defer done()
+
+	return s.listSubscriptions(ctx, querySubscriptionsByEventType, "%,"+string(eventType)+",%")
+}
+
+func (s *Sqlite3) listSubscriptions(ctx context.Context, query string, args ...interface{}) ([]*webhook.Subscription, error) {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*webhook.Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: ListSubscriptions scan error, %s", err)
+		}
+		subs = append(subs, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// RemoveSubscription removes the subscription along with its deliveries
+func (s *Sqlite3) RemoveSubscription(ctx context.Context, tenant string, id int64) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "RemoveSubscription")
+	defer done()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, queryRemoveDeliveriesBySubscription, tenant, id); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, queryRemoveSubscription, tenant, id)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return storage.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// CreateDelivery returns storage.ErrDuplicate if the event is already delivered
+// to the subscription
+func (s *Sqlite3) CreateDelivery(ctx context.Context, d *webhook.Delivery) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "CreateDelivery")
+	defer done()
+
+	payload, err := json.Marshal(d.Event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	d.CreatedAt = now
+	d.UpdatedAt = now
+
+	res, err := s.q.ExecContext(ctx, queryInsertDelivery,
+		d.SubscriptionID,
+		d.Event.ID,
+		d.Event.Type,
+		string(payload),
+		d.Status,
+		d.Attempts,
+		d.NextAttemptAt.Unix(),
+		d.CreatedAt,
+		d.UpdatedAt,
+	)
+	if isUniqueViolation(err) {
+		return storage.ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+
+	d.ID, err = res.LastInsertId()
+	return err
+}
+
+func (s *Sqlite3) GetDelivery(ctx context.Context, subscriptionID, id int64) (*webhook.Delivery, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "GetDelivery")
+	defer done()
+
+	d, err := scanDelivery(s.q.QueryRowContext(ctx, queryDeliveryBySubscriptionIDAndID, subscriptionID, id))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, storage.ErrRecordNotFound
+	case err != nil:
+		return nil, fmt.Errorf("sqlite3: GetDelivery result scan error, %s", err)
+	}
+
+	return d, nil
+}
+
+// ListDeliveries returns the latest deliveries of the subscription, the newest first
+func (s *Sqlite3) ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]*webhook.Delivery, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "ListDeliveries")
+	defer done()
+
+	rows, err := s.q.QueryContext(ctx, queryDeliveriesBySubscriptionID, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*webhook.Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite3: ListDeliveries scan error, %s", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// ClaimDueDeliveries returns the pending deliveries that are due along with
+// their subscriptions, the oldest first, they are not due again until the lease
+// has passed
+func (s *Sqlite3) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*webhook.Delivery, error) {
+	ctx, done := storage.Observe(ctx, "sqlite3", "ClaimDueDeliveries")
+	defer done()
+
+	now := time.Now()
+	rows, err := s.q.QueryContext(ctx, queryClaimDueDeliveries, now.Add(lease).Unix(), now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*webhook.Delivery{}
+	for rows.Next() {
+		var (
+			d             = &webhook.Delivery{Subscription: &webhook.Subscription{}}
+			payload       string
+			nextAttemptAt int64
+			eventTypes    string
+		)
+		if err := rows.Scan(
+			&d.ID,
+			&d.SubscriptionID,
+			&payload,
+			&d.Status,
+			&d.Attempts,
+			&d.ResponseStatus,
+			&d.LastError,
+			&nextAttemptAt,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&d.Subscription.Tenant,
+			&d.Subscription.URL,
+			&eventTypes,
+			&d.Subscription.Secret,
+			&d.Subscription.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("sqlite3: ClaimDueDeliveries scan error, %s", err)
+		}
+		if err := json.Unmarshal([]byte(payload), &d.Event); err != nil {
+			return nil, fmt.Errorf("sqlite3: ClaimDueDeliveries payload decode error, %s", err)
+		}
+		d.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+		d.Subscription.ID = d.SubscriptionID
+		d.Subscription.EventTypes = splitEventTypes(eventTypes)
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the rows returned by an update are in no particular order
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+
+	return deliveries, nil
+}
+
+// UpdateDelivery writes the result of the last attempt of the delivery
+func (s *Sqlite3) UpdateDelivery(ctx context.Context, d *webhook.Delivery) error {
+	ctx, done := storage.Observe(ctx, "sqlite3", "UpdateDelivery")
+	defer done()
+
+	updatedAt := time.Now()
+
+	res, err := s.q.ExecContext(ctx, queryUpdateDelivery,
+		d.Status,
+		d.Attempts,
+		d.ResponseStatus,
+		d.LastError,
+		d.NextAttemptAt.Unix(),
+		updatedAt,
+		d.ID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrRecordNotFound
+	}
+	d.UpdatedAt = updatedAt
+
+	return nil
+}
+
+func scanSubscription(row rowScanner) (*webhook.Subscription, error) {
+	var (
+		sub        = &webhook.Subscription{}
+		eventTypes string
+	)
+	if err := row.Scan(&sub.ID, &sub.Tenant, &sub.URL, &eventTypes, &sub.Secret, &sub.CreatedAt); err != nil {
+		return nil, err
+	}
+	sub.EventTypes = splitEventTypes(eventTypes)
+
+	return sub, nil
+}
+
+func scanDelivery(row rowScanner) (*webhook.Delivery, error) {
+	var (
+		d             = &webhook.Delivery{}
+		payload       string
+		nextAttemptAt int64
+	)
+	if err := row.Scan(
+		&d.ID,
+		&d.SubscriptionID,
+		&payload,
+		&d.Status,
+		&d.Attempts,
+		&d.ResponseStatus,
+		&d.LastError,
+		&nextAttemptAt,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(payload), &d.Event); err != nil {
+		return nil, err
+	}
+	d.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+
+	return d, nil
+}
+
+func joinEventTypes(eventTypes []cart.EventType) string {
+	types := make([]string, len(eventTypes))
+	for i, t := range eventTypes {
+		types[i] = string(t)
+	}
+	return strings.Join(types, ",")
+}
+
+func splitEventTypes(s string) []cart.EventType {
+	eventTypes := []cart.EventType{}
+	for _, t := range strings.Split(s, ",") {
+		if t != "" {
+			eventTypes = append(eventTypes, cart.EventType(t))
+		}
+	}
+	return eventTypes
+}
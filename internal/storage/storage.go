@@ -6,4 +6,6 @@ import (
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrConflict       = errors.New("record has been changed by another operation")
+	ErrDuplicate      = errors.New("record already exists")
 )
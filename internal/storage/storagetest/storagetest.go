@@ -0,0 +1,764 @@
+// Package storagetest is the conformance suite of the storage backends, every
+// backend runs it so that they all behave the same
+package storagetest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/idempotency"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/storage"
+	"github.com/cubny/cart/internal/webhook"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Storage is a storage backend under test
+type Storage interface {
+	service.Storage
+	handler.IdempotencyStore
+	outbox.Storage
+	webhook.Storage
+	Migrate() error
+	TruncateAllTables() error
+}
+
+// Run runs the suite, newStorage must return an empty migrated storage every
+// time it is called, the suite closes it
+func Run(t *testing.T, newStorage func(t *testing.T) Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s Storage)
+	}{
+		{name: "carts", test: testCarts},
+		{name: "open carts", test: testOpenCarts},
+		{name: "guest carts", test: testGuestCarts},
+		{name: "cart versions", test: testCartVersions},
+		{name: "items", test: testItems},
+		{name: "merge items", test: testMergeItems},
+		{name: "merge items concurrently", test: testMergeItemsConcurrently},
+		{name: "checkout", test: testCheckout},
+		{name: "transactions", test: testTransactions},
+		{name: "transactions concurrently", test: testTransactionsConcurrently},
+		{name: "idempotency keys", test: testIdempotencyKeys},
+		{name: "outbox", test: testOutbox},
+		{name: "webhooks", test: testWebhooks},
+		{name: "truncate", test: testTruncate},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newStorage(t)
+			defer s.Close()
+			test.test(t, s)
+		})
+	}
+}
+
+func testCarts(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+	assert.Equal(t, int64(1), c.ID)
+	assert.False(t, c.CreatedAt.IsZero())
+	assert.Equal(t, c.CreatedAt, c.UpdatedAt)
+
+	other := &cart.Cart{UserID: 2, Status: cart.StatusOpen, Currency: "USD"}
+	assert.Nil(t, s.CreateCart(ctx, other))
+	assert.Equal(t, int64(2), other.ID)
+
+	got, err := s.GetCart(ctx, 1, c.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, c.ID, got.ID)
+	assert.Equal(t, int64(1), got.UserID)
+	assert.Equal(t, cart.StatusOpen, got.Status)
+	assert.Equal(t, cart.Currency("EUR"), got.Currency)
+
+	// the cart read is a copy
+	got.Status = cart.StatusAbandoned
+	got, err = s.GetCart(ctx, 1, c.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, cart.StatusOpen, got.Status)
+
+	_, err = s.GetCart(ctx, 2, c.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	_, err = s.GetCart(ctx, 1, 100)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+
+	c.Status = cart.StatusAbandoned
+	assert.Nil(t, s.UpdateCartStatus(ctx, c))
+	got, err = s.GetCart(ctx, 1, c.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, cart.StatusAbandoned, got.Status)
+
+	assert.Equal(t, storage.ErrRecordNotFound, s.UpdateCartStatus(ctx, &cart.Cart{ID: 100, Status: cart.StatusOpen}))
+}
+
+func testOpenCarts(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	_, err := s.GetOpenCartByUserID(ctx, 1)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+
+	got, err := s.GetOpenCartByUserID(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, c.ID, got.ID)
+
+	// a user has only one open cart
+	assert.NotNil(t, s.CreateCart(ctx, &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}))
+
+	c.Status = cart.StatusAbandoned
+	assert.Nil(t, s.UpdateCartStatus(ctx, c))
+	_, err = s.GetOpenCartByUserID(ctx, 1)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+
+	next := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, next))
+	got, err = s.GetOpenCartByUserID(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, next.ID, got.ID)
+
+	// the abandoned cart cannot be opened again while the user has an open cart
+	c.Status = cart.StatusOpen
+	assert.NotNil(t, s.UpdateCartStatus(ctx, c))
+}
+
+func testGuestCarts(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	first := cart.NewGuestCart()
+	assert.Nil(t, s.CreateCart(ctx, first))
+	second := cart.NewGuestCart()
+	assert.Nil(t, s.CreateCart(ctx, second))
+
+	got, err := s.GetCart(ctx, 0, first.ID)
+	assert.Nil(t, err)
+	assert.True(t, got.IsGuest())
+
+	// the guests have no open cart to look up
+	_, err = s.GetOpenCartByUserID(ctx, 0)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+
+	first.UserID = 1
+	assert.Nil(t, s.ClaimCart(ctx, first))
+	got, err = s.GetCart(ctx, 1, first.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, cart.StatusOpen, got.Status)
+	_, err = s.GetCart(ctx, 0, first.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+
+	// a cart is claimed only once
+	first.UserID = 2
+	assert.Equal(t, storage.ErrConflict, s.ClaimCart(ctx, first))
+	assert.Equal(t, storage.ErrConflict, s.ClaimCart(ctx, &cart.Cart{ID: 100, UserID: 2, Status: cart.StatusOpen}))
+
+	// the status is written by the claim too
+	second.UserID = 1
+	second.Status = cart.StatusAbandoned
+	assert.Nil(t, s.ClaimCart(ctx, second))
+	got, err = s.GetCart(ctx, 1, second.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, cart.StatusAbandoned, got.Status)
+}
+
+func testCartVersions(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+	assert.Equal(t, int64(1), c.Version)
+
+	assert.Nil(t, s.IncrementCartVersion(ctx, c))
+	assert.Equal(t, int64(2), c.Version)
+
+	// the version read is the one incremented by someone else
+	stale := &cart.Cart{ID: c.ID}
+	assert.Nil(t, s.IncrementCartVersion(ctx, stale))
+	assert.Equal(t, int64(3), stale.Version)
+
+	got, err := s.GetCart(ctx, 1, c.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), got.Version)
+	got, err = s.GetOpenCartByUserID(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), got.Version)
+
+	assert.Equal(t, storage.ErrRecordNotFound, s.IncrementCartVersion(ctx, &cart.Cart{ID: 100}))
+}
+
+func testItems(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+
+	item := &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}
+	assert.Nil(t, s.CreateItem(ctx, item))
+	assert.Equal(t, int64(1), item.ID)
+	assert.False(t, item.CreatedAt.IsZero())
+
+	second := &cart.Item{CartID: c.ID, ProductID: 2, Quantity: 2, Price: cart.NewMoney(500, "EUR")}
+	assert.Nil(t, s.CreateItem(ctx, second))
+	assert.Equal(t, int64(2), second.ID)
+
+	// a product is added to a cart once
+	assert.Equal(t, storage.ErrDuplicate, s.CreateItem(ctx, &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}))
+
+	got, err := s.GetItem(ctx, item.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, item.CartID, got.CartID)
+	assert.Equal(t, item.ProductID, got.ProductID)
+	assert.Equal(t, item.Quantity, got.Quantity)
+	assert.Equal(t, item.Price, got.Price)
+
+	got, err = s.FindItemByProductID(ctx, c.ID, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, second.ID, got.ID)
+
+	_, err = s.GetItem(ctx, 100)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	_, err = s.FindItemByProductID(ctx, c.ID, 3)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+
+	item.Quantity = 3
+	item.Price = cart.NewMoney(3000, "EUR")
+	assert.Nil(t, s.UpdateItem(ctx, item))
+	got, err = s.GetItem(ctx, item.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), got.Quantity)
+	assert.Equal(t, cart.NewMoney(3000, "EUR"), got.Price)
+	assert.Equal(t, storage.ErrRecordNotFound, s.UpdateItem(ctx, &cart.Item{ID: 100}))
+
+	items, err := s.ListItemsByCartID(ctx, c.ID)
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, item.ID, items[0].ID)
+	assert.Equal(t, second.ID, items[1].ID)
+
+	items, err = s.ListItemsByCartID(ctx, 100)
+	assert.Nil(t, err)
+	assert.NotNil(t, items)
+	assert.Empty(t, items)
+
+	assert.Nil(t, s.RemoveItem(ctx, item.ID))
+	assert.Nil(t, s.RemoveItem(ctx, item.ID))
+	_, err = s.GetItem(ctx, item.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+
+	assert.Nil(t, s.RemoveItemsByCartID(ctx, c.ID))
+	items, err = s.ListItemsByCartID(ctx, c.ID)
+	assert.Nil(t, err)
+	assert.Empty(t, items)
+}
+
+func testMergeItems(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+
+	// the first merge of a product creates its item
+	item := &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}
+	assert.Nil(t, s.MergeItem(ctx, item))
+	assert.Equal(t, int64(1), item.ID)
+
+	// the next ones add up and return the merged item
+	merged := &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")}
+	assert.Nil(t, s.MergeItem(ctx, merged))
+	assert.Equal(t, item.ID, merged.ID)
+	assert.Equal(t, int64(3), merged.Quantity)
+	assert.Equal(t, cart.NewMoney(3000, "EUR"), merged.Price)
+
+	items, err := s.ListItemsByCartID(ctx, c.ID)
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, int64(3), items[0].Quantity)
+}
+
+func testMergeItemsConcurrently(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+
+	// concurrent merges of a new product neither lose an increment nor insert it twice
+	merges := 10
+	var wg sync.WaitGroup
+	for i := 0; i < merges; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, s.MergeItem(ctx, &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1, Price: cart.NewMoney(50, "EUR")}))
+		}()
+	}
+	wg.Wait()
+
+	items, err := s.ListItemsByCartID(ctx, c.ID)
+	assert.Nil(t, err)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, int64(merges), items[0].Quantity)
+		assert.Equal(t, cart.NewMoney(int64(merges)*50, "EUR"), items[0].Price)
+	}
+}
+
+func testCheckout(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+
+	order := &cart.Order{
+		CartID:        c.ID,
+		UserID:        1,
+		ItemCount:     2,
+		TotalQuantity: 3,
+		Total:         cart.NewMoney(2500, "EUR"),
+		Currency:      "EUR",
+		Lines: []*cart.OrderLine{
+			{ProductID: 1, Quantity: 2, Price: cart.NewMoney(2000, "EUR")},
+			{ProductID: 2, Quantity: 1, Price: cart.NewMoney(500, "EUR")},
+		},
+	}
+	assert.Nil(t, s.CheckoutCart(ctx, order))
+	assert.Equal(t, int64(1), order.ID)
+	assert.False(t, order.CheckedOutAt.IsZero())
+	assert.Equal(t, order.ID, order.Lines[0].OrderID)
+	assert.Equal(t, int64(1), order.Lines[0].ID)
+	assert.Equal(t, int64(2), order.Lines[1].ID)
+
+	got, err := s.GetCart(ctx, 1, c.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, cart.StatusCheckedOut, got.Status)
+
+	// a cart is checked out once
+	assert.Equal(t, storage.ErrConflict, s.CheckoutCart(ctx, &cart.Order{CartID: c.ID}))
+	assert.Equal(t, storage.ErrConflict, s.CheckoutCart(ctx, &cart.Order{CartID: 100}))
+
+	for _, get := range []func() (*cart.Order, error){
+		func() (*cart.Order, error) { return s.GetOrder(ctx, order.ID) },
+		func() (*cart.Order, error) { return s.GetOrderByCartID(ctx, c.ID) },
+	} {
+		got, err := get()
+		assert.Nil(t, err)
+		assert.Equal(t, order.ID, got.ID)
+		assert.Equal(t, order.CartID, got.CartID)
+		assert.Equal(t, order.UserID, got.UserID)
+		assert.Equal(t, order.ItemCount, got.ItemCount)
+		assert.Equal(t, order.TotalQuantity, got.TotalQuantity)
+		assert.Equal(t, order.Total, got.Total)
+		assert.Equal(t, order.Currency, got.Currency)
+		if assert.Len(t, got.Lines, 2) {
+			assert.Equal(t, *order.Lines[0], *got.Lines[0])
+			assert.Equal(t, *order.Lines[1], *got.Lines[1])
+		}
+	}
+
+	_, err = s.GetOrder(ctx, 100)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	_, err = s.GetOrderByCartID(ctx, 100)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+}
+
+func testTransactions(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+
+	// the writes of a transaction are committed together
+	err := s.WithTx(ctx, func(tx service.Storage) error {
+		if err := tx.CreateItem(ctx, &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1, Price: cart.NewMoney(1000, "EUR")}); err != nil {
+			return err
+		}
+		// a nested transaction joins it
+		return tx.WithTx(ctx, func(tx service.Storage) error {
+			return tx.MergeItem(ctx, &cart.Item{CartID: c.ID, ProductID: 2, Quantity: 1, Price: cart.NewMoney(500, "EUR")})
+		})
+	})
+	assert.Nil(t, err)
+
+	items, err := s.ListItemsByCartID(ctx, c.ID)
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+
+	// and rolled back together when the transaction fails
+	err = s.WithTx(ctx, func(tx service.Storage) error {
+		if err := tx.RemoveItemsByCartID(ctx, c.ID); err != nil {
+			return err
+		}
+		if err := tx.CreateCart(ctx, &cart.Cart{UserID: 2, Status: cart.StatusOpen, Currency: "EUR"}); err != nil {
+			return err
+		}
+		order := &cart.Order{CartID: c.ID, UserID: 1, Total: cart.NewMoney(0, "EUR"), Currency: "EUR"}
+		if err := tx.CheckoutCart(ctx, order); err != nil {
+			return err
+		}
+		return assert.AnError
+	})
+	assert.Equal(t, assert.AnError, err)
+
+	items, err = s.ListItemsByCartID(ctx, c.ID)
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+	_, err = s.GetOpenCartByUserID(ctx, 2)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	got, err := s.GetCart(ctx, 1, c.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, cart.StatusOpen, got.Status)
+	_, err = s.GetOrderByCartID(ctx, c.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+}
+
+func testTransactionsConcurrently(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+
+	// the transactions that read the cart and then add a new product to it do
+	// not interleave, so the product is found by all but the first
+	adds := 10
+	var wg sync.WaitGroup
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.WithTx(ctx, func(tx service.Storage) error {
+				if _, err := tx.GetCart(ctx, 1, c.ID); err != nil {
+					return err
+				}
+				_, err := tx.FindItemByProductID(ctx, c.ID, 1)
+				if err != storage.ErrRecordNotFound {
+					return err
+				}
+				return tx.CreateItem(ctx, &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1, Price: cart.NewMoney(50, "EUR")})
+			})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	items, err := s.ListItemsByCartID(ctx, c.ID)
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+}
+
+func testIdempotencyKeys(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	r := &idempotency.Record{Scope: "user:1", Key: "key-1", RequestHash: "hash-1", ExpiresAt: time.Now().Add(time.Minute)}
+	assert.Nil(t, s.CreateIdempotencyRecord(ctx, r))
+	assert.False(t, r.CreatedAt.IsZero())
+
+	// the key is unique per scope
+	assert.Equal(t, storage.ErrDuplicate, s.CreateIdempotencyRecord(ctx, &idempotency.Record{Scope: "user:1", Key: "key-1", RequestHash: "hash-2", ExpiresAt: time.Now().Add(time.Minute)}))
+	assert.Nil(t, s.CreateIdempotencyRecord(ctx, &idempotency.Record{Scope: "user:2", Key: "key-1", RequestHash: "hash-2", ExpiresAt: time.Now().Add(time.Minute)}))
+
+	got, err := s.GetIdempotencyRecord(ctx, "user:1", "key-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "hash-1", got.RequestHash)
+	assert.False(t, got.Done())
+
+	r.StatusCode = http.StatusCreated
+	r.Header = http.Header{"Content-Type": {"application/json"}, "Etag": {`"1"`}}
+	r.Body = []byte(`{"id":1}`)
+	r.ExpiresAt = time.Now().Add(time.Hour)
+	assert.Nil(t, s.UpdateIdempotencyRecord(ctx, r))
+
+	got, err = s.GetIdempotencyRecord(ctx, "user:1", "key-1")
+	assert.Nil(t, err)
+	assert.True(t, got.Done())
+	assert.Equal(t, http.StatusCreated, got.StatusCode)
+	assert.Equal(t, r.Header, got.Header)
+	assert.Equal(t, r.Body, got.Body)
+	assert.WithinDuration(t, r.ExpiresAt, got.ExpiresAt, time.Second)
+
+	_, err = s.GetIdempotencyRecord(ctx, "user:2", "key-2")
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	assert.Equal(t, storage.ErrRecordNotFound, s.UpdateIdempotencyRecord(ctx, &idempotency.Record{Scope: "user:2", Key: "key-2"}))
+
+	// a removed key can be used again
+	assert.Nil(t, s.RemoveIdempotencyRecord(ctx, "user:1", "key-1"))
+	_, err = s.GetIdempotencyRecord(ctx, "user:1", "key-1")
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	assert.Nil(t, s.CreateIdempotencyRecord(ctx, &idempotency.Record{Scope: "user:1", Key: "key-1", RequestHash: "hash-3", ExpiresAt: time.Now().Add(time.Minute)}))
+
+	// an expired key is not found and can be used again
+	expired := &idempotency.Record{Scope: "user:1", Key: "key-3", RequestHash: "hash-1", ExpiresAt: time.Now().Add(-time.Minute)}
+	assert.Nil(t, s.CreateIdempotencyRecord(ctx, expired))
+	_, err = s.GetIdempotencyRecord(ctx, "user:1", "key-3")
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	expired.ExpiresAt = time.Now().Add(time.Minute)
+	assert.Nil(t, s.CreateIdempotencyRecord(ctx, expired))
+	_, err = s.GetIdempotencyRecord(ctx, "user:1", "key-3")
+	assert.Nil(t, err)
+}
+
+func testOutbox(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	newEvent := func(eventType cart.EventType, cartID int64) *cart.Event {
+		e, err := cart.NewEvent(eventType, &cart.Cart{ID: cartID, UserID: 1}, map[string]int64{"id": cartID})
+		assert.Nil(t, err)
+		return e
+	}
+
+	created := newEvent(cart.EventCartCreated, 1)
+	assert.Nil(t, s.CreateEvent(ctx, created))
+	assert.Equal(t, int64(1), created.ID)
+	assert.Nil(t, s.CreateEvent(ctx, newEvent(cart.EventItemAdded, 1)))
+	assert.Nil(t, s.CreateEvent(ctx, newEvent(cart.EventCartCreated, 2)))
+
+	// the events are written in the transaction of the change
+	err := s.WithTx(ctx, func(tx service.Storage) error {
+		assert.Nil(t, tx.CreateEvent(ctx, newEvent(cart.EventCartCreated, 3)))
+		return assert.AnError
+	})
+	assert.Equal(t, assert.AnError, err)
+
+	// only the first pending message of a cart is due
+	messages, err := s.ClaimDueOutboxMessages(ctx, 10, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, int64(1), messages[0].Event.ID)
+		assert.Equal(t, cart.EventCartCreated, messages[0].Event.Type)
+		assert.Equal(t, int64(1), messages[0].Event.CartID)
+		assert.Equal(t, int64(1), messages[0].Event.UserID)
+		assert.JSONEq(t, `{"id":1}`, string(messages[0].Event.Data))
+		assert.Equal(t, outbox.StatusPending, messages[0].Status)
+		assert.Equal(t, 0, messages[0].Attempts)
+		assert.Equal(t, int64(3), messages[1].Event.ID)
+	}
+
+	messages, err = s.ClaimDueOutboxMessages(ctx, 1, time.Minute)
+	assert.Nil(t, err)
+	if !assert.Len(t, messages, 1) {
+		return
+	}
+	first := messages[0]
+	assert.Equal(t, int64(1), first.Event.ID)
+
+	// a claimed message is not due for the other dispatchers until it is
+	// updated, nor are the next events of its cart
+	messages, err = s.ClaimDueOutboxMessages(ctx, 10, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, int64(3), messages[0].Event.ID)
+	}
+
+	// once it is delivered the next event of the cart is due
+	first.Status = outbox.StatusDelivered
+	first.Attempts = 1
+	assert.Nil(t, s.UpdateOutboxMessage(ctx, first))
+	assert.False(t, first.UpdatedAt.IsZero())
+
+	messages, err = s.ClaimDueOutboxMessages(ctx, 10, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, int64(2), messages[0].Event.ID)
+		assert.Equal(t, int64(3), messages[1].Event.ID)
+	}
+
+	// a failed event is retried later, the next events of its cart wait for it
+	failed := messages[0]
+	failed.Attempts = 1
+	failed.LastError = "webhook: unexpected status 500"
+	failed.NextAttemptAt = time.Now().Add(time.Hour)
+	assert.Nil(t, s.UpdateOutboxMessage(ctx, failed))
+	assert.Nil(t, s.CreateEvent(ctx, newEvent(cart.EventCartEmptied, 1)))
+
+	messages, err = s.ClaimDueOutboxMessages(ctx, 10, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, int64(3), messages[0].Event.ID)
+	}
+
+	// a dead-lettered event does not hold its cart back
+	failed.Status = outbox.StatusDead
+	assert.Nil(t, s.UpdateOutboxMessage(ctx, failed))
+
+	messages, err = s.ClaimDueOutboxMessages(ctx, 10, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, int64(3), messages[0].Event.ID)
+		assert.Equal(t, cart.EventCartEmptied, messages[1].Event.Type)
+	}
+
+	assert.Equal(t, storage.ErrRecordNotFound, s.UpdateOutboxMessage(ctx, &outbox.Message{Event: &cart.Event{ID: 100}}))
+}
+
+func testWebhooks(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	acme := &webhook.Subscription{
+		Tenant:     "acme",
+		URL:        "https://acme.example.com/hooks",
+		EventTypes: []cart.EventType{cart.EventCartCreated, cart.EventItemAdded},
+		Secret:     "acme-secret",
+	}
+	assert.Nil(t, s.CreateSubscription(ctx, acme))
+	assert.Equal(t, int64(1), acme.ID)
+	assert.False(t, acme.CreatedAt.IsZero())
+	globex := &webhook.Subscription{Tenant: "globex", URL: "https://globex.example.com", EventTypes: []cart.EventType{cart.EventItemAdded}, Secret: "globex-secret"}
+	assert.Nil(t, s.CreateSubscription(ctx, globex))
+
+	// the subscriptions of a tenant are not found by the others
+	got, err := s.GetSubscription(ctx, "acme", acme.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, acme.URL, got.URL)
+	assert.Equal(t, acme.EventTypes, got.EventTypes)
+	assert.Equal(t, "acme-secret", got.Secret)
+	_, err = s.GetSubscription(ctx, "globex", acme.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+
+	subs, err := s.ListSubscriptions(ctx, "globex")
+	assert.Nil(t, err)
+	if assert.Len(t, subs, 1) {
+		assert.Equal(t, globex.ID, subs[0].ID)
+	}
+
+	// the event types are matched whole, of all the tenants
+	for eventType, expected := range map[cart.EventType][]int64{
+		cart.EventItemAdded:   {acme.ID, globex.ID},
+		cart.EventCartCreated: {acme.ID},
+		cart.EventCartEmptied: {},
+		"Added":               {},
+	} {
+		subs, err := s.ListSubscriptionsByEventType(ctx, eventType)
+		assert.Nil(t, err)
+		ids := []int64{}
+		for _, sub := range subs {
+			ids = append(ids, sub.ID)
+		}
+		assert.Equal(t, expected, ids, eventType)
+	}
+
+	// an event is delivered once per subscription
+	event, err := cart.NewEvent(cart.EventItemAdded, &cart.Cart{ID: 3, UserID: 1}, map[string]int64{"id": 5})
+	assert.Nil(t, err)
+	event.ID = 7
+	first := &webhook.Delivery{SubscriptionID: acme.ID, Event: event, Status: outbox.StatusPending, NextAttemptAt: time.Now()}
+	assert.Nil(t, s.CreateDelivery(ctx, first))
+	assert.Equal(t, int64(1), first.ID)
+	assert.Equal(t, storage.ErrDuplicate, s.CreateDelivery(ctx, &webhook.Delivery{SubscriptionID: acme.ID, Event: event, Status: outbox.StatusPending, NextAttemptAt: time.Now()}))
+	assert.Nil(t, s.CreateDelivery(ctx, &webhook.Delivery{SubscriptionID: globex.ID, Event: event, Status: outbox.StatusPending, NextAttemptAt: time.Now()}))
+
+	// the due deliveries come with their subscriptions
+	deliveries, err := s.ClaimDueDeliveries(ctx, 10, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, deliveries, 2) {
+		assert.Equal(t, first.ID, deliveries[0].ID)
+		assert.Equal(t, int64(7), deliveries[0].Event.ID)
+		assert.Equal(t, cart.EventItemAdded, deliveries[0].Event.Type)
+		assert.Equal(t, int64(3), deliveries[0].Event.CartID)
+		assert.JSONEq(t, `{"id":5}`, string(deliveries[0].Event.Data))
+		assert.Equal(t, outbox.StatusPending, deliveries[0].Status)
+		assert.Equal(t, acme.URL, deliveries[0].Subscription.URL)
+		assert.Equal(t, "acme-secret", deliveries[0].Subscription.Secret)
+		assert.Equal(t, "globex-secret", deliveries[1].Subscription.Secret)
+	}
+
+	// a failed delivery is due after its backoff
+	first.Attempts = 1
+	first.ResponseStatus = 500
+	first.LastError = "webhook: unexpected status 500"
+	first.NextAttemptAt = time.Now().Add(time.Hour)
+	assert.Nil(t, s.UpdateDelivery(ctx, first))
+	deliveries, err = s.ClaimDueDeliveries(ctx, 10, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, globex.ID, deliveries[0].SubscriptionID)
+	}
+
+	d, err := s.GetDelivery(ctx, acme.ID, first.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, d.Attempts)
+	assert.Equal(t, 500, d.ResponseStatus)
+	assert.Equal(t, "webhook: unexpected status 500", d.LastError)
+	assert.WithinDuration(t, first.NextAttemptAt, d.NextAttemptAt, time.Second)
+	_, err = s.GetDelivery(ctx, globex.ID, first.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	assert.Equal(t, storage.ErrRecordNotFound, s.UpdateDelivery(ctx, &webhook.Delivery{ID: 100}))
+
+	// the log of a subscription is the newest first
+	event.ID = 8
+	assert.Nil(t, s.CreateDelivery(ctx, &webhook.Delivery{SubscriptionID: acme.ID, Event: event, Status: outbox.StatusPending, NextAttemptAt: time.Now()}))
+	deliveries, err = s.ListDeliveries(ctx, acme.ID, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, deliveries, 2) {
+		assert.Equal(t, int64(8), deliveries[0].Event.ID)
+		assert.Equal(t, int64(7), deliveries[1].Event.ID)
+	}
+	deliveries, err = s.ListDeliveries(ctx, acme.ID, 1)
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+
+	// a subscription is removed by its tenant along with its deliveries
+	assert.Equal(t, storage.ErrRecordNotFound, s.RemoveSubscription(ctx, "globex", acme.ID))
+	assert.Nil(t, s.RemoveSubscription(ctx, "acme", acme.ID))
+	_, err = s.GetSubscription(ctx, "acme", acme.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	_, err = s.GetDelivery(ctx, acme.ID, first.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	deliveries, err = s.ClaimDueDeliveries(ctx, 10, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, globex.ID, deliveries[0].SubscriptionID)
+	}
+
+	// a claimed delivery is not due for the other dispatchers until it is updated
+	deliveries, err = s.ClaimDueDeliveries(ctx, 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+	deliveries, err = s.ClaimDueDeliveries(ctx, 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func testTruncate(t *testing.T, s Storage) {
+	ctx := context.TODO()
+
+	c := &cart.Cart{UserID: 1, Status: cart.StatusOpen, Currency: "EUR"}
+	assert.Nil(t, s.CreateCart(ctx, c))
+	assert.Nil(t, s.CreateItem(ctx, &cart.Item{CartID: c.ID, ProductID: 1, Quantity: 1, Price: cart.NewMoney(100, "EUR")}))
+	assert.Nil(t, s.CheckoutCart(ctx, &cart.Order{CartID: c.ID, UserID: 1, Total: cart.NewMoney(100, "EUR")}))
+
+	assert.Nil(t, s.CreateIdempotencyRecord(ctx, &idempotency.Record{Scope: "user:1", Key: "key-1", RequestHash: "hash-1", ExpiresAt: time.Now().Add(time.Minute)}))
+	event := &cart.Event{Type: cart.EventCartCreated, CartID: c.ID, Data: []byte(`{}`), OccurredAt: time.Now()}
+	assert.Nil(t, s.CreateEvent(ctx, event))
+	sub := &webhook.Subscription{Tenant: "acme", URL: "https://example.com", EventTypes: []cart.EventType{cart.EventCartCreated}, Secret: "secret"}
+	assert.Nil(t, s.CreateSubscription(ctx, sub))
+	assert.Nil(t, s.CreateDelivery(ctx, &webhook.Delivery{SubscriptionID: sub.ID, Event: event, Status: outbox.StatusPending, NextAttemptAt: time.Now()}))
+
+	assert.Nil(t, s.TruncateAllTables())
+
+	_, err := s.GetCart(ctx, 1, c.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	_, err = s.GetOrderByCartID(ctx, c.ID)
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	items, err := s.ListItemsByCartID(ctx, c.ID)
+	assert.Nil(t, err)
+	assert.Empty(t, items)
+	_, err = s.GetIdempotencyRecord(ctx, "user:1", "key-1")
+	assert.Equal(t, storage.ErrRecordNotFound, err)
+	messages, err := s.ClaimDueOutboxMessages(ctx, 10, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+	subs, err := s.ListSubscriptions(ctx, "acme")
+	assert.Nil(t, err)
+	assert.Empty(t, subs)
+	deliveries, err := s.ClaimDueDeliveries(ctx, 10, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, deliveries)
+}
@@ -1,11 +1,17 @@
 package tests_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
 	"github.com/cubny/cart/internal/tests"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -20,7 +26,15 @@ func TestCreateCart_Integration(t *testing.T) {
 			Method:         http.MethodPost,
 			Target:         "/carts",
 			AccessKey:      "abcdef123456",
-			ExpectedBody:   `{"id":1, "user_id":1}`,
+			ExpectedBody:   `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`,
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "user has an open cart - returns the open cart",
+			Method:         http.MethodPost,
+			Target:         "/carts",
+			AccessKey:      "abcdef123456",
+			ExpectedBody:   `{"id":1, "user_id":1, "status":"open", "currency":"EUR"}`,
 			ExpectedStatus: http.StatusCreated,
 		},
 		{
@@ -57,6 +71,79 @@ func TestAddItems_Integration(t *testing.T) {
 			ExpectedBody:   `{"cart_id":` + strconv.Itoa(int(cartID)) + `, "id":1, "price":100, "product_id":1, "quantity":1}`,
 			ExpectedStatus: http.StatusCreated,
 		},
+		{
+			Name:           "price is set by the catalog",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"product_id":3, "quantity":2, "price": 0.01}`,
+			ExpectedBody:   `{"cart_id":` + strconv.Itoa(int(cartID)) + `, "id":2, "price":24, "product_id":3, "quantity":2}`,
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "product is not purchasable - error",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"product_id":8, "quantity":1}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - product is not purchasable"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "product does not exist - error",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"product_id":100, "quantity":1}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - product does not exist"}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:           "quantity too large - error",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"product_id":4, "quantity":92233720368547758}`,
+			ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - the fields of the request are invalid", "violations":[{"field":"quantity", "rule":"max", "message":"quantity must be at most 10000"}]}}`,
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, test := range testsCases {
+		test := test
+		tests.HandlerTest(t, a, &test)
+	}
+}
+
+func TestAddItemsMerge_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	userID := int64(1)
+	cartID, _ := testDB.Seed1Cart(userID)
+	itemID, _ := testDB.Seed1Item(userID, cartID)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:           "product already in cart - error",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"product_id":1, "quantity":1, "price": 100.00}`,
+			ExpectedBody:   `{"error":{"code":100400, "details":"Bad Request - an item with the same product exists in the cart"}}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:      "product already in cart with merge - merged",
+			Method:    http.MethodPost,
+			Target:    fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey: "abcdef123456",
+			ReqBody:   `{"product_id":1, "quantity":2, "price": 200.00, "merge": true}`,
+			ExpectedBody: `{"cart_id":` + strconv.Itoa(int(cartID)) + `, "id":` + strconv.Itoa(int(itemID)) +
+				`, "price":300, "product_id":1, "quantity":3}`,
+			ExpectedStatus: http.StatusCreated,
+		},
 	}
 
 	for _, test := range testsCases {
@@ -65,6 +152,42 @@ func TestAddItems_Integration(t *testing.T) {
 	}
 }
 
+func TestAddItemsMergeConcurrently_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	userID := int64(1)
+	cartID, _ := testDB.Seed1Cart(userID)
+
+	adds := 20
+	var wg sync.WaitGroup
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/carts/%d/items?merge=true", cartID),
+				strings.NewReader(`{"product_id":1, "quantity":1, "price": 10.00}`))
+			auth.AddKeyToRequest(req, "abcdef123456")
+			rec := httptest.NewRecorder()
+			a.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		}()
+	}
+	wg.Wait()
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/carts/%d", cartID), nil)
+	auth.AddKeyToRequest(req, "abcdef123456")
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	details := &cart.Details{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(details))
+	assert.Equal(t, int64(1), details.ItemCount)
+	assert.Equal(t, int64(adds), details.TotalQuantity)
+}
+
 func TestRemoveItems_Integration(t *testing.T) {
 	if testing.Short() {
 		t.Skip()
@@ -91,6 +214,61 @@ func TestRemoveItems_Integration(t *testing.T) {
 	}
 }
 
+func TestUpdateItem_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	// create an item
+	userID := int64(1)
+	cartID, _ := testDB.Seed1Cart(userID)
+	itemID, _ := testDB.Seed1Item(userID, cartID)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:      "ok",
+			Method:    http.MethodPatch,
+			Target:    fmt.Sprintf("/items/%d", itemID),
+			AccessKey: "abcdef123456",
+			ReqBody:   `{"quantity":3}`,
+			ExpectedBody: `{"cart_id":` + strconv.Itoa(int(cartID)) + `, "id":` + strconv.Itoa(int(itemID)) +
+				`, "price":300, "product_id":1, "quantity":3}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "item of another user - not found",
+			Method:         http.MethodPatch,
+			Target:         fmt.Sprintf("/items/%d", itemID),
+			AccessKey:      "bcdefg123456",
+			ReqBody:        `{"quantity":3}`,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "quantity 0 - removes the item",
+			Method:         http.MethodPatch,
+			Target:         fmt.Sprintf("/items/%d", itemID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"quantity":0}`,
+			ExpectedStatus: http.StatusNoContent,
+		},
+		{
+			Name:           "removed item - not found",
+			Method:         http.MethodPatch,
+			Target:         fmt.Sprintf("/items/%d", itemID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"quantity":1}`,
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - item does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range testsCases {
+		test := test
+		tests.HandlerTest(t, a, &test)
+	}
+}
+
 func TestEmptyCart_Integration(t *testing.T) {
 	if testing.Short() {
 		t.Skip()
@@ -117,3 +295,191 @@ func TestEmptyCart_Integration(t *testing.T) {
 		tests.HandlerTest(t, a, &test)
 	}
 }
+
+func TestGetCart_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	// create a cart with items
+	userID := int64(1)
+	cartID, _ := testDB.Seed1Cart(userID)
+	err := testDB.Seed5Items(userID, cartID)
+	assert.Nil(t, err)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:           "ok",
+			Method:         http.MethodGet,
+			Target:         fmt.Sprintf("/carts/%d", cartID),
+			AccessKey:      "abcdef123456",
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "cart of another user - not found",
+			Method:         http.MethodGet,
+			Target:         fmt.Sprintf("/carts/%d", cartID),
+			AccessKey:      "bcdefg123456",
+			ExpectedBody:   `{"error":{"code":100404, "details":"Not found - cart does not exist"}}`,
+			ExpectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range testsCases {
+		test := test
+		tests.HandlerTest(t, a, &test)
+	}
+}
+
+func TestChangeCartStatus_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	userID := int64(1)
+	cartID, _ := testDB.Seed1Cart(userID)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:           "abandon - ok",
+			Method:         http.MethodPatch,
+			Target:         fmt.Sprintf("/carts/%d", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"status":"abandoned"}`,
+			ExpectedBody:   `{"id":` + strconv.Itoa(int(cartID)) + `, "user_id":1, "status":"abandoned", "currency":"EUR"}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "add item to abandoned cart - conflict",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"product_id":1, "quantity":1, "price": 100.00}`,
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart is not open"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+		{
+			Name:           "empty abandoned cart - conflict",
+			Method:         http.MethodDelete,
+			Target:         fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey:      "abcdef123456",
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart is not open"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+		{
+			Name:           "reopen - ok",
+			Method:         http.MethodPatch,
+			Target:         fmt.Sprintf("/carts/%d", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"status":"open"}`,
+			ExpectedBody:   `{"id":` + strconv.Itoa(int(cartID)) + `, "user_id":1, "status":"open", "currency":"EUR"}`,
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "reopen an open cart - conflict",
+			Method:         http.MethodPatch,
+			Target:         fmt.Sprintf("/carts/%d", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"status":"open"}`,
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart cannot move to the requested status"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, test := range testsCases {
+		test := test
+		tests.HandlerTest(t, a, &test)
+	}
+}
+
+func TestCheckout_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	userID := int64(1)
+	emptyCartID, _ := testDB.Seed1Cart(userID)
+	tests.HandlerTest(t, a, &tests.TestCase{
+		Name:           "empty cart - invalid params",
+		Method:         http.MethodPost,
+		Target:         fmt.Sprintf("/carts/%d/checkout", emptyCartID),
+		AccessKey:      "abcdef123456",
+		ExpectedBody:   `{"error":{"code":100422, "details":"Invalid params - cart has no items"}}`,
+		ExpectedStatus: http.StatusUnprocessableEntity,
+	})
+
+	cartID, _ := testDB.Seed1Cart(userID)
+	err := testDB.Seed5Items(userID, cartID)
+	assert.Nil(t, err)
+
+	testsCases := []tests.TestCase{
+		{
+			Name:           "ok",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/checkout", cartID),
+			AccessKey:      "abcdef123456",
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "checkout again - returns the same order",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/checkout", cartID),
+			AccessKey:      "abcdef123456",
+			ExpectedStatus: http.StatusCreated,
+		},
+		{
+			Name:           "add item to checked out cart - conflict",
+			Method:         http.MethodPost,
+			Target:         fmt.Sprintf("/carts/%d/items", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"product_id":6, "quantity":1, "price": 100.00}`,
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart is not open"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+		{
+			Name:           "reopen checked out cart - conflict",
+			Method:         http.MethodPatch,
+			Target:         fmt.Sprintf("/carts/%d", cartID),
+			AccessKey:      "abcdef123456",
+			ReqBody:        `{"status":"open"}`,
+			ExpectedBody:   `{"error":{"code":100409, "details":"Conflict - cart cannot move to the requested status"}}`,
+			ExpectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, test := range testsCases {
+		test := test
+		tests.HandlerTest(t, a, &test)
+	}
+}
+
+func TestCartSubtotalIsExact_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	userID := int64(1)
+	cartID, _ := testDB.Seed1Cart(userID)
+
+	// the sample catalog prices product 6 at 0.10 and product 7 at 0.20
+	for _, productID := range []int64{6, 7} {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/carts/%d/items", cartID),
+			strings.NewReader(fmt.Sprintf(`{"product_id":%d, "quantity":1}`, productID)))
+		auth.AddKeyToRequest(req, "abcdef123456")
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/carts/%d", cartID), nil)
+	auth.AddKeyToRequest(req, "abcdef123456")
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	body := &struct {
+		Subtotal json.RawMessage `json:"subtotal"`
+	}{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(body))
+	assert.Equal(t, "0.30", string(body.Subtotal))
+}
@@ -0,0 +1,130 @@
+package tests_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// guestRequest serves the request of a guest holding the cart token, a user
+// request is made when the access key is given too
+func guestRequest(method, target, body, cartToken, accessKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if cartToken != "" {
+		auth.AddCartTokenToRequest(req, cartToken)
+	}
+	if accessKey != "" {
+		auth.AddKeyToRequest(req, accessKey)
+	}
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec
+}
+
+// newGuestCart creates a guest cart with the items and returns the cart and its token
+func newGuestCart(t *testing.T, items ...string) (*cart.Cart, string) {
+	t.Helper()
+
+	rec := guestRequest(http.MethodPost, "/carts", "", "", "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	c := &cart.Cart{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(c))
+	assert.True(t, c.IsGuest())
+
+	token := rec.Header().Get(auth.CartTokenHeader)
+	assert.NotEmpty(t, token)
+
+	for _, item := range items {
+		rec := guestRequest(http.MethodPost, fmt.Sprintf("/carts/%d/items", c.ID), item, token, "")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	return c, token
+}
+
+func TestGuestCart_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	c, token := newGuestCart(t, `{"product_id":1, "quantity":1}`)
+
+	// the guest holding the token of an open cart gets it back
+	rec := guestRequest(http.MethodPost, "/carts", "", token, "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"id":%d, "status":"open", "currency":"EUR"}`, c.ID), rec.Body.String())
+
+	rec = guestRequest(http.MethodGet, fmt.Sprintf("/carts/%d", c.ID), "", token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	details := &cart.Details{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(details))
+	assert.Equal(t, int64(1), details.ItemCount)
+
+	// the token is of one cart only
+	other, otherToken := newGuestCart(t)
+	rec = guestRequest(http.MethodGet, fmt.Sprintf("/carts/%d", c.ID), "", otherToken, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = guestRequest(http.MethodPost, fmt.Sprintf("/carts/%d/items", c.ID), `{"product_id":2, "quantity":1}`, otherToken, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// and no user has access to a guest cart
+	rec = guestRequest(http.MethodGet, fmt.Sprintf("/carts/%d", other.ID), "", "", "abcdef123456")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// the guests cannot check out
+	rec = guestRequest(http.MethodPost, fmt.Sprintf("/carts/%d/checkout", c.ID), "", token, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestClaimCart_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	// the user has no open cart, the guest cart becomes the user's
+	first, firstToken := newGuestCart(t, `{"product_id":1, "quantity":2}`)
+	rec := guestRequest(http.MethodPost, fmt.Sprintf("/carts/%d/claim", first.ID), "", firstToken, "cdefgh123456")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	details := &cart.Details{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(details))
+	assert.Equal(t, first.ID, details.ID)
+	assert.Equal(t, int64(20), details.UserID)
+	assert.Equal(t, int64(2), details.TotalQuantity)
+
+	// the cart is not a guest cart anymore
+	rec = guestRequest(http.MethodGet, fmt.Sprintf("/carts/%d", first.ID), "", firstToken, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = guestRequest(http.MethodPost, fmt.Sprintf("/carts/%d/claim", first.ID), "", firstToken, "abcdef123456")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// the user has an open cart, the items of the guest cart are merged into it
+	second, secondToken := newGuestCart(t, `{"product_id":1, "quantity":3}`, `{"product_id":2, "quantity":1}`)
+	rec = guestRequest(http.MethodPost, fmt.Sprintf("/carts/%d/claim", second.ID), `{"merge_rule":"max"}`, secondToken, "cdefgh123456")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	details = &cart.Details{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(details))
+	assert.Equal(t, first.ID, details.ID)
+	assert.Equal(t, int64(2), details.ItemCount)
+	assert.Equal(t, int64(4), details.TotalQuantity)
+	assert.Equal(t, "310.00 EUR", details.Subtotal.String())
+
+	// the guest cart is left empty and abandoned
+	rec = guestRequest(http.MethodPost, "/carts", "", secondToken, "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	c := &cart.Cart{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(c))
+	assert.NotEqual(t, second.ID, c.ID)
+	assert.NotEmpty(t, rec.Header().Get(auth.CartTokenHeader))
+}
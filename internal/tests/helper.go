@@ -4,12 +4,17 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http/httptest"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/cubny/cart/internal/auth"
 	"github.com/cubny/cart/internal/handler"
+
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // TestCase is meant to be used in test tables for testing http handlers
@@ -26,6 +31,14 @@ type TestCase struct {
 	Method string
 	// accessKey is the auth header value to send the reqeust with
 	AccessKey string
+	// bearerToken is sent in the Authorization header instead of the accessKey when set
+	BearerToken string
+	// cartToken is sent in the X-Cart-Token header when set
+	CartToken string
+	// headers are sent with the request
+	Headers map[string]string
+	// expectedHeaders are the headers the response is expected to have
+	ExpectedHeaders map[string]string
 	// target is the route of the handler to be tested
 	Target string
 }
@@ -36,7 +49,17 @@ func HandlerTest(t *testing.T, h *handler.Handler, tc *TestCase) {
 
 	req := httptest.NewRequest(tc.Method, tc.Target, strings.NewReader(tc.ReqBody))
 
-	auth.AddKeyToRequest(req, tc.AccessKey)
+	if tc.BearerToken != "" {
+		auth.AddTokenToRequest(req, tc.BearerToken)
+	} else {
+		auth.AddKeyToRequest(req, tc.AccessKey)
+	}
+	if tc.CartToken != "" {
+		auth.AddCartTokenToRequest(req, tc.CartToken)
+	}
+	for name, value := range tc.Headers {
+		req.Header.Set(name, value)
+	}
 
 	rec := httptest.NewRecorder()
 	h.ServeHTTP(rec, req)
@@ -55,9 +78,89 @@ func HandlerTest(t *testing.T, h *handler.Handler, tc *TestCase) {
 	}
 
 	assert.Equal(t, tc.ExpectedStatus, resp.StatusCode)
+
+	for name, value := range tc.ExpectedHeaders {
+		assert.Equal(t, value, resp.Header.Get(name))
+	}
 }
 
 func isJSON(str string) bool {
 	var js json.RawMessage
 	return json.Unmarshal([]byte(str), &js) == nil
 }
+
+// MetricValue returns the value of the counter or the gauge, or the number of the
+// observations of the histogram, that has the labels in the default prometheus registry. it is
+// 0 when nothing is recorded with the labels yet
+func MetricValue(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.Nil(t, err)
+
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			matched := 0
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v == l.GetValue() {
+					matched++
+				}
+			}
+			if matched != len(labels) {
+				continue
+			}
+			switch {
+			case m.GetHistogram() != nil:
+				return float64(m.GetHistogram().GetSampleCount())
+			case m.GetGauge() != nil:
+				return m.GetGauge().GetValue()
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+
+	return 0
+}
+
+// SpanTree returns the names of the spans of the trace as a tree, the children
+// are indented under their parents in the order they started, e.g.
+//
+//	GET /carts/:cartID
+//	  service.CartDetails
+//	    sqlite3.GetCart
+func SpanTree(spans tracetest.SpanStubs, traceID trace.TraceID) []string {
+	children := map[trace.SpanID][]tracetest.SpanStub{}
+	ids := map[trace.SpanID]bool{}
+	for _, s := range spans {
+		if s.SpanContext.TraceID() == traceID {
+			ids[s.SpanContext.SpanID()] = true
+		}
+	}
+	// the spans whose parents are not in the trace are the roots
+	var roots []tracetest.SpanStub
+	for _, s := range spans {
+		switch {
+		case s.SpanContext.TraceID() != traceID:
+		case ids[s.Parent.SpanID()]:
+			children[s.Parent.SpanID()] = append(children[s.Parent.SpanID()], s)
+		default:
+			roots = append(roots, s)
+		}
+	}
+
+	tree := []string{}
+	var walk func(spans []tracetest.SpanStub, depth int)
+	walk = func(spans []tracetest.SpanStub, depth int) {
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].StartTime.Before(spans[j].StartTime) })
+		for _, s := range spans {
+			tree = append(tree, strings.Repeat("  ", depth)+s.Name)
+			walk(children[s.SpanContext.SpanID()], depth+1)
+		}
+	}
+	walk(roots, 0)
+
+	return tree
+}
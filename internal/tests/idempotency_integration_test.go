@@ -0,0 +1,65 @@
+package tests_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/handler"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// idempotentRequest serves the request of a guest made with the Idempotency-Key
+func idempotentRequest(method, target, body, cartToken, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if cartToken != "" {
+		auth.AddCartTokenToRequest(req, cartToken)
+	}
+	req.Header.Set(handler.IdempotencyKeyHeader, key)
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyKey_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	// the guests without a cart are anonymous, a key of one is not replayed to
+	// another, nor is the token of its cart
+	rec := idempotentRequest(http.MethodPost, "/carts", "", "", "new-cart-5b0b4c4e")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	token := rec.Header().Get(auth.CartTokenHeader)
+	assert.NotEmpty(t, token)
+
+	rec = idempotentRequest(http.MethodPost, "/carts", "", "", "new-cart-5b0b4c4e")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get(handler.IdempotentReplayedHeader))
+	assert.NotEmpty(t, rec.Header().Get(auth.CartTokenHeader))
+	assert.NotEqual(t, token, rec.Header().Get(auth.CartTokenHeader))
+
+	cartID, err := cartTokens.VerifyCartToken(token)
+	assert.Nil(t, err)
+	itemsURL := fmt.Sprintf("/carts/%d/items", cartID)
+
+	// the retry of the item is not refused as a duplicate
+	rec = idempotentRequest(http.MethodPost, itemsURL, `{"product_id":1, "quantity":1}`, token, "add-item-1")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = idempotentRequest(http.MethodPost, itemsURL, `{"product_id":1, "quantity":1}`, token, "add-item-1")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get(handler.IdempotentReplayedHeader))
+
+	rec = guestRequest(http.MethodGet, fmt.Sprintf("/carts/%d", cartID), "", token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"total_quantity":1`)
+
+	// the key is of the first item only
+	rec = idempotentRequest(http.MethodPost, itemsURL, `{"product_id":2, "quantity":1}`, token, "add-item-1")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
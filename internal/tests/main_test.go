@@ -1,17 +1,27 @@
 package tests_test
 
 import (
+	"encoding/json"
 	"flag"
 	"github.com/cubny/cart/internal/tests/testdb"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/cubny/cart/internal/auth"
 	"github.com/cubny/cart/internal/handler"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/product"
 	"github.com/cubny/cart/internal/service"
+	"github.com/cubny/cart/internal/storage/memory"
+	"github.com/cubny/cart/internal/storage/postgres"
 	"github.com/cubny/cart/internal/storage/sqlite3"
+	"github.com/cubny/cart/internal/tracing"
+	"github.com/cubny/cart/internal/webhook"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var (
@@ -21,6 +31,27 @@ var (
 
 	// testDB is a helper to manipulate the database such as migrations, seeding, etc.
 	testDB *testdb.TestDB
+
+	// cartTokens signs the tokens of the guest carts
+	cartTokens = auth.NewCartTokens([]byte("test-secret"))
+
+	// testOutbox is the outbox of the test database
+	testOutbox outbox.Storage
+
+	// testWebhooks is the webhook service the events are published to
+	testWebhooks *webhook.Service
+
+	// testWebhookStorage is the storage of the webhook deliveries of the test database
+	testWebhookStorage webhook.Storage
+
+	// spans keeps the spans of the requests
+	spans *tracetest.InMemoryExporter
+
+	// testBackend is the storage backend the tests run against, sqlite3, postgres or memory
+	testBackend = "sqlite3"
+
+	// adminKeys are the admin keys of the tenants
+	adminKeys = auth.NewAdminKeys(map[string]string{"acme": "acme-admin-key", "globex": "globex-admin-key"})
 )
 
 func TestMain(m *testing.M) {
@@ -30,6 +61,8 @@ func TestMain(m *testing.M) {
 
 func testMain(m *testing.M) int {
 	if !testing.Short() { // if it's an integration test, then setup everything
+		spans = tracing.NewInMemoryExporter()
+
 		log.Info("setting up test db")
 		db, err := setupDatabase()
 		if err != nil {
@@ -38,20 +71,38 @@ func testMain(m *testing.M) int {
 		}
 		defer db.Close()
 
-		service, err := service.New(db)
+		service, err := service.New(db, product.New())
 		if err != nil {
 			log.WithError(err).Info("cannot instantiate cart service")
 			return 1
 		}
 
-		authClient := auth.New()
-		a, err = handler.New(service, authClient)
+		authServer := newAuthServer()
+		defer authServer.Close()
+
+		authClient := auth.NewHTTPClient(authServer.URL, auth.DefaultOptions)
+		tokenVerifier, err := newTokenVerifier()
+		if err != nil {
+			log.WithError(err).Infof("cannot instantiate token verifier, %s", err)
+			return 1
+		}
+
+		testWebhooks = webhook.New(db)
+		a, err = handler.New(service, authClient, handler.Options{
+			TokenVerifier:   tokenVerifier,
+			CartTokens:      cartTokens,
+			IdempotencyKeys: db,
+			Webhooks:        testWebhooks,
+			AdminKeys:       adminKeys,
+		})
 		if err != nil {
 			log.WithError(err).Infof("cannot instantiate handler, %s", err)
 			return 1
 		}
 
 		testDB = testdb.New(db, service)
+		testOutbox = db
+		testWebhookStorage = db
 		if err := testDB.Refresh(); err != nil {
 			log.WithError(err).Infof("cannot refresh db, %s", err)
 			return 1
@@ -60,7 +111,28 @@ func testMain(m *testing.M) int {
 	return m.Run()
 }
 
-func setupDatabase() (*sqlite3.Sqlite3, error) {
+// storage is a backend the integration tests can run against
+type storage interface {
+	service.Storage
+	handler.IdempotencyStore
+	outbox.Storage
+	webhook.Storage
+	testdb.Storage
+}
+
+// setupDatabase creates a new sqlite3 test database, the tests run against the
+// postgres database of CART_TEST_POSTGRES_DSN instead when it is set, or in
+// memory when CART_TEST_STORAGE is memory
+func setupDatabase() (storage, error) {
+	if dsn := os.Getenv("CART_TEST_POSTGRES_DSN"); dsn != "" {
+		testBackend = "postgres"
+		return postgres.New(dsn)
+	}
+	if os.Getenv("CART_TEST_STORAGE") == "memory" {
+		testBackend = "memory"
+		return memory.New(), nil
+	}
+
 	dbfile, err := os.Create("test.db")
 	if err != nil {
 		return nil, err
@@ -72,3 +144,18 @@ func setupDatabase() (*sqlite3.Sqlite3, error) {
 
 	return db, nil
 }
+
+// newAuthServer starts a local stand-in of the auth service which knows the
+// sample keys of the stub auth client
+func newAuthServer() *httptest.Server {
+	stub := auth.New()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ak, err := stub.VerifyKey(r.Context(), auth.KeyFromClientRequest(r))
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": ak.ID, "key": ak.Key, "user_id": ak.UserID})
+	}))
+}
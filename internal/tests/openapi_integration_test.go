@@ -0,0 +1,284 @@
+package tests_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/jsonerror"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/webhook"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/stretchr/testify/assert"
+)
+
+// conformance serves the requests by the test handler and checks them and their
+// responses against the OpenAPI document the handler serves
+type conformance struct {
+	t      *testing.T
+	doc    *openapi3.T
+	router routers.Router
+	// served are the operations of the document the requests are served by, e.g. GET /carts/{cartID}
+	served map[string]bool
+}
+
+func init() {
+	// the Swagger UI page is read as it is
+	openapi3filter.RegisterBodyDecoder("text/html", openapi3filter.FileBodyDecoder)
+}
+
+func newConformance(t *testing.T) *conformance {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		t.FailNow()
+	}
+
+	doc, err := openapi3.NewLoader().LoadFromData(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &conformance{t: t, doc: doc, router: router, served: map[string]bool{}}
+}
+
+// request is a request of the test, its headers are set when they are not empty
+type request struct {
+	method, target, body string
+	accessKey, token     string
+	cartToken, adminKey  string
+	headers              map[string]string
+	// invalid is true when the request is made to break the document on purpose,
+	// the document must reject it too
+	invalid bool
+}
+
+// serve serves the request and checks it and its response against the document
+func (c *conformance) serve(r request) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	req := httptest.NewRequest(r.method, "http://localhost"+r.target, strings.NewReader(r.body))
+	if r.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case r.accessKey != "":
+		auth.AddKeyToRequest(req, r.accessKey)
+	case r.token != "":
+		auth.AddTokenToRequest(req, r.token)
+	case r.adminKey != "":
+		auth.AddAdminKeyToRequest(req, r.adminKey)
+	}
+	if r.cartToken != "" {
+		auth.AddCartTokenToRequest(req, r.cartToken)
+	}
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+
+	route, params, err := c.router.FindRoute(req)
+	if err != nil {
+		c.t.Fatalf("%s %s is not in the document: %s", r.method, r.target, err)
+	}
+	c.served[route.Method+" "+route.Path] = true
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req.Clone(context.TODO()),
+		PathParams: params,
+		Route:      route,
+		Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	input.Request.Body = ioutil.NopCloser(strings.NewReader(r.body))
+	err = openapi3filter.ValidateRequest(context.TODO(), input)
+	if r.invalid {
+		assert.NotNil(c.t, err, "the document accepts %s %s %s", r.method, r.target, r.body)
+	} else {
+		assert.Nil(c.t, err, "%s %s %s", r.method, r.target, r.body)
+	}
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	err = openapi3filter.ValidateResponse(context.TODO(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 rec.Code,
+		Header:                 rec.Header(),
+		Body:                   ioutil.NopCloser(bytes.NewReader(rec.Body.Bytes())),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	assert.Nil(c.t, err, "the response %d to %s %s: %s", rec.Code, r.method, r.target, rec.Body.String())
+	return rec
+}
+
+// decode decodes the body of the response
+func (c *conformance) decode(rec *httptest.ResponseRecorder, v interface{}) {
+	c.t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		c.t.Fatalf("cannot decode %s: %s", rec.Body.String(), err)
+	}
+}
+
+func TestOpenAPI_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	c := newConformance(t)
+	problem := map[string]string{"Accept": jsonerror.ProblemContentType}
+	token := issueToken(t, map[string]interface{}{
+		"sub": "24",
+		"aud": "cart",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	// the service
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: "/health"}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: "/openapi.json"}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: "/docs"}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: "/problems"}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: "/problems/cart-not-found"}).Code)
+	assert.Equal(t, http.StatusNotFound, c.serve(request{method: http.MethodGet, target: "/problems/no-such-problem", headers: problem}).Code)
+
+	// the cart of a user
+	rec := c.serve(request{method: http.MethodPost, target: "/carts", token: token, headers: map[string]string{"Idempotency-Key": "openapi-cart"}})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	userCart := &cart.Cart{}
+	c.decode(rec, userCart)
+	assert.Equal(t, http.StatusUnauthorized, c.serve(request{method: http.MethodPost, target: "/carts", accessKey: "incorrect"}).Code)
+
+	rec = c.serve(request{method: http.MethodGet, target: fmt.Sprintf("/carts/%d", userCart.ID), token: token})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	assert.Equal(t, http.StatusNotFound, c.serve(request{method: http.MethodGet, target: "/carts/1000000", token: token}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, c.serve(request{method: http.MethodGet, target: "/carts/abc", token: token, headers: problem, invalid: true}).Code)
+
+	// its items
+	itemsTarget := fmt.Sprintf("/carts/%d/items", userCart.ID)
+	rec = c.serve(request{method: http.MethodPost, target: itemsTarget, body: `{"product_id":1, "quantity":2}`, token: token, headers: map[string]string{"If-Match": etag}})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	item := &cart.Item{}
+	c.decode(rec, item)
+	rec = c.serve(request{method: http.MethodPost, target: itemsTarget, body: `{"product_id":2, "quantity":1, "price":"10.50"}`, token: token})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	other := &cart.Item{}
+	c.decode(rec, other)
+	assert.Equal(t, http.StatusCreated, c.serve(request{method: http.MethodPost, target: itemsTarget + "?merge=true", body: `{"product_id":1, "quantity":1}`, token: token}).Code)
+
+	assert.Equal(t, http.StatusBadRequest, c.serve(request{method: http.MethodPost, target: itemsTarget, body: `{"product_id":1, "quantity":1}`, token: token}).Code)
+	assert.Equal(t, http.StatusBadRequest, c.serve(request{method: http.MethodPost, target: itemsTarget, body: `{"product_id":`, token: token, headers: problem, invalid: true}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, c.serve(request{method: http.MethodPost, target: itemsTarget, body: `{"quantity":0, "zoo":1}`, token: token, invalid: true}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, c.serve(request{method: http.MethodPost, target: itemsTarget, body: `{"quantity":0}`, token: token, headers: problem, invalid: true}).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, c.serve(request{method: http.MethodPost, target: itemsTarget, body: `{"product_id":3, "quantity":1}`, token: token, headers: map[string]string{"If-Match": etag}}).Code)
+
+	rec = c.serve(request{method: http.MethodPatch, target: fmt.Sprintf("/items/%d", item.ID), body: `{"quantity":5}`, token: token})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusNoContent, c.serve(request{method: http.MethodPatch, target: fmt.Sprintf("/items/%d", other.ID), body: `{"quantity":0}`, token: token}).Code)
+	assert.Equal(t, http.StatusNotFound, c.serve(request{method: http.MethodDelete, target: fmt.Sprintf("/items/%d", other.ID), token: token, headers: problem}).Code)
+	assert.Equal(t, http.StatusNoContent, c.serve(request{method: http.MethodDelete, target: fmt.Sprintf("/items/%d", item.ID), token: token}).Code)
+	assert.Equal(t, http.StatusCreated, c.serve(request{method: http.MethodPost, target: itemsTarget, body: `{"product_id":1, "quantity":1}`, token: token}).Code)
+
+	// its checkout
+	assert.Equal(t, http.StatusUnprocessableEntity, c.serve(request{method: http.MethodPatch, target: fmt.Sprintf("/carts/%d", userCart.ID), body: `{"status":"paid"}`, token: token, invalid: true}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, c.serve(request{method: http.MethodPatch, target: fmt.Sprintf("/carts/%d", userCart.ID), body: `{"status":"checked_out"}`, token: token, headers: problem}).Code)
+	rec = c.serve(request{method: http.MethodPost, target: fmt.Sprintf("/carts/%d/checkout", userCart.ID), token: token})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	order := &cart.Order{}
+	c.decode(rec, order)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: fmt.Sprintf("/orders/%d", order.ID), token: token}).Code)
+	assert.Equal(t, http.StatusNotFound, c.serve(request{method: http.MethodGet, target: fmt.Sprintf("/orders/%d", order.ID), accessKey: "abcdef123456"}).Code)
+	assert.Equal(t, http.StatusConflict, c.serve(request{method: http.MethodDelete, target: itemsTarget, token: token, headers: problem}).Code)
+
+	// a cart that is emptied and abandoned
+	rec = c.serve(request{method: http.MethodPost, target: "/carts", token: token})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	abandoned := &cart.Cart{}
+	c.decode(rec, abandoned)
+	assert.Equal(t, http.StatusCreated, c.serve(request{method: http.MethodPost, target: fmt.Sprintf("/carts/%d/items", abandoned.ID), body: `{"product_id":1, "quantity":1}`, token: token}).Code)
+	assert.Equal(t, http.StatusNoContent, c.serve(request{method: http.MethodDelete, target: fmt.Sprintf("/carts/%d/items", abandoned.ID), token: token}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodPatch, target: fmt.Sprintf("/carts/%d", abandoned.ID), body: `{"status":"abandoned"}`, token: token}).Code)
+	assert.Equal(t, http.StatusConflict, c.serve(request{method: http.MethodPatch, target: fmt.Sprintf("/carts/%d", userCart.ID), body: `{"status":"abandoned"}`, token: token}).Code)
+
+	// the cart of a guest, claimed by the user
+	rec = c.serve(request{method: http.MethodPost, target: "/carts"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	guestCart := &cart.Cart{}
+	c.decode(rec, guestCart)
+	cartToken := rec.Header().Get(auth.CartTokenHeader)
+	assert.Equal(t, http.StatusCreated, c.serve(request{method: http.MethodPost, target: fmt.Sprintf("/carts/%d/items", guestCart.ID), body: `{"product_id":1, "quantity":2}`, cartToken: cartToken}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: fmt.Sprintf("/carts/%d", guestCart.ID), cartToken: cartToken}).Code)
+	assert.Equal(t, http.StatusUnauthorized, c.serve(request{method: http.MethodPost, target: fmt.Sprintf("/carts/%d/checkout", guestCart.ID), cartToken: cartToken, headers: problem}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, c.serve(request{method: http.MethodPost, target: fmt.Sprintf("/carts/%d/claim", guestCart.ID), body: `{"merge_rule":"oldest"}`, token: token, cartToken: cartToken, invalid: true}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodPost, target: fmt.Sprintf("/carts/%d/claim", guestCart.ID), body: `{"merge_rule":"max"}`, token: token, cartToken: cartToken}).Code)
+
+	// the webhooks of a tenant
+	rec = c.serve(request{method: http.MethodPost, target: "/admin/webhooks", body: `{"url":"http://localhost/events", "event_types":["ItemAdded"]}`, adminKey: "acme-admin-key"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	sub := &webhook.Subscription{}
+	c.decode(rec, sub)
+	assert.Equal(t, http.StatusUnprocessableEntity, c.serve(request{method: http.MethodPost, target: "/admin/webhooks", body: `{"url":"http://localhost/events"}`, adminKey: "acme-admin-key", invalid: true}).Code)
+	assert.Equal(t, http.StatusUnauthorized, c.serve(request{method: http.MethodGet, target: "/admin/webhooks", adminKey: "wrong-key"}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: "/admin/webhooks", adminKey: "acme-admin-key"}).Code)
+	assert.Equal(t, http.StatusOK, c.serve(request{method: http.MethodGet, target: fmt.Sprintf("/admin/webhooks/%d", sub.ID), adminKey: "acme-admin-key"}).Code)
+	assert.Equal(t, http.StatusNotFound, c.serve(request{method: http.MethodGet, target: fmt.Sprintf("/admin/webhooks/%d", sub.ID), adminKey: "globex-admin-key"}).Code)
+
+	assert.Equal(t, http.StatusCreated, c.serve(request{method: http.MethodPost, target: fmt.Sprintf("/carts/%d/items", guestCart.ID), body: `{"product_id":2, "quantity":1}`, token: token}).Code)
+	events := outbox.NewDispatcher(testOutbox, testWebhooks, outbox.DefaultOptions)
+	for {
+		published, err := events.Dispatch(context.TODO())
+		assert.Nil(t, err)
+		if published == 0 {
+			break
+		}
+	}
+
+	rec = c.serve(request{method: http.MethodGet, target: fmt.Sprintf("/admin/webhooks/%d/deliveries?limit=10", sub.ID), adminKey: "acme-admin-key"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	deliveries := []*webhook.Delivery{}
+	c.decode(rec, &deliveries)
+	if assert.NotEmpty(t, deliveries) {
+		target := fmt.Sprintf("/admin/webhooks/%d/deliveries/%d/redeliver", sub.ID, deliveries[0].ID)
+		assert.Equal(t, http.StatusAccepted, c.serve(request{method: http.MethodPost, target: target, adminKey: "acme-admin-key"}).Code)
+	}
+	assert.Equal(t, http.StatusUnprocessableEntity, c.serve(request{method: http.MethodGet, target: fmt.Sprintf("/admin/webhooks/%d/deliveries?limit=1000", sub.ID), adminKey: "acme-admin-key", invalid: true}).Code)
+	assert.Equal(t, http.StatusNotFound, c.serve(request{method: http.MethodPost, target: fmt.Sprintf("/admin/webhooks/%d/deliveries/1000000/redeliver", sub.ID), adminKey: "acme-admin-key"}).Code)
+	assert.Equal(t, http.StatusNoContent, c.serve(request{method: http.MethodDelete, target: fmt.Sprintf("/admin/webhooks/%d", sub.ID), adminKey: "acme-admin-key"}).Code)
+	assert.Equal(t, http.StatusNotFound, c.serve(request{method: http.MethodDelete, target: fmt.Sprintf("/admin/webhooks/%d", sub.ID), adminKey: "acme-admin-key"}).Code)
+
+	// every route of the handler is in the document and is served above, the
+	// document has no operation the handler does not serve
+	param := regexp.MustCompile(`:(\w+)`)
+	routes := map[string]bool{}
+	for _, route := range a.Routes() {
+		operation := route.Method + " " + param.ReplaceAllString(route.Path, "{$1}")
+		routes[operation] = true
+		assert.True(t, c.served[operation], "%s is not served by the test", operation)
+	}
+	for path, item := range c.doc.Paths.Map() {
+		for method := range item.Operations() {
+			assert.True(t, routes[method+" "+path], "%s %s of the document is not a route", method, path)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package tests_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	var (
+		mu       sync.Mutex
+		received []*cart.Event
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &cart.Event{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(e))
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, e)
+	}))
+	defer webhook.Close()
+
+	c, token := newGuestCart(t, `{"product_id":1, "quantity":1}`)
+	rec := guestRequest(http.MethodDelete, fmt.Sprintf("/carts/%d/items", c.ID), "", token, "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// a refused change has no event
+	rec = guestRequest(http.MethodPost, fmt.Sprintf("/carts/%d/items", c.ID), `{"product_id":404, "quantity":1}`, token, "")
+	assert.NotEqual(t, http.StatusCreated, rec.Code)
+
+	dispatcher := outbox.NewDispatcher(testOutbox, outbox.NewWebhookSink(webhook.URL, nil), outbox.DefaultOptions)
+	for {
+		delivered, err := dispatcher.Dispatch(context.TODO())
+		assert.Nil(t, err)
+		if delivered == 0 {
+			break
+		}
+	}
+
+	// the events of the cart are delivered in order
+	types := []cart.EventType{}
+	for _, e := range received {
+		if e.CartID == c.ID {
+			types = append(types, e.Type)
+		}
+	}
+	assert.Equal(t, []cart.EventType{cart.EventCartCreated, cart.EventItemAdded, cart.EventCartEmptied}, types)
+}
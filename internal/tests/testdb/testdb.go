@@ -36,11 +36,22 @@ func (t *TestDB) Refresh() error {
 	return nil
 }
 
+// Seed1Cart creates a new open cart for the user, the cart the user already
+// has open is abandoned so that tests do not share the same cart
 func (t *TestDB) Seed1Cart(userID int64) (int64, error) {
 	c, err := t.service.CreateCart(context.TODO(), userID)
 	if err != nil {
 		return 0, err
 	}
+
+	if _, err := t.service.ChangeCartStatus(context.TODO(), userID, c.ID, cart.StatusAbandoned); err != nil {
+		return 0, err
+	}
+
+	c, err = t.service.CreateCart(context.TODO(), userID)
+	if err != nil {
+		return 0, err
+	}
 	return c.ID, err
 }
 
@@ -49,9 +60,8 @@ func (t *TestDB) Seed1Item(userID, cartID int64) (int64, error) {
 		ProductID: 1,
 		CartID:    cartID,
 		Quantity:  1,
-		Price:     cart.Price(100.00),
 	}
-	if err := t.service.AddItem(context.TODO(), userID, item); err != nil {
+	if _, err := t.service.AddItem(context.TODO(), userID, item, false); err != nil {
 		return 0, err
 	}
 
@@ -65,9 +75,8 @@ func (t *TestDB) Seed5Items(userID, cartID int64) error {
 			ProductID: int64(i),
 			CartID:    cartID,
 			Quantity:  1,
-			Price:     cart.Price(100.00),
 		}
-		if err := t.service.AddItem(context.TODO(), userID, item); err != nil {
+		if _, err := t.service.AddItem(context.TODO(), userID, item, false); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,138 @@
+package tests_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// issuerKey signs the bearer tokens of the integration tests, it plays the role
+// of the key of an OIDC issuer
+var issuerKey *rsa.PrivateKey
+
+// newTokenVerifier creates the issuer key and a verifier which reads it from a
+// JWKS file, like the -jwksFile flag does
+func newTokenVerifier() (*auth.JWTVerifier, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	issuerKey = key
+
+	doc, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(doc); err != nil {
+		return nil, err
+	}
+
+	jwks, err := auth.LoadJWKSFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	opts := auth.DefaultJWTOptions
+	opts.Audience = "cart"
+	return auth.NewJWTVerifier(jwks, opts), nil
+}
+
+// issueToken creates a RS256 token signed by the issuer key
+func issueToken(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	h := crypto.SHA256.New()
+	h.Write([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, issuerKey, crypto.SHA256, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestBearerToken_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	testsCases := []tests.TestCase{
+		{
+			Name:   "expired token - error",
+			Method: http.MethodPost,
+			Target: "/carts",
+			BearerToken: issueToken(t, map[string]interface{}{
+				"sub": "42",
+				"aud": "cart",
+				"exp": time.Now().Add(-time.Hour).Unix(),
+			}),
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:   "token of another audience - error",
+			Method: http.MethodPost,
+			Target: "/carts",
+			BearerToken: issueToken(t, map[string]interface{}{
+				"sub": "42",
+				"aud": "billing",
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}),
+			ExpectedBody:   `{"error":{"code":100401, "details": "Unauthorised access - incorrect access_key"}}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range testsCases {
+		tests.HandlerTest(t, a, &test)
+	}
+
+	// the user of the token owns the cart
+	req := httptest.NewRequest(http.MethodPost, "/carts", nil)
+	auth.AddTokenToRequest(req, issueToken(t, map[string]interface{}{
+		"sub": "42",
+		"aud": "cart",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	c := &cart.Cart{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(c))
+	assert.Equal(t, int64(42), c.UserID)
+}
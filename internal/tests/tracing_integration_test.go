@@ -0,0 +1,50 @@
+package tests_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/tests"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	c, token := newGuestCart(t, `{"product_id":1, "quantity":1}`)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/carts/%d", c.ID), nil)
+	auth.AddCartTokenToRequest(req, token)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the request continues the trace of the caller through the service and the storage
+	traceID, err := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	assert.Nil(t, err)
+	expected := []string{
+		"GET /carts/:cartID",
+		"  service.CartDetails",
+	}
+	if testBackend != "memory" {
+		expected = append(expected,
+			"    "+testBackend+".GetCart",
+			"    "+testBackend+".ListItemsByCartID",
+		)
+	}
+	assert.Equal(t, expected, tests.SpanTree(spans.GetSpans(), traceID))
+
+	for _, s := range spans.GetSpans() {
+		if s.SpanContext.TraceID() == traceID && s.Name == "GET /carts/:cartID" {
+			assert.Equal(t, "b7ad6b7169203331", s.Parent.SpanID().String())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tests_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cubny/cart/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// conditionalRequest serves the request of a guest that expects the cart at the
+// version of the entity tag
+func conditionalRequest(method, target, body, cartToken, ifMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	auth.AddCartTokenToRequest(req, cartToken)
+	req.Header.Set("If-Match", ifMatch)
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCartVersion_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	c, token := newGuestCart(t)
+	cartURL := fmt.Sprintf("/carts/%d", c.ID)
+
+	rec := guestRequest(http.MethodGet, cartURL, "", token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	assert.Equal(t, `"1"`, etag)
+
+	// the first tab adds an item to the cart it read, and learns the new version
+	rec = conditionalRequest(http.MethodPost, cartURL+"/items", `{"product_id":1, "quantity":1}`, token, etag)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+
+	// the second tab read the cart before that, its change is refused
+	rec = conditionalRequest(http.MethodDelete, cartURL+"/items", "", token, etag)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	rec = guestRequest(http.MethodGet, cartURL, "", token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), `"item_count":1`)
+
+	// once it reads the cart again it can change it
+	rec = conditionalRequest(http.MethodDelete, cartURL+"/items", "", token, rec.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+
+	rec = guestRequest(http.MethodGet, cartURL, "", token, "")
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), `"item_count":0`)
+
+	// a list of the versions the tabs read, weak or strong, matches any of them
+	rec = conditionalRequest(http.MethodPost, cartURL+"/items", `{"product_id":2, "quantity":1}`, token, `"1", W/"3"`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, `"4"`, rec.Header().Get("ETag"))
+}
@@ -0,0 +1,151 @@
+package tests_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/auth"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/webhook"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// adminRequest serves the request of the admin of a tenant
+func adminRequest(method, target, body, adminKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if adminKey != "" {
+		auth.AddAdminKeyToRequest(req, adminKey)
+	}
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhook_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	var (
+		mu       sync.Mutex
+		secret   string
+		cartID   int64
+		failures = 1
+		received []*cart.Event
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Nil(t, webhook.VerifySignature(secret, r.Header.Get(webhook.SignatureHeader), body, time.Now(), webhook.DefaultSignatureTolerance))
+
+		e := &cart.Event{}
+		assert.Nil(t, json.Unmarshal(body, e))
+		if e.CartID == cartID && failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received = append(received, e)
+	}))
+	defer receiver.Close()
+
+	// the admin of a tenant subscribes to the events
+	rec := adminRequest(http.MethodPost, "/admin/webhooks", "", "wrong-key")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = adminRequest(http.MethodPost, "/admin/webhooks", fmt.Sprintf(`{"url":%q, "event_types":["CartCreated","ItemAdded"]}`, receiver.URL), "acme-admin-key")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	sub := &webhook.Subscription{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(sub))
+	assert.NotEmpty(t, sub.Secret)
+	mu.Lock()
+	secret = sub.Secret
+	mu.Unlock()
+
+	// which the other tenants do not see
+	rec = adminRequest(http.MethodGet, fmt.Sprintf("/admin/webhooks/%d", sub.ID), "", "globex-admin-key")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = adminRequest(http.MethodGet, fmt.Sprintf("/admin/webhooks/%d", sub.ID), "", "acme-admin-key")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), sub.Secret)
+
+	c, token := newGuestCart(t)
+	mu.Lock()
+	cartID = c.ID
+	mu.Unlock()
+	rec = guestRequest(http.MethodPost, fmt.Sprintf("/carts/%d/items", c.ID), `{"product_id":1, "quantity":1}`, token, "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = guestRequest(http.MethodDelete, fmt.Sprintf("/carts/%d/items", c.ID), "", token, "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// the outbox publishes the events to the subscriptions
+	events := outbox.NewDispatcher(testOutbox, testWebhooks, outbox.DefaultOptions)
+	for {
+		published, err := events.Dispatch(context.TODO())
+		assert.Nil(t, err)
+		if published == 0 {
+			break
+		}
+	}
+
+	// the first delivery fails and waits for the backoff
+	// the receiver is on the loopback, which the default client refuses to dial
+	deliveries := webhook.NewDispatcher(testWebhookStorage, http.DefaultClient, outbox.DefaultOptions)
+	_, err := deliveries.Dispatch(context.TODO())
+	assert.Nil(t, err)
+
+	rec = adminRequest(http.MethodGet, fmt.Sprintf("/admin/webhooks/%d/deliveries", sub.ID), "", "acme-admin-key")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	log := []*webhook.Delivery{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&log))
+
+	var failed *webhook.Delivery
+	for _, d := range log {
+		if d.Event.CartID != c.ID {
+			continue
+		}
+		assert.NotEqual(t, cart.EventCartEmptied, d.Event.Type)
+		if d.Status == outbox.StatusPending {
+			failed = d
+		}
+	}
+	if !assert.NotNil(t, failed) {
+		return
+	}
+	assert.Equal(t, 1, failed.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, failed.ResponseStatus)
+
+	// the redelivered delivery does not wait for the backoff
+	rec = adminRequest(http.MethodPost, fmt.Sprintf("/admin/webhooks/%d/deliveries/%d/redeliver", sub.ID, failed.ID), "", "acme-admin-key")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	_, err = deliveries.Dispatch(context.TODO())
+	assert.Nil(t, err)
+
+	types := []cart.EventType{}
+	mu.Lock()
+	for _, e := range received {
+		if e.CartID == c.ID {
+			types = append(types, e.Type)
+		}
+	}
+	mu.Unlock()
+	assert.ElementsMatch(t, []cart.EventType{cart.EventCartCreated, cart.EventItemAdded}, types)
+
+	rec = adminRequest(http.MethodDelete, fmt.Sprintf("/admin/webhooks/%d", sub.ID), "", "acme-admin-key")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
@@ -0,0 +1,102 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cubny/cart/internal/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceparent is a W3C trace context header of a span of another service
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// spanNamed returns the span of the name
+func spanNamed(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no span %s in %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func TestSetup(t *testing.T) {
+	for _, exporter := range []string{"", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP} {
+		t.Run(exporter, func(t *testing.T) {
+			opts := tracing.DefaultOptions
+			opts.Exporter = exporter
+			opts.Endpoint = "http://localhost:4318"
+			shutdown, err := tracing.Setup(context.TODO(), opts)
+			assert.Nil(t, err)
+			assert.Nil(t, shutdown(context.TODO()))
+		})
+	}
+
+	_, err := tracing.Setup(context.TODO(), tracing.Options{Exporter: "jaeger"})
+	assert.Equal(t, tracing.ErrUnknownExporter, err)
+}
+
+func TestStartServer(t *testing.T) {
+	spans := tracing.NewInMemoryExporter()
+
+	req := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+	req.Header.Set("traceparent", traceparent)
+	ctx, span := tracing.StartServer(req, "/carts/:cartID")
+	_, child := tracing.Start(ctx, "service.CartDetails", attribute.Int64("cart.id", 1))
+	child.End()
+	tracing.EndServer(span, http.StatusInternalServerError)
+
+	server := spanNamed(t, spans.GetSpans(), "GET /carts/:cartID")
+	// the span continues the trace of the caller
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.True(t, server.Parent.IsRemote())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Contains(t, server.Attributes, attribute.String("http.route", "/carts/:cartID"))
+	assert.Contains(t, server.Attributes, attribute.Int("http.response.status_code", http.StatusInternalServerError))
+	assert.Equal(t, codes.Error, server.Status.Code)
+
+	service := spanNamed(t, spans.GetSpans(), "service.CartDetails")
+	assert.Equal(t, server.SpanContext.SpanID(), service.Parent.SpanID())
+	assert.Contains(t, service.Attributes, attribute.Int64("cart.id", 1))
+}
+
+func TestTransport(t *testing.T) {
+	spans := tracing.NewInMemoryExporter()
+
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	ctx, parent := tracing.Start(context.TODO(), "service.AddItem")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/products/1", nil)
+	assert.Nil(t, err)
+	resp, err := (&http.Client{Transport: tracing.Transport(nil)}).Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	parent.End()
+
+	// the request is not changed, its copy carries the context of the span
+	assert.Empty(t, req.Header.Get("traceparent"))
+	client := spanNamed(t, spans.GetSpans(), "HTTP GET")
+	assert.Equal(t, "00-"+client.SpanContext.TraceID().String()+"-"+client.SpanContext.SpanID().String()+"-01", received)
+	assert.Equal(t, parent.SpanContext().SpanID(), client.Parent.SpanID())
+	assert.Equal(t, trace.SpanKindClient, client.SpanKind)
+	assert.Contains(t, client.Attributes, attribute.String("url.path", "/products/1"))
+	assert.Contains(t, client.Attributes, attribute.Int("http.response.status_code", http.StatusNotFound))
+	assert.Equal(t, codes.Unset, client.Status.Code)
+}
@@ -0,0 +1,299 @@
+// Package validation decodes the json bodies of the requests into their types and
+// checks them by the rules declared in the validate tags of the fields, e.g.
+//
+//	type addItemRequest struct {
+//		ProductID *int64 `json:"product_id" validate:"required,min=1"`
+//	}
+//
+// the rules are
+//   - required: the field is in the body and is not zero, nor null. the fields
+//     that can be zero, e.g. a quantity of 0, are pointers
+//   - min=N, max=N: the number, or the decimal such as cart.Money, is at least,
+//     at most, N. the length of a string or a slice is
+//   - oneof=a b c: the string is one of the values
+//
+// N is a number or the name of a limit, see SetLimit.
+// the rules other than required are not checked on the fields that are not in
+// the body. every violation is reported at once, the unknown fields included
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the rules a field can violate
+const (
+	RuleUnknown  = "unknown"
+	RuleType     = "type"
+	RuleRequired = "required"
+	RuleMin      = "min"
+	RuleMax      = "max"
+	RuleOneOf    = "oneof"
+)
+
+// limits are the numbers the rules name, by their names
+var limits = map[string]string{}
+
+// SetLimit names the number n so that the rules can be given it by the name,
+// e.g. max=maxQuantity, a limit that is checked elsewhere too is then declared
+// once. it is not safe to call while the requests are decoded, call it in init
+func SetLimit(name string, n int64) {
+	limits[name] = strconv.FormatInt(n, 10)
+}
+
+// ErrEmptyBody is returned by Decode when the body has no json at all
+var ErrEmptyBody = errors.New("body is empty")
+
+// Violation is a rule a field of a request violates
+type Violation struct {
+	// Field is the json name of the field
+	Field string `json:"field"`
+	// Rule the field violates, e.g. required
+	Rule string `json:"rule"`
+	// Message tells the violation to the people, e.g. quantity must be at least 1
+	Message string `json:"message"`
+}
+
+// Violations of the rules by the fields of a request, in the order of the fields
+type Violations []Violation
+
+func (v Violations) Error() string {
+	messages := make([]string, len(v))
+	for i, violation := range v {
+		messages[i] = violation.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Decode decodes the json object of the body into v, a pointer to a struct, and
+// validates it. the error is Violations if the object has unknown fields, fields
+// of the wrong type or fields that break their rules, ErrEmptyBody if the body
+// is empty and any other error if it is not a json object
+func Decode(body io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return ErrEmptyBody
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+
+	s := reflect.ValueOf(v).Elem()
+	fields := jsonFields(s.Type())
+
+	var violations Violations
+	for name := range object {
+		if _, ok := fields[name]; !ok {
+			violations = append(violations, Violation{Field: name, Rule: RuleUnknown, Message: name + " is not a known field"})
+		}
+	}
+	// the fields are decoded one by one so that every field of the wrong type is reported
+	present, invalid := map[string]bool{}, map[string]bool{}
+	for i := 0; i < s.NumField(); i++ {
+		name, ok := jsonName(s.Type().Field(i))
+		if !ok {
+			continue
+		}
+		raw, ok := object[name]
+		if !ok {
+			continue
+		}
+		present[name] = true
+		if err := json.Unmarshal(raw, s.Field(i).Addr().Interface()); err != nil {
+			violations = append(violations, Violation{Field: name, Rule: RuleType, Message: name + " is not a valid " + typeName(s.Type().Field(i).Type)})
+			invalid[name] = true
+		}
+	}
+	// the unknown fields of a body come in no order
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+
+	violations = append(violations, validate(s, present, invalid)...)
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// validate checks the fields by their rules, the fields of the wrong type are
+// reported already
+func validate(s reflect.Value, present, invalid map[string]bool) Violations {
+	var violations Violations
+	for i := 0; i < s.NumField(); i++ {
+		field := s.Type().Field(i)
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+		tag := field.Tag.Get("validate")
+		if tag == "" || invalid[name] {
+			continue
+		}
+		if v, ok := check(name, s.Field(i), tag, present[name]); !ok {
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// check checks the value of the field by the rules of its tag, the first rule
+// the value violates is reported
+func check(name string, value reflect.Value, tag string, present bool) (Violation, bool) {
+	for _, rule := range strings.Split(tag, ",") {
+		rule, arg := splitRule(rule)
+		if n, ok := limits[arg]; ok {
+			arg = n
+		}
+		if rule == RuleRequired {
+			if !present || value.IsZero() {
+				return Violation{Field: name, Rule: RuleRequired, Message: name + " is required"}, false
+			}
+			continue
+		}
+		if !present {
+			return Violation{}, true
+		}
+
+		value := reflect.Indirect(value)
+		if !value.IsValid() {
+			continue
+		}
+		switch rule {
+		case RuleMin:
+			if compare(value, arg) < 0 {
+				return Violation{Field: name, Rule: RuleMin, Message: fmt.Sprintf("%s must be at least %s", subject(name, value), arg)}, false
+			}
+		case RuleMax:
+			if compare(value, arg) > 0 {
+				return Violation{Field: name, Rule: RuleMax, Message: fmt.Sprintf("%s must be at most %s", subject(name, value), arg)}, false
+			}
+		case RuleOneOf:
+			values := strings.Fields(arg)
+			if value.Kind() != reflect.String || !contains(values, value.String()) {
+				return Violation{Field: name, Rule: RuleOneOf, Message: fmt.Sprintf("%s must be one of %s", name, strings.Join(values, ", "))}, false
+			}
+		default:
+			panic("validation: unknown rule " + rule + " of " + name)
+		}
+	}
+	return Violation{}, true
+}
+
+// decimal is a value compared by its decimal form, e.g. cart.Money
+type decimal interface {
+	Decimal() string
+}
+
+// compare compares the value, or its length, to the number n
+func compare(value reflect.Value, n string) int {
+	limit, ok := new(big.Rat).SetString(n)
+	if !ok {
+		panic("validation: " + n + " is not a number")
+	}
+
+	var x *big.Rat
+	switch {
+	case value.Type().Implements(reflect.TypeOf((*decimal)(nil)).Elem()):
+		x, _ = new(big.Rat).SetString(value.Interface().(decimal).Decimal())
+	case value.Kind() >= reflect.Int && value.Kind() <= reflect.Int64:
+		x = new(big.Rat).SetInt64(value.Int())
+	case value.Kind() >= reflect.Uint && value.Kind() <= reflect.Uint64:
+		x = new(big.Rat).SetUint64(value.Uint())
+	case value.Kind() == reflect.Float32 || value.Kind() == reflect.Float64:
+		x = new(big.Rat).SetFloat64(value.Float())
+	case value.Kind() == reflect.String || value.Kind() == reflect.Slice || value.Kind() == reflect.Map:
+		x = new(big.Rat).SetInt64(int64(value.Len()))
+	}
+	if x == nil {
+		panic("validation: " + value.Type().String() + " cannot be compared")
+	}
+	return x.Cmp(limit)
+}
+
+// subject is what min and max are about, the value or its length
+func subject(name string, value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return "the length of " + name
+	case reflect.Slice, reflect.Map:
+		return "the number of " + name
+	}
+	return name
+}
+
+// jsonFields are the json names of the fields of the struct type
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := jsonName(t.Field(i)); ok {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// jsonName is the name of the field in json, ok is false if the field is not in json
+func jsonName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	}
+	return name, true
+}
+
+// typeName is how the type of a field is told in the violations
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Implements(reflect.TypeOf((*decimal)(nil)).Elem()):
+		return "amount"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+		return "number"
+	case t.Kind() == reflect.Bool:
+		return "boolean"
+	case t.Kind() == reflect.String:
+		return "string"
+	case t.Kind() == reflect.Slice:
+		return "list"
+	}
+	return "value"
+}
+
+func splitRule(rule string) (name, arg string) {
+	parts := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
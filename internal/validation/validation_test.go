@@ -0,0 +1,132 @@
+package validation_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/validation"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	ID       *int64      `json:"id" validate:"required,min=1"`
+	Quantity *int64      `json:"quantity" validate:"required,min=0,max=100"`
+	Name     string      `json:"name" validate:"max=5"`
+	Rule     string      `json:"rule" validate:"oneof=sum max"`
+	Tags     []string    `json:"tags" validate:"required,min=1"`
+	Price    *cart.Money `json:"price" validate:"min=0"`
+	Merge    bool        `json:"merge"`
+	Count    *int64      `json:"count" validate:"max=maxCount"`
+	internal string
+}
+
+func init() {
+	validation.SetLimit("maxCount", 3)
+}
+
+func TestDecode(t *testing.T) {
+	tcs := []struct {
+		name     string
+		body     string
+		expected validation.Violations
+	}{
+		{
+			name: "valid",
+			body: `{"id":1, "quantity":0, "name":"abc", "rule":"sum", "tags":["a"], "price":"10.50", "merge":true}`,
+		},
+		{
+			name: "optional fields are not checked when missing",
+			body: `{"id":1, "quantity":100, "tags":["a"]}`,
+		},
+		{
+			name: "required fields are missing",
+			body: `{}`,
+			expected: validation.Violations{
+				{Field: "id", Rule: "required", Message: "id is required"},
+				{Field: "quantity", Rule: "required", Message: "quantity is required"},
+				{Field: "tags", Rule: "required", Message: "tags is required"},
+			},
+		},
+		{
+			name: "null is missing",
+			body: `{"id":null, "quantity":1, "tags":["a"]}`,
+			expected: validation.Violations{
+				{Field: "id", Rule: "required", Message: "id is required"},
+			},
+		},
+		{
+			name: "ranges",
+			body: `{"id":0, "quantity":101, "name":"abcdef", "tags":[], "price":-0.01}`,
+			expected: validation.Violations{
+				{Field: "id", Rule: "min", Message: "id must be at least 1"},
+				{Field: "quantity", Rule: "max", Message: "quantity must be at most 100"},
+				{Field: "name", Rule: "max", Message: "the length of name must be at most 5"},
+				{Field: "tags", Rule: "min", Message: "the number of tags must be at least 1"},
+				{Field: "price", Rule: "min", Message: "price must be at least 0"},
+			},
+		},
+		{
+			name: "named limit",
+			body: `{"id":1, "quantity":1, "tags":["a"], "count":4}`,
+			expected: validation.Violations{
+				{Field: "count", Rule: "max", Message: "count must be at most 3"},
+			},
+		},
+		{
+			name: "oneof",
+			body: `{"id":1, "quantity":1, "tags":["a"], "rule":"min"}`,
+			expected: validation.Violations{
+				{Field: "rule", Rule: "oneof", Message: "rule must be one of sum, max"},
+			},
+		},
+		{
+			name: "unknown fields and wrong types come first, by their names",
+			body: `{"id":"one", "quantity":1, "tags":["a"], "zoo":1, "internal":"x", "merge":"yes", "price":"ten"}`,
+			expected: validation.Violations{
+				{Field: "id", Rule: "type", Message: "id is not a valid number"},
+				{Field: "internal", Rule: "unknown", Message: "internal is not a known field"},
+				{Field: "merge", Rule: "type", Message: "merge is not a valid boolean"},
+				{Field: "price", Rule: "type", Message: "price is not a valid amount"},
+				{Field: "zoo", Rule: "unknown", Message: "zoo is not a known field"},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validation.Decode(strings.NewReader(tc.body), &request{})
+			if tc.expected == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.Equal(t, tc.expected, err)
+		})
+	}
+}
+
+func TestDecode_Body(t *testing.T) {
+	req := &request{}
+	assert.Nil(t, validation.Decode(strings.NewReader(`{"id":2, "quantity":3, "tags":["a","b"], "price":10.5}`), req))
+	assert.Equal(t, int64(2), *req.ID)
+	assert.Equal(t, int64(3), *req.Quantity)
+	assert.Equal(t, []string{"a", "b"}, req.Tags)
+	assert.Equal(t, cart.NewMoney(1050, cart.DefaultCurrency), *req.Price)
+
+	assert.Equal(t, validation.ErrEmptyBody, validation.Decode(strings.NewReader(" \n"), &request{}))
+
+	for _, body := range []string{`{"id":`, `[1]`, `"id"`} {
+		err := validation.Decode(strings.NewReader(body), &request{})
+		assert.NotNil(t, err, body)
+		_, isViolations := err.(validation.Violations)
+		assert.False(t, isViolations, body)
+	}
+}
+
+func TestViolations_Error(t *testing.T) {
+	v := validation.Violations{
+		{Field: "id", Rule: "required", Message: "id is required"},
+		{Field: "quantity", Rule: "min", Message: "quantity must be at least 1"},
+	}
+	assert.Equal(t, "id is required, quantity must be at least 1", v.Error())
+}
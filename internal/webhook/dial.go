@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/cubny/cart/internal/outbox"
+)
+
+// ErrAddressNotPublic is the error of dialling a webhook whose host resolves to
+// an address that is not public, e.g. loopback, link-local or private
+var ErrAddressNotPublic = errors.New("address of the webhook is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, 100.64.0.0/10, it is not
+// reachable from the internet though netip does not call it private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// newPublicClient creates the client of the deliveries that dials the public
+// addresses only. the addresses are checked once the host is resolved, so that
+// a tenant cannot make the dispatcher post to the hosts of the internal
+// network, by a name that resolves to them or by a redirect either
+func newPublicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   outbox.DefaultWebhookTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   dialPublic,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// the proxy would be dialled instead of the webhook, it is not checked
+	transport.Proxy = nil
+
+	return &http.Client{Timeout: outbox.DefaultWebhookTimeout, Transport: transport}
+}
+
+// dialPublic is the Control of the dialer, it refuses the addresses that are not public
+func dialPublic(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublic(ip) {
+		return fmt.Errorf("%w, %s", ErrAddressNotPublic, ip)
+	}
+
+	return nil
+}
+
+// isPublic reports whether the address is reachable from the internet
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,40 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialPublic(t *testing.T) {
+	tcs := []struct {
+		address string
+		public  bool
+	}{
+		{address: "93.184.216.34:443", public: true},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443", public: true},
+		{address: "127.0.0.1:80"},
+		{address: "[::1]:80"},
+		{address: "0.0.0.0:80"},
+		{address: "10.0.0.1:80"},
+		{address: "172.16.0.1:80"},
+		{address: "192.168.1.1:80"},
+		{address: "169.254.169.254:80"},
+		{address: "100.64.0.1:80"},
+		{address: "[fe80::1]:80"},
+		{address: "[fd00::1]:80"},
+		{address: "[::ffff:127.0.0.1]:80"},
+		{address: "224.0.0.1:80"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.address, func(t *testing.T) {
+			err := dialPublic("tcp", tc.address, nil)
+			if tc.public {
+				assert.Nil(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, ErrAddressNotPublic), err)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cubny/cart/internal/outbox"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	deliveriesCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cart",
+			Name:      "webhook_deliveries_counter",
+			Help:      "Counter of the attempts to deliver the events to the webhooks by result",
+		}, []string{"type", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(deliveriesCount)
+}
+
+// Dispatcher posts the deliveries that are due to the webhooks of their
+// subscriptions, a failed delivery is retried with the backoff of the options
+// and is dead-lettered after MaxAttempts
+type Dispatcher struct {
+	storage    Storage
+	httpClient *http.Client
+	opts       outbox.Options
+}
+
+// NewDispatcher creates a dispatcher of the deliveries of the storage, if
+// httpClient is nil a client with outbox.DefaultWebhookTimeout that dials the
+// public addresses only is used, see ErrAddressNotPublic
+func NewDispatcher(storage Storage, httpClient *http.Client, opts outbox.Options) *Dispatcher {
+	if httpClient == nil {
+		httpClient = newPublicClient()
+	}
+
+	return &Dispatcher{storage: storage, httpClient: httpClient, opts: opts}
+}
+
+// Run posts the deliveries until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		attempted, err := d.Dispatch(ctx)
+		if err != nil {
+			log.WithError(err).Errorf("webhook: dispatch %s", err)
+		}
+
+		// a full batch may be followed by more deliveries that are due
+		if attempted < d.opts.BatchSize || err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Dispatch posts the deliveries that are due once and returns how many were attempted
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	deliveries, err := d.storage.ClaimDueDeliveries(ctx, d.opts.BatchSize, d.opts.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, delivery := range deliveries {
+		delivery.Attempts++
+
+		status, err := d.post(ctx, delivery)
+		delivery.ResponseStatus = status
+		eventType := string(delivery.Event.Type)
+		switch {
+		case err == nil:
+			delivery.Status = outbox.StatusDelivered
+			delivery.LastError = ""
+			deliveriesCount.With(prometheus.Labels{"type": eventType, "result": "delivered"}).Inc()
+		case delivery.Attempts >= d.opts.MaxAttempts:
+			delivery.Status = outbox.StatusDead
+			delivery.LastError = err.Error()
+			log.WithError(err).Errorf("webhook: delivery %d dead-lettered after %d attempts, %s", delivery.ID, delivery.Attempts, err)
+			deliveriesCount.With(prometheus.Labels{"type": eventType, "result": "dead"}).Inc()
+		default:
+			delivery.LastError = err.Error()
+			delivery.NextAttemptAt = time.Now().Add(d.opts.Backoff(delivery.Attempts))
+			log.WithError(err).Warnf("webhook: delivery %d failed at attempt %d, %s", delivery.ID, delivery.Attempts, err)
+			deliveriesCount.With(prometheus.Labels{"type": eventType, "result": "failed"}).Inc()
+		}
+
+		if err := d.storage.UpdateDelivery(ctx, delivery); err != nil {
+			return i + 1, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// post posts the event of the delivery to the webhook, signed with the secret
+// of the subscription. it returns the status the webhook responded with
+func (d *Dispatcher) post(ctx context.Context, delivery *Delivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(delivery.Subscription.Secret, time.Now(), body))
+	req.Header.Set(DeliveryIDHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(EventTypeHeader, string(delivery.Event.Type))
+	req.Header.Set(EventIDHeader, strconv.FormatInt(delivery.Event.ID, 10))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook: request failed, %s", err)
+	}
+	defer resp.Body.Close()
+	// the connection is reused only once the body is read
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
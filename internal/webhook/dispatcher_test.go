@@ -0,0 +1,268 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/storage/memory"
+	"github.com/cubny/cart/internal/webhook"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testOptions = outbox.Options{
+	Interval:    time.Millisecond,
+	BatchSize:   10,
+	MaxAttempts: 3,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  5 * time.Millisecond,
+	Lease:       time.Minute,
+}
+
+// receiver is a webhook that verifies the signatures of the deliveries with
+// the secret, it fails the first failures of them
+type receiver struct {
+	t      *testing.T
+	secret string
+
+	mu       sync.Mutex
+	failures int
+	received []*cart.Event
+	headers  []http.Header
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	assert.Nil(rcv.t, err)
+	assert.Nil(rcv.t, webhook.VerifySignature(rcv.secret, r.Header.Get(webhook.SignatureHeader), body, time.Now(), webhook.DefaultSignatureTolerance))
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	if rcv.failures > 0 {
+		rcv.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	e := &cart.Event{}
+	assert.Nil(rcv.t, json.Unmarshal(body, e))
+	rcv.received = append(rcv.received, e)
+	rcv.headers = append(rcv.headers, r.Header.Clone())
+}
+
+func (rcv *receiver) eventIDs() []int64 {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	ids := []int64{}
+	for _, e := range rcv.received {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+// newReceiver starts a receiver and subscribes it to the event types
+func newReceiver(t *testing.T, s *webhook.Service, failures int, eventTypes ...cart.EventType) (*receiver, *webhook.Subscription, func()) {
+	t.Helper()
+
+	rcv := &receiver{t: t, failures: failures}
+	srv := httptest.NewServer(rcv)
+
+	sub, err := s.CreateSubscription(context.TODO(), "acme", srv.URL, eventTypes)
+	assert.Nil(t, err)
+	rcv.secret = sub.Secret
+
+	return rcv, sub, srv.Close
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	s := webhook.New(db)
+
+	items, itemsSub, stop := newReceiver(t, s, 0, cart.EventItemAdded)
+	defer stop()
+	carts, _, stop := newReceiver(t, s, 0, cart.EventCartCreated, cart.EventItemAdded)
+	defer stop()
+
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 1, cart.EventCartCreated)))
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 2, cart.EventItemAdded)))
+
+	d := webhook.NewDispatcher(db, http.DefaultClient, testOptions)
+	attempted, err := d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempted)
+	attempted, err = d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, attempted)
+
+	assert.Equal(t, []int64{2}, items.eventIDs())
+	assert.Equal(t, []int64{1, 2}, carts.eventIDs())
+	if assert.Len(t, items.headers, 1) {
+		assert.Equal(t, "application/json", items.headers[0].Get("Content-Type"))
+		assert.Equal(t, "ItemAdded", items.headers[0].Get(webhook.EventTypeHeader))
+		assert.Equal(t, "2", items.headers[0].Get(webhook.EventIDHeader))
+	}
+
+	// the delivery log tells the result
+	deliveries, err := s.ListDeliveries(ctx, "acme", itemsSub.ID, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, outbox.StatusDelivered, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusOK, deliveries[0].ResponseStatus)
+		assert.Equal(t, strconv.FormatInt(deliveries[0].ID, 10), items.headers[0].Get(webhook.DeliveryIDHeader))
+	}
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	s := webhook.New(db)
+
+	rcv, sub, stop := newReceiver(t, s, 2, cart.EventItemAdded)
+	defer stop()
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 1, cart.EventItemAdded)))
+
+	d := webhook.NewDispatcher(db, http.DefaultClient, testOptions)
+	_, err := d.Dispatch(ctx)
+	assert.Nil(t, err)
+
+	// the failed delivery waits for the backoff
+	deliveries, err := s.ListDeliveries(ctx, "acme", sub.ID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, outbox.StatusPending, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].ResponseStatus)
+	assert.Equal(t, "webhook: unexpected status 503", deliveries[0].LastError)
+	assert.True(t, deliveries[0].NextAttemptAt.After(time.Now().Add(-time.Millisecond)))
+
+	assert.Eventually(t, func() bool {
+		_, err := d.Dispatch(ctx)
+		assert.Nil(t, err)
+		return len(rcv.eventIDs()) == 1
+	}, time.Second, time.Millisecond)
+
+	deliveries, err = s.ListDeliveries(ctx, "acme", sub.ID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, outbox.StatusDelivered, deliveries[0].Status)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+	assert.Equal(t, "", deliveries[0].LastError)
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	s := webhook.New(db)
+
+	rcv, sub, stop := newReceiver(t, s, 3, cart.EventItemAdded)
+	defer stop()
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 1, cart.EventItemAdded)))
+
+	d := webhook.NewDispatcher(db, http.DefaultClient, testOptions)
+	for i := 0; i < 3; i++ {
+		_, err := d.Dispatch(ctx)
+		assert.Nil(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the delivery is dead-lettered after MaxAttempts
+	deliveries, err := s.ListDeliveries(ctx, "acme", sub.ID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, outbox.StatusDead, deliveries[0].Status)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+	attempted, err := d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, attempted)
+
+	// until it is redelivered
+	_, err = s.Redeliver(ctx, "acme", sub.ID, deliveries[0].ID)
+	assert.Nil(t, err)
+	attempted, err = d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, attempted)
+	assert.Equal(t, []int64{1}, rcv.eventIDs())
+}
+
+func TestDispatcher_NotPublic(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	s := webhook.New(db)
+
+	// the receiver is on the loopback, the default client does not dial it
+	rcv, sub, stop := newReceiver(t, s, 0, cart.EventItemAdded)
+	defer stop()
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 1, cart.EventItemAdded)))
+
+	d := webhook.NewDispatcher(db, nil, testOptions)
+	attempted, err := d.Dispatch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, attempted)
+	assert.Empty(t, rcv.eventIDs())
+
+	deliveries, err := s.ListDeliveries(ctx, "acme", sub.ID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, outbox.StatusPending, deliveries[0].Status)
+	assert.Contains(t, deliveries[0].LastError, webhook.ErrAddressNotPublic.Error())
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	db := memory.New()
+	s := webhook.New(db)
+
+	rcv, _, stop := newReceiver(t, s, 1, cart.EventItemAdded)
+	defer stop()
+	for id := int64(1); id <= 3; id++ {
+		assert.Nil(t, s.Publish(context.TODO(), newEvent(t, id, cart.EventItemAdded)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		webhook.NewDispatcher(db, http.DefaultClient, testOptions).Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return len(rcv.eventIDs()) == 3 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":1}`)
+	header := webhook.Sign("secret", now, body)
+
+	assert.Nil(t, webhook.VerifySignature("secret", header, body, now, time.Minute))
+	assert.Nil(t, webhook.VerifySignature("secret", header, body, now.Add(30*time.Second), time.Minute))
+
+	tcs := []struct {
+		name   string
+		secret string
+		header string
+		body   string
+		now    time.Time
+	}{
+		{name: "other secret", secret: "other", header: header, body: `{"id":1}`, now: now},
+		{name: "other body", secret: "secret", header: header, body: `{"id":2}`, now: now},
+		{name: "too old", secret: "secret", header: header, body: `{"id":1}`, now: now.Add(2 * time.Minute)},
+		{name: "no timestamp", secret: "secret", header: header[len("t=1234567890,"):], body: `{"id":1}`, now: now},
+		{name: "malformed", secret: "secret", header: "v1", body: `{"id":1}`, now: now},
+		{name: "empty", secret: "secret", body: `{"id":1}`, now: now},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, webhook.ErrInvalidSignature, webhook.VerifySignature(tc.secret, tc.header, []byte(tc.body), tc.now, time.Minute))
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the signature of a delivery, t=<unix seconds>,v1=<hex hmac>
+	SignatureHeader = "X-Cart-Signature"
+	// DeliveryIDHeader carries the id of the delivery
+	DeliveryIDHeader = "X-Cart-Delivery-ID"
+	// EventTypeHeader carries the type of the event
+	EventTypeHeader = "X-Cart-Event-Type"
+	// EventIDHeader carries the id of the event, the receivers skip the events
+	// they got already by it
+	EventIDHeader = "X-Cart-Event-ID"
+
+	// DefaultSignatureTolerance is how old a signature a receiver should accept
+	DefaultSignatureTolerance = 5 * time.Minute
+)
+
+var ErrInvalidSignature = errors.New("signature is not valid")
+
+// Sign returns the signature of the body sent at t, it is the HMAC-SHA256 of
+// "<t in unix seconds>.<body>" keyed with the secret of the subscription
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// VerifySignature verifies the signature header of the body, the signatures
+// older than tolerance are refused so that a delivery cannot be replayed later
+func VerifySignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidSignature
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			sig = kv[1]
+		}
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(t, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,242 @@
+// Package webhook pushes the events of the cart changes to the webhooks the
+// tenants subscribe to. the events the outbox publishes to the service are
+// fanned out to a delivery per subscription, which the dispatcher posts to the
+// webhook signed with the secret of the subscription
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/storage"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrDeliveryNotFound     = errors.New("delivery not found")
+	ErrInvalidURL           = errors.New("url must be an absolute http or https url")
+	ErrInvalidEventType     = errors.New("event type is not valid")
+	ErrNoEventTypes         = errors.New("at least one event type is required")
+)
+
+// Subscription is a webhook of a tenant and the types of the events it gets
+type Subscription struct {
+	ID         int64            `json:"id"`
+	Tenant     string           `json:"-"`
+	URL        string           `json:"url"`
+	EventTypes []cart.EventType `json:"event_types"`
+	// Secret signs the deliveries, it is only returned when the subscription is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribed reports whether the subscription gets the events of the type
+func (s *Subscription) Subscribed(eventType cart.EventType) bool {
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delivery is an event to be posted to the webhook of a subscription, along with
+// the result of its last attempt
+type Delivery struct {
+	ID             int64         `json:"id"`
+	SubscriptionID int64         `json:"subscription_id"`
+	Event          *cart.Event   `json:"event"`
+	Status         outbox.Status `json:"status"`
+	Attempts       int           `json:"attempts"`
+	// ResponseStatus is what the webhook responded to the last attempt with, 0
+	// if it did not respond
+	ResponseStatus int       `json:"response_status,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	// Subscription is the one the delivery is posted to, it is only set on
+	// the deliveries that are due
+	Subscription *Subscription `json:"-"`
+}
+
+// Storage provides the subscriptions and their deliveries, the subscriptions
+// of a tenant are not found by the others
+type Storage interface {
+	CreateSubscription(ctx context.Context, s *Subscription) error
+	GetSubscription(ctx context.Context, tenant string, id int64) (*Subscription, error)
+	ListSubscriptions(ctx context.Context, tenant string) ([]*Subscription, error)
+	// ListSubscriptionsByEventType returns the subscriptions of all the tenants to the event type
+	ListSubscriptionsByEventType(ctx context.Context, eventType cart.EventType) ([]*Subscription, error)
+	// RemoveSubscription removes the subscription along with its deliveries
+	RemoveSubscription(ctx context.Context, tenant string, id int64) error
+	// CreateDelivery returns storage.ErrDuplicate if the event is already
+	// delivered to the subscription
+	CreateDelivery(ctx context.Context, d *Delivery) error
+	GetDelivery(ctx context.Context, subscriptionID, id int64) (*Delivery, error)
+	// ListDeliveries returns the latest deliveries of the subscription, the newest first
+	ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]*Delivery, error)
+	// ClaimDueDeliveries returns the pending deliveries that are due along with
+	// their subscriptions, the oldest first, and holds them for the lease: they
+	// are not due for the other dispatchers until the lease has passed or they
+	// are updated
+	ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*Delivery, error)
+	// UpdateDelivery writes the result of the last attempt of the delivery
+	UpdateDelivery(ctx context.Context, d *Delivery) error
+}
+
+// Service manages the subscriptions of the tenants, it is the sink the outbox
+// publishes the events to
+type Service struct {
+	storage Storage
+}
+
+// New creates a new Service
+func New(storage Storage) *Service {
+	return &Service{storage: storage}
+}
+
+// CreateSubscription subscribes the webhook at rawURL to the events of the types,
+// a secret is generated to sign the deliveries with
+func (s *Service) CreateSubscription(ctx context.Context, tenant, rawURL string, eventTypes []cart.EventType) (*Subscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	if len(eventTypes) == 0 {
+		return nil, ErrNoEventTypes
+	}
+
+	sub := &Subscription{Tenant: tenant, URL: u.String()}
+	for _, t := range eventTypes {
+		if !t.Valid() {
+			return nil, ErrInvalidEventType
+		}
+		if !sub.Subscribed(t) {
+			sub.EventTypes = append(sub.EventTypes, t)
+		}
+	}
+
+	sub.Secret, err = newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.CreateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// GetSubscription returns the subscription of the tenant, without its secret
+func (s *Service) GetSubscription(ctx context.Context, tenant string, id int64) (*Subscription, error) {
+	sub, err := s.storage.GetSubscription(ctx, tenant, id)
+	switch {
+	case err == storage.ErrRecordNotFound:
+		return nil, ErrSubscriptionNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	sub.Secret = ""
+	return sub, nil
+}
+
+// ListSubscriptions returns the subscriptions of the tenant, without their secrets
+func (s *Service) ListSubscriptions(ctx context.Context, tenant string) ([]*Subscription, error) {
+	subs, err := s.storage.ListSubscriptions(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sub := range subs {
+		sub.Secret = ""
+	}
+	return subs, nil
+}
+
+// RemoveSubscription unsubscribes the webhook, its pending deliveries are dropped
+func (s *Service) RemoveSubscription(ctx context.Context, tenant string, id int64) error {
+	err := s.storage.RemoveSubscription(ctx, tenant, id)
+	if err == storage.ErrRecordNotFound {
+		return ErrSubscriptionNotFound
+	}
+
+	return err
+}
+
+// ListDeliveries returns the latest deliveries of the subscription of the tenant
+func (s *Service) ListDeliveries(ctx context.Context, tenant string, subscriptionID int64, limit int) ([]*Delivery, error) {
+	if _, err := s.GetSubscription(ctx, tenant, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.ListDeliveries(ctx, subscriptionID, limit)
+}
+
+// Redeliver makes the delivery due right away, whatever it has come to, with
+// all of its attempts ahead of it
+func (s *Service) Redeliver(ctx context.Context, tenant string, subscriptionID, deliveryID int64) (*Delivery, error) {
+	if _, err := s.GetSubscription(ctx, tenant, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	d, err := s.storage.GetDelivery(ctx, subscriptionID, deliveryID)
+	switch {
+	case err == storage.ErrRecordNotFound:
+		return nil, ErrDeliveryNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	d.Status = outbox.StatusPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now()
+	if err := s.storage.UpdateDelivery(ctx, d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Publish fans the event out to a delivery per subscription to its type. it is
+// retried by the outbox if it fails midway, the deliveries made already are not
+// made twice
+func (s *Service) Publish(ctx context.Context, e *cart.Event) error {
+	subs, err := s.storage.ListSubscriptionsByEventType(ctx, e.Type)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		err := s.storage.CreateDelivery(ctx, &Delivery{
+			SubscriptionID: sub.ID,
+			Event:          e,
+			Status:         outbox.StatusPending,
+			NextAttemptAt:  time.Now(),
+		})
+		if err != nil && err != storage.ErrDuplicate {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newSecret generates the secret of a subscription
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,166 @@
+package webhook_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+	"github.com/cubny/cart/internal/outbox"
+	"github.com/cubny/cart/internal/storage/memory"
+	"github.com/cubny/cart/internal/webhook"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newEvent(t *testing.T, id int64, eventType cart.EventType) *cart.Event {
+	t.Helper()
+
+	e, err := cart.NewEvent(eventType, &cart.Cart{ID: 1, UserID: 1}, map[string]int64{"id": id})
+	assert.Nil(t, err)
+	e.ID = id
+	return e
+}
+
+func TestService_CreateSubscription(t *testing.T) {
+	ctx := context.TODO()
+	s := webhook.New(memory.New())
+
+	sub, err := s.CreateSubscription(ctx, "acme", "https://acme.example.com/hooks", []cart.EventType{cart.EventItemAdded, cart.EventCartCreated, cart.EventItemAdded})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), sub.ID)
+	assert.Equal(t, []cart.EventType{cart.EventItemAdded, cart.EventCartCreated}, sub.EventTypes)
+	assert.True(t, strings.HasPrefix(sub.Secret, "whsec_"))
+
+	// the secret is only told once
+	got, err := s.GetSubscription(ctx, "acme", sub.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "", got.Secret)
+	subs, err := s.ListSubscriptions(ctx, "acme")
+	assert.Nil(t, err)
+	if assert.Len(t, subs, 1) {
+		assert.Equal(t, "", subs[0].Secret)
+	}
+
+	// every subscription has a secret of its own
+	other, err := s.CreateSubscription(ctx, "acme", "http://localhost:9000", []cart.EventType{cart.EventCartEmptied})
+	assert.Nil(t, err)
+	assert.NotEqual(t, sub.Secret, other.Secret)
+
+	tcs := []struct {
+		name       string
+		url        string
+		eventTypes []cart.EventType
+		err        error
+	}{
+		{name: "relative url", url: "/hooks", eventTypes: []cart.EventType{cart.EventItemAdded}, err: webhook.ErrInvalidURL},
+		{name: "other scheme", url: "ftp://acme.example.com", eventTypes: []cart.EventType{cart.EventItemAdded}, err: webhook.ErrInvalidURL},
+		{name: "no host", url: "https://", eventTypes: []cart.EventType{cart.EventItemAdded}, err: webhook.ErrInvalidURL},
+		{name: "no event types", url: "https://acme.example.com", err: webhook.ErrNoEventTypes},
+		{name: "unknown event type", url: "https://acme.example.com", eventTypes: []cart.EventType{"CartShipped"}, err: webhook.ErrInvalidEventType},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.CreateSubscription(ctx, "acme", tc.url, tc.eventTypes)
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
+
+func TestService_Tenants(t *testing.T) {
+	ctx := context.TODO()
+	s := webhook.New(memory.New())
+
+	sub, err := s.CreateSubscription(ctx, "acme", "https://acme.example.com", []cart.EventType{cart.EventItemAdded})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 1, cart.EventItemAdded)))
+
+	// the subscriptions of a tenant are not found by the others
+	_, err = s.GetSubscription(ctx, "globex", sub.ID)
+	assert.Equal(t, webhook.ErrSubscriptionNotFound, err)
+	_, err = s.ListDeliveries(ctx, "globex", sub.ID, 10)
+	assert.Equal(t, webhook.ErrSubscriptionNotFound, err)
+	_, err = s.Redeliver(ctx, "globex", sub.ID, 1)
+	assert.Equal(t, webhook.ErrSubscriptionNotFound, err)
+	assert.Equal(t, webhook.ErrSubscriptionNotFound, s.RemoveSubscription(ctx, "globex", sub.ID))
+	subs, err := s.ListSubscriptions(ctx, "globex")
+	assert.Nil(t, err)
+	assert.Empty(t, subs)
+
+	assert.Nil(t, s.RemoveSubscription(ctx, "acme", sub.ID))
+	_, err = s.GetSubscription(ctx, "acme", sub.ID)
+	assert.Equal(t, webhook.ErrSubscriptionNotFound, err)
+}
+
+func TestService_Publish(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	s := webhook.New(db)
+
+	items, err := s.CreateSubscription(ctx, "acme", "https://acme.example.com/items", []cart.EventType{cart.EventItemAdded, cart.EventItemRemoved})
+	assert.Nil(t, err)
+	carts, err := s.CreateSubscription(ctx, "globex", "https://globex.example.com", []cart.EventType{cart.EventCartCreated, cart.EventItemAdded})
+	assert.Nil(t, err)
+
+	// an event is delivered to each subscription to its type
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 1, cart.EventCartCreated)))
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 2, cart.EventItemAdded)))
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 3, cart.EventCartEmptied)))
+	// the outbox retries an event it failed to publish, it is not delivered twice
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 2, cart.EventItemAdded)))
+
+	eventIDs := func(tenant string, subscriptionID int64) []int64 {
+		deliveries, err := s.ListDeliveries(ctx, tenant, subscriptionID, 10)
+		assert.Nil(t, err)
+		ids := []int64{}
+		for _, d := range deliveries {
+			assert.Equal(t, outbox.StatusPending, d.Status)
+			ids = append(ids, d.Event.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int64{2}, eventIDs("acme", items.ID))
+	assert.Equal(t, []int64{2, 1}, eventIDs("globex", carts.ID))
+
+	due, err := db.ClaimDueDeliveries(ctx, 10, 0)
+	assert.Nil(t, err)
+	assert.Len(t, due, 3)
+}
+
+func TestService_Redeliver(t *testing.T) {
+	ctx := context.TODO()
+	db := memory.New()
+	s := webhook.New(db)
+
+	sub, err := s.CreateSubscription(ctx, "acme", "https://acme.example.com", []cart.EventType{cart.EventItemAdded})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Publish(ctx, newEvent(t, 1, cart.EventItemAdded)))
+
+	// the delivery is dead-lettered
+	due, err := db.ClaimDueDeliveries(ctx, 10, 0)
+	assert.Nil(t, err)
+	dead := due[0]
+	dead.Status = outbox.StatusDead
+	dead.Attempts = 15
+	dead.ResponseStatus = 500
+	dead.LastError = "webhook: unexpected status 500"
+	assert.Nil(t, db.UpdateDelivery(ctx, dead))
+	due, err = db.ClaimDueDeliveries(ctx, 10, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, due)
+
+	d, err := s.Redeliver(ctx, "acme", sub.ID, dead.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, outbox.StatusPending, d.Status)
+	assert.Equal(t, 0, d.Attempts)
+	assert.Equal(t, 500, d.ResponseStatus)
+	assert.WithinDuration(t, time.Now(), d.NextAttemptAt, time.Second)
+
+	due, err = db.ClaimDueDeliveries(ctx, 10, 0)
+	assert.Nil(t, err)
+	assert.Len(t, due, 1)
+
+	_, err = s.Redeliver(ctx, "acme", sub.ID, 100)
+	assert.Equal(t, webhook.ErrDeliveryNotFound, err)
+}
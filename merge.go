@@ -0,0 +1,54 @@
+package cart
+
+import "errors"
+
+var ErrInvalidMergeRule = errors.New("merge rule is not valid")
+
+// MergeRule decides the item that results from two items of the same product,
+// e.g. when a guest cart is merged into the cart of a user
+type MergeRule string
+
+const (
+	// MergeSum adds up the quantities of the items
+	MergeSum MergeRule = "sum"
+	// MergeNewest keeps the item that was changed last
+	MergeNewest MergeRule = "newest"
+	// MergeMax keeps the item with the larger quantity
+	MergeMax MergeRule = "max"
+)
+
+// Valid reports whether the rule is a known one
+func (r MergeRule) Valid() bool {
+	switch r {
+	case MergeSum, MergeNewest, MergeMax:
+		return true
+	}
+	return false
+}
+
+// Merge merges other into item, both must be of the same product
+func (r MergeRule) Merge(item, other *Item) error {
+	switch r {
+	case MergeSum:
+		price, err := item.Price.Add(other.Price)
+		if err != nil {
+			return err
+		}
+		item.Quantity += other.Quantity
+		item.Price = price
+	case MergeNewest:
+		if other.UpdatedAt.After(item.UpdatedAt) {
+			item.Quantity = other.Quantity
+			item.Price = other.Price
+		}
+	case MergeMax:
+		if other.Quantity > item.Quantity {
+			item.Quantity = other.Quantity
+			item.Price = other.Price
+		}
+	default:
+		return ErrInvalidMergeRule
+	}
+
+	return nil
+}
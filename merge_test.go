@@ -0,0 +1,78 @@
+package cart_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cubny/cart"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeRule_Merge(t *testing.T) {
+	now := time.Now()
+	older := &cart.Item{ProductID: 1, Quantity: 3, Price: cart.NewMoney(300, "EUR"), UpdatedAt: now.Add(-time.Hour)}
+	newer := &cart.Item{ProductID: 1, Quantity: 1, Price: cart.NewMoney(100, "EUR"), UpdatedAt: now}
+
+	tests := []struct {
+		name          string
+		rule          cart.MergeRule
+		item          cart.Item
+		other         cart.Item
+		expected      cart.Item
+		expectedError error
+	}{
+		{
+			name:     "sum",
+			rule:     cart.MergeSum,
+			item:     *older,
+			other:    *newer,
+			expected: cart.Item{ProductID: 1, Quantity: 4, Price: cart.NewMoney(400, "EUR"), UpdatedAt: older.UpdatedAt},
+		},
+		{
+			name:     "newest keeps the other item when it is newer",
+			rule:     cart.MergeNewest,
+			item:     *older,
+			other:    *newer,
+			expected: cart.Item{ProductID: 1, Quantity: 1, Price: cart.NewMoney(100, "EUR"), UpdatedAt: older.UpdatedAt},
+		},
+		{
+			name:     "newest keeps the item when it is newer",
+			rule:     cart.MergeNewest,
+			item:     *newer,
+			other:    *older,
+			expected: *newer,
+		},
+		{
+			name:     "max keeps the larger quantity",
+			rule:     cart.MergeMax,
+			item:     *newer,
+			other:    *older,
+			expected: cart.Item{ProductID: 1, Quantity: 3, Price: cart.NewMoney(300, "EUR"), UpdatedAt: newer.UpdatedAt},
+		},
+		{
+			name:          "sum of different currencies - ErrCurrencyMismatch",
+			rule:          cart.MergeSum,
+			item:          *older,
+			other:         cart.Item{ProductID: 1, Quantity: 1, Price: cart.NewMoney(100, "USD")},
+			expected:      *older,
+			expectedError: cart.ErrCurrencyMismatch,
+		},
+		{
+			name:          "unknown rule - ErrInvalidMergeRule",
+			rule:          "oldest",
+			item:          *older,
+			other:         *newer,
+			expected:      *older,
+			expectedError: cart.ErrInvalidMergeRule,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.rule.Merge(&test.item, &test.other)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expected, test.item)
+		})
+	}
+}
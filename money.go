@@ -0,0 +1,207 @@
+package cart
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount    = errors.New("amount is not valid")
+	ErrInvalidCurrency  = errors.New("currency is not valid")
+	ErrCurrencyMismatch = errors.New("currencies do not match")
+	ErrAmountOverflow   = errors.New("amount is out of range")
+)
+
+// Currency is an ISO-4217 currency code
+type Currency string
+
+// DefaultCurrency is the currency of amounts that do not specify one, e.g. the
+// plain "price": 10.50 payloads
+const DefaultCurrency Currency = "EUR"
+
+// currencyExponents holds the number of minor unit digits of the supported
+// currencies, e.g. 2 for EUR as 1 EUR is 100 cents
+var currencyExponents = map[Currency]int{
+	"AUD": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"NOK": 2,
+	"PLN": 2,
+	"SEK": 2,
+	"USD": 2,
+}
+
+// Valid reports whether the currency is supported
+func (c Currency) Valid() bool {
+	_, ok := currencyExponents[c]
+	return ok
+}
+
+// Exponent is the number of minor unit digits of the currency
+func (c Currency) Exponent() int {
+	return currencyExponents[c]
+}
+
+// Money is an exact amount of money. the amount is kept in the minor units of
+// the currency, e.g. cents, so sums and products never lose precision the way
+// floats do. the zero value is zero in no currency, it can be added to any amount
+//
+// Money is encoded in json as a plain number, e.g. 10.50, to stay compatible
+// with the clients that send and read prices as numbers
+type Money struct {
+	// Amount in minor units of the currency
+	Amount   int64
+	Currency Currency
+}
+
+// NewMoney creates an amount of money from its minor units
+func NewMoney(amount int64, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney parses a decimal amount such as "10.50" in the given currency.
+// amounts with more decimals than the currency has are rounded half away from
+// zero, e.g. 10.005 EUR is 10.01 EUR
+func ParseMoney(s string, currency Currency) (Money, error) {
+	if !currency.Valid() {
+		return Money{}, ErrInvalidCurrency
+	}
+
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return Money{}, ErrInvalidAmount
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(currency.Exponent())), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+
+	amount := roundHalfAwayFromZero(r.Num(), r.Denom())
+	if !amount.IsInt64() {
+		return Money{}, ErrInvalidAmount
+	}
+
+	return NewMoney(amount.Int64(), currency), nil
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Add returns the sum of the two amounts, both must be in the same currency.
+// the zero value of Money takes the currency of the other amount. a sum that
+// does not fit in the amount is ErrAmountOverflow
+func (m Money) Add(o Money) (Money, error) {
+	currency := m.Currency
+	switch {
+	case m.Currency == "":
+		currency = o.Currency
+	case o.Currency == "":
+	case m.Currency != o.Currency:
+		return Money{}, ErrCurrencyMismatch
+	}
+
+	sum := new(big.Int).Add(big.NewInt(m.Amount), big.NewInt(o.Amount))
+	if !sum.IsInt64() {
+		return Money{}, ErrAmountOverflow
+	}
+
+	return NewMoney(sum.Int64(), currency), nil
+}
+
+// Multiply returns the amount multiplied by the quantity, a product that does
+// not fit in the amount is ErrAmountOverflow
+func (m Money) Multiply(quantity int64) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(quantity))
+	if !product.IsInt64() {
+		return Money{}, ErrAmountOverflow
+	}
+
+	return NewMoney(product.Int64(), m.Currency), nil
+}
+
+// Decimal formats the amount as a decimal number with the digits of its
+// currency, e.g. 10.50
+func (m Money) Decimal() string {
+	exp := m.Currency.Exponent()
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := fmt.Sprintf("%0*d", exp+1, amount)
+	if exp == 0 {
+		return sign + digits
+	}
+
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// String formats the amount with its currency, e.g. 10.50 EUR
+func (m Money) String() string {
+	return strings.TrimSpace(m.Decimal() + " " + string(m.Currency))
+}
+
+// MarshalJSON encodes the amount as a json number, e.g. 10.50
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.Decimal()), nil
+}
+
+// UnmarshalJSON decodes a json number or string in the DefaultCurrency. the
+// number is parsed from its text, it never goes through a float
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return ErrInvalidAmount
+	}
+
+	parsed, err := ParseMoney(n.String(), DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// roundHalfAwayFromZero divides num by denom and rounds the result half away
+// from zero
+func roundHalfAwayFromZero(num, denom *big.Int) *big.Int {
+	n := new(big.Int).Abs(num)
+	d := new(big.Int).Abs(denom)
+
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if r.Lsh(r, 1).Cmp(d) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+
+	if num.Sign()*denom.Sign() < 0 {
+		q.Neg(q)
+	}
+
+	return q
+}
@@ -0,0 +1,111 @@
+package cart_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/cubny/cart"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name          string
+		amount        string
+		currency      cart.Currency
+		expected      cart.Money
+		expectedError error
+	}{
+		{name: "decimal", amount: "10.50", currency: "EUR", expected: cart.NewMoney(1050, "EUR")},
+		{name: "integer", amount: "10", currency: "EUR", expected: cart.NewMoney(1000, "EUR")},
+		{name: "negative", amount: "-0.01", currency: "EUR", expected: cart.NewMoney(-1, "EUR")},
+		{name: "exponent", amount: "1.05e1", currency: "EUR", expected: cart.NewMoney(1050, "EUR")},
+		{name: "rounds half away from zero", amount: "10.005", currency: "EUR", expected: cart.NewMoney(1001, "EUR")},
+		{name: "rounds down below half", amount: "10.0049", currency: "EUR", expected: cart.NewMoney(1000, "EUR")},
+		{name: "rounds negative half away from zero", amount: "-10.005", currency: "EUR", expected: cart.NewMoney(-1001, "EUR")},
+		{name: "currency without minor units", amount: "1050", currency: "JPY", expected: cart.NewMoney(1050, "JPY")},
+		{name: "currency with 3 minor digits", amount: "1.005", currency: "KWD", expected: cart.NewMoney(1005, "KWD")},
+		{name: "not a number", amount: "ten", currency: "EUR", expectedError: cart.ErrInvalidAmount},
+		{name: "overflow", amount: "100000000000000000000", currency: "EUR", expectedError: cart.ErrInvalidAmount},
+		{name: "unknown currency", amount: "10.50", currency: "XYZ", expectedError: cart.ErrInvalidCurrency},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := cart.ParseMoney(test.amount, test.currency)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expected, m)
+		})
+	}
+}
+
+func TestMoney_Add(t *testing.T) {
+	sum, err := cart.NewMoney(10, "EUR").Add(cart.NewMoney(20, "EUR"))
+	assert.Nil(t, err)
+	assert.Equal(t, cart.NewMoney(30, "EUR"), sum)
+
+	// the float64 sum of 0.1 and 0.2 is 0.30000000000000004
+	sum, err = cart.Money{}.Add(cart.NewMoney(10, "EUR"))
+	assert.Nil(t, err)
+	sum, err = sum.Add(cart.NewMoney(20, "EUR"))
+	assert.Nil(t, err)
+	assert.Equal(t, "0.30", sum.Decimal())
+
+	_, err = cart.NewMoney(10, "EUR").Add(cart.NewMoney(10, "USD"))
+	assert.Equal(t, cart.ErrCurrencyMismatch, err)
+
+	_, err = cart.NewMoney(math.MaxInt64, "EUR").Add(cart.NewMoney(1, "EUR"))
+	assert.Equal(t, cart.ErrAmountOverflow, err)
+	_, err = cart.NewMoney(math.MinInt64, "EUR").Add(cart.NewMoney(-1, "EUR"))
+	assert.Equal(t, cart.ErrAmountOverflow, err)
+}
+
+func TestMoney_Multiply(t *testing.T) {
+	product, err := cart.NewMoney(1050, "EUR").Multiply(3)
+	assert.Nil(t, err)
+	assert.Equal(t, cart.NewMoney(3150, "EUR"), product)
+
+	// 92233720368547758 * 1000 does not fit in an int64, it must not wrap around
+	_, err = cart.NewMoney(1000, "EUR").Multiply(92233720368547758)
+	assert.Equal(t, cart.ErrAmountOverflow, err)
+}
+
+func TestMoney_Decimal(t *testing.T) {
+	assert.Equal(t, "10.50", cart.NewMoney(1050, "EUR").Decimal())
+	assert.Equal(t, "0.05", cart.NewMoney(5, "EUR").Decimal())
+	assert.Equal(t, "-0.05", cart.NewMoney(-5, "EUR").Decimal())
+	assert.Equal(t, "1050", cart.NewMoney(1050, "JPY").Decimal())
+	assert.Equal(t, "1.005", cart.NewMoney(1005, "KWD").Decimal())
+	assert.Equal(t, "10.50 EUR", cart.NewMoney(1050, "EUR").String())
+}
+
+func TestMoney_JSON(t *testing.T) {
+	item := &cart.Item{Price: cart.NewMoney(1050, "EUR")}
+	b, err := json.Marshal(item)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"id":0, "product_id":0, "cart_id":0, "quantity":0, "price":10.50}`, string(b))
+
+	tests := []struct {
+		name          string
+		body          string
+		expected      cart.Money
+		expectedError bool
+	}{
+		{name: "number", body: `{"price": 10.50}`, expected: cart.NewMoney(1050, "EUR")},
+		{name: "number that is not exact as float", body: `{"price": 0.29}`, expected: cart.NewMoney(29, "EUR")},
+		{name: "string", body: `{"price": "10.50"}`, expected: cart.NewMoney(1050, "EUR")},
+		{name: "null", body: `{"price": null}`, expected: cart.Money{}},
+		{name: "invalid", body: `{"price": "ten"}`, expectedError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item := &cart.Item{}
+			err := json.Unmarshal([]byte(test.body), item)
+			assert.Equal(t, test.expectedError, err != nil)
+			assert.Equal(t, test.expected, item.Price)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package cart
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrEmptyCart = errors.New("cart has no items")
+
+// Order is the immutable snapshot of a cart taken at checkout, it is what the
+// order service reads to fulfil the purchase
+type Order struct {
+	ID     int64        `json:"id"`
+	CartID int64        `json:"cart_id"`
+	UserID int64        `json:"user_id"`
+	Lines  []*OrderLine `json:"lines"`
+
+	// ItemCount is the number of lines in the order
+	ItemCount int64 `json:"item_count"`
+	// TotalQuantity is the sum of the quantities of all lines
+	TotalQuantity int64 `json:"total_quantity"`
+	// Total is the sum of the prices of all lines
+	Total        Money     `json:"total"`
+	Currency     Currency  `json:"currency"`
+	CheckedOutAt time.Time `json:"checked_out_at"`
+}
+
+// OrderLine is the snapshot of a single cart item
+type OrderLine struct {
+	ID        int64 `json:"id"`
+	OrderID   int64 `json:"order_id"`
+	ProductID int64 `json:"product_id"`
+	Quantity  int64 `json:"quantity"`
+
+	// Price is the total price of the line, i.e. product's price * quantity
+	Price Money `json:"price"`
+}
+
+// NewOrder creates the order snapshot of a cart from its details
+func NewOrder(d *Details) (*Order, error) {
+	if len(d.Items) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	o := &Order{
+		CartID:        d.ID,
+		UserID:        d.UserID,
+		Lines:         make([]*OrderLine, 0, len(d.Items)),
+		ItemCount:     d.ItemCount,
+		TotalQuantity: d.TotalQuantity,
+		Total:         d.Subtotal,
+		Currency:      d.Subtotal.Currency,
+	}
+
+	for _, item := range d.Items {
+		o.Lines = append(o.Lines, &OrderLine{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
+	}
+
+	return o, nil
+}